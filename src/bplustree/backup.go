@@ -0,0 +1,331 @@
+package bplustree
+
+import (
+	"encoding/json"
+	"errors"
+	aol "fios-db/src/log"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// A backup is taken while writers keep committing. It consists of a snapshot of the db file and the tail of the WAL:
+//
+// The last commit is pinned as a snapshot, see mvcc.go, and every page the db file held as of that commit is copied
+// from the WAL or the db file into the db file of the backup. Meanwhile checkpoints leave the WAL untruncated, so once
+// the copy is done the frames of every transaction which committed after the snapshot are still in the WAL. These are
+// copied to the WAL of the backup, up to the last COMMIT frame, after which the WAL is truncated again by the next
+// checkpoint. A manifest written last records the commit the db file is a snapshot of, and marks the backup complete.
+//
+// Since the files of a backup are laid out like those of a db, a backup may be opened as is, which replays its whole
+// WAL. Restore instead writes the db file of a backup elsewhere and replays its WAL up to a chosen commit, giving a
+// point in time restore to any commit from the snapshot to the end of the backup.
+//
+// Pages written by BulkLoad and by the truncation step of Vacuum bypass the WAL, so neither runs during a backup.
+
+// backupFileName is the file name of the db file and the WAL within a backup
+const backupFileName = "db"
+
+// backupManifestName is the name of the manifest of a backup
+const backupManifestName = "backup.json"
+
+// ErrBackupDirNotEmpty is returned when a backup is written to a directory which already holds files
+var ErrBackupDirNotEmpty = errors.New("backups must be written to an empty directory")
+
+// ErrDbExists is returned when a backup is restored where a db already exists
+var ErrDbExists = errors.New("a db already exists where the backup would be restored")
+
+// ErrCommitNotInBackup is returned when a backup is restored to a commit it does not cover
+var ErrCommitNotInBackup = errors.New("commit is not covered by the backup")
+
+// BackupInfo describes a backup. Commits are numbered by their commit sequence number, see Snapshot.Seq, so a backup
+// may be restored to any commit from Seq to LastSeq
+type BackupInfo struct {
+	Seq     int64 `json:"seq"`     // commit the db file of the backup is a snapshot of
+	LastSeq int64 `json:"lastSeq"` // last commit in the WAL of the backup
+	Pages   int64 `json:"pages"`   // number of pages of the db file of the backup
+}
+
+// A backupPoint is where a backup starts
+type backupPoint struct {
+	seq      int64 // commit pinned as the snapshot copied to the db file of the backup
+	numPages int64 // number of pages of the db file as of the snapshot
+	walStart int64 // offset of the oldest frame of the WAL
+	walEnd   int64 // offset following the COMMIT frame of the snapshot
+}
+
+// Backup writes a consistent copy of the tree to dir without blocking writers, see above. dir is created if it does
+// not exist and must otherwise be empty
+func (t *BPlusTree) Backup(dir string) (BackupInfo, error) {
+	return t.backup(dir, nil)
+}
+
+// backup writes a backup of the tree to dir, calling afterSnapshot once the snapshot is copied if it is set
+func (t *BPlusTree) backup(dir string, afterSnapshot func()) (BackupInfo, error) {
+	t.backupLock.RLock()
+	defer t.backupLock.RUnlock()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return BackupInfo{}, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	if len(entries) > 0 {
+		return BackupInfo{}, ErrBackupDirNotEmpty
+	}
+
+	point := t.bpm.beginBackup()
+	defer t.bpm.endBackup(point)
+	name := filepath.Join(dir, backupFileName)
+	if err := t.bpm.copySnapshot(name+".db", point); err != nil {
+		return BackupInfo{}, err
+	}
+	if afterSnapshot != nil {
+		afterSnapshot()
+	}
+	commits, err := t.bpm.copyWALTail(name, point)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+
+	info := BackupInfo{
+		Seq:     point.seq,
+		LastSeq: point.seq + commits,
+		Pages:   point.numPages,
+	}
+	return info, writeManifest(dir, info)
+}
+
+// beginBackup pins the last commit as the snapshot of a backup and stops checkpoints from truncating the WAL until
+// endBackup is called
+func (bpm *BufferPoolManager) beginBackup() backupPoint {
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+	bpm.snapshots[bpm.commitSeq]++
+	bpm.backups++
+	return backupPoint{
+		seq:      bpm.commitSeq,
+		numPages: (bpm.committedSize + PageSize - 1) / PageSize,
+		walStart: bpm.wal.log.FirstOffset(),
+		walEnd:   bpm.wal.log.Size(),
+	}
+}
+
+// endBackup unpins the snapshot of a backup and lets checkpoints truncate the WAL again
+func (bpm *BufferPoolManager) endBackup(point backupPoint) {
+	bpm.mu.Lock()
+	bpm.backups--
+	bpm.mu.Unlock()
+	bpm.UnpinSnapshot(point.seq)
+}
+
+// copySnapshot writes every page as of the snapshot of the backup to the file fileName
+func (bpm *BufferPoolManager) copySnapshot(fileName string, point backupPoint) error {
+	file, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	for pageNum := int64(0); pageNum < point.numPages; pageNum++ {
+		data, err := bpm.getPageAt(pageNum, point.seq, true)
+		// pages the db file was extended by for a transaction which never committed were never written
+		if err != nil && !(errors.Is(err, ErrCorruption) && unwritten(data)) {
+			_ = file.Close()
+			return err
+		}
+		if _, err := file.WriteAt(data, pageNum*PageSize); err != nil {
+			_ = file.Close()
+			return ioError("write", file, err)
+		}
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return ioError("sync", file, err)
+	}
+	if err := file.Close(); err != nil {
+		return ioError("close", file, err)
+	}
+	return nil
+}
+
+// copyWALTail copies the frames of every transaction which committed after the snapshot of the backup to the WAL
+// stored in fileName, up to the last commit. Returns the number of commits copied
+func (bpm *BufferPoolManager) copyWALTail(fileName string, point backupPoint) (int64, error) {
+	bpm.mu.Lock()
+	end := bpm.wal.log.Size()
+	bpm.mu.Unlock()
+	// the WAL is synced so that the backup holds no commit which could still be lost by the db
+	if err := bpm.wal.log.Flush(); err != nil {
+		return 0, err
+	}
+
+	committed := map[int64]bool{}
+	var commits int64
+	err := bpm.wal.scan(point.walStart, end, func(offset int64, frame *Frame, _ []byte) error {
+		if frame.FrameType == COMMIT && offset >= point.walEnd {
+			committed[frame.TxnId] = true
+			commits++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	l := aol.NewLog(fileName)
+	err = bpm.wal.scan(point.walStart, end, func(_ int64, frame *Frame, record []byte) error {
+		if !committed[frame.TxnId] || (frame.FrameType != PUT && frame.FrameType != COMMIT) {
+			return nil
+		}
+		_, err := l.Append(record)
+		return err
+	})
+	if err == nil {
+		err = l.Flush()
+	}
+	if closeErr := l.Close(); err == nil {
+		err = closeErr
+	}
+	return commits, err
+}
+
+// Restore writes the db stored in the backup in dir to fileName, replaying the WAL of the backup up to commit seq, or
+// up to its last commit if seq is negative. Returns ErrCommitNotInBackup if the backup does not cover seq, and
+// ErrDbExists if a db is already stored in fileName
+func Restore(dir string, fileName string, seq int64) error {
+	info, err := ReadBackupInfo(dir)
+	if err != nil {
+		return err
+	}
+	if seq < 0 {
+		seq = info.LastSeq
+	}
+	if seq < info.Seq || seq > info.LastSeq {
+		return fmt.Errorf("%w: commit %d is not within [%d, %d]", ErrCommitNotInBackup, seq, info.Seq, info.LastSeq)
+	}
+	existing, err := filepath.Glob(fileName + ".*")
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return ErrDbExists
+	}
+
+	name := filepath.Join(dir, backupFileName)
+	dbFile, err := os.OpenFile(fileName+".db", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	err = copyFile(dbFile, name+".db")
+	if err == nil {
+		err = replayWAL(dbFile, name, seq-info.Seq)
+	}
+	if err == nil {
+		if syncErr := dbFile.Sync(); syncErr != nil {
+			err = ioError("sync", dbFile, syncErr)
+		}
+	}
+	if closeErr := dbFile.Close(); err == nil && closeErr != nil {
+		err = ioError("close", dbFile, closeErr)
+	}
+	return err
+}
+
+// ReadBackupInfo reads the manifest of the backup in dir
+func ReadBackupInfo(dir string) (BackupInfo, error) {
+	var info BackupInfo
+	manifest, err := os.ReadFile(filepath.Join(dir, backupManifestName))
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(manifest, &info)
+	return info, err
+}
+
+// writeManifest writes the manifest of a backup to dir, which marks the backup complete
+func writeManifest(dir string, info BackupInfo) error {
+	manifest, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filepath.Join(dir, backupManifestName), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(manifest); err != nil {
+		_ = file.Close()
+		return ioError("write", file, err)
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return ioError("sync", file, err)
+	}
+	return file.Close()
+}
+
+// copyFile copies the file stored in fileName to dst
+func copyFile(dst *os.File, fileName string) error {
+	src, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = src.Close() }()
+	if _, err := io.Copy(dst, src); err != nil {
+		return ioError("copy", src, err)
+	}
+	return nil
+}
+
+// replayWAL writes the pages of the first n transactions committed to the WAL stored in fileName to the db file
+func replayWAL(dbFile *os.File, fileName string, n int64) error {
+	wal := NewWAL(fileName, 0, DefaultAsyncFlushInterval)
+	defer func() { _ = wal.Close() }()
+
+	uncommittedFrames := map[int64][]*Frame{}
+	var commits int64
+	return wal.scan(wal.log.FirstOffset(), wal.log.Size(), func(_ int64, frame *Frame, _ []byte) error {
+		if commits >= n {
+			return nil
+		}
+		if frame.FrameType == PUT {
+			uncommittedFrames[frame.TxnId] = append(uncommittedFrames[frame.TxnId], frame)
+			return nil
+		}
+		for _, committedFrame := range uncommittedFrames[frame.TxnId] {
+			if _, err := dbFile.WriteAt(committedFrame.Data, committedFrame.PageNum*PageSize); err != nil {
+				return ioError("write", dbFile, err)
+			}
+		}
+		delete(uncommittedFrames, frame.TxnId)
+		commits++
+		return nil
+	})
+}
+
+// scan calls fn with the offset of every frame of the WAL in [from, end), the frame and the record it was read from
+func (wal *WAL) scan(from, end int64, fn func(offset int64, frame *Frame, record []byte) error) error {
+	it := wal.log.Iterator(from)
+	defer it.Close()
+	for ; it.Valid() && it.Offset() < end; it.Next() {
+		frame, err := wal.deserializeFrame(it.Offset(), it.Record())
+		if err != nil {
+			return err
+		}
+		if err := fn(it.Offset(), frame, it.Record()); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+// unwritten returns whether the page was never written
+func unwritten(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return len(data) == PageSize
+}
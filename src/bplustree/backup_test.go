@@ -0,0 +1,189 @@
+package bplustree
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const BackupDir = TestDir + "/backup"
+const RestoreDir = TestDir + "/restored"
+const RestoreFile = RestoreDir + "/db"
+
+// restore restores the backup up to commit seq and opens the restored tree
+func restore(t *testing.T, seq int64) BPlusTree {
+	_ = os.Mkdir(RestoreDir, 0755)
+	assert.NoError(t, Restore(BackupDir, RestoreFile, seq))
+	return NewBPlusTree(RestoreFile, 64, 128)
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 64, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	keys := seedTree(&bpt, 500)
+	assert.NoError(t, bpt.Set("large", strings.Repeat("large", PageSize)))
+
+	// Act
+	info, err := bpt.Backup(BackupDir)
+	assert.NoError(t, err)
+	assert.NoError(t, bpt.Set("after", "backup"))
+	restored := restore(t, -1)
+
+	// Assert
+	assert.Equal(t, info.Seq, info.LastSeq)
+	restored.ValidateTreeStructure()
+	assert.Equal(t, len(keys)+1, countKeys(t, &restored, "", ""))
+	for _, key := range keys {
+		value, present, err := restored.Get(key)
+		assert.NoError(t, err)
+		assert.True(t, present)
+		assert.Equal(t, "v"+key, value)
+	}
+	value, _, err := restored.Get("large")
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Repeat("large", PageSize), value)
+	_, present, err := restored.Get("after")
+	assert.NoError(t, err)
+	assert.False(t, present)
+}
+
+func TestRestoreToCommit(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 64, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	seedTree(&bpt, 100)
+
+	// Act
+	// writes committed while the snapshot is copied reach the backup through its WAL, even across a checkpoint
+	info, err := bpt.backup(BackupDir, func() {
+		for i := 0; i < 10; i++ {
+			assert.NoError(t, bpt.Set(fmt.Sprintf("w%d", i), "value"))
+		}
+		assert.NoError(t, bpt.Checkpoint())
+	})
+	assert.NoError(t, err)
+	restored := restore(t, info.Seq+4)
+
+	// Assert
+	assert.Equal(t, info.Seq+10, info.LastSeq)
+	restored.ValidateTreeStructure()
+	assert.Equal(t, 100, countKeys(t, &restored, "k", "l"))
+	assert.Equal(t, 4, countKeys(t, &restored, "w", "x"))
+	_, present, err := restored.Get("w3")
+	assert.NoError(t, err)
+	assert.True(t, present)
+}
+
+func TestBackupWithConcurrentWriters(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 16, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	seedTree(&bpt, 300)
+	wg := sync.WaitGroup{}
+
+	// Act
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				assert.NoError(t, bpt.Set(fmt.Sprintf("w%d-%03d", w, i), "value"))
+			}
+		}(w)
+	}
+	_, err := bpt.Backup(BackupDir)
+	wg.Wait()
+	assert.NoError(t, err)
+	restored := restore(t, -1)
+
+	// Assert
+	// the backup holds every write of a writer up to some point, and none after it
+	restored.ValidateTreeStructure()
+	assert.Equal(t, 300, countKeys(t, &restored, "k", "l"))
+	for w := 0; w < 4; w++ {
+		n := countKeys(t, &restored, fmt.Sprintf("w%d-", w), fmt.Sprintf("w%d.", w))
+		if n > 0 {
+			_, present, err := restored.Get(fmt.Sprintf("w%d-%03d", w, n-1))
+			assert.NoError(t, err)
+			assert.True(t, present)
+		}
+	}
+}
+
+func TestBackupCanBeOpened(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 64, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	seedTree(&bpt, 100)
+	_, err := bpt.backup(BackupDir, func() {
+		assert.NoError(t, bpt.Set("during", "backup"))
+	})
+	assert.NoError(t, err)
+
+	// Act
+	opened := NewBPlusTree(BackupDir+"/"+backupFileName, 64, 128)
+
+	// Assert
+	opened.ValidateTreeStructure()
+	assert.Equal(t, 101, countKeys(t, &opened, "", ""))
+}
+
+func TestBackupRejectsNonEmptyDir(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 64, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	_, err := bpt.Backup(BackupDir)
+	assert.NoError(t, err)
+
+	// Act
+	_, err = bpt.Backup(BackupDir)
+
+	// Assert
+	assert.Equal(t, ErrBackupDirNotEmpty, err)
+}
+
+func TestRestoreRejectsExistingDb(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 64, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	_, err := bpt.Backup(BackupDir)
+	assert.NoError(t, err)
+
+	// Act
+	err = Restore(BackupDir, TestFile, -1)
+
+	// Assert
+	assert.Equal(t, ErrDbExists, err)
+}
+
+func TestRestoreRejectsCommitNotInBackup(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 64, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	seedTree(&bpt, 10)
+	info, err := bpt.Backup(BackupDir)
+	assert.NoError(t, err)
+	_ = os.Mkdir(RestoreDir, 0755)
+
+	// Act
+	before := Restore(BackupDir, RestoreFile, info.Seq-1)
+	after := Restore(BackupDir, RestoreFile, info.LastSeq+1)
+
+	// Assert
+	assert.True(t, errors.Is(before, ErrCommitNotInBackup))
+	assert.True(t, errors.Is(after, ErrCommitNotInBackup))
+	_, err = os.Stat(RestoreFile + ".db")
+	assert.True(t, os.IsNotExist(err))
+}
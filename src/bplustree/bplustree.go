@@ -1,40 +1,91 @@
 package bplustree
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"sync"
 )
 
-// DefaultCapacity numKeys*keySize + (numKeys + 1)*pageRefSize + pageTypeSize + keyCountSize <= pageSize, where capacity = numKeys
-const DefaultCapacity = int((PageSize - PageTypeSize - KeyCountSize - KeySize) / (KeySize + PageRefSize))
+// DefaultCapacity is the number of bytes a node may occupy. Nodes are split once they grow past capacity bytes and are
+// rebalanced with a sibling once they shrink below a quarter of capacity bytes
+const DefaultCapacity = UsablePageSize
 
-// BPlusTree Implementation of a right biased b+ tree
+// ErrKeyTooLarge is returned when a key is too large to be stored in a node. A single entry may use at most a quarter
+// of the node capacity, which guarantees that splits and merges always produce nodes within bounds. Values which would
+// push an entry past that limit are moved to overflow pages, but keys must always be stored inline
+var ErrKeyTooLarge = errors.New("key is too large to be stored in a node")
+
+// BPlusTree Implementation of a right biased b+ tree. Reads and writes of single keys run concurrently using latch
+// crabbing on the pages of the tree, see latch.go
 type BPlusTree struct {
-	rwLock   *sync.RWMutex
-	root     *Node
-	capacity int
-	bpm      *BufferPoolManager
+	backupLock *sync.RWMutex // held shared by backups, and exclusively by writes which bypass the WAL, see backup.go
+	txnLock    *sync.RWMutex // held shared by single key reads and writes, and exclusively by multi key transactions
+	smoLock    *sync.Mutex   // serializes writes which may split or merge nodes
+	rootLatch  *sync.RWMutex // guards which page is the root
+	capacity   int
+	bpm        *BufferPoolManager
+
+	durability Durability // durability of the writes and transactions of this handle, that of the db if 0, see durability.go
+
+	stopCheckpointer    chan struct{} // closed to stop the periodic checkpointer, nil if there is none
+	checkpointerStopped chan struct{}
 }
 
-func NewBPlusTree(fileName string, cacheSize int, capacity int) BPlusTree {
-	bpm := NewBPM(fileName, cacheSize)
-	if capacity < 0 {
+// NewBPlusTree opens the tree stored in fileName. capacity is the number of bytes a node may occupy and is capped at
+// the usable page size. A negative capacity uses DefaultCapacity
+func NewBPlusTree(fileName string, cacheSize int, capacity int, opts ...Option) BPlusTree {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	bpm := NewBPM(fileName, cacheSize, opts...)
+	if capacity < 0 || capacity > UsablePageSize {
 		capacity = DefaultCapacity
 	}
-	return BPlusTree{
-		rwLock: &sync.RWMutex{},
-		root: bpm.GetRoot(),
-		capacity: capacity,
-		bpm:      bpm,
+	t := BPlusTree{
+		backupLock: &sync.RWMutex{},
+		txnLock:    &sync.RWMutex{},
+		smoLock:    &sync.Mutex{},
+		rootLatch:  &sync.RWMutex{},
+		capacity:   capacity,
+		bpm:        bpm,
+	}
+	if o.checkpointInterval > 0 {
+		t.stopCheckpointer = make(chan struct{})
+		t.checkpointerStopped = make(chan struct{})
+		go t.runCheckpointer(o.checkpointInterval, t.stopCheckpointer, t.checkpointerStopped)
+	}
+	return t
+}
+
+// Checkpoint writes every committed page in the WAL to the db file and truncates the WAL, see checkpoint.go
+func (t *BPlusTree) Checkpoint() error {
+	return t.bpm.Checkpoint()
+}
+
+// CacheStats returns the counters and the memory usage of the buffer pool, see replacement.go and memory.go
+func (t *BPlusTree) CacheStats() CacheStats {
+	return t.bpm.CacheStats()
+}
+
+// Close waits for running writes and transactions to finish, checkpoints the WAL and closes the files of the tree. The
+// tree must not be used once closed
+func (t *BPlusTree) Close() error {
+	t.txnLock.Lock()
+	defer t.txnLock.Unlock()
+	if t.stopCheckpointer != nil {
+		close(t.stopCheckpointer)
+		<-t.checkpointerStopped
 	}
+	return t.bpm.Close()
 }
 
 func (t *BPlusTree) PrintTree() {
-	t.rwLock.RLock()
-	defer t.rwLock.RUnlock()
+	t.txnLock.Lock()
+	defer t.txnLock.Unlock()
 	printLayers := make(map[int][][]string)
-	t.printTree(t.root, printLayers, 0)
+	t.printTree(t.mustGet(t.bpm.RootPageNum()), printLayers, 0)
 	for i := 0;;i++ {
 		if _, ok := printLayers[i]; !ok {
 			fmt.Println()
@@ -52,26 +103,43 @@ func (t *BPlusTree) printTree(node *Node, printLayers map[int][][]string, layer
 
 	if !node.IsLeaf {
 		for _, child := range node.Children {
-			t.printTree(t.bpm.Get(child), printLayers, layer + 1)
+			t.printTree(t.mustGet(child), printLayers, layer + 1)
 		}
 	}
 }
 
-// ValidateTreeStructure is used for debugging. Traverses the tree and does some simple sanity checks
+// ValidateTreeStructure is used for debugging. Traverses the tree and does some simple sanity checks. Writers are
+// blocked while the tree is validated
 func (t *BPlusTree) ValidateTreeStructure() {
-	t.rwLock.RLock()
-	defer t.rwLock.RUnlock()
-	t.validateTreeStructure("", "", t.root)
+	t.txnLock.Lock()
+	defer t.txnLock.Unlock()
+	leaves := make([]*Node, 0)
+	root := t.mustGet(t.bpm.RootPageNum())
+	t.validateTreeStructure("", "", root, root, &leaves)
+
+	for idx, leaf := range leaves {
+		var prev, next int64
+		if idx > 0 {
+			prev = leaves[idx-1].PageNum
+		}
+		if idx < len(leaves)-1 {
+			next = leaves[idx+1].PageNum
+		}
+		if leaf.Prev != prev || leaf.Next != next {
+			log.Fatalf("Leaves must point at their neighbouring leaves")
+		}
+	}
 }
 
-func (t *BPlusTree) validateTreeStructure(leftParentKey, rightParentKey string, node *Node) {
-	if len(node.Keys) > t.capacity {
-		log.Fatalf("More keys than configured capacity")
+func (t *BPlusTree) validateTreeStructure(leftParentKey, rightParentKey string, root, node *Node, leaves *[]*Node) {
+	if node.Size() > t.capacity {
+		log.Fatalf("Node occupies more bytes than configured capacity")
 	}
-	if node != t.root && len(node.Keys) < t.capacity / 2 {
-		log.Fatalf("Non root nodes must have at least capacity / 2 keys")
+	if node != root && node.Size() < t.minFill() {
+		log.Fatalf("Non root nodes must occupy at least capacity / 4 bytes")
 	}
-	if node.IsLeaf && len(node.Keys) != len(node.Values) {
+	if node.IsLeaf && (len(node.Keys) != len(node.Values) || len(node.Keys) != len(node.Overflow) ||
+		len(node.Keys) != len(node.Versions)) {
 		log.Fatalf("Leaf nodes must have same number of keys as values")
 	}
 	if !node.IsLeaf && len(node.Keys) + 1 != len(node.Children) {
@@ -110,208 +178,374 @@ func (t *BPlusTree) validateTreeStructure(leftParentKey, rightParentKey string,
 				rpk = node.Keys[idx]
 			}
 
-			t.validateTreeStructure(lpk, rpk, t.bpm.Get(child))
+			t.validateTreeStructure(lpk, rpk, root, t.mustGet(child), leaves)
 		}
+	} else {
+		*leaves = append(*leaves, node)
 	}
 }
 
-func (t *BPlusTree) Get(key string) (string, bool) {
-	t.rwLock.RLock()
-	defer t.rwLock.RUnlock()
-	return t.get(key, t.root)
+// mustGet reads the node stored in the page for debugging, where a corrupt page is fatal
+func (t *BPlusTree) mustGet(pageNum int64) *Node {
+	node, err := t.bpm.Get(pageNum)
+	if err != nil {
+		log.Fatalf("Failure reading node: %v", err)
+	}
+	return node
 }
 
-func (t *BPlusTree) get(key string, node *Node) (string, bool) {
-	if node.IsLeaf {
-		i, keyExists := findKeyIndexInLeaf(key, node.Keys)
-		if keyExists {
-			return node.Values[i], true
-		} else {
-			return "", false
+// Get returns the value of the key. Returns an error matching ErrCorruption or ErrIO if a page on the path to the key is
+// corrupt or cannot be read
+func (t *BPlusTree) Get(key string) (string, bool, error) {
+	value, _, ok, err := t.GetVersion(key)
+	return value, ok, err
+}
+
+// GetVersion returns the value of the key along with its version. The version starts at 1 when the key is created and
+// is incremented every time the key is written
+func (t *BPlusTree) GetVersion(key string) (string, int64, bool, error) {
+	t.txnLock.RLock()
+	defer t.txnLock.RUnlock()
+	return t.get(key)
+}
+
+func (t *BPlusTree) get(key string) (string, int64, bool, error) {
+	leaf, err := t.findLeaf(atOrAfter(key))
+	if err != nil {
+		return "", 0, false, err
+	}
+	defer t.bpm.Latch(leaf.PageNum).RUnlock()
+	i, keyExists := findKeyIndexInLeaf(key, leaf.Keys)
+	if keyExists {
+		value, err := t.value(leaf, i)
+		if err != nil {
+			return "", 0, false, err
 		}
+		return value, leaf.Versions[i], true, nil
 	} else {
-		i := findChildPointerIndex(key, node.Keys)
-		return t.get(key, t.bpm.Get(node.Children[i]))
+		return "", 0, false, nil
+	}
+}
+
+// value returns the value of the entry at index i of the leaf node, reading it from overflow pages if needed
+func (t *BPlusTree) value(node *Node, i int) (string, error) {
+	if node.Overflow[i] != 0 {
+		return t.bpm.GetOverflow(node.Overflow[i])
 	}
+	return node.Values[i], nil
 }
 
-func (t *BPlusTree) Set(key, value string) {
-	t.rwLock.Lock()
-	defer t.rwLock.Unlock()
-	newNode, newKey, didSplit := t.set(key, value, t.root)
-	if didSplit {
-		newRoot := NewInnerNode(t.bpm.GetFreePage(), []string{newKey}, []int64{t.root.PageNum, newNode.PageNum})
-		t.root = newRoot
-		t.bpm.Set(t.root)
-		t.bpm.SetRoot(t.root.PageNum)
+// Set sets the value of the key. If a corrupt page is found on the way, or a page cannot be read or written, the write
+// is aborted and an error matching ErrCorruption or ErrIO is returned. An error matching ErrNotDurable is returned
+// instead if the write was applied but could not be synced
+func (t *BPlusTree) Set(key, value string) error {
+	if err := t.checkKey(key); err != nil {
+		return err
 	}
-	t.bpm.Commit()
+
+	_, err := t.apply(&writeOp{key: key, value: value})
+	return err
 }
 
-func (t *BPlusTree) set(key string, value string, node *Node) (*Node, string, bool) {
+// set inserts the key value pair of op into the subtree rooted at node. Returns whether node was modified, in which
+// case the caller is responsible for rebalancing and persisting it
+func (t *BPlusTree) set(op *writeOp, node *Node) (bool, error) {
 	if node.IsLeaf {
-		i, found := findKeyIndexInLeaf(key, node.Keys)
+		i, found := findKeyIndexInLeaf(op.key, node.Keys)
+		if ok, err := t.checkCondition(op, node, i, found); !ok || err != nil {
+			return false, err
+		}
+		if found && node.Overflow[i] != 0 {
+			if err := t.bpm.DeleteOverflow(op.txn, node.Overflow[i]); err != nil {
+				return false, err
+			}
+		} else if !found {
+			node.InsertKey(op.key, i)
+			node.InsertValue("", i)
+		}
+		if err := t.setValue(op.txn, node, i, op.value); err != nil {
+			return false, err
+		}
 		if found {
-			node.Values[i] = value
-			t.bpm.Set(node)
-			return nil, "", false
+			node.Versions[i]++
 		} else {
-			node.InsertKey(key, i)
-			node.InsertValue(value, i)
-			if len(node.Keys) > t.capacity {
-				nn := NewLeafNode(t.bpm.GetFreePage(), node.Keys[len(node.Keys)/2:], node.Values[len(node.Values)/2:])
-				node.Keys = node.Keys[:len(node.Keys)/2]
-				node.Values = node.Values[:len(node.Values)/2]
-				t.bpm.Set(node)
-				t.bpm.Set(nn)
-				return nn, nn.Keys[0], true
-			}
-			t.bpm.Set(node)
-			return nil, "", false
+			node.Versions[i] = t.bpm.FirstVersion()
 		}
+		op.applied = true
+		return true, nil
 	} else {
-		i := findChildPointerIndex(key, node.Keys)
-		newNode, newKey, didSplit := t.set(key, value, t.bpm.Get(node.Children[i]))
-		if !didSplit {
-			return nil, "", false
+		i := findChildPointerIndex(op.key, node.Keys)
+		child, err := t.latchChild(op, node.Children[i])
+		if err != nil {
+			return false, err
 		}
-		node.InsertKey(newKey, i)
-		node.InsertChild(newNode.PageNum, i+1)
-		if len(node.Keys) > t.capacity {
-			nodeSize := len(node.Keys)
-			nn := NewInnerNode(t.bpm.GetFreePage(), node.Keys[nodeSize/2+1:], node.Children[nodeSize/2+1:])
-			middleKey := node.Keys[len(node.Keys)/2]
-			node.Keys = node.Keys[:nodeSize/2]
-			node.Children = node.Children[:nodeSize/2+1]
-			t.bpm.Set(node)
-			t.bpm.Set(nn)
-			return nn, middleKey, true
+		if modified, err := t.set(op, child); !modified || err != nil {
+			return false, err
 		}
-		t.bpm.Set(node)
-
-		return nil, "", false
+		return t.fixChild(op, node, i, child)
 	}
 }
 
-func (t *BPlusTree) Delete(key string) {
-	t.rwLock.Lock()
-	defer t.rwLock.Unlock()
-	_ = t.delete(key, t.root)
-	if len(t.root.Keys) == 0 && !t.root.IsLeaf {
-		oldRootPageNumber := t.root.PageNum
-		t.root = t.bpm.Get(t.root.Children[0])
-		t.bpm.DeletePage(oldRootPageNumber)
-		t.bpm.SetRoot(t.root.PageNum)
-	}
-	t.bpm.Commit()
+// Delete removes the key. If a corrupt page is found on the way, or a page cannot be read or written, the delete is
+// aborted and an error matching ErrCorruption or ErrIO is returned. An error matching ErrNotDurable is returned instead
+// if the delete was applied but could not be synced
+func (t *BPlusTree) Delete(key string) error {
+	_, err := t.apply(&writeOp{key: key, delete: true})
+	return err
 }
 
-// returns whether nodes are underCapacity
-func (t *BPlusTree) delete(key string, node *Node) bool {
+// delete removes the key of op from the subtree rooted at node. Returns whether node was modified, in which case the
+// caller is responsible for rebalancing and persisting it
+func (t *BPlusTree) delete(op *writeOp, node *Node) (bool, error) {
 	if node.IsLeaf {
-		i, found := findKeyIndexInLeaf(key, node.Keys)
+		i, found := findKeyIndexInLeaf(op.key, node.Keys)
 		if !found {
-			return false
+			return false, nil
+		}
+		if ok, err := t.checkCondition(op, node, i, found); !ok || err != nil {
+			return false, err
+		}
+		if node.Overflow[i] != 0 {
+			if err := t.bpm.DeleteOverflow(op.txn, node.Overflow[i]); err != nil {
+				return false, err
+			}
 		}
+		t.bpm.RetireVersion(op.txn, node.Versions[i])
 		node.DeleteKey(i)
 		node.DeleteValue(i)
-		t.bpm.Set(node)
-		return len(node.Keys) < t.capacity/2
+		op.applied = true
+		return true, nil
 	} else {
-		i := findChildPointerIndex(key, node.Keys)
-		childUnderCapacity := t.delete(key, t.bpm.Get(node.Children[i]))
-		if childUnderCapacity {
-			if t.canBorrowFromLeft(i, node) {
-				leftChild := t.bpm.Get(node.Children[i-1])
-				rightChild := t.bpm.Get(node.Children[i])
-				k, v, child := leftChild.RemoveMax()
-				if node.Keys[i-1] == key {
-					minKey := k
-					if !rightChild.IsLeaf {
-						minKey = t.getMinKey(rightChild)
-					}
-					rightChild.AcceptMaxFromLeftChild(minKey, v, child)
-				} else {
-					maxKey := k
-					if !rightChild.IsLeaf {
-						maxKey = node.Keys[i-1]
-					}
-					rightChild.AcceptMaxFromLeftChild(maxKey, v, child)
-				}
-				node.Keys[i-1] = k
-				t.bpm.Set(node)
-				t.bpm.Set(leftChild)
-				t.bpm.Set(rightChild)
-			} else if t.canBorrowFromRight(i, node) {
-				leftChild := t.bpm.Get(node.Children[i])
-				rightChild := t.bpm.Get(node.Children[i+1])
-				minKey := t.getMinKey(rightChild)
-				_, v, child := rightChild.RemoveMin()
-				leftChild.AcceptMinFromRightChild(minKey, v, child)
-				node.Keys[i] = t.getMinKey(rightChild)
-				t.bpm.Set(node)
-				t.bpm.Set(leftChild)
-				t.bpm.Set(rightChild)
-			} else if t.canMergeWithLeft(i) {
-				leftChild := t.bpm.Get(node.Children[i-1])
-				rightChild := t.bpm.Get(node.Children[i])
-				if leftChild.IsLeaf {
-					leftChild.Keys = append(leftChild.Keys, rightChild.Keys...)
-					leftChild.Values = append(leftChild.Values, rightChild.Values...)
-				} else {
-					leftChild.Keys = append(append(leftChild.Keys, t.getMinKey(rightChild)), rightChild.Keys...)
-					leftChild.Children = append(leftChild.Children, rightChild.Children...)
-				}
-				node.DeleteKey(i - 1)
-				node.DeleteChild(i)
-				t.bpm.Set(node)
-				t.bpm.Set(leftChild)
-				t.bpm.DeletePage(rightChild.PageNum)
-			} else {
-				leftChild := t.bpm.Get(node.Children[i])
-				rightChild := t.bpm.Get(node.Children[i+1])
-				if leftChild.IsLeaf {
-					leftChild.Keys = append(leftChild.Keys, rightChild.Keys...)
-					leftChild.Values = append(leftChild.Values, rightChild.Values...)
-				} else {
-					leftChild.Keys = append(append(leftChild.Keys, t.getMinKey(rightChild)), rightChild.Keys...)
-					leftChild.Children = append(leftChild.Children, rightChild.Children...)
-				}
-				node.DeleteKey(i)
-				node.DeleteChild(i + 1)
-				t.bpm.Set(node)
-				t.bpm.Set(leftChild)
-				t.bpm.DeletePage(rightChild.PageNum)
-			}
-		} else if i > 0 && node.Keys[i-1] == key {
-			node.Keys[i-1] = t.getMinKey(t.bpm.Get(node.Children[i]))
-			t.bpm.Set(node)
+		i := findChildPointerIndex(op.key, node.Keys)
+		child, err := t.latchChild(op, node.Children[i])
+		if err != nil {
+			return false, err
+		}
+		if modified, err := t.delete(op, child); !modified || err != nil {
+			return false, err
+		}
+		return t.fixChild(op, node, i, child)
+	}
+}
+
+// checkCondition returns whether the condition of op holds for the entry at index i of the leaf node
+func (t *BPlusTree) checkCondition(op *writeOp, node *Node, i int, found bool) (bool, error) {
+	if op.cond == nil {
+		return true, nil
+	}
+	if !found {
+		return op.cond("", 0, false), nil
+	}
+	value, err := t.value(node, i)
+	if err != nil {
+		return false, err
+	}
+	return op.cond(value, node.Versions[i], true), nil
+}
+
+// setValue stores the value of the entry at index i of the leaf node inline, or in a chain of overflow pages if the
+// entry would otherwise be too large
+func (t *BPlusTree) setValue(txn *WriteTxn, node *Node, i int, value string) error {
+	if t.overflows(node.Keys[i], value) {
+		pageNum, err := t.bpm.SetOverflow(txn, value)
+		if err != nil {
+			return err
 		}
+		node.Values[i] = ""
+		node.Overflow[i] = pageNum
+	} else {
+		node.Values[i] = value
+		node.Overflow[i] = 0
+	}
+	return nil
+}
 
-		return len(node.Keys) < t.capacity/2
+// fixChild splits the modified child at index i of node if it has grown past capacity, or rebalances it with a sibling
+// if it has shrunk below the minimum fill, and persists it. Returns whether node was modified as a result
+func (t *BPlusTree) fixChild(op *writeOp, node *Node, i int, child *Node) (bool, error) {
+	if child.Size() > t.capacity {
+		return true, t.splitChild(op, node, i, child)
+	}
+	if child.Size() < t.minFill() && len(node.Children) > 1 {
+		return true, t.rebalanceChild(op, node, i, child)
 	}
+	return false, t.bpm.Set(op.txn, child)
 }
 
-func (t *BPlusTree) canBorrowFromLeft(i int, node *Node) bool {
-	return i > 0 && t.bpm.Get(node.Children[i-1]).CanLend(t.capacity)
+// fixRoot splits the modified root if it has grown past capacity, or replaces it with its only child once it has no keys
+// left. The root latch must be held unless the root was safe
+func (t *BPlusTree) fixRoot(op *writeOp, root *Node) error {
+	if root.Size() > t.capacity {
+		pageNum, err := t.bpm.GetFreePage(op.txn)
+		if err != nil {
+			return err
+		}
+		newRoot := NewInnerNode(pageNum, []string{}, []int64{root.PageNum})
+		if err := t.splitChild(op, newRoot, 0, root); err != nil {
+			return err
+		}
+		if err := t.bpm.Set(op.txn, newRoot); err != nil {
+			return err
+		}
+		t.bpm.SetRoot(op.txn, newRoot.PageNum)
+	} else if len(root.Keys) == 0 && !root.IsLeaf {
+		t.bpm.DeletePage(op.txn, root.PageNum)
+		t.bpm.SetRoot(op.txn, root.Children[0])
+	} else {
+		return t.bpm.Set(op.txn, root)
+	}
+	return nil
 }
 
-func (t *BPlusTree) canBorrowFromRight(i int, node *Node) bool {
-	return i < len(node.Children)-1 && t.bpm.Get(node.Children[i+1]).CanLend(t.capacity)
+// splitChild moves the upper half (by bytes) of the child at index i of node into a new node and inserts the key
+// separating the two into node
+func (t *BPlusTree) splitChild(op *writeOp, node *Node, i int, child *Node) error {
+	splitIdx := child.SplitIndex()
+	pageNum, err := t.bpm.GetFreePage(op.txn)
+	if err != nil {
+		return err
+	}
+	var nn *Node
+	var separator string
+	if child.IsLeaf {
+		nn = NewLeafNode(pageNum, child.Keys[splitIdx:], child.Values[splitIdx:])
+		copy(nn.Overflow, child.Overflow[splitIdx:])
+		copy(nn.Versions, child.Versions[splitIdx:])
+		separator = nn.Keys[0]
+		child.Keys = child.Keys[:splitIdx]
+		child.Values = child.Values[:splitIdx]
+		child.Overflow = child.Overflow[:splitIdx]
+		child.Versions = child.Versions[:splitIdx]
+		t.linkLeaf(op, nn, child.PageNum, child.Next)
+		child.Next = nn.PageNum
+	} else {
+		nn = NewInnerNode(pageNum, child.Keys[splitIdx+1:], child.Children[splitIdx+1:])
+		separator = child.Keys[splitIdx]
+		child.Keys = child.Keys[:splitIdx]
+		child.Children = child.Children[:splitIdx+1]
+	}
+	node.InsertKey(separator, i)
+	node.InsertChild(nn.PageNum, i+1)
+	if err := t.bpm.Set(op.txn, child); err != nil {
+		return err
+	}
+	return t.bpm.Set(op.txn, nn)
 }
 
-func (t *BPlusTree) canMergeWithLeft(i int) bool {
-	return i > 0
+// rebalanceChild borrows entries from a sibling of the underfull child at index i of node until it is no longer
+// underfull. If the sibling cannot spare enough entries the two nodes are merged instead. Since an entry occupies at most
+// a quarter of the capacity, the merged node is guaranteed to fit
+func (t *BPlusTree) rebalanceChild(op *writeOp, node *Node, i int, child *Node) error {
+	if i > 0 {
+		leftChild, err := t.latch(op, node.Children[i-1])
+		if err != nil {
+			return err
+		}
+		for child.Size() < t.minFill() && leftChild.CanLend(len(leftChild.Keys)-1, t.minFill()) {
+			k, v, c, ver := leftChild.RemoveMax()
+			if child.IsLeaf {
+				child.AcceptMaxFromLeftChild(k, v, c, ver)
+			} else {
+				child.AcceptMaxFromLeftChild(node.Keys[i-1], v, c, ver)
+			}
+			node.Keys[i-1] = k
+		}
+		if child.Size() >= t.minFill() {
+			if err := t.bpm.Set(op.txn, leftChild); err != nil {
+				return err
+			}
+			return t.bpm.Set(op.txn, child)
+		}
+		return t.mergeChildren(op, node, i-1, leftChild, child)
+	} else {
+		rightChild, err := t.latch(op, node.Children[i+1])
+		if err != nil {
+			return err
+		}
+		for child.Size() < t.minFill() && rightChild.CanLend(0, t.minFill()) {
+			k, v, c, ver := rightChild.RemoveMin()
+			if child.IsLeaf {
+				child.AcceptMinFromRightChild(k, v, c, ver)
+				node.Keys[i] = rightChild.Keys[0]
+			} else {
+				child.AcceptMinFromRightChild(node.Keys[i], v, c, ver)
+				node.Keys[i] = k
+			}
+		}
+		if child.Size() >= t.minFill() {
+			if err := t.bpm.Set(op.txn, child); err != nil {
+				return err
+			}
+			return t.bpm.Set(op.txn, rightChild)
+		}
+		return t.mergeChildren(op, node, i, child, rightChild)
+	}
 }
 
-func (t *BPlusTree) getMinKey(n *Node) string {
-	if n.IsLeaf {
-		return n.Keys[0]
+// mergeChildren merges rightChild into leftChild, where the two are separated by the key at index i of node
+func (t *BPlusTree) mergeChildren(op *writeOp, node *Node, i int, leftChild, rightChild *Node) error {
+	if leftChild.IsLeaf {
+		leftChild.Keys = append(leftChild.Keys, rightChild.Keys...)
+		leftChild.Values = append(leftChild.Values, rightChild.Values...)
+		leftChild.Overflow = append(leftChild.Overflow, rightChild.Overflow...)
+		leftChild.Versions = append(leftChild.Versions, rightChild.Versions...)
+		leftChild.Next = rightChild.Next
+		if rightChild.Next != 0 {
+			op.setPrev(rightChild.Next, leftChild.PageNum)
+		}
 	} else {
-		return t.getMinKey(t.bpm.Get(n.Children[0]))
+		leftChild.Keys = append(append(leftChild.Keys, node.Keys[i]), rightChild.Keys...)
+		leftChild.Children = append(leftChild.Children, rightChild.Children...)
+	}
+	node.DeleteKey(i)
+	node.DeleteChild(i + 1)
+	if err := t.bpm.Set(op.txn, leftChild); err != nil {
+		return err
+	}
+	t.bpm.DeletePage(op.txn, rightChild.PageNum)
+	return nil
+}
+
+// linkLeaf sets the neighbours of a newly created leaf and points the leaf after it back at it
+func (t *BPlusTree) linkLeaf(op *writeOp, leaf *Node, prev, next int64) {
+	leaf.Prev = prev
+	leaf.Next = next
+	if next != 0 {
+		op.setPrev(next, leaf.PageNum)
 	}
 }
 
+// checkKey returns ErrKeyTooLarge if the key cannot be stored in a node
+func (t *BPlusTree) checkKey(key string) error {
+	if overflowEntrySize(key) > t.maxEntrySize() || internalEntrySize(key) > t.maxEntrySize() {
+		return ErrKeyTooLarge
+	}
+	return nil
+}
+
+// storedEntrySize returns the number of bytes a leaf entry occupies once its value is moved to overflow pages if needed
+func (t *BPlusTree) storedEntrySize(key, value string) int {
+	if t.overflows(key, value) {
+		return overflowEntrySize(key)
+	}
+	return leafEntrySize(key, value)
+}
+
+// overflows returns whether the value of a leaf entry is stored in overflow pages rather than inline
+func (t *BPlusTree) overflows(key, value string) bool {
+	return leafEntrySize(key, value) > t.maxEntrySize()
+}
+
+// minFill is the number of bytes below which a non root node is rebalanced with a sibling
+func (t *BPlusTree) minFill() int {
+	return t.capacity / 4
+}
+
+// maxEntrySize is the largest number of bytes a single entry may occupy in a node
+func (t *BPlusTree) maxEntrySize() int {
+	return t.capacity / 4
+}
+
 func findKeyIndexInLeaf(key string, keys []string) (int, bool) {
 	index, found := binarySearch(key, keys)
 	if found {
@@ -3,7 +3,9 @@ package bplustree
 import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"math/rand"
 	"os"
+	"strings"
 	"sync"
 	"testing"
 )
@@ -20,10 +22,20 @@ func init() {
 	_ = os.RemoveAll(TestDir)
 }
 
+// crash stops the background work of the tree without checkpointing the WAL or closing any file, which leaves the files
+// as they would be had the db crashed. The tree must not be used afterwards
+func crash(bpt *BPlusTree) {
+	if bpt.stopCheckpointer != nil {
+		close(bpt.stopCheckpointer)
+		<-bpt.checkpointerStopped
+	}
+	bpt.bpm.stopBackgroundWriter()
+}
+
 func TestOddCapacityLargeCache(t *testing.T) {
 	// Arrange
 	_ = os.Mkdir(TestDir, 0755)
-	bpt := NewBPlusTree(TestFile, 100, 5)
+	bpt := NewBPlusTree(TestFile, 100, 97)
 	defer func() {_ = os.RemoveAll(TestDir)}()
 	// NOTE: these values were ordered specially to hit all delete and set cases
 	tuplesToInsert := []Pair{{"pp", "pp"}, {"hh", "hh"}, {"n", "n"}, {"x", "x"}, {"jj", "jj"}, {"ff", "ff"}, {"c", "c"}, {"ss", "ss"}, {"mm", "mm"}, {"l", "l"}, {"zz", "zz"}, {"a", "a"}, {"gg", "gg"}, {"j", "j"}, {"u", "u"}, {"ii", "ii"}, {"k", "k"}, {"q", "q"}, {"rr", "rr"}, {"dd", "dd"}, {"v", "v"}, {"nn", "nn"}, {"s", "s"}, {"ee", "ee"}, {"g", "g"}, {"aa", "aa"}, {"xx", "xx"}, {"w", "w"}, {"e", "e"}, {"r", "r"}, {"vv", "vv"}, {"uu", "uu"}, {"i", "i"}, {"oo", "oo"}, {"f", "f"}, {"z", "z"}, {"tt", "tt"}, {"h", "h"}, {"b", "b"}, {"m", "m"}, {"d", "d"}, {"t", "t"}, {"y", "y"}, {"yy", "yy"}, {"cc", "cc"}, {"kk", "kk"}, {"ll", "ll"}, {"p", "p"}, {"ww", "ww"}, {"o", "o"}, {"qq", "qq"}, {"bb", "bb"}}
@@ -37,7 +49,7 @@ func TestOddCapacityLargeCache(t *testing.T) {
 		bpt.ValidateTreeStructure()
 		for i := 0; i <= idx; i++ {
 			p := tuplesToInsert[i]
-			value, present :=  bpt.Get(p.key)
+			value, present, _ :=  bpt.Get(p.key)
 			assert.True(t, present)
 			assert.Equal(t, p.value, value)
 		}
@@ -49,12 +61,12 @@ func TestOddCapacityLargeCache(t *testing.T) {
 		bpt.ValidateTreeStructure()
 		for i := 0; i <= idx; i++ {
 			p := tuplesToDelete[i]
-			_, present :=  bpt.Get(p.key)
+			_, present, _ :=  bpt.Get(p.key)
 			assert.False(t, present)
 		}
 		for i := idx + 1; i < len(tuplesToDelete); i++ {
 			p := tuplesToDelete[i]
-			value, present :=  bpt.Get(p.key)
+			value, present, _ :=  bpt.Get(p.key)
 			assert.True(t, present)
 			assert.Equal(t, p.value, value)
 		}
@@ -64,7 +76,7 @@ func TestOddCapacityLargeCache(t *testing.T) {
 func TestEvenCapacityLargeCache(t *testing.T) {
 	// Arrange
 	_ = os.Mkdir(TestDir, 0755)
-	bpt := NewBPlusTree(TestFile, 100, 4)
+	bpt := NewBPlusTree(TestFile, 100, 96)
 	defer func() {_ = os.RemoveAll(TestDir)}()
 	// NOTE: these values were ordered specially to hit all delete and set cases
 	tuplesToInsert := []Pair{{"d", "d"}, {"nn", "nn"}, {"m", "m"}, {"uu", "uu"}, {"kk", "kk"}, {"s", "s"}, {"t", "t"}, {"jj", "jj"}, {"ff", "ff"}, {"dd", "dd"}, {"x", "x"}, {"ii", "ii"}, {"ww", "ww"}, {"b", "b"}, {"e", "e"}, {"pp", "pp"}, {"l", "l"}, {"gg", "gg"}, {"j", "j"}, {"g", "g"}, {"y", "y"}, {"zz", "zz"}, {"w", "w"}, {"k", "k"}, {"a", "a"}, {"qq", "qq"}, {"hh", "hh"}, {"v", "v"}, {"c", "c"}, {"oo", "oo"}, {"f", "f"}, {"u", "u"}, {"o", "o"}, {"xx", "xx"}, {"q", "q"}, {"i", "i"}, {"ll", "ll"}, {"yy", "yy"}, {"ss", "ss"}, {"ee", "ee"}, {"z", "z"}, {"h", "h"}, {"cc", "cc"}, {"vv", "vv"}, {"aa", "aa"}, {"mm", "mm"}, {"n", "n"}, {"tt", "tt"}, {"r", "r"}, {"p", "p"}, {"bb", "bb"}, {"rr", "rr"},}
@@ -77,7 +89,7 @@ func TestEvenCapacityLargeCache(t *testing.T) {
 		bpt.ValidateTreeStructure()
 		for i := 0; i <= idx; i++ {
 			p := tuplesToInsert[i]
-			value, present :=  bpt.Get(p.key)
+			value, present, _ :=  bpt.Get(p.key)
 			assert.True(t, present)
 			assert.Equal(t, p.value, value)
 		}
@@ -89,12 +101,12 @@ func TestEvenCapacityLargeCache(t *testing.T) {
 		bpt.ValidateTreeStructure()
 		for i := 0; i <= idx; i++ {
 			p := tuplesToDelete[i]
-			_, present :=  bpt.Get(p.key)
+			_, present, _ :=  bpt.Get(p.key)
 			assert.False(t, present)
 		}
 		for i := idx + 1; i < len(tuplesToDelete); i++ {
 			p := tuplesToDelete[i]
-			value, present :=  bpt.Get(p.key)
+			value, present, _ :=  bpt.Get(p.key)
 			assert.True(t, present)
 			assert.Equal(t, p.value, value)
 		}
@@ -104,7 +116,7 @@ func TestEvenCapacityLargeCache(t *testing.T) {
 func TestOddCapacitySmallCache(t *testing.T) {
 	// Arrange
 	_ = os.Mkdir(TestDir, 0755)
-	bpt := NewBPlusTree(TestFile, 1, 5)
+	bpt := NewBPlusTree(TestFile, 1, 97)
 	defer func() {_ = os.RemoveAll(TestDir)}()
 	// NOTE: these values were ordered specially to hit all delete and set cases
 	tuplesToInsert := []Pair{{"pp", "pp"}, {"hh", "hh"}, {"n", "n"}, {"x", "x"}, {"jj", "jj"}, {"ff", "ff"}, {"c", "c"}, {"ss", "ss"}, {"mm", "mm"}, {"l", "l"}, {"zz", "zz"}, {"a", "a"}, {"gg", "gg"}, {"j", "j"}, {"u", "u"}, {"ii", "ii"}, {"k", "k"}, {"q", "q"}, {"rr", "rr"}, {"dd", "dd"}, {"v", "v"}, {"nn", "nn"}, {"s", "s"}, {"ee", "ee"}, {"g", "g"}, {"aa", "aa"}, {"xx", "xx"}, {"w", "w"}, {"e", "e"}, {"r", "r"}, {"vv", "vv"}, {"uu", "uu"}, {"i", "i"}, {"oo", "oo"}, {"f", "f"}, {"z", "z"}, {"tt", "tt"}, {"h", "h"}, {"b", "b"}, {"m", "m"}, {"d", "d"}, {"t", "t"}, {"y", "y"}, {"yy", "yy"}, {"cc", "cc"}, {"kk", "kk"}, {"ll", "ll"}, {"p", "p"}, {"ww", "ww"}, {"o", "o"}, {"qq", "qq"}, {"bb", "bb"}}
@@ -118,7 +130,7 @@ func TestOddCapacitySmallCache(t *testing.T) {
 		bpt.ValidateTreeStructure()
 		for i := 0; i <= idx; i++ {
 			p := tuplesToInsert[i]
-			value, present :=  bpt.Get(p.key)
+			value, present, _ :=  bpt.Get(p.key)
 			assert.True(t, present)
 			assert.Equal(t, p.value, value)
 		}
@@ -130,12 +142,12 @@ func TestOddCapacitySmallCache(t *testing.T) {
 		bpt.ValidateTreeStructure()
 		for i := 0; i <= idx; i++ {
 			p := tuplesToDelete[i]
-			_, present :=  bpt.Get(p.key)
+			_, present, _ :=  bpt.Get(p.key)
 			assert.False(t, present)
 		}
 		for i := idx + 1; i < len(tuplesToDelete); i++ {
 			p := tuplesToDelete[i]
-			value, present :=  bpt.Get(p.key)
+			value, present, _ :=  bpt.Get(p.key)
 			assert.True(t, present)
 			assert.Equal(t, p.value, value)
 		}
@@ -145,7 +157,7 @@ func TestOddCapacitySmallCache(t *testing.T) {
 func TestEvenCapacitySmallCache(t *testing.T) {
 	// Arrange
 	_ = os.Mkdir(TestDir, 0755)
-	bpt := NewBPlusTree(TestFile, 1, 4)
+	bpt := NewBPlusTree(TestFile, 1, 96)
 	defer func() {_ = os.RemoveAll(TestDir)}()
 	// NOTE: these values were ordered specially to hit all delete and set cases
 	tuplesToInsert := []Pair{{"d", "d"}, {"nn", "nn"}, {"m", "m"}, {"uu", "uu"}, {"kk", "kk"}, {"s", "s"}, {"t", "t"}, {"jj", "jj"}, {"ff", "ff"}, {"dd", "dd"}, {"x", "x"}, {"ii", "ii"}, {"ww", "ww"}, {"b", "b"}, {"e", "e"}, {"pp", "pp"}, {"l", "l"}, {"gg", "gg"}, {"j", "j"}, {"g", "g"}, {"y", "y"}, {"zz", "zz"}, {"w", "w"}, {"k", "k"}, {"a", "a"}, {"qq", "qq"}, {"hh", "hh"}, {"v", "v"}, {"c", "c"}, {"oo", "oo"}, {"f", "f"}, {"u", "u"}, {"o", "o"}, {"xx", "xx"}, {"q", "q"}, {"i", "i"}, {"ll", "ll"}, {"yy", "yy"}, {"ss", "ss"}, {"ee", "ee"}, {"z", "z"}, {"h", "h"}, {"cc", "cc"}, {"vv", "vv"}, {"aa", "aa"}, {"mm", "mm"}, {"n", "n"}, {"tt", "tt"}, {"r", "r"}, {"p", "p"}, {"bb", "bb"}, {"rr", "rr"},}
@@ -158,7 +170,7 @@ func TestEvenCapacitySmallCache(t *testing.T) {
 		bpt.ValidateTreeStructure()
 		for i := 0; i <= idx; i++ {
 			p := tuplesToInsert[i]
-			value, present :=  bpt.Get(p.key)
+			value, present, _ :=  bpt.Get(p.key)
 			assert.True(t, present)
 			assert.Equal(t, p.value, value)
 		}
@@ -170,12 +182,12 @@ func TestEvenCapacitySmallCache(t *testing.T) {
 		bpt.ValidateTreeStructure()
 		for i := 0; i <= idx; i++ {
 			p := tuplesToDelete[i]
-			_, present :=  bpt.Get(p.key)
+			_, present, _ :=  bpt.Get(p.key)
 			assert.False(t, present)
 		}
 		for i := idx + 1; i < len(tuplesToDelete); i++ {
 			p := tuplesToDelete[i]
-			value, present :=  bpt.Get(p.key)
+			value, present, _ :=  bpt.Get(p.key)
 			assert.True(t, present)
 			assert.Equal(t, p.value, value)
 		}
@@ -185,7 +197,7 @@ func TestEvenCapacitySmallCache(t *testing.T) {
 func TestDeleteKeyThatDoesNotExist(t *testing.T) {
 	// Arrange
 	_ = os.Mkdir(TestDir, 0755)
-	bpt := NewBPlusTree(TestFile, 1, 4)
+	bpt := NewBPlusTree(TestFile, 1, 96)
 	defer func() {_ = os.RemoveAll(TestDir)}()
 	// seed the bplus tree with some data
 	tuples := []Pair{{"a", "a"}, {"b", "b"}, {"c", "c"}, {"d", "d"}, {"e", "e"}}
@@ -198,7 +210,7 @@ func TestDeleteKeyThatDoesNotExist(t *testing.T) {
 	bpt.ValidateTreeStructure()
 	// verify other values still exist
 	for _, pair := range tuples {
-		value, present :=  bpt.Get(pair.key)
+		value, present, _ :=  bpt.Get(pair.key)
 		assert.True(t, present)
 		assert.Equal(t, pair.value, value)
 	}
@@ -207,7 +219,7 @@ func TestDeleteKeyThatDoesNotExist(t *testing.T) {
 func TestGetKeyThatDoesNotExist(t *testing.T) {
 	// Arrange
 	_ = os.Mkdir(TestDir, 0755)
-	bpt := NewBPlusTree(TestFile, 1, 4)
+	bpt := NewBPlusTree(TestFile, 1, 96)
 	defer func() {_ = os.RemoveAll(TestDir)}()
 	// seed the bplus tree with some data
 	tuples := []Pair{{"a", "a"}, {"b", "b"}, {"c", "c"}, {"d", "d"}, {"e", "e"}}
@@ -216,7 +228,7 @@ func TestGetKeyThatDoesNotExist(t *testing.T) {
 	}
 
 	// Act/Assert
-	_, present :=  bpt.Get("f")
+	_, present, _ :=  bpt.Get("f")
 	assert.False(t, present)
 	bpt.ValidateTreeStructure()
 }
@@ -224,7 +236,7 @@ func TestGetKeyThatDoesNotExist(t *testing.T) {
 func TestSetKeyThatAlreadyExists(t *testing.T) {
 	// Arrange
 	_ = os.Mkdir(TestDir, 0755)
-	bpt := NewBPlusTree(TestFile, 1, 4)
+	bpt := NewBPlusTree(TestFile, 1, 96)
 	defer func() {_ = os.RemoveAll(TestDir)}()
 	// seed the bplus tree with some data
 	tuples := []Pair{{"a", "a"}, {"b", "b"}, {"c", "c"}, {"d", "d"}, {"e", "e"}}
@@ -236,7 +248,7 @@ func TestSetKeyThatAlreadyExists(t *testing.T) {
 	bpt.Set("a", "a-new")
 	bpt.ValidateTreeStructure()
 	for _, pair := range tuples {
-		value, present :=  bpt.Get(pair.key)
+		value, present, _ :=  bpt.Get(pair.key)
 		assert.True(t, present)
 		if pair.key == "a" {
 			assert.Equal(t, "a-new", value)
@@ -249,7 +261,7 @@ func TestSetKeyThatAlreadyExists(t *testing.T) {
 func TestOddCapacityLargeCacheRebootAfterCrash(t *testing.T) {
 	// Arrange
 	_ = os.Mkdir(TestDir, 0755)
-	bpt := NewBPlusTree(TestFile, 100, 5)
+	bpt := NewBPlusTree(TestFile, 100, 97)
 	defer func() {_ = os.RemoveAll(TestDir)}()
 	// NOTE: these values were ordered specially to hit all delete and set cases
 	tuplesToInsert := []Pair{{"pp", "pp"}, {"hh", "hh"}, {"n", "n"}, {"x", "x"}, {"jj", "jj"}, {"ff", "ff"}, {"c", "c"}, {"ss", "ss"}, {"mm", "mm"}, {"l", "l"}, {"zz", "zz"}, {"a", "a"}, {"gg", "gg"}, {"j", "j"}, {"u", "u"}, {"ii", "ii"}, {"k", "k"}, {"q", "q"}, {"rr", "rr"}, {"dd", "dd"}, {"v", "v"}, {"nn", "nn"}, {"s", "s"}, {"ee", "ee"}, {"g", "g"}, {"aa", "aa"}, {"xx", "xx"}, {"w", "w"}, {"e", "e"}, {"r", "r"}, {"vv", "vv"}, {"uu", "uu"}, {"i", "i"}, {"oo", "oo"}, {"f", "f"}, {"z", "z"}, {"tt", "tt"}, {"h", "h"}, {"b", "b"}, {"m", "m"}, {"d", "d"}, {"t", "t"}, {"y", "y"}, {"yy", "yy"}, {"cc", "cc"}, {"kk", "kk"}, {"ll", "ll"}, {"p", "p"}, {"ww", "ww"}, {"o", "o"}, {"qq", "qq"}, {"bb", "bb"}}
@@ -263,13 +275,15 @@ func TestOddCapacityLargeCacheRebootAfterCrash(t *testing.T) {
 		bpt.ValidateTreeStructure()
 		for i := 0; i <= idx; i++ {
 			p := tuplesToInsert[i]
-			value, present :=  bpt.Get(p.key)
+			value, present, _ :=  bpt.Get(p.key)
 			assert.True(t, present)
 			assert.Equal(t, p.value, value)
 		}
 
+		crash(&bpt)
+
 		// creating a new btree simulates recovering from a crash
-		bpt = NewBPlusTree(TestFile, 100, 5)
+		bpt = NewBPlusTree(TestFile, 100, 97)
 	}
 
 	// delete all tuples
@@ -278,25 +292,27 @@ func TestOddCapacityLargeCacheRebootAfterCrash(t *testing.T) {
 		bpt.ValidateTreeStructure()
 		for i := 0; i <= idx; i++ {
 			p := tuplesToDelete[i]
-			_, present :=  bpt.Get(p.key)
+			_, present, _ :=  bpt.Get(p.key)
 			assert.False(t, present)
 		}
 		for i := idx + 1; i < len(tuplesToDelete); i++ {
 			p := tuplesToDelete[i]
-			value, present :=  bpt.Get(p.key)
+			value, present, _ :=  bpt.Get(p.key)
 			assert.True(t, present)
 			assert.Equal(t, p.value, value)
 		}
 
+		crash(&bpt)
+
 		// creating a new btree simulates recovering from a crash
-		bpt = NewBPlusTree(TestFile, 100, 5)
+		bpt = NewBPlusTree(TestFile, 100, 97)
 	}
 }
 
 func TestEvenCapacityLargeCacheRebootAfterCrash(t *testing.T) {
 	// Arrange
 	_ = os.Mkdir(TestDir, 0755)
-	bpt := NewBPlusTree(TestFile, 100, 4)
+	bpt := NewBPlusTree(TestFile, 100, 96)
 	defer func() {_ = os.RemoveAll(TestDir)}()
 	// NOTE: these values were ordered specially to hit all delete and set cases
 	tuplesToInsert := []Pair{{"d", "d"}, {"nn", "nn"}, {"m", "m"}, {"uu", "uu"}, {"kk", "kk"}, {"s", "s"}, {"t", "t"}, {"jj", "jj"}, {"ff", "ff"}, {"dd", "dd"}, {"x", "x"}, {"ii", "ii"}, {"ww", "ww"}, {"b", "b"}, {"e", "e"}, {"pp", "pp"}, {"l", "l"}, {"gg", "gg"}, {"j", "j"}, {"g", "g"}, {"y", "y"}, {"zz", "zz"}, {"w", "w"}, {"k", "k"}, {"a", "a"}, {"qq", "qq"}, {"hh", "hh"}, {"v", "v"}, {"c", "c"}, {"oo", "oo"}, {"f", "f"}, {"u", "u"}, {"o", "o"}, {"xx", "xx"}, {"q", "q"}, {"i", "i"}, {"ll", "ll"}, {"yy", "yy"}, {"ss", "ss"}, {"ee", "ee"}, {"z", "z"}, {"h", "h"}, {"cc", "cc"}, {"vv", "vv"}, {"aa", "aa"}, {"mm", "mm"}, {"n", "n"}, {"tt", "tt"}, {"r", "r"}, {"p", "p"}, {"bb", "bb"}, {"rr", "rr"},}
@@ -309,13 +325,15 @@ func TestEvenCapacityLargeCacheRebootAfterCrash(t *testing.T) {
 		bpt.ValidateTreeStructure()
 		for i := 0; i <= idx; i++ {
 			p := tuplesToInsert[i]
-			value, present :=  bpt.Get(p.key)
+			value, present, _ :=  bpt.Get(p.key)
 			assert.True(t, present)
 			assert.Equal(t, p.value, value)
 		}
 
+		crash(&bpt)
+
 		// creating a new btree simulates recovering from a crash
-		bpt = NewBPlusTree(TestFile, 100, 4)
+		bpt = NewBPlusTree(TestFile, 100, 96)
 	}
 
 	// delete all tuples
@@ -324,25 +342,27 @@ func TestEvenCapacityLargeCacheRebootAfterCrash(t *testing.T) {
 		bpt.ValidateTreeStructure()
 		for i := 0; i <= idx; i++ {
 			p := tuplesToDelete[i]
-			_, present :=  bpt.Get(p.key)
+			_, present, _ :=  bpt.Get(p.key)
 			assert.False(t, present)
 		}
 		for i := idx + 1; i < len(tuplesToDelete); i++ {
 			p := tuplesToDelete[i]
-			value, present :=  bpt.Get(p.key)
+			value, present, _ :=  bpt.Get(p.key)
 			assert.True(t, present)
 			assert.Equal(t, p.value, value)
 		}
 
+		crash(&bpt)
+
 		// creating a new btree simulates recovering from a crash
-		bpt = NewBPlusTree(TestFile, 100, 4)
+		bpt = NewBPlusTree(TestFile, 100, 96)
 	}
 }
 
 func TestOddCapacitySmallCacheRebootAfterCrash(t *testing.T) {
 	// Arrange
 	_ = os.Mkdir(TestDir, 0755)
-	bpt := NewBPlusTree(TestFile, 1, 5)
+	bpt := NewBPlusTree(TestFile, 1, 97)
 	defer func() {_ = os.RemoveAll(TestDir)}()
 	// NOTE: these values were ordered specially to hit all delete and set cases
 	tuplesToInsert := []Pair{{"pp", "pp"}, {"hh", "hh"}, {"n", "n"}, {"x", "x"}, {"jj", "jj"}, {"ff", "ff"}, {"c", "c"}, {"ss", "ss"}, {"mm", "mm"}, {"l", "l"}, {"zz", "zz"}, {"a", "a"}, {"gg", "gg"}, {"j", "j"}, {"u", "u"}, {"ii", "ii"}, {"k", "k"}, {"q", "q"}, {"rr", "rr"}, {"dd", "dd"}, {"v", "v"}, {"nn", "nn"}, {"s", "s"}, {"ee", "ee"}, {"g", "g"}, {"aa", "aa"}, {"xx", "xx"}, {"w", "w"}, {"e", "e"}, {"r", "r"}, {"vv", "vv"}, {"uu", "uu"}, {"i", "i"}, {"oo", "oo"}, {"f", "f"}, {"z", "z"}, {"tt", "tt"}, {"h", "h"}, {"b", "b"}, {"m", "m"}, {"d", "d"}, {"t", "t"}, {"y", "y"}, {"yy", "yy"}, {"cc", "cc"}, {"kk", "kk"}, {"ll", "ll"}, {"p", "p"}, {"ww", "ww"}, {"o", "o"}, {"qq", "qq"}, {"bb", "bb"}}
@@ -356,13 +376,15 @@ func TestOddCapacitySmallCacheRebootAfterCrash(t *testing.T) {
 		bpt.ValidateTreeStructure()
 		for i := 0; i <= idx; i++ {
 			p := tuplesToInsert[i]
-			value, present :=  bpt.Get(p.key)
+			value, present, _ :=  bpt.Get(p.key)
 			assert.True(t, present)
 			assert.Equal(t, p.value, value)
 		}
 
+		crash(&bpt)
+
 		// creating a new btree simulates recovering from a crash
-		bpt = NewBPlusTree(TestFile, 1, 5)
+		bpt = NewBPlusTree(TestFile, 1, 97)
 	}
 
 	// delete all tuples
@@ -371,25 +393,27 @@ func TestOddCapacitySmallCacheRebootAfterCrash(t *testing.T) {
 		bpt.ValidateTreeStructure()
 		for i := 0; i <= idx; i++ {
 			p := tuplesToDelete[i]
-			_, present :=  bpt.Get(p.key)
+			_, present, _ :=  bpt.Get(p.key)
 			assert.False(t, present)
 		}
 		for i := idx + 1; i < len(tuplesToDelete); i++ {
 			p := tuplesToDelete[i]
-			value, present :=  bpt.Get(p.key)
+			value, present, _ :=  bpt.Get(p.key)
 			assert.True(t, present)
 			assert.Equal(t, p.value, value)
 		}
 
+		crash(&bpt)
+
 		// creating a new btree simulates recovering from a crash
-		bpt = NewBPlusTree(TestFile, 1, 5)
+		bpt = NewBPlusTree(TestFile, 1, 97)
 	}
 }
 
 func TestEvenCapacitySmallCacheRebootAfterCrash(t *testing.T) {
 	// Arrange
 	_ = os.Mkdir(TestDir, 0755)
-	bpt := NewBPlusTree(TestFile, 1, 4)
+	bpt := NewBPlusTree(TestFile, 1, 96)
 	defer func() {_ = os.RemoveAll(TestDir)}()
 	// NOTE: these values were ordered specially to hit all delete and set cases
 	tuplesToInsert := []Pair{{"d", "d"}, {"nn", "nn"}, {"m", "m"}, {"uu", "uu"}, {"kk", "kk"}, {"s", "s"}, {"t", "t"}, {"jj", "jj"}, {"ff", "ff"}, {"dd", "dd"}, {"x", "x"}, {"ii", "ii"}, {"ww", "ww"}, {"b", "b"}, {"e", "e"}, {"pp", "pp"}, {"l", "l"}, {"gg", "gg"}, {"j", "j"}, {"g", "g"}, {"y", "y"}, {"zz", "zz"}, {"w", "w"}, {"k", "k"}, {"a", "a"}, {"qq", "qq"}, {"hh", "hh"}, {"v", "v"}, {"c", "c"}, {"oo", "oo"}, {"f", "f"}, {"u", "u"}, {"o", "o"}, {"xx", "xx"}, {"q", "q"}, {"i", "i"}, {"ll", "ll"}, {"yy", "yy"}, {"ss", "ss"}, {"ee", "ee"}, {"z", "z"}, {"h", "h"}, {"cc", "cc"}, {"vv", "vv"}, {"aa", "aa"}, {"mm", "mm"}, {"n", "n"}, {"tt", "tt"}, {"r", "r"}, {"p", "p"}, {"bb", "bb"}, {"rr", "rr"},}
@@ -402,13 +426,15 @@ func TestEvenCapacitySmallCacheRebootAfterCrash(t *testing.T) {
 		bpt.ValidateTreeStructure()
 		for i := 0; i <= idx; i++ {
 			p := tuplesToInsert[i]
-			value, present :=  bpt.Get(p.key)
+			value, present, _ :=  bpt.Get(p.key)
 			assert.True(t, present)
 			assert.Equal(t, p.value, value)
 		}
 
+		crash(&bpt)
+
 		// creating a new btree simulates recovering from a crash
-		bpt = NewBPlusTree(TestFile, 1, 4)
+		bpt = NewBPlusTree(TestFile, 1, 96)
 	}
 
 	// delete all tuples
@@ -417,18 +443,20 @@ func TestEvenCapacitySmallCacheRebootAfterCrash(t *testing.T) {
 		bpt.ValidateTreeStructure()
 		for i := 0; i <= idx; i++ {
 			p := tuplesToDelete[i]
-			_, present :=  bpt.Get(p.key)
+			_, present, _ :=  bpt.Get(p.key)
 			assert.False(t, present)
 		}
 		for i := idx + 1; i < len(tuplesToDelete); i++ {
 			p := tuplesToDelete[i]
-			value, present :=  bpt.Get(p.key)
+			value, present, _ :=  bpt.Get(p.key)
 			assert.True(t, present)
 			assert.Equal(t, p.value, value)
 		}
 
+		crash(&bpt)
+
 		// creating a new btree simulates recovering from a crash
-		bpt = NewBPlusTree(TestFile, 1, 4)
+		bpt = NewBPlusTree(TestFile, 1, 96)
 	}
 }
 
@@ -478,7 +506,7 @@ func TestLargeAmountOfEntriesWithDefaultConfig(t *testing.T) {
 			bpt.ValidateTreeStructure()
 			for i := 0; i <= idx; i++ {
 				p := tuplesToInsert[i]
-				value, present :=  bpt.Get(p.key)
+				value, present, _ :=  bpt.Get(p.key)
 				assert.True(t, present)
 				assert.Equal(t, p.value, value)
 			}
@@ -486,6 +514,7 @@ func TestLargeAmountOfEntriesWithDefaultConfig(t *testing.T) {
 
 		if i % 3 == 0 {
 			// restart the bpt on one of the iterations. Number was chosen randomly
+			crash(&bpt)
 			bpt = NewBPlusTree(TestFile, 64, -1)
 		}
 
@@ -495,12 +524,12 @@ func TestLargeAmountOfEntriesWithDefaultConfig(t *testing.T) {
 			bpt.ValidateTreeStructure()
 			for i := 0; i <= idx; i++ {
 				p := tuplesToDelete[i]
-				_, present :=  bpt.Get(p.key)
+				_, present, _ :=  bpt.Get(p.key)
 				assert.False(t, present)
 			}
 			for i := idx + 1; i < len(tuplesToDelete); i++ {
 				p := tuplesToDelete[i]
-				value, present :=  bpt.Get(p.key)
+				value, present, _ :=  bpt.Get(p.key)
 				assert.True(t, present)
 				assert.Equal(t, p.value, value)
 			}
@@ -508,6 +537,7 @@ func TestLargeAmountOfEntriesWithDefaultConfig(t *testing.T) {
 
 		if i % 2 == 0 {
 			// restart the bpt on one of the iterations. Number was chosen randomly
+			crash(&bpt)
 			bpt = NewBPlusTree(TestFile, 64, -1)
 		}
 	}
@@ -516,7 +546,7 @@ func TestLargeAmountOfEntriesWithDefaultConfig(t *testing.T) {
 func TestConcurrentAccessSmallCache(t *testing.T) {
 	// Arrange
 	_ = os.Mkdir(TestDir, 0755)
-	bpt := NewBPlusTree(TestFile, 1, 4)
+	bpt := NewBPlusTree(TestFile, 1, 104)
 	defer func() {_ = os.RemoveAll(TestDir)}()
 	// NOTE: these values were ordered specially to hit all delete and set cases
 	templateTuplesToInsert := []Pair{{"d", "d"}, {"nn", "nn"}, {"m", "m"}, {"uu", "uu"}, {"kk", "kk"}, {"s", "s"}, {"t", "t"}, {"jj", "jj"}, {"ff", "ff"}, {"dd", "dd"}, {"x", "x"}, {"ii", "ii"}, {"ww", "ww"}, {"b", "b"}, {"e", "e"}, {"pp", "pp"}, {"l", "l"}, {"gg", "gg"}, {"j", "j"}, {"g", "g"}, {"y", "y"}, {"zz", "zz"}, {"w", "w"}, {"k", "k"}, {"a", "a"}, {"qq", "qq"}, {"hh", "hh"}, {"v", "v"}, {"c", "c"}, {"oo", "oo"}, {"f", "f"}, {"u", "u"}, {"o", "o"}, {"xx", "xx"}, {"q", "q"}, {"i", "i"}, {"ll", "ll"}, {"yy", "yy"}, {"ss", "ss"}, {"ee", "ee"}, {"z", "z"}, {"h", "h"}, {"cc", "cc"}, {"vv", "vv"}, {"aa", "aa"}, {"mm", "mm"}, {"n", "n"}, {"tt", "tt"}, {"r", "r"}, {"p", "p"}, {"bb", "bb"}, {"rr", "rr"},}
@@ -548,7 +578,7 @@ func TestConcurrentAccessSmallCache(t *testing.T) {
 				bpt.ValidateTreeStructure()
 				for i := 0; i <= idx; i++ {
 					p := tuplesToInsert[i]
-					value, present :=  bpt.Get(p.key)
+					value, present, _ :=  bpt.Get(p.key)
 					assert.True(t, present)
 					assert.Equal(t, p.value, value)
 				}
@@ -560,12 +590,12 @@ func TestConcurrentAccessSmallCache(t *testing.T) {
 				bpt.ValidateTreeStructure()
 				for i := 0; i <= idx; i++ {
 					p := tuplesToDelete[i]
-					_, present :=  bpt.Get(p.key)
+					_, present, _ :=  bpt.Get(p.key)
 					assert.False(t, present)
 				}
 				for i := idx + 1; i < len(tuplesToDelete); i++ {
 					p := tuplesToDelete[i]
-					value, present :=  bpt.Get(p.key)
+					value, present, _ :=  bpt.Get(p.key)
 					assert.True(t, present)
 					assert.Equal(t, p.value, value)
 				}
@@ -578,7 +608,7 @@ func TestConcurrentAccessSmallCache(t *testing.T) {
 func TestConcurrentAccessLargeCache(t *testing.T) {
 	// Arrange
 	_ = os.Mkdir(TestDir, 0755)
-	bpt := NewBPlusTree(TestFile, 500, 4)
+	bpt := NewBPlusTree(TestFile, 500, 104)
 	defer func() {_ = os.RemoveAll(TestDir)}()
 	// NOTE: these values were ordered specially to hit all delete and set cases
 	templateTuplesToInsert := []Pair{{"d", "d"}, {"nn", "nn"}, {"m", "m"}, {"uu", "uu"}, {"kk", "kk"}, {"s", "s"}, {"t", "t"}, {"jj", "jj"}, {"ff", "ff"}, {"dd", "dd"}, {"x", "x"}, {"ii", "ii"}, {"ww", "ww"}, {"b", "b"}, {"e", "e"}, {"pp", "pp"}, {"l", "l"}, {"gg", "gg"}, {"j", "j"}, {"g", "g"}, {"y", "y"}, {"zz", "zz"}, {"w", "w"}, {"k", "k"}, {"a", "a"}, {"qq", "qq"}, {"hh", "hh"}, {"v", "v"}, {"c", "c"}, {"oo", "oo"}, {"f", "f"}, {"u", "u"}, {"o", "o"}, {"xx", "xx"}, {"q", "q"}, {"i", "i"}, {"ll", "ll"}, {"yy", "yy"}, {"ss", "ss"}, {"ee", "ee"}, {"z", "z"}, {"h", "h"}, {"cc", "cc"}, {"vv", "vv"}, {"aa", "aa"}, {"mm", "mm"}, {"n", "n"}, {"tt", "tt"}, {"r", "r"}, {"p", "p"}, {"bb", "bb"}, {"rr", "rr"},}
@@ -610,7 +640,7 @@ func TestConcurrentAccessLargeCache(t *testing.T) {
 				bpt.ValidateTreeStructure()
 				for i := 0; i <= idx; i++ {
 					p := tuplesToInsert[i]
-					value, present :=  bpt.Get(p.key)
+					value, present, _ :=  bpt.Get(p.key)
 					assert.True(t, present)
 					assert.Equal(t, p.value, value)
 				}
@@ -622,12 +652,12 @@ func TestConcurrentAccessLargeCache(t *testing.T) {
 				bpt.ValidateTreeStructure()
 				for i := 0; i <= idx; i++ {
 					p := tuplesToDelete[i]
-					_, present :=  bpt.Get(p.key)
+					_, present, _ :=  bpt.Get(p.key)
 					assert.False(t, present)
 				}
 				for i := idx + 1; i < len(tuplesToDelete); i++ {
 					p := tuplesToDelete[i]
-					value, present :=  bpt.Get(p.key)
+					value, present, _ :=  bpt.Get(p.key)
 					assert.True(t, present)
 					assert.Equal(t, p.value, value)
 				}
@@ -636,3 +666,117 @@ func TestConcurrentAccessLargeCache(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestVariableLengthKeysAndValues(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 16, -1)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	rnd := rand.New(rand.NewSource(1))
+	tuples := make([]Pair, 0)
+	for i := 0; i < 500; i++ {
+		key := fmt.Sprintf("%08x-%04x-%04x-%04x-%012x", rnd.Uint32(), rnd.Intn(1<<16), rnd.Intn(1<<16), rnd.Intn(1<<16), rnd.Int63n(1<<48))
+		value := fmt.Sprintf(`{"id":%d,"name":"%s"}`, i, strings.Repeat("x", rnd.Intn(500)))
+		tuples = append(tuples, Pair{key, value})
+	}
+
+	// Act/Assert
+	for _, pair := range tuples {
+		assert.NoError(t, bpt.Set(pair.key, pair.value))
+	}
+	bpt.ValidateTreeStructure()
+
+	crash(&bpt)
+
+	// creating a new btree simulates recovering from a crash
+	bpt = NewBPlusTree(TestFile, 16, -1)
+	for _, pair := range tuples {
+		value, present, _ := bpt.Get(pair.key)
+		assert.True(t, present)
+		assert.Equal(t, pair.value, value)
+	}
+
+	// overwrite values with values of a different length, shrinking some nodes and growing others
+	for idx, pair := range tuples {
+		tuples[idx].value = strings.Repeat("y", rnd.Intn(900))
+		assert.NoError(t, bpt.Set(pair.key, tuples[idx].value))
+	}
+	bpt.ValidateTreeStructure()
+
+	for idx, pair := range tuples {
+		if idx%2 == 0 {
+			bpt.Delete(pair.key)
+		}
+	}
+	bpt.ValidateTreeStructure()
+	for idx, pair := range tuples {
+		value, present, _ := bpt.Get(pair.key)
+		assert.Equal(t, idx%2 != 0, present)
+		if present {
+			assert.Equal(t, pair.value, value)
+		}
+	}
+}
+
+func TestSetKeyTooLarge(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 16, -1)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	key := strings.Repeat("a", PageSize/4)
+
+	// Act
+	err := bpt.Set(key, "a")
+
+	// Assert
+	assert.Equal(t, ErrKeyTooLarge, err)
+	_, present, _ := bpt.Get(key)
+	assert.False(t, present)
+	bpt.ValidateTreeStructure()
+}
+
+func TestLargeValuesAreStoredInOverflowPages(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 16, -1)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	small := strings.Repeat("s", PageSize/8)
+	large := strings.Repeat("0123456789", 3*1024*1024/10)
+	medium := strings.Repeat("m", 3*PageSize)
+
+	// Act/Assert
+	assert.NoError(t, bpt.Set("small", small))
+	assert.NoError(t, bpt.Set("large", large))
+	bpt.ValidateTreeStructure()
+	value, present, _ := bpt.Get("large")
+	assert.True(t, present)
+	assert.Equal(t, large, value)
+
+	crash(&bpt)
+
+	// creating a new btree simulates recovering from a crash
+	bpt = NewBPlusTree(TestFile, 16, -1)
+	value, present, _ = bpt.Get("large")
+	assert.True(t, present)
+	assert.Equal(t, large, value)
+	value, present, _ = bpt.Get("small")
+	assert.True(t, present)
+	assert.Equal(t, small, value)
+
+	// replacing and deleting the value returns its overflow pages to the free list once the write commits, so storing
+	// it again does not grow the db file
+	assert.NoError(t, bpt.Set("large", medium))
+	value, _, _ = bpt.Get("large")
+	assert.Equal(t, medium, value)
+	fi, _ := os.Stat(TestFile + ".db")
+	sizeBefore := fi.Size()
+	bpt.Delete("large")
+	_, present, _ = bpt.Get("large")
+	assert.False(t, present)
+	assert.NoError(t, bpt.Set("large2", large))
+	fi, _ = os.Stat(TestFile + ".db")
+	assert.Equal(t, sizeBefore, fi.Size())
+	value, _, _ = bpt.Get("large2")
+	assert.Equal(t, large, value)
+	bpt.ValidateTreeStructure()
+}
@@ -2,16 +2,18 @@ package bplustree
 
 import (
 	"fios-db/src/serialization"
-	"github.com/hashicorp/golang-lru"
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"sync"
 )
 
 // Metadata page structure
 // +-----------------------------+
 // + rootPage (8 bytes)          +
 // + freeListStartPage (8 bytes) +
+// + versionClock (8 bytes)      +
 // +                             +
 // +-----------------------------+
 
@@ -22,21 +24,67 @@ import (
 // +                                             +
 // +---------------------------------------------+
 
+// Internal and leaf nodes are stored in slotted pages. The slot array grows from the front of the page while the cells
+// it points at are packed from the back of the page, so keys and values may be of any length as long as the node
+// fits within the page.
+
 // Internal node page structure
 // +---------------------------------------------+
 // + pageType (2 bytes)                          +
 // + numKeys  (2 bytes)                          +
-// + keys (numKeys * 8 bytes)                    +
-// + children ((numKeys+1) * 8 bytes)            +
+// + firstChild (8 bytes)                        +
+// + slots (numKeys * 2 bytes)                   +
+// +                                             +
+// + free space                                  +
 // +                                             +
+// + cells (numKeys * variable bytes)            +
+// +---------------------------------------------+
+
+// Internal node cell structure
+// +---------------------------------------------+
+// + keyLen (2 bytes)                            +
+// + child to the right of the key (8 bytes)     +
+// + key (keyLen bytes)                          +
 // +---------------------------------------------+
 
 // Leaf node page structure
 // +---------------------------------------------+
 // + pageType (2 bytes)                          +
 // + numKeys  (2 bytes)                          +
-// + keys (numKeys * 8 bytes)                    +
-// + value (numKeys * 8 bytes)                   +
+// + prevLeaf (8 bytes, 0 if first leaf)         +
+// + nextLeaf (8 bytes, 0 if last leaf)          +
+// + slots (numKeys * 2 bytes)                   +
+// +                                             +
+// + free space                                  +
+// +                                             +
+// + cells (numKeys * variable bytes)            +
+// +---------------------------------------------+
+
+// Leaf node cell structure
+// +---------------------------------------------+
+// + keyLen (2 bytes)                            +
+// + valueLen (2 bytes)                          +
+// + version (8 bytes)                           +
+// + key (keyLen bytes)                          +
+// + value (valueLen bytes)                      +
+// +---------------------------------------------+
+
+// Leaf node cell structure for values stored in overflow pages
+// +---------------------------------------------+
+// + keyLen (2 bytes)                            +
+// + valueLen (2 bytes, always -1)               +
+// + version (8 bytes)                           +
+// + key (keyLen bytes)                          +
+// + firstOverflowPage (8 bytes)                 +
+// +---------------------------------------------+
+
+// Overflow page structure. Values too large to be stored inline are split
+// across a chain of overflow pages
+// +---------------------------------------------+
+// + pageType (2 bytes)                          +
+// + nextOverflowPage (8 bytes, -1 if last)      +
+// + dataLen (2 bytes)                           +
+// + data (dataLen bytes)                        +
 // +                                             +
 // +---------------------------------------------+
 
@@ -45,91 +93,226 @@ type PageType int16
 const INTERNAL PageType = 1
 const LEAF PageType = 2
 const FREE PageType = 3
+const OVERFLOW PageType = 4
+
+func (pageType PageType) String() string {
+	switch pageType {
+	case INTERNAL:
+		return "INTERNAL"
+	case LEAF:
+		return "LEAF"
+	case FREE:
+		return "FREE"
+	case OVERFLOW:
+		return "OVERFLOW"
+	}
+	return fmt.Sprintf("PageType(%d)", int16(pageType))
+}
 
+// A BufferPoolManager is safe for concurrent use. Callers latch the pages they read or write through Latch, while the
+// WAL, the metadata and the size of the db file are guarded internally
 type BufferPoolManager struct {
-	cache         *lru.Cache
-	dbFile        *os.File
-	wal           *WAL
-	rootPageNum   int64
-	freePageStart int64
+	mu                     sync.Mutex // guards the WAL, the metadata and the size of the db file
+	pool                   *bufferPool
+	writeMu                sync.Mutex // serializes writes of dirty pages to the db file, taken after mu
+	dbFile                 *os.File
+	wal                    *WAL
+	rootPageNum            int64
+	committedRootPageNum   int64 // root as of the last commit, which is the root stored in the metadata page
+	freePageStart          int64
+	committedFreePageStart int64                   // start of the free list as of the last commit
+	size                   int64                   // size of the db file
+	committedSize          int64                   // size of the db file as of the last commit
+	versionClock           int64                   // highest version of a deleted key, see RetireVersion
+	commitSeq              int64                   // number of transactions committed since the db was opened
+	pageSeqs               map[int64]int64         // page number to the commit which wrote the page, see mvcc.go
+	versions               map[int64][]pageVersion // page number to the versions of the page kept for snapshots
+	snapshots              map[int64]int           // commit to the number of snapshots pinned at it
+	checkpointSize         int64                   // size in bytes the WAL may grow to before it is checkpointed
+	backups                int                     // number of backups running, which keep the WAL from being truncated
+	durability             Durability              // durability of transactions which do not set their own
+	latchesMu              sync.Mutex
+	latches                map[int64]*sync.RWMutex // page number to the latch guarding the page
+
+	stopWriter    chan struct{} // closed to stop the background writer
+	writerStopped chan struct{}
+}
+
+// A WriteTxn groups the pages written by one writer so that they are committed or rolled back together. Pages released
+// by the writer only return to the free list once it commits, so a concurrent writer can never reuse a page which is
+// still referenced by the last committed state. A WriteTxn must only be used by one goroutine
+type WriteTxn struct {
+	id    int64
+	freed []int64 // pages released by the transaction
+	root  int64   // root set by the transaction, 0 if it did not change the root
+	dirty bool    // whether the transaction changed the metadata page
+
+	durability Durability // how durable the transaction is once committed, the durability of the db if 0
 }
 
-func NewBPM(fileName string, cacheSize int) *BufferPoolManager {
+// NewBPM opens the db file and WAL stored in fileName with a buffer pool of cacheSize pages unless it is sized in bytes
+// with WithCacheBytes, see bufferpool.go. The WAL is checkpointed once it grows past the checkpoint size, or only when
+// Checkpoint is called if the checkpoint size is not positive
+func NewBPM(fileName string, cacheSize int, opts ...Option) *BufferPoolManager {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
 	dbFile, err := os.OpenFile(fileName + ".db", os.O_RDWR|os.O_CREATE, 0666)
 	if err != nil {
 		log.Fatalf("Failure opening file")
 	}
-	wal := NewWAL(fileName)
-	cache, err := lru.New(cacheSize)
-	if err != nil {
-		log.Fatalf("Failure creating LRU cache")
-	}
+	wal := NewWAL(fileName, o.groupCommitDelay, o.asyncFlushInterval)
 
 	bpm := &BufferPoolManager{
-		cache:  cache,
-		dbFile: dbFile,
-		wal:    wal,
+		pool:           newBufferPool(cacheSize, o.cacheBytes, o.newReplacementPolicy(), o.scanResistant),
+		dbFile:         dbFile,
+		wal:            wal,
+		latches:        map[int64]*sync.RWMutex{},
+		pageSeqs:       map[int64]int64{},
+		versions:       map[int64][]pageVersion{},
+		snapshots:      map[int64]int{},
+		checkpointSize: o.checkpointSize,
+		durability:     o.durability,
 	}
 
-	bpm.Recover()
+	if err := bpm.Recover(); err != nil {
+		log.Fatalf("Failure recovering WAL: %v", err)
+	}
 
 	if fi, _ := bpm.dbFile.Stat(); fi.Size() > PageSize {
 		// Successful initialization requires setting up both the metadata page and the root page. This seems like a bit
 		// of a hack. maybe we can clean this up
-		bpm.readMetadata()
+		if err := bpm.readMetadata(); err != nil {
+			log.Fatalf("Failure reading metadata: %v", err)
+		}
 	} else {
 		bpm.initializeDbFile(1, -1)
-		bpm.Set(&Node{
+		txn := bpm.BeginWrite()
+		err := bpm.Set(txn, &Node{
 			Keys:    make([]string, 0),
 			Values:  make([]string, 0),
 			IsLeaf:  true,
 			PageNum: 1,
 		})
+		if err == nil {
+			err = bpm.Commit(txn)
+		}
+		if err != nil {
+			log.Fatalf("Failure initializing root node: %v", err)
+		}
 	}
 
+	fi, err := bpm.dbFile.Stat()
+	if err != nil {
+		log.Fatalf("Failure reading dbFile size")
+	}
+	bpm.size = fi.Size()
+	bpm.committedSize = bpm.size
+
+	bpm.stopWriter = make(chan struct{})
+	bpm.writerStopped = make(chan struct{})
+	go bpm.runBackgroundWriter(o.backgroundWriterInterval, bpm.stopWriter, bpm.writerStopped)
 	return bpm
 }
 
-func (bpm *BufferPoolManager) Recover() {
-	committedFrames := bpm.wal.ReadAllCommittedFrames()
+// Recover writes every page committed to the WAL before a crash to the db file, after which the WAL is truncated. The
+// WAL is left untouched if Recover returns an error, so recovery can be retried
+func (bpm *BufferPoolManager) Recover() error {
+	committedFrames, readErrs := bpm.wal.ReadAllCommittedFrames()
+	var err error
 	for frame := range committedFrames {
-		_, err := bpm.dbFile.WriteAt(frame.Data, frame.PageNum * PageSize)
 		if err != nil {
-			log.Fatalf("Failure writing dbFile")
+			// keep draining the frames so the reader finishes
+			continue
 		}
+		if _, writeErr := bpm.dbFile.WriteAt(frame.Data, frame.PageNum * PageSize); writeErr != nil {
+			err = ioError("write", bpm.dbFile, writeErr)
+		}
+	}
+	if readErr := <-readErrs; readErr != nil {
+		return readErr
 	}
+	if err != nil {
+		return err
+	}
+	if err = bpm.syncDbFile(); err != nil {
+		return err
+	}
+	return bpm.wal.Truncate()
+}
+
+// SetRoot makes pageNum the root of the tree. The new root is stored in the metadata page once txn commits
+func (bpm *BufferPoolManager) SetRoot(txn *WriteTxn, pageNum int64) {
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+	bpm.rootPageNum = pageNum
+	txn.root = pageNum
+	txn.dirty = true
 }
 
-func (bpm *BufferPoolManager) SetRoot(pageNum int64) {
-	bpm.setMetadata(pageNum, bpm.freePageStart)
+// RootPageNum returns the page number of the root, including roots set by transactions which have not committed yet
+func (bpm *BufferPoolManager) RootPageNum() int64 {
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+	return bpm.rootPageNum
 }
 
-func (bpm *BufferPoolManager) GetRoot() *Node {
-	return bpm.Get(bpm.rootPageNum)
+func (bpm *BufferPoolManager) GetRoot() (*Node, error) {
+	return bpm.Get(bpm.RootPageNum())
 }
 
-func (bpm *BufferPoolManager) Get(pageNum int64) *Node {
-	nodeBytes := bpm.getPage(pageNum)
+// Latch returns the latch guarding the page with the given page number. A page must be latched shared while it is read
+// and exclusively while it is written
+func (bpm *BufferPoolManager) Latch(pageNum int64) *sync.RWMutex {
+	bpm.latchesMu.Lock()
+	defer bpm.latchesMu.Unlock()
+	latch, ok := bpm.latches[pageNum]
+	if !ok {
+		latch = &sync.RWMutex{}
+		bpm.latches[pageNum] = latch
+	}
+	return latch
+}
+
+// BeginWrite starts a new write transaction
+func (bpm *BufferPoolManager) BeginWrite() *WriteTxn {
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+	return &WriteTxn{
+		id: bpm.wal.NextTxnId(),
+	}
+}
+
+// Get reads the node stored in the page. The node is shared with other readers and must not be modified, see
+// nodecache.go. Returns a CorruptPageError if the page is corrupt, or an IOError if it could not be read
+func (bpm *BufferPoolManager) Get(pageNum int64) (*Node, error) {
+	return bpm.get(pageNum, false)
+}
+
+// GetForScan reads the node stored in the page on behalf of a range scan, which the buffer pool keeps from displacing
+// the working set if it is scan resistant
+func (bpm *BufferPoolManager) GetForScan(pageNum int64) (*Node, error) {
+	return bpm.get(pageNum, true)
+}
+
+func decodeNode(pageNum int64, nodeBytes []byte) (*Node, error) {
 	pageType := PageType(serialization.BytesToInt16(nodeBytes[:PageTypeSize]))
 	if pageType != INTERNAL && pageType != LEAF {
-		log.Fatalf("Page is not a leaf or internal node")
+		return nil, &CorruptPageError{PageNum: pageNum, Reason: "page is not a leaf or internal node"}
 	}
 
-	numKeys := serialization.BytesToInt16(nodeBytes[PageTypeSize : PageTypeSize+KeyCountSize])
-
+	numKeys := int(serialization.BytesToInt16(nodeBytes[PageTypeSize : PageTypeSize+KeyCountSize]))
 	keys := make([]string, numKeys)
-	keyBytes := nodeBytes[PageTypeSize+KeyCountSize : PageTypeSize+KeyCountSize+KeySize*numKeys]
-	var i int16
-	for i = 0; i < numKeys; i++ {
-		keys[i] = serialization.FixedLengthBytesToString(keyBytes[KeySize*i : KeySize*(i+1)])
-	}
 
 	if pageType == INTERNAL {
 		children := make([]int64, numKeys+1)
-		childrenBytes := nodeBytes[PageTypeSize+KeyCountSize+KeySize*numKeys : PageTypeSize+KeyCountSize+KeySize*numKeys+PageRefSize*(1+numKeys)]
-		var i int16
-		for i = 0; i < numKeys+1; i++ {
-			children[i] = serialization.BytesToInt64(childrenBytes[KeySize*i : KeySize*(i+1)])
+		children[0] = serialization.BytesToInt64(nodeBytes[PageTypeSize+KeyCountSize : InternalHeaderSize])
+		for i := 0; i < numKeys; i++ {
+			cell := nodeBytes[readSlot(nodeBytes, InternalHeaderSize, i):]
+			keyLen := int(serialization.BytesToInt16(cell[:KeyLenSize]))
+			children[i+1] = serialization.BytesToInt64(cell[KeyLenSize : KeyLenSize+PageRefSize])
+			keys[i] = string(cell[KeyLenSize+PageRefSize : KeyLenSize+PageRefSize+keyLen])
 		}
 
 		return &Node{
@@ -137,49 +320,112 @@ func (bpm *BufferPoolManager) Get(pageNum int64) *Node {
 			Children: children,
 			PageNum:  pageNum,
 			IsLeaf:   false,
-		}
+		}, nil
 	} else {
 		values := make([]string, numKeys)
-		valueBytes := nodeBytes[PageTypeSize+KeyCountSize+KeySize*numKeys : PageTypeSize+KeyCountSize+KeySize*numKeys+ValueSize*numKeys]
-		var i int16
-		for i = 0; i < numKeys; i++ {
-			values[i] = serialization.FixedLengthBytesToString(valueBytes[ValueSize*i : ValueSize*(i+1)])
+		overflow := make([]int64, numKeys)
+		versions := make([]int64, numKeys)
+		for i := 0; i < numKeys; i++ {
+			cell := nodeBytes[readSlot(nodeBytes, LeafHeaderSize, i):]
+			keyLen := int(serialization.BytesToInt16(cell[:KeyLenSize]))
+			valueLen := int(serialization.BytesToInt16(cell[KeyLenSize : KeyLenSize+ValueLenSize]))
+			versions[i] = serialization.BytesToInt64(cell[KeyLenSize+ValueLenSize : KeyLenSize+ValueLenSize+VersionSize])
+			cell = cell[KeyLenSize+ValueLenSize+VersionSize:]
+			keys[i] = string(cell[:keyLen])
+			if valueLen == OverflowValueLen {
+				overflow[i] = serialization.BytesToInt64(cell[keyLen : keyLen+PageRefSize])
+			} else {
+				values[i] = string(cell[keyLen : keyLen+valueLen])
+			}
 		}
 
 		return &Node{
-			Keys:    keys,
-			Values:  values,
-			PageNum: pageNum,
-			IsLeaf:  true,
-		}
+			Keys:     keys,
+			Values:   values,
+			Overflow: overflow,
+			Versions: versions,
+			Prev:     serialization.BytesToInt64(nodeBytes[PageTypeSize+KeyCountSize : PageTypeSize+KeyCountSize+PageRefSize]),
+			Next:     serialization.BytesToInt64(nodeBytes[PageTypeSize+KeyCountSize+PageRefSize : LeafHeaderSize]),
+			PageNum:  pageNum,
+			IsLeaf:   true,
+		}, nil
 	}
 }
 
-func (bpm *BufferPoolManager) getPage(pageNum int64) []byte {
-	// check the cache
-	page, ok := bpm.cache.Get(pageNum)
-	if ok {
-		return page.([]byte)
+// readSlot returns the offset within the page of the cell pointed at by the i-th slot
+func readSlot(pageBytes []byte, headerSize int, i int) int {
+	slotOffset := headerSize + SlotSize*i
+	return int(serialization.BytesToInt16(pageBytes[slotOffset : slotOffset+SlotSize]))
+}
+
+// getPage reads the page through the buffer pool
+func (bpm *BufferPoolManager) getPage(pageNum int64) ([]byte, error) {
+	return bpm.getPageForScan(pageNum, false)
+}
+
+// getPageForScan reads the page through the buffer pool, on behalf of a range scan if scan is set
+func (bpm *BufferPoolManager) getPageForScan(pageNum int64, scan bool) ([]byte, error) {
+	f, err := bpm.fetchPage(pageNum, scan)
+	if err != nil {
+		return nil, err
+	}
+	defer bpm.pool.unpin(f)
+	return f.data, nil
+}
+
+// fetchPage returns the frame holding the page pinned, loading the page into the buffer pool from the WAL or the db file
+// if it is not there. scan is set if a range scan reads the page. The frame must be unpinned once it is no longer read
+func (bpm *BufferPoolManager) fetchPage(pageNum int64, scan bool) (*frame, error) {
+	if f := bpm.pool.pin(pageNum, scan); f != nil {
+		return f, nil
 	}
 
 	// check the WAL
-	buffer, ok := bpm.wal.Read(pageNum)
-	if ok {
-		return buffer
+	bpm.mu.Lock()
+	buffer, ok, err := bpm.wal.Read(pageNum)
+	bpm.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if buffer, err = bpm.readPage(pageNum); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := verifyPage(pageNum, buffer); err != nil {
+		return nil, err
 	}
+	return bpm.pool.load(pageNum, buffer, scan), nil
+}
 
-	// read from disk
-	buffer = make([]byte, PageSize)
+// readPage reads the page from the db file
+func (bpm *BufferPoolManager) readPage(pageNum int64) ([]byte, error) {
+	buffer := make([]byte, PageSize)
 	_, err := bpm.dbFile.ReadAt(buffer, pageNum*PageSize)
-	if err != nil {
-		log.Fatalf("Failed to read page")
+	if err == io.EOF {
+		return nil, &CorruptPageError{PageNum: pageNum, Reason: "page is past the end of the db file"}
+	} else if err != nil {
+		return nil, ioError("read", bpm.dbFile, err)
 	}
+	return buffer, nil
+}
 
-	return buffer
+// Set writes the node to its page as part of txn. Returns an IOError if the page could not be appended to the WAL
+func (bpm *BufferPoolManager) Set(txn *WriteTxn, node *Node) error {
+	data := encodeNode(node)
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+	return bpm.setPage(txn, node.PageNum, data)
 }
 
-func (bpm *BufferPoolManager) Set(node *Node) {
-	data := make([]byte, 0)
+// encodeNode serializes the node into a slotted page
+func encodeNode(node *Node) []byte {
+	if node.Size() > UsablePageSize {
+		log.Fatalf("Node does not fit within a page")
+	}
+
+	data := make([]byte, PageSize)
 
 	var pageType PageType
 	if node.IsLeaf {
@@ -188,111 +434,400 @@ func (bpm *BufferPoolManager) Set(node *Node) {
 		pageType = INTERNAL
 	}
 
-	data = append(data, serialization.Int16ToBytes(int16(pageType))...)
-	data = append(data, serialization.Int16ToBytes(int16(len(node.Keys)))...)
-	for _, key := range node.Keys {
-		data = append(data, serialization.StringToBytes(key, KeySize)...)
-	}
+	copy(data, serialization.Int16ToBytes(int16(pageType)))
+	copy(data[PageTypeSize:], serialization.Int16ToBytes(int16(len(node.Keys))))
 
+	headerSize := LeafHeaderSize
 	if node.IsLeaf {
-		for _, value := range node.Values {
-			data = append(data, serialization.StringToBytes(value, ValueSize)...)
-		}
+		copy(data[PageTypeSize+KeyCountSize:], serialization.Int64ToBytes(node.Prev))
+		copy(data[PageTypeSize+KeyCountSize+PageRefSize:], serialization.Int64ToBytes(node.Next))
 	} else {
-		for _, child := range node.Children {
-			data = append(data, serialization.Int64ToBytes(child)...)
+		headerSize = InternalHeaderSize
+		copy(data[PageTypeSize+KeyCountSize:], serialization.Int64ToBytes(node.Children[0]))
+	}
+
+	// cells are packed from the back of the page towards the slot array
+	cellOffset := UsablePageSize
+	for i, key := range node.Keys {
+		cell := make([]byte, 0)
+		cell = append(cell, serialization.Int16ToBytes(int16(len(key)))...)
+		if node.IsLeaf && node.Overflow[i] != 0 {
+			cell = append(cell, serialization.Int16ToBytes(OverflowValueLen)...)
+			cell = append(cell, serialization.Int64ToBytes(node.Versions[i])...)
+			cell = append(cell, key...)
+			cell = append(cell, serialization.Int64ToBytes(node.Overflow[i])...)
+		} else if node.IsLeaf {
+			cell = append(cell, serialization.Int16ToBytes(int16(len(node.Values[i])))...)
+			cell = append(cell, serialization.Int64ToBytes(node.Versions[i])...)
+			cell = append(cell, key...)
+			cell = append(cell, node.Values[i]...)
+		} else {
+			cell = append(cell, serialization.Int64ToBytes(node.Children[i+1])...)
+			cell = append(cell, key...)
 		}
+
+		cellOffset -= len(cell)
+		copy(data[cellOffset:], cell)
+		copy(data[headerSize+SlotSize*i:], serialization.Int16ToBytes(int16(cellOffset)))
 	}
+	return data
+}
 
-	data = append(data, make([]byte, PageSize - len(data))...)
+// SetOverflow writes the value to a newly allocated chain of overflow pages and returns the first page of the chain
+func (bpm *BufferPoolManager) SetOverflow(txn *WriteTxn, value string) (int64, error) {
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
 
-	bpm.setPage(node.PageNum, data)
+	pageNums := make([]int64, numOverflowPages(value))
+	for i := range pageNums {
+		pageNum, err := bpm.getFreePage(txn)
+		if err != nil {
+			return 0, err
+		}
+		pageNums[i] = pageNum
+	}
+
+	for i, data := range encodeOverflow(value, pageNums) {
+		if err := bpm.setPage(txn, pageNums[i], data); err != nil {
+			return 0, err
+		}
+	}
+
+	return pageNums[0], nil
 }
 
-func (bpm *BufferPoolManager) setPage(pageNum int64, data []byte) {
-	bpm.wal.Append(Frame{
-		FrameType: PUT,
-		PageNum:   pageNum,
-		Data:      data,
-	})
+// numOverflowPages returns the number of overflow pages the value is stored in
+func numOverflowPages(value string) int {
+	dataSize := UsablePageSize - OverflowHeaderSize
+	return (len(value) + dataSize - 1) / dataSize
+}
+
+// encodeOverflow serializes the value into a chain of overflow pages stored in pageNums
+func encodeOverflow(value string, pageNums []int64) [][]byte {
+	dataSize := UsablePageSize - OverflowHeaderSize
+	pages := make([][]byte, len(pageNums))
+	for i := range pageNums {
+		nextPageNum := int64(-1)
+		if i < len(pageNums)-1 {
+			nextPageNum = pageNums[i+1]
+		}
+		chunk := value[i*dataSize:]
+		if len(chunk) > dataSize {
+			chunk = chunk[:dataSize]
+		}
+
+		data := make([]byte, PageSize)
+		copy(data, serialization.Int16ToBytes(int16(OVERFLOW)))
+		copy(data[PageTypeSize:], serialization.Int64ToBytes(nextPageNum))
+		copy(data[PageTypeSize+PageRefSize:], serialization.Int16ToBytes(int16(len(chunk))))
+		copy(data[OverflowHeaderSize:], chunk)
+		pages[i] = data
+	}
+	return pages
+}
 
-	bpm.cache.Add(pageNum, data)
+// GetOverflow reads the value stored in the chain of overflow pages starting at pageNum
+func (bpm *BufferPoolManager) GetOverflow(pageNum int64) (string, error) {
+	return readOverflow(pageNum, bpm.getPage)
 }
 
-func (bpm *BufferPoolManager) DeletePage(pageNum int64) {
-	bpm.cache.Remove(pageNum)
+// CacheStats returns the counters and the memory usage of the buffer pool
+func (bpm *BufferPoolManager) CacheStats() CacheStats {
+	stats := bpm.pool.cacheStats()
+	stats.WALReads = bpm.wal.FrameReads()
+	return stats
+}
 
-	pageBytes := make([]byte, PageSize)
-	for idx, pageTypeByte := range serialization.Int16ToBytes(int16(FREE)) {
-		pageBytes[idx] = pageTypeByte
+// readOverflow reads the value stored in the chain of overflow pages starting at pageNum using getPage
+func readOverflow(pageNum int64, getPage func(pageNum int64) ([]byte, error)) (string, error) {
+	value := make([]byte, 0)
+	for pageNum > 0 {
+		pageBytes, err := getOverflowPage(pageNum, getPage)
+		if err != nil {
+			return "", err
+		}
+		dataLen := int(serialization.BytesToInt16(pageBytes[PageTypeSize+PageRefSize : OverflowHeaderSize]))
+		value = append(value, pageBytes[OverflowHeaderSize:OverflowHeaderSize+dataLen]...)
+		pageNum = serialization.BytesToInt64(pageBytes[PageTypeSize : PageTypeSize+PageRefSize])
 	}
-	for idx, nextPageByte := range serialization.Int64ToBytes(bpm.freePageStart) {
-		pageBytes[idx+PageTypeSize] = nextPageByte
+	return string(value), nil
+}
+
+// DeleteOverflow returns every page in the chain of overflow pages starting at pageNum to the free list once txn
+// commits
+func (bpm *BufferPoolManager) DeleteOverflow(txn *WriteTxn, pageNum int64) error {
+	for pageNum > 0 {
+		pageBytes, err := getOverflowPage(pageNum, bpm.getPage)
+		if err != nil {
+			return err
+		}
+		nextPageNum := serialization.BytesToInt64(pageBytes[PageTypeSize : PageTypeSize+PageRefSize])
+		bpm.DeletePage(txn, pageNum)
+		pageNum = nextPageNum
 	}
+	return nil
+}
 
-	bpm.wal.Append(Frame{
+// getOverflowPage reads the page using getPage and checks that it is an overflow page
+func getOverflowPage(pageNum int64, getPage func(pageNum int64) ([]byte, error)) ([]byte, error) {
+	pageBytes, err := getPage(pageNum)
+	if err != nil {
+		return nil, err
+	}
+	pageType := PageType(serialization.BytesToInt16(pageBytes[:PageTypeSize]))
+	if pageType != OVERFLOW {
+		return nil, &CorruptPageError{PageNum: pageNum, Reason: "page is not an overflow page"}
+	}
+	dataLen := int(serialization.BytesToInt16(pageBytes[PageTypeSize+PageRefSize : OverflowHeaderSize]))
+	if dataLen < 0 || OverflowHeaderSize+dataLen > UsablePageSize {
+		return nil, &CorruptPageError{PageNum: pageNum, Reason: "overflow data does not fit within the page"}
+	}
+	return pageBytes, nil
+}
+
+// setPage stamps the page with its checksum, appends it to the WAL as part of txn and puts it in the buffer pool. The
+// caller must hold mu
+func (bpm *BufferPoolManager) setPage(txn *WriteTxn, pageNum int64, data []byte) error {
+	stampPage(data)
+	err := bpm.wal.Append(Frame{
 		FrameType: PUT,
+		TxnId:     txn.id,
 		PageNum:   pageNum,
-		Data: pageBytes,
+		Data:      data,
 	})
-	bpm.setPage(pageNum, pageBytes)
-	bpm.setMetadata(bpm.rootPageNum, pageNum)
+	if err != nil {
+		return err
+	}
+
+	bpm.pool.put(pageNum, data)
+	return nil
 }
 
-func (bpm *BufferPoolManager) Commit() {
-	bpm.wal.Append(Frame{
-		FrameType: COMMIT,
-	})
+// DeletePage returns the page to the free list once txn commits
+func (bpm *BufferPoolManager) DeletePage(txn *WriteTxn, pageNum int64) {
+	txn.freed = append(txn.freed, pageNum)
 }
 
-func (bpm *BufferPoolManager) GetFreePage() int64 {
-	if bpm.freePageStart <= 0 {
-		offset, err := bpm.dbFile.Seek(0, io.SeekEnd)
-		if err != nil {
-			log.Fatalf("Seek failed")
+// Commit makes every page written by txn durable. Pages released by txn are added to the free list, and the metadata
+// page is rewritten if txn changed it. If the COMMIT frame could not be appended, Commit returns an error and txn is
+// left uncommitted, in which case it must be aborted or rolled back. With SYNC durability the COMMIT frame is synced
+// while the buffer pool is locked, with GROUP durability it is synced along with those of concurrent commits once the
+// buffer pool is no longer locked, while with ASYNC durability Commit returns before it is synced, see durability.go.
+// Whatever the durability, txn is committed as soon as its COMMIT frame is appended since recovery replays it from
+// then on, so should the sync fail Commit returns a NotDurableError, in which case txn must neither be aborted nor
+// rolled back
+func (bpm *BufferPoolManager) Commit(txn *WriteTxn) error {
+	durability := txn.durability
+	if durability == 0 {
+		durability = bpm.durability
+	}
+	end, err := bpm.commit(txn, durability == SYNC)
+	if err != nil {
+		return err
+	}
+	switch durability {
+	case GROUP:
+		if err := bpm.wal.Sync(end); err != nil {
+			return &NotDurableError{Err: err}
 		}
-		if offset == 0 {
-			// extend the file
-			_, err = bpm.dbFile.Write(make([]byte, PageSize))
-			if err != nil {
-				log.Fatalf("Extending file failed")
-			}
-			offset += PageSize
+	case ASYNC:
+		bpm.wal.SyncLater(end)
+	}
+	return nil
+}
+
+// commit appends the COMMIT frame of txn to the WAL, syncing it if sync is set, and returns the offset following it.
+// Returns a NotDurableError if txn was committed but the WAL could not be synced
+func (bpm *BufferPoolManager) commit(txn *WriteTxn, sync bool) (int64, error) {
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+
+	freePageStart := bpm.freePageStart
+	for _, pageNum := range txn.freed {
+		pageBytes := make([]byte, PageSize)
+		copy(pageBytes, serialization.Int16ToBytes(int16(FREE)))
+		copy(pageBytes[PageTypeSize:], serialization.Int64ToBytes(freePageStart))
+		if err := bpm.setPage(txn, pageNum, pageBytes); err != nil {
+			return 0, err
+		}
+		freePageStart = pageNum
+		txn.dirty = true
+	}
+	rootPageNum := bpm.committedRootPageNum
+	if txn.root != 0 {
+		rootPageNum = txn.root
+	}
+	if txn.dirty {
+		// the metadata page never holds the root of a transaction which has not committed yet. The free list may
+		// include pages allocated by such a transaction, which are leaked rather than corrupted should it never commit
+		metadata := bpm.serializeMetadata(rootPageNum, freePageStart, bpm.versionClock)
+		if err := bpm.setPage(txn, 0, metadata); err != nil {
+			return 0, err
+		}
+	}
+
+	seq := bpm.commitSeq + 1
+	pageNums := bpm.wal.UncommittedPages(txn.id)
+	for _, pageNum := range pageNums {
+		// txn can no longer be aborted once its COMMIT frame is appended, so it is checked beforehand
+		if err := bpm.pool.checkUncommitted(pageNum); err != nil {
+			return 0, err
+		}
+	}
+	for _, pageNum := range pageNums {
+		bpm.retainVersion(pageNum, seq)
+	}
+	end, err := bpm.wal.Commit(txn.id)
+	if err != nil {
+		return 0, err
+	}
+	// txn is committed from here on, whether or not its COMMIT frame is synced
+	for _, pageNum := range pageNums {
+		bpm.pool.commit(pageNum, end)
+	}
+	bpm.freePageStart = freePageStart
+	bpm.committedFreePageStart = freePageStart
+	bpm.committedRootPageNum = rootPageNum
+	bpm.commitSeq = seq
+	if len(bpm.snapshots) > 0 {
+		for _, pageNum := range pageNums {
+			bpm.pageSeqs[pageNum] = seq
 		}
- 		if offset%PageSize != 0 {
-			log.Fatalf("DBFile size is not a multiple of page size")
+	}
+	bpm.committedSize = bpm.size
+	if sync {
+		if err := bpm.wal.Flush(); err != nil {
+			return end, &NotDurableError{Err: err}
+		}
+	}
+
+	if bpm.checkpointSize > 0 && bpm.wal.SizeInBytes() >= bpm.checkpointSize {
+		if err := bpm.checkpoint(); err != nil {
+			// the WAL keeps growing until a checkpoint succeeds, but txn is committed regardless
+			log.Printf("Failure checkpointing WAL: %v", err)
 		}
-		// extend the file
-		_, err = bpm.dbFile.Write(make([]byte, PageSize))
+	}
+	return end, nil
+}
+
+// Rollback discards every page written by txn from the buffer pool and the WAL, releases the pages the db file was extended
+// by, and restores the root and free list of the last committed state. Rollback must not run concurrently with any other
+// write transaction. The writes of txn are discarded even if Rollback returns an error
+func (bpm *BufferPoolManager) Rollback(txn *WriteTxn) error {
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+
+	err := bpm.discard(txn)
+	bpm.rootPageNum = bpm.committedRootPageNum
+	bpm.freePageStart = bpm.committedFreePageStart
+	if truncateErr := bpm.dbFile.Truncate(bpm.committedSize); truncateErr != nil {
+		// the pages the db file was extended by are leaked
+		return ioError("truncate", bpm.dbFile, truncateErr)
+	}
+	bpm.size = bpm.committedSize
+	return err
+}
+
+// Abort discards every page written by txn from the buffer pool and the WAL, and restores the root if txn changed it. Unlike
+// Rollback, Abort may run concurrently with other write transactions, which is why pages allocated by txn are leaked
+// rather than returned to the free list. txn must have held exclusive latches on every page it wrote, and smoLock if it
+// changed the root, until it is aborted. The writes of txn are discarded even if Abort returns an error
+func (bpm *BufferPoolManager) Abort(txn *WriteTxn) error {
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+
+	err := bpm.discard(txn)
+	if txn.root != 0 {
+		bpm.rootPageNum = bpm.committedRootPageNum
+	}
+	return err
+}
+
+// discard drops every page written by txn from the buffer pool and appends an ABORT frame for txn. The caller must hold mu
+func (bpm *BufferPoolManager) discard(txn *WriteTxn) error {
+	pageNums := bpm.wal.UncommittedPages(txn.id)
+	if len(pageNums) == 0 {
+		return nil
+	}
+	for _, pageNum := range pageNums {
+		bpm.pool.discard(pageNum)
+	}
+	return bpm.wal.Append(Frame{
+		FrameType: ABORT,
+		TxnId:     txn.id,
+	})
+}
+
+// GetFreePage allocates a page for txn, reusing a page from the free list if there is one
+func (bpm *BufferPoolManager) GetFreePage(txn *WriteTxn) (int64, error) {
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+	return bpm.getFreePage(txn)
+}
+
+// getFreePage allocates a page for txn. The caller must hold mu
+func (bpm *BufferPoolManager) getFreePage(txn *WriteTxn) (int64, error) {
+	txn.dirty = true
+	if bpm.freePageStart <= 0 {
+		// extend the file, rounding its size up to whole pages should the db have crashed while extending it
+		pageNum := (bpm.size + PageSize - 1) / PageSize
+		_, err := bpm.dbFile.WriteAt(make([]byte, PageSize), pageNum*PageSize)
 		if err != nil {
-			log.Fatalf("Extending file failed")
+			return 0, ioError("extend", bpm.dbFile, err)
 		}
+		bpm.size = (pageNum + 1) * PageSize
 
-		return offset / PageSize
+		return pageNum, nil
 	}
 
 	freePageNum := bpm.freePageStart
-	pageBytes := bpm.getPage(freePageNum)
+	pageBytes, err := bpm.latestPage(freePageNum)
+	if err != nil {
+		return 0, err
+	}
 	pageType := PageType(serialization.BytesToInt16(pageBytes[:PageTypeSize]))
 	if pageType != FREE {
-		log.Fatalf("Page is not free")
-		return -1
+		return 0, &CorruptPageError{PageNum: freePageNum, Reason: "page on the free list is not free"}
 	} else {
 		bpm.freePageStart = serialization.BytesToInt64(pageBytes[PageTypeSize : PageTypeSize+PageRefSize])
-		bpm.setMetadata(bpm.rootPageNum, bpm.freePageStart)
-		return freePageNum
+		return freePageNum, nil
+	}
+}
+
+// latestPage reads the latest version of the page from the buffer pool, the WAL or the db file without loading it into
+// the buffer pool. The caller must hold mu
+func (bpm *BufferPoolManager) latestPage(pageNum int64) ([]byte, error) {
+	if f := bpm.pool.pin(pageNum, false); f != nil {
+		defer bpm.pool.unpin(f)
+		return f.data, nil
 	}
+	pageBytes, ok, err := bpm.wal.Read(pageNum)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if pageBytes, err = bpm.readPage(pageNum); err != nil {
+			return nil, err
+		}
+	}
+	if err := verifyPage(pageNum, pageBytes); err != nil {
+		return nil, err
+	}
+	return pageBytes, nil
 }
 
 func (bpm *BufferPoolManager) initializeDbFile(rootPage, freePageStart int64) {
-	metadataBytes := bpm.serializeMetadata(rootPage, freePageStart)
+	metadataBytes := bpm.serializeMetadata(rootPage, freePageStart, 0)
+	stampPage(metadataBytes)
 	_, err := bpm.dbFile.WriteAt(metadataBytes, 0)
 	if err != nil {
 		log.Fatalf("Failure initializing metadata")
 	}
 
 	bpm.rootPageNum = rootPage
+	bpm.committedRootPageNum = rootPage
 	bpm.freePageStart = freePageStart
+	bpm.committedFreePageStart = freePageStart
 
 	// create space for root node
 	_, err = bpm.dbFile.WriteAt(make([]byte, PageSize), PageSize)
@@ -301,22 +836,21 @@ func (bpm *BufferPoolManager) initializeDbFile(rootPage, freePageStart int64) {
 	}
 }
 
-func (bpm *BufferPoolManager) setMetadata(rootPage, freePageStart int64) {
-	metadataBytes := bpm.serializeMetadata(rootPage, freePageStart)
-	bpm.setPage(0, metadataBytes)
-
-	bpm.rootPageNum = rootPage
-	bpm.freePageStart = freePageStart
-}
-
-func (bpm *BufferPoolManager) readMetadata() {
-	metadataBytes := bpm.getPage(0)
+func (bpm *BufferPoolManager) readMetadata() error {
+	metadataBytes, err := bpm.latestPage(0)
+	if err != nil {
+		return err
+	}
 
 	bpm.rootPageNum = serialization.BytesToInt64(metadataBytes[:PageRefSize])
+	bpm.committedRootPageNum = bpm.rootPageNum
 	bpm.freePageStart = serialization.BytesToInt64(metadataBytes[PageRefSize : 2*PageRefSize])
+	bpm.committedFreePageStart = bpm.freePageStart
+	bpm.versionClock = serialization.BytesToInt64(metadataBytes[2*PageRefSize : 3*PageRefSize])
+	return nil
 }
 
-func (bpm *BufferPoolManager) serializeMetadata(rootPage, freePageStart int64) []byte {
+func (bpm *BufferPoolManager) serializeMetadata(rootPage, freePageStart, versionClock int64) []byte {
 	metadataBytes := make([]byte, PageSize)
 	for idx, rootPageByte := range serialization.Int64ToBytes(rootPage) {
 		metadataBytes[idx] = rootPageByte
@@ -324,6 +858,26 @@ func (bpm *BufferPoolManager) serializeMetadata(rootPage, freePageStart int64) [
 	for idx, freePageStartByte := range serialization.Int64ToBytes(freePageStart) {
 		metadataBytes[idx+PageRefSize] = freePageStartByte
 	}
+	copy(metadataBytes[2*PageRefSize:], serialization.Int64ToBytes(versionClock))
 
 	return metadataBytes
 }
+
+// RetireVersion records that txn deletes a key at version. The version clock stored in the metadata page is advanced
+// to the version, and a key set again after being deleted starts past the clock, see FirstVersion. Versions therefore
+// never repeat across a delete, so a condition on the version of a deleted key cannot hold for the key set again
+func (bpm *BufferPoolManager) RetireVersion(txn *WriteTxn, version int64) {
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+	txn.dirty = true
+	if version > bpm.versionClock {
+		bpm.versionClock = version
+	}
+}
+
+// FirstVersion returns the version of a key which is not in the tree once it is set
+func (bpm *BufferPoolManager) FirstVersion() int64 {
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+	return bpm.versionClock + 1
+}
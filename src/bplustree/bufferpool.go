@@ -0,0 +1,413 @@
+package bplustree
+
+import (
+	"fios-db/src/serialization"
+	"log"
+	"sync"
+	"time"
+)
+
+// The buffer pool keeps recently used pages in memory, each in a frame of its own. A frame holds the latest version of
+// its page along with:
+//
+//  pinCount     number of callers reading the page. A pinned frame is never evicted
+//  uncommitted  whether the page was written by a transaction which has not committed yet. Such a frame stays in the
+//               pool until the transaction commits or aborts, so that committing never needs to read the WAL
+//  dirty        whether the last committed version of the page has yet to be written to the db file. A dirty frame
+//               stays in the pool until the background writer writes it
+//
+// Frames which are neither pinned, uncommitted nor dirty are evicted in the order chosen by the replacement policy of the
+// pool, see replacement.go, once the pool holds more pages than its capacity, or uses more memory than its budget if it
+// is sized in bytes, see memory.go. Should every frame be in use, the pool
+// grows past its capacity and wakes the background writer to clean dirty frames, and shrinks back as frames become
+// evictable.
+//
+// The background writer periodically writes the committed versions of dirty pages to the db file, syncing the WAL up to
+// their COMMIT frames first so that no page reaches the db file before its commit is durable. A checkpoint writes every
+// dirty page the same way before truncating the WAL, which is why every page committed in the WAL but missing from the
+// db file is always dirty in the pool. Once the background writer has written a page, the WAL forgets its committed
+// version, so that the page is read from the db file rather than the WAL should it be evicted.
+
+// DefaultBackgroundWriterInterval is how often the background writer writes dirty pages to the db file
+const DefaultBackgroundWriterInterval = 100 * time.Millisecond
+
+// A frame holds a page in the buffer pool. Frames are guarded by the mutex of their pool
+type frame struct {
+	pageNum     int64
+	data        []byte // latest version of the page, which is never modified in place
+	node        *Node  // node decoded from data, nil until the page is read as a node, see nodecache.go
+	pinCount    int
+	uncommitted bool
+	dirty       bool
+	committed   []byte // last committed version of the page if dirty
+	lsn         int64  // offset of the WAL following the COMMIT frame of committed if dirty
+	memory      int64  // bytes of memory the frame is accounted for, see memory.go
+	nodeMemory  int64  // bytes of memory of node included in memory
+	overflow    bool   // whether the latest version of the page is an overflow page
+}
+
+// A bufferPool is safe for concurrent use
+type bufferPool struct {
+	mu            sync.Mutex
+	capacity      int              // number of pages the pool holds, unless it is sized in bytes
+	maxBytes      int64            // bytes of memory the pool may use, 0 if it is sized in pages
+	bytes         int64            // bytes of memory used by the frames of the pool
+	overflowBytes int64            // bytes of memory used by the frames holding overflow pages
+	nodeBytes     int64            // bytes of memory used by decoded nodes
+	frames        map[int64]*frame // page number to the frame holding the page
+	policy        ReplacementPolicy
+	scanResistant bool // whether the policy is told about accesses made by range scans
+	stats         CacheStats
+	needsWrite    chan struct{} // wakes the background writer when the pool is over capacity
+}
+
+// A dirtyPage is the committed version of a page which has yet to be written to the db file
+type dirtyPage struct {
+	pageNum int64
+	data    []byte
+	lsn     int64
+}
+
+// newBufferPool returns a pool holding capacity pages, or using up to maxBytes bytes of memory if maxBytes > 0
+func newBufferPool(capacity int, maxBytes int64, policy ReplacementPolicy, scanResistant bool) *bufferPool {
+	if capacity < 1 {
+		capacity = 1
+	}
+	if maxBytes < 0 {
+		maxBytes = 0
+	}
+	return &bufferPool{
+		capacity:      capacity,
+		maxBytes:      maxBytes,
+		frames:        map[int64]*frame{},
+		policy:        policy,
+		scanResistant: scanResistant,
+		stats: CacheStats{
+			Policy: policy.Name(),
+			Opened: time.Now(),
+		},
+		needsWrite: make(chan struct{}, 1),
+	}
+}
+
+// pin returns the frame holding the page pinned, or nil if the page is not in the pool. scan is set if a range scan
+// reads the page. Pinned frames must be unpinned once they are no longer read
+func (p *bufferPool) pin(pageNum int64, scan bool) *frame {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f, ok := p.frames[pageNum]
+	if !ok {
+		p.stats.Misses++
+		return nil
+	}
+	p.stats.Hits++
+	f.pinCount++
+	p.policy.Access(pageNum, p.isScan(scan, f.data))
+	return f
+}
+
+// unpin releases a frame pinned by pin or load
+func (p *bufferPool) unpin(f *frame) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f.pinCount--
+	p.evict()
+}
+
+// load adds the page read from the WAL or the db file to the pool and returns its frame pinned. scan is set if a range
+// scan reads the page. The frame already holding the page is returned instead if the page was added concurrently
+func (p *bufferPool) load(pageNum int64, data []byte, scan bool) *frame {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f := p.frame(pageNum, data, scan)
+	f.pinCount++
+	p.evict()
+	return f
+}
+
+// committedVersion returns the last committed version of the page, or nil if the pool does not hold it. That is the
+// case if the page is not in the pool, or if its frame holds a version which has not committed yet on top of a committed
+// version which was written to the db file. The node decoded from the version is returned too if the frame holds it.
+// scan is set if a range scan reads the page
+func (p *bufferPool) committedVersion(pageNum int64, scan bool) ([]byte, *Node) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f, ok := p.frames[pageNum]
+	var data []byte
+	var node *Node
+	if ok && !f.uncommitted {
+		data, node = f.data, f.node
+	} else if ok && f.dirty {
+		data = f.committed
+	}
+	if data == nil {
+		p.stats.Misses++
+		return nil, nil
+	}
+	p.stats.Hits++
+	p.policy.Access(pageNum, p.isScan(scan, data))
+	return data, node
+}
+
+// put makes data the latest version of the page, written by a transaction which has not committed yet
+func (p *bufferPool) put(pageNum int64, data []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f := p.frame(pageNum, data, false)
+	f.data = data
+	f.node = nil
+	f.uncommitted = true
+	p.account(f)
+	p.evict()
+}
+
+// checkUncommitted returns a CorruptPageError unless the pool holds a version of the page written by a transaction which
+// has not committed yet, which is the version commit marks as committed
+func (p *bufferPool) checkUncommitted(pageNum int64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if f, ok := p.frames[pageNum]; !ok || !f.uncommitted {
+		return &CorruptPageError{PageNum: pageNum, Reason: "page written by the transaction is not in the buffer pool"}
+	}
+	return nil
+}
+
+// commit marks the page as committed by the commit whose COMMIT frame ends at lsn, making it dirty. Pages which fail
+// checkUncommitted are left untouched, so the caller must check them before committing
+func (p *bufferPool) commit(pageNum int64, lsn int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f, ok := p.frames[pageNum]
+	if !ok || !f.uncommitted {
+		return
+	}
+	f.uncommitted = false
+	f.dirty = true
+	f.committed = f.data
+	f.lsn = lsn
+	p.account(f)
+}
+
+// discard drops the version of the page written by a transaction which aborted, reverting to the last committed
+// version if it is dirty. The page must not be pinned
+func (p *bufferPool) discard(pageNum int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f, ok := p.frames[pageNum]
+	if !ok {
+		return
+	}
+	f.uncommitted = false
+	if f.dirty {
+		f.data = f.committed
+		f.node = nil
+		p.account(f)
+		return
+	}
+	p.remove(f)
+}
+
+// dirtyPages returns the committed versions of every dirty page
+func (p *bufferPool) dirtyPages() []dirtyPage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pages := make([]dirtyPage, 0)
+	for _, f := range p.frames {
+		if f.dirty {
+			pages = append(pages, dirtyPage{
+				pageNum: f.pageNum,
+				data:    f.committed,
+				lsn:     f.lsn,
+			})
+		}
+	}
+	return pages
+}
+
+// markClean records that the version of the page committed at lsn was written to the db file. The page stays dirty if
+// it was committed again since
+func (p *bufferPool) markClean(pageNum int64, lsn int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f, ok := p.frames[pageNum]
+	if !ok || !f.dirty || f.lsn != lsn {
+		return
+	}
+	f.dirty = false
+	f.committed = nil
+	p.account(f)
+	p.evict()
+}
+
+// clean returns whether the last committed version of the page is in the db file, which is the case unless the page is
+// dirty. The caller must hold the mu of the BufferPoolManager so that the page is not committed concurrently
+func (p *bufferPool) clean(pageNum int64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f, ok := p.frames[pageNum]
+	return !ok || !f.dirty
+}
+
+// drop removes the frames of every page from pageNum on, which must be neither in use nor dirty, once the db file is
+// truncated before them
+func (p *bufferPool) drop(pageNum int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, f := range p.frames {
+		if f.pageNum >= pageNum {
+			p.remove(f)
+		}
+	}
+}
+
+// size returns the number of pages in the pool
+func (p *bufferPool) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.frames)
+}
+
+// cacheStats returns the counters and the memory usage of the pool
+func (p *bufferPool) cacheStats() CacheStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := p.stats
+	stats.Pages = len(p.frames)
+	stats.Bytes = p.bytes
+	stats.OverflowBytes = p.overflowBytes
+	stats.NodeBytes = p.nodeBytes
+	stats.MaxBytes = p.maxBytes
+	if p.maxBytes == 0 {
+		stats.MaxPages = p.capacity
+	}
+	stats.Time = time.Now()
+	return stats
+}
+
+// frame returns the frame holding the page, adding a frame holding data if there is none and otherwise recording an
+// access to the page. The caller must hold mu and evict once the frame is in use
+func (p *bufferPool) frame(pageNum int64, data []byte, scan bool) *frame {
+	if f, ok := p.frames[pageNum]; ok {
+		p.policy.Access(pageNum, p.isScan(scan, f.data))
+		return f
+	}
+	f := &frame{
+		pageNum: pageNum,
+		data:    data,
+	}
+	p.frames[pageNum] = f
+	p.account(f)
+	p.policy.Admit(pageNum, p.isScan(scan, data))
+	return f
+}
+
+// isScan returns whether an access to the page is reported to the policy as made by a range scan. Internal nodes are
+// read on the way to every leaf a scan reads, and are only hot if they are reported as such. The caller must hold mu
+func (p *bufferPool) isScan(scan bool, data []byte) bool {
+	return scan && p.scanResistant && PageType(serialization.BytesToInt16(data[:PageTypeSize])) != INTERNAL
+}
+
+// evict drops the frames chosen by the policy among those which are not in use until the pool is back within its
+// capacity, and wakes the background writer should dirty frames keep it over capacity. The caller must hold mu
+func (p *bufferPool) evict() {
+	for p.overCapacity() {
+		pageNum, ok := p.policy.Victim(p.evictable)
+		if !ok {
+			break
+		}
+		p.remove(p.frames[pageNum])
+		p.stats.Evictions++
+	}
+	if p.overCapacity() {
+		select {
+		case p.needsWrite <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// evictable returns whether the frame holding the page is not in use. The caller must hold mu
+func (p *bufferPool) evictable(pageNum int64) bool {
+	f := p.frames[pageNum]
+	return f.pinCount == 0 && !f.uncommitted && !f.dirty
+}
+
+// remove drops the frame from the pool. The caller must hold mu
+func (p *bufferPool) remove(f *frame) {
+	p.policy.Remove(f.pageNum)
+	delete(p.frames, f.pageNum)
+	p.unaccount(f)
+}
+
+// writeDirtyPages writes the committed version of every dirty page to the db file, after syncing the WAL up to the
+// commits of the pages. The db file is not synced. Pages are written one at a time, and pages written before a failure
+// become clean. Returns the pages written
+func (bpm *BufferPoolManager) writeDirtyPages() ([]int64, error) {
+	bpm.writeMu.Lock()
+	defer bpm.writeMu.Unlock()
+
+	pages := bpm.pool.dirtyPages()
+	written := make([]int64, 0, len(pages))
+	if len(pages) == 0 {
+		return written, nil
+	}
+	end := int64(0)
+	for _, page := range pages {
+		if page.lsn > end {
+			end = page.lsn
+		}
+	}
+	if err := bpm.wal.Sync(end); err != nil {
+		return written, err
+	}
+	for _, page := range pages {
+		if _, err := bpm.dbFile.WriteAt(page.data, page.pageNum*PageSize); err != nil {
+			return written, ioError("write", bpm.dbFile, err)
+		}
+		bpm.pool.markClean(page.pageNum, page.lsn)
+		written = append(written, page.pageNum)
+	}
+	return written, nil
+}
+
+// writeBack writes every dirty page to the db file, see writeDirtyPages, after which the WAL forgets the committed
+// versions written so that pages evicted from the buffer pool are read back from the db file. The caller must not hold
+// mu, which checkpoints do not need since they truncate the WAL instead
+func (bpm *BufferPoolManager) writeBack() error {
+	written, err := bpm.writeDirtyPages()
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+	for _, pageNum := range written {
+		// the page may have been committed again since it was written, in which case the WAL holds the only copy of its
+		// last committed version
+		if bpm.pool.clean(pageNum) {
+			bpm.wal.Forget(pageNum)
+		}
+	}
+	return err
+}
+
+// runBackgroundWriter writes dirty pages to the db file every interval, or as soon as the pool is over capacity, until
+// stop is closed
+func (bpm *BufferPoolManager) runBackgroundWriter(interval time.Duration, stop <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-bpm.pool.needsWrite:
+		case <-stop:
+			return
+		}
+		if err := bpm.writeBack(); err != nil {
+			log.Printf("Failure writing dirty pages: %v", err)
+		}
+	}
+}
+
+// stopBackgroundWriter stops the background writer, leaving dirty pages in the buffer pool
+func (bpm *BufferPoolManager) stopBackgroundWriter() {
+	close(bpm.stopWriter)
+	<-bpm.writerStopped
+}
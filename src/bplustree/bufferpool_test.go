@@ -0,0 +1,150 @@
+package bplustree
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+// filledPage returns a page filled with b
+func filledPage(b byte) []byte {
+	data := make([]byte, PageSize)
+	for i := range data {
+		data[i] = b
+	}
+	return data
+}
+
+func TestPinnedPagesAreNotEvicted(t *testing.T) {
+	// Arrange
+	pool := newBufferPool(2, 0, NewLRUPolicy(), false)
+	pinned := pool.load(1, filledPage(1), false)
+
+	// Act
+	for pageNum := int64(2); pageNum < 10; pageNum++ {
+		pool.unpin(pool.load(pageNum, filledPage(byte(pageNum)), false))
+	}
+	pinnedIsResident := pool.pin(1, false) != nil
+	pool.unpin(pinned)
+	pool.unpin(pinned)
+	pool.unpin(pool.load(10, filledPage(10), false))
+	pool.unpin(pool.load(11, filledPage(11), false))
+
+	// Assert
+	assert.True(t, pinnedIsResident)
+	assert.Nil(t, pool.pin(1, false))
+	assert.Equal(t, 2, pool.size())
+}
+
+func TestDirtyPagesAreNotEvictedUntilWritten(t *testing.T) {
+	// Arrange
+	pool := newBufferPool(1, 0, NewLRUPolicy(), false)
+	for pageNum := int64(1); pageNum <= 3; pageNum++ {
+		pool.put(pageNum, filledPage(byte(pageNum)))
+		pool.commit(pageNum, pageNum)
+	}
+
+	// Act
+	sizeWhileDirty := len(pool.dirtyPages())
+	for _, dirty := range pool.dirtyPages() {
+		pool.markClean(dirty.pageNum, dirty.lsn)
+	}
+
+	// Assert
+	assert.Equal(t, 3, sizeWhileDirty)
+	assert.Equal(t, 1, pool.size())
+	assert.Equal(t, 0, len(pool.dirtyPages()))
+}
+
+func TestDiscardRevertsToCommittedVersion(t *testing.T) {
+	// Arrange
+	pool := newBufferPool(4, 0, NewLRUPolicy(), false)
+	pool.put(1, filledPage(1))
+	pool.commit(1, 1)
+	pool.put(1, filledPage(2))
+	pool.put(2, filledPage(2))
+
+	// Act
+	pool.discard(1)
+	pool.discard(2)
+
+	// Assert
+	f := pool.pin(1, false)
+	assert.Equal(t, filledPage(1), f.data)
+	assert.True(t, f.dirty)
+	pool.unpin(f)
+	assert.Nil(t, pool.pin(2, false))
+}
+
+func TestCommitOfPageMissingFromPoolIsRejected(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 16, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	assert.NoError(t, bpt.Set("a", "old"))
+	txn := bpt.Begin()
+	assert.NoError(t, txn.Put("a", "new"))
+	for _, pageNum := range bpt.bpm.wal.UncommittedPages(txn.writeTxn.id) {
+		bpt.bpm.pool.discard(pageNum)
+	}
+
+	// Act
+	err := txn.Commit()
+
+	// Assert
+	assert.True(t, errors.Is(err, ErrCorruption))
+	value, _, getErr := bpt.Get("a")
+	assert.NoError(t, getErr)
+	assert.Equal(t, "old", value)
+	assert.NoError(t, bpt.Set("b", "set"))
+}
+
+func TestEvictedPagesAreReadFromDbFileOnceWritten(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128, WithCheckpointSize(-1))
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	keys := seedTree(&bpt, 100)
+	// the pool wakes the background writer as soon as it is over capacity, which is stopped to write pages back in one go
+	bpt.bpm.stopBackgroundWriter()
+	assert.NoError(t, bpt.bpm.writeBack())
+	walReadsBefore := bpt.CacheStats().WALReads
+
+	// Act
+	for _, key := range keys {
+		value, _, err := bpt.Get(key)
+		assert.NoError(t, err)
+		assert.Equal(t, "v"+key, value)
+	}
+	walReadsAfter := bpt.CacheStats().WALReads
+
+	// Assert
+	// the pool holds a single page, so pages are evicted and read back all along, but never from the WAL
+	assert.Greater(t, walSize(t), int64(0))
+	assert.Greater(t, bpt.CacheStats().Evictions, int64(0))
+	assert.Equal(t, walReadsBefore, walReadsAfter)
+}
+
+func TestBackgroundWriterWritesCommittedPages(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 64, 128, WithBackgroundWriterInterval(time.Millisecond), WithCheckpointSize(-1))
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	keys := seedTree(&bpt, 100)
+
+	// Act
+	time.Sleep(50 * time.Millisecond)
+
+	// Assert
+	// pages reach the db file without the WAL being checkpointed
+	assert.Greater(t, walSize(t), int64(0))
+	assert.Equal(t, 0, len(bpt.bpm.pool.dirtyPages()))
+	leaf := leafOf(&bpt, keys[0])
+	pageBytes, err := bpt.bpm.readPage(leaf.PageNum)
+	assert.NoError(t, err)
+	node, err := decodeNode(leaf.PageNum, pageBytes)
+	assert.NoError(t, err)
+	assert.Equal(t, leaf.Keys, node.Keys)
+}
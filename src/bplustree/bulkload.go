@@ -0,0 +1,314 @@
+package bplustree
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Loading sorted entries with Set splits a leaf every few inserts and appends every page it touches to the WAL. BulkLoad
+// instead builds a new tree bottom up:
+//
+// Leaves are packed from left to right up to the fill factor as entries stream in, and written straight to the end of
+// the db file along with the overflow pages of large values. Only the first key and the page of every leaf are kept in
+// memory, from which each level of internal nodes is packed the same way until a level fits in a single node, the
+// root. Should the last node of a level end up below the minimum fill, it is merged with the node before it, or the
+// entries of both are split evenly between them if they do not fit in one node.
+//
+// The pages of the new tree bypass the WAL and the buffer pool, since nothing refers to them until the tree is
+// committed. Once every page is written the db file is synced, and a single transaction makes the new root the root of
+// the tree. Should the db crash before that transaction commits, the db reopens with the empty tree it had before, and
+// the pages written so far are leaked until the next Vacuum.
+
+// DefaultFillFactor is the fraction of the capacity of a node BulkLoad fills, leaving room for later inserts before
+// nodes split
+const DefaultFillFactor = 0.9
+
+// ErrTreeNotEmpty is returned when entries are bulk loaded into a tree which already holds keys
+var ErrTreeNotEmpty = errors.New("entries can only be bulk loaded into an empty tree")
+
+// ErrUnsortedInput is matched by errors.Is when the entries to bulk load are not in strictly ascending key order
+var ErrUnsortedInput = errors.New("bulk loaded keys must be in strictly ascending order")
+
+// A BulkLoadSource yields the entries to bulk load in strictly ascending key order
+type BulkLoadSource interface {
+	// Next returns the next entry, or false once every entry was returned
+	Next() (KeyValue, bool, error)
+}
+
+// A childRef is a node of a level being built along with the smallest key of its subtree
+type childRef struct {
+	key     string
+	pageNum int64
+}
+
+// A bulkLoader builds a new tree as part of a single transaction
+type bulkLoader struct {
+	t       *BPlusTree
+	txn     *WriteTxn
+	target  int   // number of bytes a node is filled to
+	version int64 // version of every entry loaded, see FirstVersion
+}
+
+// BulkLoad builds the tree from the entries of source, filling every node to fillFactor of its capacity, and commits it
+// at once. A fillFactor outside (0, 1] uses DefaultFillFactor. The tree must be empty, and the keys of source must be
+// strictly ascending or ErrUnsortedInput is returned. Returns the number of entries loaded. Nothing is loaded if an
+// error is returned
+func (t *BPlusTree) BulkLoad(source BulkLoadSource, fillFactor float64) (int, error) {
+	t.backupLock.Lock()
+	defer t.backupLock.Unlock()
+	t.txnLock.Lock()
+	defer t.txnLock.Unlock()
+
+	oldRoot, err := t.bpm.Get(t.bpm.RootPageNum())
+	if err != nil {
+		return 0, err
+	}
+	if !oldRoot.IsLeaf || len(oldRoot.Keys) > 0 {
+		return 0, ErrTreeNotEmpty
+	}
+	if fillFactor <= 0 || fillFactor > 1 {
+		fillFactor = DefaultFillFactor
+	}
+	// a node closed before the next entry would push it past the target is never below the minimum fill
+	target := int(fillFactor * float64(t.capacity))
+	if target < t.minFill()+t.maxEntrySize() {
+		target = t.minFill() + t.maxEntrySize()
+	}
+	if target > t.capacity {
+		target = t.capacity
+	}
+
+	b := &bulkLoader{
+		t:       t,
+		txn:     t.bpm.BeginWrite(),
+		target:  target,
+		version: t.bpm.FirstVersion(),
+	}
+	b.txn.durability = t.durability
+	n, err := b.load(source, oldRoot.PageNum)
+	if errors.Is(err, ErrNotDurable) {
+		return n, err
+	} else if err != nil {
+		_ = t.bpm.Rollback(b.txn)
+		return 0, err
+	}
+	return n, nil
+}
+
+// load writes the tree built from the entries of source and commits it in place of the empty root
+func (b *bulkLoader) load(source BulkLoadSource, oldRoot int64) (int, error) {
+	level, n, err := b.loadLeaves(source)
+	if err != nil || n == 0 {
+		return 0, err
+	}
+	for len(level) > 1 {
+		if level, err = b.loadLevel(level); err != nil {
+			return 0, err
+		}
+	}
+	if err := b.t.bpm.extendToReserved(); err != nil {
+		return 0, err
+	}
+	if err := b.t.bpm.syncDbFile(); err != nil {
+		return 0, err
+	}
+	b.t.bpm.SetRoot(b.txn, level[0].pageNum)
+	b.t.bpm.DeletePage(b.txn, oldRoot)
+	if err := b.t.bpm.Commit(b.txn); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// loadLeaves packs the entries of source into leaves and writes them. Returns every leaf along with the number of
+// entries loaded
+func (b *bulkLoader) loadLeaves(source BulkLoadSource) ([]childRef, int, error) {
+	level := make([]childRef, 0)
+	var prev, cur *Node
+	n := 0
+	for {
+		kv, ok, err := source.Next()
+		if err != nil {
+			return nil, 0, err
+		}
+		if !ok {
+			break
+		}
+		if err := b.t.checkKey(kv.Key); err != nil {
+			return nil, 0, err
+		}
+		if cur != nil && len(cur.Keys) > 0 && kv.Key <= cur.Keys[len(cur.Keys)-1] {
+			return nil, 0, fmt.Errorf("%w: %q follows %q", ErrUnsortedInput, kv.Key, cur.Keys[len(cur.Keys)-1])
+		}
+
+		if cur == nil || cur.Size()+b.t.storedEntrySize(kv.Key, kv.Value) > b.target {
+			next := NewLeafNode(b.t.bpm.reservePage(b.txn), nil, nil)
+			if cur != nil {
+				cur.Next = next.PageNum
+				next.Prev = cur.PageNum
+			}
+			if prev != nil {
+				if err := b.t.bpm.writePage(prev.PageNum, encodeNode(prev)); err != nil {
+					return nil, 0, err
+				}
+			}
+			prev, cur = cur, next
+			level = append(level, childRef{key: kv.Key, pageNum: cur.PageNum})
+		}
+
+		i := len(cur.Keys)
+		cur.InsertKey(kv.Key, i)
+		cur.InsertValue("", i)
+		cur.Versions[i] = b.version
+		if b.t.overflows(kv.Key, kv.Value) {
+			if cur.Overflow[i], err = b.writeOverflow(kv.Value); err != nil {
+				return nil, 0, err
+			}
+		} else {
+			cur.Values[i] = kv.Value
+		}
+		n++
+	}
+	if cur == nil {
+		return level, 0, nil
+	}
+
+	if prev != nil && cur.Size() < b.t.minFill() {
+		level = b.balanceLast(level, prev, cur)
+	}
+	for _, node := range []*Node{prev, cur} {
+		if node == nil || len(node.Keys) == 0 {
+			continue
+		}
+		if err := b.t.bpm.writePage(node.PageNum, encodeNode(node)); err != nil {
+			return nil, 0, err
+		}
+	}
+	return level, n, nil
+}
+
+// loadLevel packs the nodes of a level into the internal nodes above them and writes them. Returns the internal nodes
+func (b *bulkLoader) loadLevel(children []childRef) ([]childRef, error) {
+	nodes := make([]*Node, 0)
+	level := make([]childRef, 0)
+	var cur *Node
+	for _, child := range children {
+		if cur == nil || cur.Size()+internalEntrySize(child.key) > b.target {
+			cur = NewInnerNode(b.t.bpm.reservePage(b.txn), nil, []int64{child.pageNum})
+			nodes = append(nodes, cur)
+			level = append(level, childRef{key: child.key, pageNum: cur.PageNum})
+			continue
+		}
+		cur.InsertKey(child.key, len(cur.Keys))
+		cur.InsertChild(child.pageNum, len(cur.Children))
+	}
+
+	if len(nodes) > 1 && cur.Size() < b.t.minFill() {
+		level = b.balanceLast(level, nodes[len(nodes)-2], cur)
+	}
+	for _, node := range nodes {
+		if len(node.Children) == 0 {
+			continue
+		}
+		if err := b.t.bpm.writePage(node.PageNum, encodeNode(node)); err != nil {
+			return nil, err
+		}
+	}
+	return level, nil
+}
+
+// balanceLast brings the last node of a level, which is below the minimum fill, back within bounds by merging it into
+// the node before it, or splitting the entries of both evenly between them if they do not fit in a single node. A merged
+// node is emptied and its page returned to the free list. Returns the level updated accordingly
+func (b *bulkLoader) balanceLast(level []childRef, prev, last *Node) []childRef {
+	merged := prev.clone()
+	if prev.IsLeaf {
+		merged.Keys = append(merged.Keys, last.Keys...)
+		merged.Values = append(merged.Values, last.Values...)
+		merged.Overflow = append(merged.Overflow, last.Overflow...)
+		merged.Versions = append(merged.Versions, last.Versions...)
+		merged.Next = 0
+	} else {
+		merged.Keys = append(append(merged.Keys, level[len(level)-1].key), last.Keys...)
+		merged.Children = append(merged.Children, last.Children...)
+	}
+
+	if merged.Size() <= b.t.capacity {
+		*prev = *merged
+		*last = Node{
+			PageNum: last.PageNum,
+			IsLeaf:  last.IsLeaf,
+		}
+		b.t.bpm.DeletePage(b.txn, last.PageNum)
+		return level[:len(level)-1]
+	}
+
+	i := merged.SplitIndex()
+	if prev.IsLeaf {
+		prev.Keys, last.Keys = merged.Keys[:i], copyStrings(merged.Keys[i:])
+		prev.Values, last.Values = merged.Values[:i], copyStrings(merged.Values[i:])
+		prev.Overflow, last.Overflow = merged.Overflow[:i], copyInt64s(merged.Overflow[i:])
+		prev.Versions, last.Versions = merged.Versions[:i], copyInt64s(merged.Versions[i:])
+		level[len(level)-1].key = last.Keys[0]
+	} else {
+		// the key at the split index moves up to separate the two nodes
+		level[len(level)-1].key = merged.Keys[i]
+		prev.Keys, last.Keys = merged.Keys[:i], copyStrings(merged.Keys[i+1:])
+		prev.Children, last.Children = merged.Children[:i+1], copyInt64s(merged.Children[i+1:])
+	}
+	return level
+}
+
+// writeOverflow writes the value to a chain of overflow pages at the end of the db file and returns the first page of
+// the chain
+func (b *bulkLoader) writeOverflow(value string) (int64, error) {
+	pageNums := make([]int64, numOverflowPages(value))
+	for i := range pageNums {
+		pageNums[i] = b.t.bpm.reservePage(b.txn)
+	}
+	for i, data := range encodeOverflow(value, pageNums) {
+		if err := b.t.bpm.writePage(pageNums[i], data); err != nil {
+			return 0, err
+		}
+	}
+	return pageNums[0], nil
+}
+
+// reservePage extends the db file by a page for txn without writing it, which writePage does later. The pages are
+// released if txn is rolled back
+func (bpm *BufferPoolManager) reservePage(txn *WriteTxn) int64 {
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+	txn.dirty = true
+	pageNum := (bpm.size + PageSize - 1) / PageSize
+	bpm.size = (pageNum + 1) * PageSize
+	return pageNum
+}
+
+// extendToReserved extends the db file over every reserved page, including those which were never written since the
+// node they were reserved for was merged away, so that every page the commit frees lies within the db file
+func (bpm *BufferPoolManager) extendToReserved() error {
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+	fi, err := bpm.dbFile.Stat()
+	if err != nil {
+		return ioError("stat", bpm.dbFile, err)
+	}
+	if fi.Size() >= bpm.size {
+		return nil
+	}
+	if err := bpm.dbFile.Truncate(bpm.size); err != nil {
+		return ioError("extend", bpm.dbFile, err)
+	}
+	return nil
+}
+
+// writePage stamps the page with its checksum and writes it straight to the db file, bypassing the WAL and the buffer
+// pool. Only pages which nothing refers to yet may be written this way
+func (bpm *BufferPoolManager) writePage(pageNum int64, data []byte) error {
+	stampPage(data)
+	if _, err := bpm.dbFile.WriteAt(data, pageNum*PageSize); err != nil {
+		return ioError("write", bpm.dbFile, err)
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package bplustree
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+)
+
+// sortedEntries returns n entries in ascending key order
+func sortedEntries(n int) []KeyValue {
+	entries := make([]KeyValue, n)
+	for i := range entries {
+		key := fmt.Sprintf("k%05d", i)
+		entries[i] = KeyValue{Key: key, Value: "v" + key}
+	}
+	return entries
+}
+
+// numLeaves returns the number of leaves of the tree
+func numLeaves(t *testing.T, bpt *BPlusTree) int {
+	live, err := bpt.livePages()
+	assert.NoError(t, err)
+	leaves := 0
+	for _, info := range live {
+		if info.pageType == LEAF {
+			leaves++
+		}
+	}
+	return leaves
+}
+
+func TestBulkLoad(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 64, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	entries := sortedEntries(5000)
+
+	// Act
+	n, err := bpt.BulkLoad(&sliceSource{entries: entries}, 1)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, 5000, n)
+	bpt.ValidateTreeStructure()
+	assert.Equal(t, 5000, countKeys(t, &bpt, "", ""))
+	for _, kv := range entries {
+		value, version, present, err := bpt.GetVersion(kv.Key)
+		assert.NoError(t, err)
+		assert.True(t, present)
+		assert.Equal(t, kv.Value, value)
+		assert.Equal(t, int64(1), version)
+	}
+	// every leaf but the last two is full
+	perLeaf := (128 - LeafHeaderSize) / leafEntrySize(entries[0].Key, entries[0].Value)
+	assert.LessOrEqual(t, numLeaves(t, &bpt), (5000+perLeaf-1)/perLeaf+1)
+}
+
+func TestBulkLoadFillFactor(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 64, 256)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+
+	// Act
+	_, err := bpt.BulkLoad(&sliceSource{entries: sortedEntries(1000)}, 0.5)
+
+	// Assert
+	assert.NoError(t, err)
+	bpt.ValidateTreeStructure()
+	perLeaf := (256 - LeafHeaderSize) / leafEntrySize("k00000", "vk00000")
+	assert.Greater(t, numLeaves(t, &bpt), 1000/perLeaf*3/2)
+	assert.NoError(t, bpt.Set("k00500a", "value"))
+	assert.Equal(t, 1001, countKeys(t, &bpt, "", ""))
+}
+
+func TestBulkLoadLargeValues(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 64, -1)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	entries := sortedEntries(50)
+	for i := range entries {
+		if i%3 == 0 {
+			entries[i].Value = strings.Repeat(entries[i].Key, PageSize)
+		}
+	}
+
+	// Act
+	_, err := bpt.BulkLoad(&sliceSource{entries: copyEntries(entries)}, 0)
+
+	// Assert
+	assert.NoError(t, err)
+	pairs, err := bpt.Scan("", "", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, entries, pairs)
+}
+
+func TestBulkLoadSmallInputs(t *testing.T) {
+	for _, n := range []int{0, 1, 2} {
+		// Arrange
+		_ = os.Mkdir(TestDir, 0755)
+		bpt := NewBPlusTree(TestFile, 64, 128)
+
+		// Act
+		loaded, err := bpt.BulkLoad(&sliceSource{entries: sortedEntries(n)}, 0)
+
+		// Assert
+		assert.NoError(t, err)
+		assert.Equal(t, n, loaded)
+		bpt.ValidateTreeStructure()
+		assert.Equal(t, n, countKeys(t, &bpt, "", ""))
+		assert.NoError(t, bpt.Close())
+		_ = os.RemoveAll(TestDir)
+	}
+}
+
+func TestBulkLoadRejectsUnsortedInput(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 64, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	sizeBefore := dbSize(t)
+	entries := sortedEntries(1000)
+	entries[500], entries[501] = entries[501], entries[500]
+
+	// Act
+	n, err := bpt.BulkLoad(&sliceSource{entries: entries}, 0)
+
+	// Assert
+	assert.True(t, errors.Is(err, ErrUnsortedInput))
+	assert.Equal(t, 0, n)
+	assert.Equal(t, 0, countKeys(t, &bpt, "", ""))
+	assert.Equal(t, sizeBefore, dbSize(t))
+	assert.NoError(t, bpt.Set("key", "value"))
+}
+
+func TestBulkLoadRejectsNonEmptyTree(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 64, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	assert.NoError(t, bpt.Set("key", "value"))
+
+	// Act
+	_, err := bpt.BulkLoad(&sliceSource{entries: sortedEntries(10)}, 0)
+
+	// Assert
+	assert.Equal(t, ErrTreeNotEmpty, err)
+	assert.Equal(t, 1, countKeys(t, &bpt, "", ""))
+}
+
+func TestBulkLoadedTreeIsRecovered(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 64, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	_, err := bpt.BulkLoad(&sliceSource{entries: sortedEntries(2000)}, 0)
+	assert.NoError(t, err)
+
+	// Act
+	crash(&bpt)
+	bpt = NewBPlusTree(TestFile, 64, 128)
+	for i := 0; i < 2000; i += 7 {
+		assert.NoError(t, bpt.Set(fmt.Sprintf("k%05da", i), "value"))
+	}
+
+	// Assert
+	bpt.ValidateTreeStructure()
+	assert.Equal(t, 2000+286, countKeys(t, &bpt, "", ""))
+}
+
+// copyEntries returns a copy of the entries, which a sliceSource consumes
+func copyEntries(entries []KeyValue) []KeyValue {
+	return append([]KeyValue{}, entries...)
+}
+
+func BenchmarkBulkLoad(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		_ = os.Mkdir(TestDir, 0755)
+		bpt := NewBPlusTree(TestFile, 64, -1)
+		_, _ = bpt.BulkLoad(&sliceSource{entries: sortedEntries(10000)}, 0)
+		_ = bpt.Close()
+		_ = os.RemoveAll(TestDir)
+	}
+}
+
+// BenchmarkSetSorted loads the same entries as BenchmarkBulkLoad one Set at a time
+func BenchmarkSetSorted(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		_ = os.Mkdir(TestDir, 0755)
+		bpt := NewBPlusTree(TestFile, 64, -1)
+		for _, kv := range sortedEntries(10000) {
+			_ = bpt.Set(kv.Key, kv.Value)
+		}
+		_ = bpt.Close()
+		_ = os.RemoveAll(TestDir)
+	}
+}
@@ -0,0 +1,42 @@
+package bplustree
+
+// A Condition decides whether a conditional write goes ahead given the current state of the key. present is false when
+// the key does not exist, in which case value is empty and version is 0
+type Condition func(value string, version int64, present bool) bool
+
+// SetIf sets the value of the key if cond holds for its current state. The check and the write happen while the leaf
+// holding the key is latched exclusively so no other write can interleave. Returns whether the value was set
+func (t *BPlusTree) SetIf(key, value string, cond Condition) (bool, error) {
+	if err := t.checkKey(key); err != nil {
+		return false, err
+	}
+
+	return t.apply(&writeOp{key: key, value: value, cond: cond})
+}
+
+// DeleteIf removes the key if cond holds for its current state. The check and the delete happen while the leaf holding
+// the key is latched exclusively so no other write can interleave. Returns whether the key was removed
+func (t *BPlusTree) DeleteIf(key string, cond Condition) (bool, error) {
+	return t.apply(&writeOp{key: key, delete: true, cond: cond})
+}
+
+// CompareAndSwap sets the value of the key only if its current value is expected. Returns whether the value was set
+func (t *BPlusTree) CompareAndSwap(key, expected, value string) (bool, error) {
+	return t.SetIf(key, value, func(currentValue string, _ int64, present bool) bool {
+		return present && currentValue == expected
+	})
+}
+
+// SetIfAbsent sets the value of the key only if the key does not exist yet. Returns whether the value was set
+func (t *BPlusTree) SetIfAbsent(key, value string) (bool, error) {
+	return t.SetIf(key, value, func(_ string, _ int64, present bool) bool {
+		return !present
+	})
+}
+
+// DeleteIfEquals removes the key only if its current value is expected. Returns whether the key was removed
+func (t *BPlusTree) DeleteIfEquals(key, expected string) (bool, error) {
+	return t.DeleteIf(key, func(currentValue string, _ int64, _ bool) bool {
+		return currentValue == expected
+	})
+}
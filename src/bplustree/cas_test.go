@@ -0,0 +1,212 @@
+package bplustree
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestVersionIsIncrementedOnEveryWrite(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+
+	// Act
+	_ = bpt.Set("a", "1")
+	_ = bpt.Set("a", "2")
+	_ = bpt.Set("a", strings.Repeat("3", 2*PageSize))
+	_ = bpt.Set("b", "1")
+
+	// Assert
+	crash(&bpt)
+	// creating a new btree simulates recovering from a crash
+	bpt = NewBPlusTree(TestFile, 1, 128)
+	_, version, present, _ := bpt.GetVersion("a")
+	assert.True(t, present)
+	assert.Equal(t, int64(3), version)
+	_, version, _, _ = bpt.GetVersion("b")
+	assert.Equal(t, int64(1), version)
+	_, version, present, _ = bpt.GetVersion("c")
+	assert.False(t, present)
+	assert.Equal(t, int64(0), version)
+}
+
+func TestVersionsSurviveSplitsAndMerges(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	for i := 0; i < 100; i++ {
+		for j := 0; j <= i%3; j++ {
+			_ = bpt.Set(fmt.Sprintf("k%03d", i), "v")
+		}
+	}
+
+	// Act
+	for i := 0; i < 100; i += 2 {
+		bpt.Delete(fmt.Sprintf("k%03d", i))
+	}
+
+	// Assert
+	bpt.ValidateTreeStructure()
+	for i := 1; i < 100; i += 2 {
+		_, version, present, _ := bpt.GetVersion(fmt.Sprintf("k%03d", i))
+		assert.True(t, present)
+		assert.Equal(t, int64(i%3+1), version)
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	_ = bpt.Set("a", "old")
+
+	// Act
+	swappedMismatch, err1 := bpt.CompareAndSwap("a", "other", "new")
+	swappedMissing, err2 := bpt.CompareAndSwap("b", "", "new")
+	swapped, err3 := bpt.CompareAndSwap("a", "old", "new")
+
+	// Assert
+	assert.NoError(t, err1)
+	assert.NoError(t, err2)
+	assert.NoError(t, err3)
+	assert.False(t, swappedMismatch)
+	assert.False(t, swappedMissing)
+	assert.True(t, swapped)
+	value, _, _ := bpt.Get("a")
+	assert.Equal(t, "new", value)
+	_, present, _ := bpt.Get("b")
+	assert.False(t, present)
+}
+
+func TestSetIfAbsent(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+
+	// Act
+	first, _ := bpt.SetIfAbsent("a", "first")
+	second, _ := bpt.SetIfAbsent("a", "second")
+	_, err := bpt.SetIfAbsent(strings.Repeat("k", 128), "v")
+
+	// Assert
+	assert.True(t, first)
+	assert.False(t, second)
+	assert.Equal(t, ErrKeyTooLarge, err)
+	value, _, _ := bpt.Get("a")
+	assert.Equal(t, "first", value)
+}
+
+func TestDeleteIfEquals(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	_ = bpt.Set("a", "a")
+
+	// Act
+	deletedMismatch, _ := bpt.DeleteIfEquals("a", "b")
+	deletedMissing, _ := bpt.DeleteIfEquals("b", "")
+	deleted, _ := bpt.DeleteIfEquals("a", "a")
+
+	// Assert
+	assert.False(t, deletedMismatch)
+	assert.False(t, deletedMissing)
+	assert.True(t, deleted)
+	_, present, _ := bpt.Get("a")
+	assert.False(t, present)
+}
+
+func TestSetIfVersion(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	_ = bpt.Set("a", "a")
+	_, version, _, _ := bpt.GetVersion("a")
+	versionIs := func(expected int64) Condition {
+		return func(_ string, version int64, present bool) bool {
+			return present && version == expected
+		}
+	}
+
+	// Act
+	stale, _ := bpt.SetIf("a", "stale", versionIs(version-1))
+	current, _ := bpt.SetIf("a", "current", versionIs(version))
+
+	// Assert
+	assert.False(t, stale)
+	assert.True(t, current)
+	value, newVersion, _, _ := bpt.GetVersion("a")
+	assert.Equal(t, "current", value)
+	assert.Equal(t, version+1, newVersion)
+}
+
+func TestVersionIsNotReusedOnceKeyIsDeleted(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	_ = bpt.Set("a", "1")
+	_ = bpt.Set("a", "2")
+	_, staleVersion, _, _ := bpt.GetVersion("a")
+	_ = bpt.Delete("a")
+	crash(&bpt)
+	// creating a new btree simulates recovering from a crash
+	bpt = NewBPlusTree(TestFile, 1, 128)
+	_ = bpt.Set("a", "recreated")
+	_ = bpt.Set("a", "updated")
+
+	// Act
+	// a client holding the version of the deleted key must not overwrite the key set again
+	stale, err := bpt.SetIf("a", "stale", func(_ string, version int64, present bool) bool {
+		return present && version == staleVersion
+	})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.False(t, stale)
+	value, version, _, _ := bpt.GetVersion("a")
+	assert.Equal(t, "updated", value)
+	assert.Equal(t, staleVersion+2, version)
+}
+
+func TestConcurrentCompareAndSwap(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	_ = bpt.Set("counter", "0")
+	wg := sync.WaitGroup{}
+
+	// Act
+	// every goroutine increments the counter 20 times, retrying whenever another goroutine got in first
+	for g := 0; g < 5; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				for {
+					value, _, _ := bpt.Get("counter")
+					var n int
+					_, _ = fmt.Sscanf(value, "%d", &n)
+					if swapped, _ := bpt.CompareAndSwap("counter", value, fmt.Sprint(n+1)); swapped {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Assert
+	value, _, _ := bpt.Get("counter")
+	assert.Equal(t, "100", value)
+}
@@ -0,0 +1,451 @@
+package bplustree
+
+import (
+	"errors"
+	"fios-db/src/serialization"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Check reads a db which is not open, see offline.go, and reports every problem it finds rather than stopping at the
+// first one like ValidateTreeStructure:
+//
+// The tree is walked from the root stored in the metadata page, checking the type and checksum of every page, that the
+// keys of every node are in order and within the bounds set by its parent, and that leaves point at their neighbours.
+// The free list is then walked from its start, and every page reached by neither is reported as orphaned. The WAL is
+// checked for corrupt frames and for committed pages past the end of the db file. A page referenced from two places is
+// reported once, at the second reference, which is also how cycles in the free list and in overflow chains are found.
+//
+// Repair rebuilds a db from every leaf which can still be read, whether or not it is reachable from the root, keeping
+// the latest version of a key found in several leaves. A key deleted from the tree may therefore reappear if an orphaned
+// leaf still holds it.
+
+// A ProblemKind classifies the problems reported by Check
+type ProblemKind string
+
+const (
+	ChecksumFailure   ProblemKind = "checksum failure"
+	WrongPageType     ProblemKind = "wrong page type"
+	CorruptNode       ProblemKind = "corrupt node"
+	KeyOrder          ProblemKind = "key order violation"
+	DanglingReference ProblemKind = "dangling reference"
+	SharedPage        ProblemKind = "page referenced twice"
+	BrokenSiblings    ProblemKind = "broken sibling pointers"
+	FreeListCycle     ProblemKind = "free list cycle"
+	OrphanedPage      ProblemKind = "orphaned page"
+	CorruptWALFrame   ProblemKind = "corrupt WAL frame"
+	WALFramePastEOF   ProblemKind = "WAL frame past EOF"
+)
+
+// A Problem is an inconsistency found by Check in a page, or in the WAL if PageNum is negative
+type Problem struct {
+	Kind    ProblemKind `json:"kind"`
+	PageNum int64       `json:"pageNum"`
+	Detail  string      `json:"detail"`
+}
+
+func (p Problem) String() string {
+	if p.PageNum < 0 {
+		return fmt.Sprintf("WAL: %s: %s", p.Kind, p.Detail)
+	}
+	return fmt.Sprintf("page %d: %s: %s", p.PageNum, p.Kind, p.Detail)
+}
+
+// A CheckReport lists the problems found by Check along with what was found of the db
+type CheckReport struct {
+	Pages    int64     `json:"pages"` // number of pages once the WAL is recovered
+	Live     int       `json:"live"`  // number of pages reachable from the root
+	Free     int       `json:"free"`  // number of pages on the free list
+	Keys     int       `json:"keys"`  // number of keys in the leaves reachable from the root
+	Problems []Problem `json:"problems"`
+}
+
+// OK returns whether Check found no problem
+func (r *CheckReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// A RepairReport describes the db rebuilt by Repair
+type RepairReport struct {
+	Leaves int `json:"leaves"` // number of leaves the keys were recovered from
+	Keys   int `json:"keys"`   // number of keys recovered
+	Lost   int `json:"lost"`   // number of keys dropped since their value could not be read
+}
+
+// A checker walks the pages of a db on behalf of Check
+type checker struct {
+	db       *offlineDb
+	numPages int64
+	report   *CheckReport
+	seen     map[int64]string // page number to what the page was first reached from
+	leaves   []*Node          // leaves reachable from the root in key order, nil for a subtree which could not be read
+}
+
+// Check reads the db stored in fileName, which must not be open, without modifying it and reports every problem found
+func Check(fileName string) (*CheckReport, error) {
+	db, err := openOfflineDb(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = db.Close() }()
+
+	c := &checker{
+		db:       db,
+		numPages: db.numPages(),
+		report:   &CheckReport{Problems: make([]Problem, 0)},
+		seen:     map[int64]string{},
+	}
+	c.report.Pages = c.numPages
+	c.checkWAL()
+	root, freePageStart, err := db.metadata()
+	if errors.Is(err, ErrCorruption) {
+		c.add(ChecksumFailure, 0, "the metadata page is corrupt, so the tree and free list cannot be walked")
+		return c.report, c.checkOrphans()
+	} else if err != nil {
+		return nil, err
+	}
+
+	c.seen[0] = "the metadata page"
+	if err := c.checkNode(root, "the metadata page", "", "", false, false); err != nil {
+		return nil, err
+	}
+	c.checkSiblings()
+	c.report.Live = len(c.seen) - 1
+	if err := c.checkFreeList(freePageStart); err != nil {
+		return nil, err
+	}
+	return c.report, c.checkOrphans()
+}
+
+// add reports a problem
+func (c *checker) add(kind ProblemKind, pageNum int64, format string, args ...interface{}) {
+	c.report.Problems = append(c.report.Problems, Problem{
+		Kind:    kind,
+		PageNum: pageNum,
+		Detail:  fmt.Sprintf(format, args...),
+	})
+}
+
+// checkWAL reports the corrupt frame the WAL was read up to, and every committed page past the end of the db file
+func (c *checker) checkWAL() {
+	if c.db.walErr != nil {
+		c.add(CorruptWALFrame, -1, "%v, the frames which follow are ignored", c.db.walErr)
+	}
+	for _, pageNum := range c.db.walPages() {
+		if pageNum*PageSize >= c.db.fileSize {
+			c.add(WALFramePastEOF, -1, "page %d is committed to the WAL but the db file holds %d pages", pageNum,
+				c.db.fileSize/PageSize)
+		}
+	}
+}
+
+// visit marks the page as reached from referrer. Returns false if the page does not exist or was already reached, which
+// is reported
+func (c *checker) visit(pageNum int64, referrer string) bool {
+	if pageNum <= 0 || pageNum >= c.numPages {
+		c.add(DanglingReference, pageNum, "referenced by %s but the db holds %d pages", referrer, c.numPages)
+		return false
+	}
+	if first, ok := c.seen[pageNum]; ok {
+		c.add(SharedPage, pageNum, "referenced by %s, and already by %s", referrer, first)
+		return false
+	}
+	c.seen[pageNum] = referrer
+	return true
+}
+
+// readPage reads a page reached from the tree or the free list. Returns nil if the page could not be read, which is
+// reported unless reading it failed, in which case the error is returned
+func (c *checker) readPage(pageNum int64) ([]byte, error) {
+	data, err := c.db.page(pageNum)
+	if errors.Is(err, ErrCorruption) {
+		c.add(ChecksumFailure, pageNum, "%v", err)
+		return nil, nil
+	}
+	return data, err
+}
+
+// checkNode checks the subtree stored in the page, whose keys must be at least lower if hasLower is set and less than
+// upper if hasUpper is set
+func (c *checker) checkNode(pageNum int64, referrer string, lower, upper string, hasLower, hasUpper bool) error {
+	if !c.visit(pageNum, referrer) {
+		return nil
+	}
+	node, err := c.readNode(pageNum, referrer)
+	if node == nil {
+		// the leaves next to the subtree cannot be checked against it
+		c.leaves = append(c.leaves, nil)
+		return err
+	}
+
+	for i, key := range node.Keys {
+		if i > 0 && key <= node.Keys[i-1] {
+			c.add(KeyOrder, pageNum, "key %q follows key %q", key, node.Keys[i-1])
+		}
+		if (hasLower && key < lower) || (hasUpper && key >= upper) {
+			c.add(KeyOrder, pageNum, "key %q is outside of the range its parent assigns to page %d", key, pageNum)
+		}
+	}
+	if node.IsLeaf {
+		c.leaves = append(c.leaves, node)
+		c.report.Keys += len(node.Keys)
+		for i, overflow := range node.Overflow {
+			if overflow != 0 {
+				c.checkOverflow(overflow, fmt.Sprintf("the value of key %q in page %d", node.Keys[i], pageNum))
+			}
+		}
+		return nil
+	}
+	for i, child := range node.Children {
+		childLower, childUpper := lower, upper
+		childHasLower, childHasUpper := hasLower, hasUpper
+		if i > 0 {
+			childLower, childHasLower = node.Keys[i-1], true
+		}
+		if i < len(node.Keys) {
+			childUpper, childHasUpper = node.Keys[i], true
+		}
+		referrer := fmt.Sprintf("child %d of page %d", i, pageNum)
+		if err := c.checkNode(child, referrer, childLower, childUpper, childHasLower, childHasUpper); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readNode reads the node stored in the page. Returns nil if the page is not a node or could not be read, which is
+// reported unless reading it failed, in which case the error is returned
+func (c *checker) readNode(pageNum int64, referrer string) (*Node, error) {
+	data, err := c.readPage(pageNum)
+	if data == nil {
+		return nil, err
+	}
+	pageType := PageType(serialization.BytesToInt16(data[:PageTypeSize]))
+	if pageType != LEAF && pageType != INTERNAL {
+		c.add(WrongPageType, pageNum, "referenced by %s as a node but is of type %s", referrer, pageType)
+		return nil, nil
+	}
+	if err := validateNodeLayout(pageNum, data); err != nil {
+		c.add(CorruptNode, pageNum, "%v", err)
+		return nil, nil
+	}
+	node, err := decodeNode(pageNum, data)
+	if err != nil {
+		c.add(CorruptNode, pageNum, "%v", err)
+		return nil, nil
+	}
+	return node, nil
+}
+
+// checkOverflow checks the chain of overflow pages starting at pageNum
+func (c *checker) checkOverflow(pageNum int64, referrer string) {
+	for pageNum > 0 && c.visit(pageNum, referrer) {
+		data, err := c.readPage(pageNum)
+		if data == nil || err != nil {
+			return
+		}
+		if pageType := PageType(serialization.BytesToInt16(data[:PageTypeSize])); pageType != OVERFLOW {
+			c.add(WrongPageType, pageNum, "referenced by %s as an overflow page but is of type %s", referrer, pageType)
+			return
+		}
+		referrer = fmt.Sprintf("overflow page %d", pageNum)
+		pageNum = serialization.BytesToInt64(data[PageTypeSize : PageTypeSize+PageRefSize])
+	}
+}
+
+// checkSiblings reports leaves which do not point at the leaves before and after them in key order. Pointers into a
+// subtree which could not be read are not checked
+func (c *checker) checkSiblings() {
+	for i, leaf := range c.leaves {
+		if leaf == nil {
+			continue
+		}
+		prev, next := leaf.Prev, leaf.Next
+		if i == 0 {
+			prev = 0
+		} else if c.leaves[i-1] != nil {
+			prev = c.leaves[i-1].PageNum
+		}
+		if i == len(c.leaves)-1 {
+			next = 0
+		} else if c.leaves[i+1] != nil {
+			next = c.leaves[i+1].PageNum
+		}
+		if leaf.Prev != prev || leaf.Next != next {
+			c.add(BrokenSiblings, leaf.PageNum, "points at leaves %d and %d rather than %d and %d", leaf.Prev, leaf.Next,
+				prev, next)
+		}
+	}
+}
+
+// checkFreeList checks every page of the free list starting at pageNum
+func (c *checker) checkFreeList(pageNum int64) error {
+	onList := map[int64]bool{}
+	referrer := "the metadata page"
+	for pageNum > 0 {
+		if onList[pageNum] {
+			c.add(FreeListCycle, pageNum, "the free list loops back to page %d from %s", pageNum, referrer)
+			return nil
+		}
+		onList[pageNum] = true
+		if !c.visit(pageNum, referrer) {
+			return nil
+		}
+		data, err := c.readPage(pageNum)
+		if data == nil {
+			return err
+		}
+		if pageType := PageType(serialization.BytesToInt16(data[:PageTypeSize])); pageType != FREE {
+			c.add(WrongPageType, pageNum, "on the free list but is of type %s", pageType)
+			return nil
+		}
+		c.report.Free++
+		referrer = fmt.Sprintf("free page %d", pageNum)
+		pageNum = serialization.BytesToInt64(data[PageTypeSize : PageTypeSize+PageRefSize])
+	}
+	return nil
+}
+
+// checkOrphans reports every page reached from neither the tree nor the free list
+func (c *checker) checkOrphans() error {
+	for pageNum := int64(1); pageNum < c.numPages; pageNum++ {
+		if _, ok := c.seen[pageNum]; ok {
+			continue
+		}
+		data, err := c.db.page(pageNum)
+		switch {
+		case errors.Is(err, ErrCorruption) && unwritten(data):
+			c.add(OrphanedPage, pageNum, "the page was never written")
+		case errors.Is(err, ErrCorruption):
+			c.add(ChecksumFailure, pageNum, "%v", err)
+			c.add(OrphanedPage, pageNum, "the page is corrupt")
+		case err != nil:
+			return err
+		default:
+			pageType := PageType(serialization.BytesToInt16(data[:PageTypeSize]))
+			c.add(OrphanedPage, pageNum, "a %s page reached from neither the root nor the free list", pageType)
+		}
+	}
+	return nil
+}
+
+// Repair rebuilds the db stored in fileName, which must not be open, from every leaf which can still be read, see above.
+// The files of the db are moved to the directory fileName.corrupt, from which the db may still be opened
+func Repair(fileName string) (RepairReport, error) {
+	report := RepairReport{}
+	entries, err := recoverEntries(fileName, &report)
+	if err != nil {
+		return report, err
+	}
+
+	corruptDir := fileName + ".corrupt"
+	if err := os.Mkdir(corruptDir, 0755); err != nil {
+		return report, err
+	}
+	oldFiles, err := dbFiles(fileName)
+	if err != nil {
+		return report, err
+	}
+	repairedName := fileName + ".repaired"
+	repaired := NewBPlusTree(repairedName, 0, -1)
+	_, err = repaired.BulkLoad(&sliceSource{entries: entries}, DefaultFillFactor)
+	if closeErr := repaired.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return report, err
+	}
+
+	for _, name := range oldFiles {
+		if err := os.Rename(name, filepath.Join(corruptDir, filepath.Base(name))); err != nil {
+			return report, err
+		}
+	}
+	newFiles, err := dbFiles(repairedName)
+	if err != nil {
+		return report, err
+	}
+	for _, name := range newFiles {
+		ext := strings.TrimPrefix(filepath.Base(name), filepath.Base(repairedName))
+		if err := os.Rename(name, fileName+ext); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// recoverEntries reads the entries of every leaf of the db stored in fileName which can be read, keeping the latest
+// version of every key, and returns them in key order
+func recoverEntries(fileName string, report *RepairReport) ([]KeyValue, error) {
+	db, err := openOfflineDb(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = db.Close() }()
+
+	versions := map[string]int64{}
+	values := map[string]string{}
+	for pageNum := int64(1); pageNum < db.numPages(); pageNum++ {
+		node, err := db.node(pageNum)
+		if errors.Is(err, ErrCorruption) || (err == nil && !node.IsLeaf) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		report.Leaves++
+		for i, key := range node.Keys {
+			if version, ok := versions[key]; ok && version >= node.Versions[i] {
+				continue
+			}
+			value := node.Values[i]
+			if node.Overflow[i] != 0 {
+				if value, err = db.overflow(node.Overflow[i]); err != nil {
+					report.Lost++
+					continue
+				}
+			}
+			versions[key] = node.Versions[i]
+			values[key] = value
+		}
+	}
+
+	entries := make([]KeyValue, 0, len(values))
+	for key, value := range values {
+		entries = append(entries, KeyValue{Key: key, Value: value})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	report.Keys = len(entries)
+	return entries, nil
+}
+
+// dbFiles returns the db file and the files of the WAL of the db stored in fileName
+func dbFiles(fileName string) ([]string, error) {
+	names := []string{fileName + ".db"}
+	for _, ext := range []string{".store", ".index"} {
+		segments, err := filepath.Glob(fileName + ".*" + ext)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, segments...)
+	}
+	return names, nil
+}
+
+// A sliceSource yields the entries of a slice in order to BulkLoad
+type sliceSource struct {
+	entries []KeyValue
+}
+
+func (s *sliceSource) Next() (KeyValue, bool, error) {
+	if len(s.entries) == 0 {
+		return KeyValue{}, false, nil
+	}
+	kv := s.entries[0]
+	s.entries = s.entries[1:]
+	return kv, true, nil
+}
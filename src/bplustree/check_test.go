@@ -0,0 +1,241 @@
+package bplustree
+
+import (
+	"fios-db/src/serialization"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+)
+
+// writeRawPage stamps the page and writes it to the db file, bypassing the tree
+func writeRawPage(t *testing.T, pageNum int64, data []byte) {
+	f, err := os.OpenFile(TestFile+".db", os.O_RDWR, 0666)
+	assert.NoError(t, err)
+	defer func() {_ = f.Close()}()
+	stampPage(data)
+	_, err = f.WriteAt(data, pageNum*PageSize)
+	assert.NoError(t, err)
+}
+
+// readRawPage reads the page from the db file
+func readRawPage(t *testing.T, pageNum int64) []byte {
+	f, err := os.Open(TestFile + ".db")
+	assert.NoError(t, err)
+	defer func() {_ = f.Close()}()
+	data := make([]byte, PageSize)
+	_, err = f.ReadAt(data, pageNum*PageSize)
+	assert.NoError(t, err)
+	return data
+}
+
+// hasProblem returns whether the report holds a problem of the kind in the page
+func hasProblem(report *CheckReport, kind ProblemKind, pageNum int64) bool {
+	for _, problem := range report.Problems {
+		if problem.Kind == kind && problem.PageNum == pageNum {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCheckHealthyDb(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 16, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	keys := seedTree(&bpt, 500)
+	kept := deleteMost(t, &bpt, keys)
+	assert.NoError(t, bpt.Set("large", strings.Repeat("large", PageSize)))
+	assert.NoError(t, bpt.Close())
+
+	// Act
+	report, err := Check(TestFile)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Empty(t, report.Problems)
+	assert.True(t, report.OK())
+	assert.Equal(t, len(kept)+1, report.Keys)
+	assert.True(t, report.Free > 0)
+	assert.Equal(t, report.Pages-1, int64(report.Live+report.Free))
+}
+
+func TestCheckRecoversWAL(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 16, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	keys := seedTree(&bpt, 300)
+	crash(&bpt)
+	sizeBefore := dbSize(t)
+
+	// Act
+	report, err := Check(TestFile)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Empty(t, report.Problems)
+	assert.Equal(t, len(keys), report.Keys)
+	assert.Equal(t, sizeBefore, dbSize(t))
+	assert.NotEmpty(t, walStoreFiles(t))
+}
+
+func TestCheckReportsChecksumFailure(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 16, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	seedTree(&bpt, 300)
+	pageNum := leafOf(&bpt, "k150").PageNum
+	assert.NoError(t, bpt.Close())
+	corruptFile(t, TestFile+".db", pageNum*PageSize+LeafHeaderSize)
+
+	// Act
+	report, err := Check(TestFile)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.False(t, report.OK())
+	assert.Equal(t, ChecksumFailure, report.Problems[0].Kind)
+	assert.Equal(t, pageNum, report.Problems[0].PageNum)
+	assert.Len(t, report.Problems, 1)
+}
+
+func TestCheckReportsKeyOrder(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 16, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	seedTree(&bpt, 300)
+	leaf := leafOf(&bpt, "k150")
+	assert.NoError(t, bpt.Close())
+	leaf.Keys[0], leaf.Keys[1] = leaf.Keys[1], leaf.Keys[0]
+	writeRawPage(t, leaf.PageNum, encodeNode(leaf))
+
+	// Act
+	report, err := Check(TestFile)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.True(t, hasProblem(report, KeyOrder, leaf.PageNum))
+}
+
+func TestCheckReportsWrongPageType(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 16, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	seedTree(&bpt, 300)
+	pageNum := leafOf(&bpt, "k150").PageNum
+	assert.NoError(t, bpt.Close())
+	data := make([]byte, PageSize)
+	copy(data, serialization.Int16ToBytes(int16(FREE)))
+	writeRawPage(t, pageNum, data)
+
+	// Act
+	report, err := Check(TestFile)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.True(t, hasProblem(report, WrongPageType, pageNum))
+}
+
+func TestCheckReportsFreeListCycle(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 16, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	deleteMost(t, &bpt, seedTree(&bpt, 300))
+	assert.NoError(t, bpt.Close())
+	freePageStart := serialization.BytesToInt64(readRawPage(t, 0)[PageRefSize : 2*PageRefSize])
+	data := readRawPage(t, freePageStart)
+	copy(data[PageTypeSize:], serialization.Int64ToBytes(freePageStart))
+	writeRawPage(t, freePageStart, data)
+
+	// Act
+	report, err := Check(TestFile)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.True(t, hasProblem(report, FreeListCycle, freePageStart))
+	assert.Equal(t, 1, report.Free)
+}
+
+func TestCheckReportsOrphanedPage(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 16, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	seedTree(&bpt, 300)
+	assert.NoError(t, bpt.Close())
+	pageNum := dbSize(t) / PageSize
+	writeRawPage(t, pageNum, encodeNode(NewLeafNode(pageNum, []string{"orphan"}, []string{"value"})))
+
+	// Act
+	report, err := Check(TestFile)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, []Problem{{
+		Kind:    OrphanedPage,
+		PageNum: pageNum,
+		Detail:  "a LEAF page reached from neither the root nor the free list",
+	}}, report.Problems)
+}
+
+func TestCheckReportsWALFramePastEOF(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 16, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	seedTree(&bpt, 300)
+	crash(&bpt)
+	assert.NoError(t, os.Truncate(TestFile+".db", PageSize))
+
+	// Act
+	report, err := Check(TestFile)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.True(t, hasProblem(report, WALFramePastEOF, -1))
+}
+
+func TestRepairRebuildsTreeFromLeaves(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 16, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	keys := seedTree(&bpt, 500)
+	assert.NoError(t, bpt.Set("large", strings.Repeat("large", PageSize)))
+	leaf := leafOf(&bpt, "k250")
+	root := bpt.bpm.RootPageNum()
+	assert.NoError(t, bpt.Close())
+	corruptFile(t, TestFile+".db", leaf.PageNum*PageSize+LeafHeaderSize)
+	corruptFile(t, TestFile+".db", root*PageSize+InternalHeaderSize)
+
+	// Act
+	report, err := Repair(TestFile)
+	repaired := NewBPlusTree(TestFile, 16, -1)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, len(keys)+1-len(leaf.Keys), report.Keys)
+	assert.Equal(t, 0, report.Lost)
+	repaired.ValidateTreeStructure()
+	assert.Equal(t, report.Keys, countKeys(t, &repaired, "", ""))
+	value, present, err := repaired.Get("large")
+	assert.NoError(t, err)
+	assert.True(t, present)
+	assert.Equal(t, strings.Repeat("large", PageSize), value)
+	_, present, err = repaired.Get(leaf.Keys[0])
+	assert.NoError(t, err)
+	assert.False(t, present)
+	assert.NoError(t, repaired.Close())
+	check, err := Check(TestFile)
+	assert.NoError(t, err)
+	assert.Empty(t, check.Problems)
+	_, err = os.Stat(TestFile + ".corrupt/db.db")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, walStoreFiles(t))
+}
@@ -0,0 +1,87 @@
+package bplustree
+
+import (
+	"log"
+	"time"
+)
+
+// A checkpoint bounds the size of the WAL, and with it the time it takes to recover from a crash. Every dirty page in
+// the buffer pool, which includes every page whose last committed version is in the WAL but not in the db file, is
+// written to the db file, the db file is synced and a CHECKPOINT frame is appended to the WAL, after which the WAL is
+// truncated. Should the db crash before the WAL is truncated, recovery skips the
+// transactions committed before the CHECKPOINT frame.
+//
+// Checkpoints run once the WAL grows past the checkpoint size at the end of a commit, and periodically if a checkpoint
+// interval is configured.
+
+// Checkpoint writes every dirty page to the db file and truncates the WAL. Nothing is truncated if the WAL could not be
+// synced or the db file could not be written, in which case the WAL is recovered the next time the db is opened
+func (bpm *BufferPoolManager) Checkpoint() error {
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+	return bpm.checkpoint()
+}
+
+// checkpoint writes every dirty page to the db file and truncates the WAL. Writes of transactions which
+// have not committed yet stay in the WAL. Nothing is checkpointed while a backup is running, see backup.go. The caller
+// must hold mu
+func (bpm *BufferPoolManager) checkpoint() error {
+	if bpm.wal.SizeInBytes() == 0 || bpm.backups > 0 {
+		return nil
+	}
+	if _, err := bpm.writeDirtyPages(); err != nil {
+		return err
+	}
+	if err := bpm.syncDbFile(); err != nil {
+		return err
+	}
+	err := bpm.wal.Append(Frame{
+		FrameType: CHECKPOINT,
+	})
+	if err != nil {
+		return err
+	}
+	return bpm.wal.Truncate()
+}
+
+// Close stops the background writer, checkpoints the WAL and closes the files backing the BufferPoolManager. The files
+// are closed even if the checkpoint fails, in which case the WAL is recovered the next time the db is opened. The
+// BufferPoolManager must not be used once closed
+func (bpm *BufferPoolManager) Close() error {
+	bpm.stopBackgroundWriter()
+
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+	err := bpm.checkpoint()
+	if walErr := bpm.wal.Close(); err == nil {
+		err = walErr
+	}
+	if closeErr := bpm.dbFile.Close(); err == nil && closeErr != nil {
+		err = ioError("close", bpm.dbFile, closeErr)
+	}
+	return err
+}
+
+func (bpm *BufferPoolManager) syncDbFile() error {
+	if err := bpm.dbFile.Sync(); err != nil {
+		return ioError("sync", bpm.dbFile, err)
+	}
+	return nil
+}
+
+// runCheckpointer checkpoints the WAL every interval until stop is closed
+func (t *BPlusTree) runCheckpointer(interval time.Duration, stop <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := t.bpm.Checkpoint(); err != nil {
+				log.Printf("Failure checkpointing WAL: %v", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package bplustree
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// walStoreFiles returns the store files of the segments of the WAL in offset order
+func walStoreFiles(t *testing.T) []string {
+	fileNames, err := filepath.Glob(TestFile + ".*.store")
+	assert.NoError(t, err)
+	return fileNames
+}
+
+func walSize(t *testing.T) int64 {
+	size := int64(0)
+	for _, fileName := range walStoreFiles(t) {
+		fi, err := os.Stat(fileName)
+		assert.NoError(t, err)
+		size += fi.Size()
+	}
+	return size
+}
+
+func TestWALIsCheckpointedOnceItGrowsPastCheckpointSize(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	checkpointSize := int64(64 * PageSize)
+	bpt := NewBPlusTree(TestFile, 1, 128, WithCheckpointSize(checkpointSize))
+	defer func() {_ = os.RemoveAll(TestDir)}()
+
+	// Act
+	maxWalSize := int64(0)
+	for i := 0; i < 500; i++ {
+		_ = bpt.Set(fmt.Sprintf("k%03d", i), fmt.Sprintf("v%03d", i))
+		if size := walSize(t); size > maxWalSize {
+			maxWalSize = size
+		}
+	}
+
+	// Assert
+	assert.Less(t, maxWalSize, checkpointSize+8*PageSize)
+	crash(&bpt)
+	// creating a new btree simulates recovering from a crash
+	bpt = NewBPlusTree(TestFile, 1, 128, WithCheckpointSize(checkpointSize))
+	bpt.ValidateTreeStructure()
+	for i := 0; i < 500; i++ {
+		value, present, _ := bpt.Get(fmt.Sprintf("k%03d", i))
+		assert.True(t, present)
+		assert.Equal(t, fmt.Sprintf("v%03d", i), value)
+	}
+}
+
+func TestCheckpointKeepsUncommittedTxns(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	_ = bpt.Set("committed", "committed")
+	committedTxn := bpt.Begin()
+	for i := 0; i < 20; i++ {
+		_ = committedTxn.Put(fmt.Sprintf("c%02d", i), "committed")
+	}
+
+	// Act
+	bpt.Checkpoint()
+	_ = committedTxn.Commit()
+	rolledBackTxn := bpt.Begin()
+	for i := 0; i < 20; i++ {
+		_ = rolledBackTxn.Put(fmt.Sprintf("r%02d", i), "rolled back")
+	}
+	bpt.Checkpoint()
+	rolledBackTxn.Rollback()
+
+	// Assert
+	assertCommittedState := func(bpt BPlusTree) {
+		bpt.ValidateTreeStructure()
+		assert.Equal(t, 21, countKeys(t, &bpt, "", ""))
+		assert.Equal(t, 20, countKeys(t, &bpt, "c00", "c99"))
+		value, _, _ := bpt.Get("committed")
+		assert.Equal(t, "committed", value)
+	}
+	assertCommittedState(bpt)
+	crash(&bpt)
+	bpt = NewBPlusTree(TestFile, 1, 128)
+	assertCommittedState(bpt)
+}
+
+func TestRecoverySkipsTxnsCommittedBeforeCheckpoint(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	wal := NewWAL(TestFile, 0, DefaultAsyncFlushInterval)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	page := func(b byte) []byte {
+		data := make([]byte, PageSize)
+		data[0] = b
+		return data
+	}
+
+	// Act
+	// the db crashed after the CHECKPOINT frame was appended but before the WAL was truncated
+	wal.Append(Frame{FrameType: PUT, TxnId: 1, PageNum: 1, Data: page(1)})
+	wal.Append(Frame{FrameType: PUT, TxnId: 2, PageNum: 2, Data: page(2)})
+	wal.Append(Frame{FrameType: COMMIT, TxnId: 1})
+	wal.Append(Frame{FrameType: CHECKPOINT})
+	wal.Append(Frame{FrameType: COMMIT, TxnId: 2})
+	frames := make([]*Frame, 0)
+	committedFrames, readErr := NewWAL(TestFile, 0, DefaultAsyncFlushInterval).ReadAllCommittedFrames()
+	for frame := range committedFrames {
+		frames = append(frames, frame)
+	}
+
+	// Assert
+	assert.NoError(t, <-readErr)
+	assert.Equal(t, 1, len(frames))
+	assert.Equal(t, int64(2), frames[0].PageNum)
+	assert.Equal(t, page(2), frames[0].Data)
+}
+
+func TestCheckpointInterval(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128, WithCheckpointInterval(10*time.Millisecond))
+	defer func() {_ = os.RemoveAll(TestDir)}()
+
+	// Act
+	_ = bpt.Set("a", "a")
+	sizeAfterSet := walSize(t)
+	time.Sleep(100 * time.Millisecond)
+	sizeAfterInterval := walSize(t)
+	bpt.Close()
+
+	// Assert
+	assert.Greater(t, sizeAfterSet, int64(0))
+	assert.Equal(t, int64(0), sizeAfterInterval)
+	bpt = NewBPlusTree(TestFile, 1, 128)
+	value, _, _ := bpt.Get("a")
+	assert.Equal(t, "a", value)
+}
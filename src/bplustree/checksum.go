@@ -0,0 +1,34 @@
+package bplustree
+
+import (
+	"fios-db/src/serialization"
+	"fmt"
+	"hash/crc32"
+)
+
+// Every page ends with a CRC32C checksum of the rest of the page, and every WAL frame ends with a checksum of the rest of
+// the frame. Checksums are verified whenever a page is read from the db file or the WAL, so a page which was only
+// partially written before a crash is reported as corrupt rather than read back as valid data. Recovery stops replaying
+// the WAL at the first corrupt frame, since frames after a torn write cannot be trusted either.
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+func checksum(data []byte) []byte {
+	return serialization.Int32ToBytes(int32(crc32.Checksum(data, castagnoli)))
+}
+
+// stampPage stores the checksum of the page in its last bytes
+func stampPage(pageBytes []byte) {
+	copy(pageBytes[UsablePageSize:], checksum(pageBytes[:UsablePageSize]))
+}
+
+// verifyPage returns a CorruptPageError if the page does not match its checksum
+func verifyPage(pageNum int64, pageBytes []byte) error {
+	if len(pageBytes) != PageSize {
+		return &CorruptPageError{PageNum: pageNum, Reason: fmt.Sprintf("page is %d bytes long", len(pageBytes))}
+	}
+	if string(checksum(pageBytes[:UsablePageSize])) != string(pageBytes[UsablePageSize:]) {
+		return &CorruptPageError{PageNum: pageNum, Reason: "checksum mismatch"}
+	}
+	return nil
+}
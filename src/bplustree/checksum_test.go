@@ -0,0 +1,155 @@
+package bplustree
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// corruptFile flips a byte of the file at offset, or at offset bytes from the end of the file if offset is negative
+func corruptFile(t *testing.T, fileName string, offset int64) {
+	f, err := os.OpenFile(fileName, os.O_RDWR, 0666)
+	assert.NoError(t, err)
+	defer func() {_ = f.Close()}()
+	if offset < 0 {
+		fi, _ := f.Stat()
+		offset += fi.Size()
+	}
+	b := make([]byte, 1)
+	_, err = f.ReadAt(b, offset)
+	assert.NoError(t, err)
+	b[0] ^= 0xff
+	_, err = f.WriteAt(b, offset)
+	assert.NoError(t, err)
+}
+
+// leafOf returns the leaf holding key
+func leafOf(bpt *BPlusTree, key string) *Node {
+	leaf, _ := bpt.findLeaf(atOrAfter(key))
+	bpt.bpm.Latch(leaf.PageNum).RUnlock()
+	return leaf
+}
+
+func TestCorruptPageIsReportedAsError(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	keys := seedTree(&bpt, 100)
+	pageNum := leafOf(&bpt, "k050").PageNum
+	assert.NoError(t, bpt.Close())
+	corruptFile(t, TestFile+".db", pageNum*PageSize+LeafHeaderSize)
+	bpt = NewBPlusTree(TestFile, 1, 128)
+
+	// Act
+	_, _, getErr := bpt.Get("k050")
+	setErr := bpt.Set("k050", "v")
+	deleteErr := bpt.Delete("k050")
+	_, scanErr := bpt.Scan("", "", 0)
+	txn := bpt.Begin()
+	assert.NoError(t, txn.Put("k000", "rolled back"))
+	txnErr := txn.Put("k050", "v")
+	commitErr := txn.Commit()
+
+	// Assert
+	var corruptPageErr *CorruptPageError
+	assert.True(t, errors.As(getErr, &corruptPageErr))
+	assert.Equal(t, pageNum, corruptPageErr.PageNum)
+	for _, err := range []error{setErr, deleteErr, scanErr, txnErr, commitErr} {
+		assert.True(t, errors.Is(err, ErrCorruption))
+	}
+	// keys in other leaves can still be read and written
+	value, _, err := bpt.Get(keys[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "v"+keys[0], value)
+	assert.NoError(t, bpt.Set(keys[99], "v"))
+	value, _, err = bpt.Get(keys[99])
+	assert.NoError(t, err)
+	assert.Equal(t, "v", value)
+}
+
+func TestWriteFailingPartWayIsAborted(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	seedTree(&bpt, 100)
+	// corrupt both neighbours of a leaf so that rebalancing the leaf once it underflows fails
+	leaf := leafOf(&bpt, "k050")
+	assert.NoError(t, bpt.Close())
+	corruptFile(t, TestFile+".db", leaf.Prev*PageSize+LeafHeaderSize)
+	corruptFile(t, TestFile+".db", leaf.Next*PageSize+LeafHeaderSize)
+	bpt = NewBPlusTree(TestFile, 1, 128)
+
+	// Act
+	deleted := 0
+	var err error
+	for err == nil {
+		if err = bpt.Delete(leaf.Keys[deleted]); err == nil {
+			deleted++
+		}
+	}
+
+	// Assert
+	assert.True(t, errors.Is(err, ErrCorruption))
+	assert.Less(t, deleted, len(leaf.Keys))
+	for _, reopen := range []bool{false, true} {
+		if reopen {
+			crash(&bpt)
+			// creating a new btree simulates recovering from a crash
+			bpt = NewBPlusTree(TestFile, 1, 128)
+		}
+		for i, key := range leaf.Keys {
+			_, present, err := bpt.Get(key)
+			assert.NoError(t, err)
+			assert.Equal(t, i >= deleted, present)
+		}
+	}
+}
+
+func TestCorruptWalFrameStopsRecovery(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	keys := seedTree(&bpt, 50)
+	_ = bpt.Set("torn", "v")
+	// flipping the last byte of the WAL breaks the checksum of the commit frame of the last write
+	storeFiles := walStoreFiles(t)
+	corruptFile(t, storeFiles[len(storeFiles)-1], -1)
+
+	// Act
+	crash(&bpt)
+	// creating a new btree simulates recovering from a crash
+	bpt = NewBPlusTree(TestFile, 1, 128)
+
+	// Assert
+	bpt.ValidateTreeStructure()
+	assert.Equal(t, keys, collect(bpt.Iterator(false)))
+	_, present, err := bpt.Get("torn")
+	assert.NoError(t, err)
+	assert.False(t, present)
+	assert.NoError(t, bpt.Set("torn", "v"))
+	crash(&bpt)
+	bpt = NewBPlusTree(TestFile, 1, 128)
+	value, _, err := bpt.Get("torn")
+	assert.NoError(t, err)
+	assert.Equal(t, "v", value)
+}
+
+func TestChecksumDetectsChangedPage(t *testing.T) {
+	// Arrange
+	page := make([]byte, PageSize)
+	copy(page, "page contents")
+	stampPage(page)
+
+	// Act
+	intact := verifyPage(1, page)
+	page[3] ^= 1
+	changed := verifyPage(1, page)
+
+	// Assert
+	assert.NoError(t, intact)
+	assert.True(t, errors.Is(changed, ErrCorruption))
+}
@@ -6,6 +6,14 @@ package bplustree
 // Each b tree node should fit within a page
 const PageSize = 4096
 
+// ChecksumSize is the number of bytes used to store the CRC32C checksum
+// of a page or WAL frame
+const ChecksumSize = 4
+
+// UsablePageSize is the number of bytes of a page available to its contents.
+// The checksum of the page is stored in the last bytes of the page
+const UsablePageSize = PageSize - ChecksumSize
+
 // PageTypeSize is the number of bytes used to store the type
 // of the b tree page (internal/leaf/free)
 const PageTypeSize = 2
@@ -14,15 +22,51 @@ const PageTypeSize = 2
 // in a WAL frame
 const FrameTypeSize = 2
 
+// TxnIdSize is the number of bytes used to store the id of the transaction
+// which wrote a WAL frame
+const TxnIdSize = 8
+
 // KeyCountSize is the number of bytes used to store the number of keys
 // in a block
 const KeyCountSize = 2
 
-// KeySize is the number of bytes used to store a key
-const KeySize = 8
+// SlotSize is the number of bytes used to store the offset of a cell
+// in the slot array of a page
+const SlotSize = 2
+
+// KeyLenSize is the number of bytes used to store the length of a key
+// in a cell
+const KeyLenSize = 2
+
+// ValueLenSize is the number of bytes used to store the length of a value
+// in a leaf cell
+const ValueLenSize = 2
 
-// ValueSize is the number of bytes used to store a value
-const ValueSize = 8
+// VersionSize is the number of bytes used to store the version of a value
+// in a leaf cell
+const VersionSize = 8
+
+// OverflowValueLen is stored in place of the value length of a leaf cell
+// whose value lives in a chain of overflow pages
+const OverflowValueLen = -1
+
+// DataLenSize is the number of bytes used to store the number of value
+// bytes held by an overflow page
+const DataLenSize = 2
 
 // PageRefSize is the number of bytes used to store a page number
 const PageRefSize = 8
+
+// LeafHeaderSize is the number of bytes preceding the slot array of a leaf
+// page. Besides the page type and key count, a leaf stores references to its
+// neighbouring leaves so that the tree can be scanned in order
+const LeafHeaderSize = PageTypeSize + KeyCountSize + 2*PageRefSize
+
+// InternalHeaderSize is the number of bytes preceding the slot array of an
+// internal page. The leftmost child is stored in the header since an internal
+// node has one more child than it has keys
+const InternalHeaderSize = PageTypeSize + KeyCountSize + PageRefSize
+
+// OverflowHeaderSize is the number of bytes preceding the value bytes of an
+// overflow page
+const OverflowHeaderSize = PageTypeSize + PageRefSize + DataLenSize
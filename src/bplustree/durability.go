@@ -0,0 +1,99 @@
+package bplustree
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// The durability of a commit decides when the commit returns relative to its COMMIT frame being synced to disk:
+//
+//  SYNC  syncs the WAL before the commit returns, so every commit pays for a sync of its own
+//  GROUP waits for the WAL to be synced along with the COMMIT frames of concurrent commits, see groupcommit.go
+//  ASYNC returns as soon as the COMMIT frame is appended. The WAL is synced in the background every async flush
+//        interval, so commits made within an interval of a crash may be lost, but never in part and never out of order
+//
+// The durability is chosen for the whole db when it is opened, and may be overridden by the writes and transactions of
+// a handle to the tree returned by WithDurability.
+
+type Durability int16
+
+const SYNC Durability = 1
+const GROUP Durability = 2
+const ASYNC Durability = 3
+
+func (d Durability) String() string {
+	switch d {
+	case SYNC:
+		return "sync"
+	case GROUP:
+		return "group"
+	case ASYNC:
+		return "async"
+	}
+	return fmt.Sprintf("Durability(%d)", int16(d))
+}
+
+// ParseDurability returns the durability named by s, which is one of "sync", "group" or "async"
+func ParseDurability(s string) (Durability, error) {
+	for _, d := range []Durability{SYNC, GROUP, ASYNC} {
+		if s == d.String() {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown durability %q", s)
+}
+
+// WithDurability returns a handle to the tree whose writes and transactions commit with the given durability rather
+// than the durability the tree was opened with. The handle shares everything else with the tree
+func (t BPlusTree) WithDurability(d Durability) BPlusTree {
+	t.durability = d
+	return t
+}
+
+// syncLater makes sure every frame before end is synced within the async flush interval, starting the background
+// flusher the first time it is needed
+func (c *groupCommitter) syncLater(end int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if end > c.pending {
+		c.pending = end
+	}
+	if c.stopFlusher == nil {
+		c.stopFlusher = make(chan struct{})
+		c.flusherStopped = make(chan struct{})
+		go c.runFlusher()
+	}
+}
+
+// runFlusher syncs the frames of async commits every async flush interval until stopFlusher is closed
+func (c *groupCommitter) runFlusher() {
+	defer close(c.flusherStopped)
+	ticker := time.NewTicker(c.asyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.mu.Lock()
+			pending := c.pending
+			c.mu.Unlock()
+			if err := c.wait(pending); err != nil {
+				log.Printf("Failure syncing WAL: %v", err)
+			}
+		case <-c.stopFlusher:
+			return
+		}
+	}
+}
+
+// close stops the background flusher if it was started. Frames of async commits which were not synced yet are left to
+// be synced by the caller
+func (c *groupCommitter) close() {
+	c.mu.Lock()
+	stop := c.stopFlusher
+	c.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-c.flusherStopped
+	}
+}
@@ -0,0 +1,76 @@
+package bplustree
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseDurability(t *testing.T) {
+	for _, d := range []Durability{SYNC, GROUP, ASYNC} {
+		// Act
+		parsed, err := ParseDurability(d.String())
+
+		// Assert
+		assert.NoError(t, err)
+		assert.Equal(t, d, parsed)
+	}
+	_, err := ParseDurability("eventually")
+	assert.Error(t, err)
+}
+
+func TestAsyncCommitsAreSyncedInBackground(t *testing.T) {
+	// Arrange
+	size := int64(1)
+	flushes := int32(0)
+	c := newGroupCommitter(0, time.Millisecond, func() int64 {
+		return atomic.LoadInt64(&size)
+	}, func() error {
+		atomic.AddInt32(&flushes, 1)
+		return nil
+	})
+	defer c.close()
+
+	// Act
+	c.syncLater(1)
+	time.Sleep(50 * time.Millisecond)
+	flushesWhileIdle := atomic.LoadInt32(&flushes)
+	time.Sleep(50 * time.Millisecond)
+
+	// Assert
+	// the flusher stops syncing once every pending frame is synced
+	assert.Equal(t, int32(1), flushesWhileIdle)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&flushes))
+}
+
+func TestWritesWithEveryDurabilityAreRecovered(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 8, 128, WithDurability(ASYNC), WithAsyncFlushInterval(time.Millisecond))
+	defer func() {_ = os.RemoveAll(TestDir)}()
+
+	// Act
+	for i, d := range []Durability{0, SYNC, GROUP, ASYNC} {
+		tree := bpt.WithDurability(d)
+		assert.NoError(t, tree.Set(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)))
+		txn := tree.Begin()
+		assert.NoError(t, txn.Put(fmt.Sprintf("txn%d", i), fmt.Sprintf("value%d", i)))
+		assert.NoError(t, txn.Commit())
+	}
+	// async commits are synced once the tree is closed
+	assert.NoError(t, bpt.Close())
+
+	// Assert
+	bpt = NewBPlusTree(TestFile, 8, 128)
+	for i := 0; i < 4; i++ {
+		for _, prefix := range []string{"key", "txn"} {
+			value, present, err := bpt.Get(fmt.Sprintf("%s%d", prefix, i))
+			assert.NoError(t, err)
+			assert.True(t, present)
+			assert.Equal(t, fmt.Sprintf("value%d", i), value)
+		}
+	}
+}
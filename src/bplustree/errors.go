@@ -0,0 +1,71 @@
+package bplustree
+
+import (
+	"errors"
+	aol "fios-db/src/log"
+	"fmt"
+	"os"
+)
+
+// ErrCorruption is matched by errors.Is for every error caused by data which fails its checksum or cannot be decoded,
+// whether it was read from the db file or the WAL
+var ErrCorruption = aol.ErrCorruption
+
+// ErrIO is matched by errors.Is for every error caused by a failed read or write of the db file or the WAL. Such errors
+// are an IOError
+var ErrIO = aol.ErrIO
+
+// ErrNotDurable is matched by errors.Is for the error of a commit which was applied, and is visible to readers, but whose
+// COMMIT frame could not be synced to the WAL. Such errors are a NotDurableError
+var ErrNotDurable = errors.New("transaction was committed but could not be made durable")
+
+// A NotDurableError reports a commit which was applied but could not be made durable. The commit must not be retried,
+// since its writes are already applied, and it is lost should the db crash before the WAL is synced
+type NotDurableError struct {
+	Err error
+}
+
+func (e *NotDurableError) Error() string {
+	return fmt.Sprintf("%s: %v", ErrNotDurable.Error(), e.Err)
+}
+
+func (e *NotDurableError) Is(target error) bool {
+	return target == ErrNotDurable
+}
+
+func (e *NotDurableError) Unwrap() error {
+	return e.Err
+}
+
+// A CorruptPageError reports a page whose contents do not match its checksum or are not valid for its type
+type CorruptPageError struct {
+	PageNum int64
+	Reason  string
+}
+
+func (e *CorruptPageError) Error() string {
+	return fmt.Sprintf("page %d is corrupt: %s", e.PageNum, e.Reason)
+}
+
+func (e *CorruptPageError) Is(target error) bool {
+	return target == ErrCorruption
+}
+
+// A CorruptFrameError reports a WAL frame whose contents do not match its checksum
+type CorruptFrameError struct {
+	Offset int64
+	Reason string
+}
+
+func (e *CorruptFrameError) Error() string {
+	return fmt.Sprintf("WAL frame %d is corrupt: %s", e.Offset, e.Reason)
+}
+
+func (e *CorruptFrameError) Is(target error) bool {
+	return target == ErrCorruption
+}
+
+// ioError reports the failure of op on the file
+func ioError(op string, file *os.File, err error) error {
+	return &aol.IOError{Op: op, File: file.Name(), Err: err}
+}
@@ -0,0 +1,69 @@
+package bplustree
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestFailedReadsReturnIOError(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	keys := seedTree(&bpt, 100)
+	assert.NoError(t, bpt.Checkpoint())
+	// every page but the last one written is read from the db file, which can no longer be read
+	_ = bpt.bpm.dbFile.Close()
+
+	// Act
+	_, _, getErr := bpt.Get(keys[0])
+	setErr := bpt.Set(keys[0], "v")
+	_, scanErr := bpt.Scan("", "", 0)
+
+	// Assert
+	for _, err := range []error{getErr, setErr, scanErr} {
+		assert.True(t, errors.Is(err, ErrIO))
+		assert.True(t, errors.Is(err, os.ErrClosed))
+	}
+	crash(&bpt)
+	// creating a new btree simulates recovering from a crash
+	bpt = NewBPlusTree(TestFile, 1, 128)
+	value, _, err := bpt.Get(keys[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "v"+keys[0], value)
+}
+
+func TestFailedCommitIsNotApplied(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	keys := seedTree(&bpt, 100)
+	assert.NoError(t, bpt.Checkpoint())
+	txn := bpt.Begin()
+	assert.NoError(t, txn.Put(keys[2], "v"))
+	// committed pages are read from the db file, while the WAL can no longer be written
+	_ = bpt.bpm.wal.log.Close()
+
+	// Act
+	commitErr := txn.Commit()
+	setErr := bpt.Set(keys[0], "v")
+	deleteErr := bpt.Delete(keys[1])
+
+	// Assert
+	for _, err := range []error{commitErr, setErr, deleteErr} {
+		assert.True(t, errors.Is(err, ErrIO))
+	}
+	for _, key := range keys[:3] {
+		value, present, err := bpt.Get(key)
+		assert.NoError(t, err)
+		assert.True(t, present)
+		assert.Equal(t, "v"+key, value)
+	}
+	crash(&bpt)
+	bpt = NewBPlusTree(TestFile, 1, 128)
+	bpt.ValidateTreeStructure()
+	assert.Equal(t, keys, collect(bpt.Iterator(false)))
+}
@@ -0,0 +1,101 @@
+package bplustree
+
+import (
+	"sync"
+	"time"
+)
+
+// Syncing the WAL on every commit caps the number of commits per second at the number of syncs the disk can do. With
+// GROUP durability, a COMMIT frame is appended to the WAL without syncing it and the committer waits for the WAL to
+// be synced up to its frame instead. The first committer to wait becomes the flusher: it waits up to the group commit
+// delay for more commits to join, syncs every frame appended so far at once and wakes every committer whose frame was
+// synced. Committers arriving while a sync is running wait for the next one, so concurrent commits share a sync.
+//
+// A transaction is committed in memory as soon as its COMMIT frame is appended, since that is what orders commits in the
+// WAL and later commits build on the metadata it committed. Its latches are only released once the sync returns though.
+// Should the sync fail, the transaction stays committed in memory and may turn out to be committed once the db is
+// reopened, so the commit returns a NotDurableError rather than the error itself and the transaction is not rolled back.
+
+// A groupCommitter batches the syncs of concurrent committers, and syncs the frames of async commits in the background,
+// see durability.go. A groupCommitter is safe for concurrent use
+type groupCommitter struct {
+	mu             sync.Mutex
+	cond           *sync.Cond
+	maxDelay       time.Duration // how long the flusher waits for more commits before syncing
+	asyncInterval  time.Duration // how often the frames of async commits are synced
+	size           func() int64  // returns the offset the next frame is appended at
+	flush          func() error  // syncs every frame appended so far
+	synced         int64         // every frame before synced is durable
+	flushing       bool          // whether a flusher is running
+	err            error         // error of the last sync if it failed
+	failedEnd      int64         // offset up to which the last sync failed to sync frames
+	pending        int64         // every frame before pending must be synced by the background flusher
+	stopFlusher    chan struct{} // closed to stop the background flusher, nil if it was never started
+	flusherStopped chan struct{}
+}
+
+func newGroupCommitter(maxDelay time.Duration, asyncInterval time.Duration, size func() int64, flush func() error) *groupCommitter {
+	c := &groupCommitter{
+		maxDelay:      maxDelay,
+		asyncInterval: asyncInterval,
+		size:          size,
+		flush:         flush,
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// syncAll syncs every frame appended so far right away, without waiting for other committers to join
+func (c *groupCommitter) syncAll() error {
+	c.mu.Lock()
+	flush := c.flush
+	c.mu.Unlock()
+	target := c.size()
+	if err := flush(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if target > c.synced {
+		c.err = nil
+		c.synced = target
+	}
+	return nil
+}
+
+// wait returns once every frame before end is durable, syncing the frames itself unless another committer is already
+// syncing. Returns the error of the sync which failed to sync the frames before end
+func (c *groupCommitter) wait(end int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.synced < end {
+		if c.err != nil && c.failedEnd >= end {
+			return c.err
+		}
+		if c.flushing {
+			c.cond.Wait()
+			continue
+		}
+
+		c.flushing = true
+		flush := c.flush
+		c.mu.Unlock()
+		if c.maxDelay > 0 {
+			time.Sleep(c.maxDelay)
+		}
+		target := c.size()
+		err := flush()
+		c.mu.Lock()
+		c.flushing = false
+		if err != nil {
+			c.err = err
+			c.failedEnd = target
+		} else if target > c.synced {
+			c.err = nil
+			c.synced = target
+		}
+		c.cond.Broadcast()
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package bplustree
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConcurrentCommitsShareSyncs(t *testing.T) {
+	// Arrange
+	numCommitters := 50
+	flushes := int32(0)
+	c := newGroupCommitter(10*time.Millisecond, 0, func() int64 {
+		return int64(numCommitters)
+	}, func() error {
+		atomic.AddInt32(&flushes, 1)
+		return nil
+	})
+	wg := sync.WaitGroup{}
+
+	// Act
+	errs := make([]error, numCommitters)
+	for i := 0; i < numCommitters; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = c.wait(int64(i + 1))
+		}(i)
+	}
+	wg.Wait()
+
+	// Assert
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&flushes))
+}
+
+func TestFailedGroupSyncIsReported(t *testing.T) {
+	// Arrange
+	size := int64(1)
+	errSync := errors.New("sync failed")
+	flushErr := errSync
+	c := newGroupCommitter(0, 0, func() int64 {
+		return size
+	}, func() error {
+		return flushErr
+	})
+
+	// Act
+	failedErr := c.wait(1)
+	retriedErr := c.wait(1)
+	size = 2
+	flushErr = nil
+	laterErr := c.wait(2)
+
+	// Assert
+	assert.Equal(t, errSync, failedErr)
+	assert.Equal(t, errSync, retriedErr)
+	assert.NoError(t, laterErr)
+}
+
+func TestFailedGroupSyncKeepsTxnCommitted(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 16, 128, WithDurability(GROUP))
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	errSync := errors.New("sync failed")
+	setFlush := func(flush func() error) {
+		bpt.bpm.wal.groupCommit.mu.Lock()
+		bpt.bpm.wal.groupCommit.flush = flush
+		bpt.bpm.wal.groupCommit.mu.Unlock()
+	}
+	flush := bpt.bpm.wal.groupCommit.flush
+	setFlush(func() error {
+		return errSync
+	})
+	txn := bpt.Begin()
+	assert.NoError(t, txn.Put("a", "txn"))
+	assert.NoError(t, txn.Put("b", "txn"))
+
+	// Act
+	commitErr := txn.Commit()
+	recommitErr := txn.Commit()
+	setErr := bpt.Set("c", "set")
+	setFlush(flush)
+
+	// Assert
+	for _, err := range []error{commitErr, setErr} {
+		assert.True(t, errors.Is(err, ErrNotDurable))
+		assert.True(t, errors.Is(err, errSync))
+	}
+	assert.Equal(t, ErrTxnClosed, recommitErr)
+	for key, expected := range map[string]string{"a": "txn", "b": "txn", "c": "set"} {
+		value, _, err := bpt.Get(key)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, value)
+	}
+	// the tree is no longer locked by the transaction
+	assert.NoError(t, bpt.Set("d", "set"))
+	crash(&bpt)
+	bpt = NewBPlusTree(TestFile, 16, 128)
+	value, _, err := bpt.Get("a")
+	assert.NoError(t, err)
+	assert.Equal(t, "txn", value)
+}
+
+func TestFailedSyncKeepsTxnCommittedAcrossReopen(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 16, 128, WithDurability(SYNC))
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	seedTree(&bpt, 50)
+	errSync := errors.New("sync failed")
+	flush := bpt.bpm.wal.groupCommit.flush
+	bpt.bpm.wal.groupCommit.mu.Lock()
+	bpt.bpm.wal.groupCommit.flush = func() error {
+		return errSync
+	}
+	bpt.bpm.wal.groupCommit.mu.Unlock()
+	txn := bpt.Begin()
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("txn%03d", i)
+		assert.NoError(t, txn.Put(key, key))
+	}
+
+	// Act
+	commitErr := txn.Commit()
+	bpt.bpm.wal.groupCommit.mu.Lock()
+	bpt.bpm.wal.groupCommit.flush = flush
+	bpt.bpm.wal.groupCommit.mu.Unlock()
+	setErr := bpt.Set("after", "set")
+	crash(&bpt)
+	bpt = NewBPlusTree(TestFile, 16, 128)
+
+	// Assert
+	assert.True(t, errors.Is(commitErr, ErrNotDurable))
+	assert.True(t, errors.Is(commitErr, errSync))
+	assert.NoError(t, setErr)
+	bpt.ValidateTreeStructure()
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("txn%03d", i)
+		value, present, err := bpt.Get(key)
+		assert.NoError(t, err)
+		assert.True(t, present)
+		assert.Equal(t, key, value)
+	}
+	value, _, err := bpt.Get("after")
+	assert.NoError(t, err)
+	assert.Equal(t, "set", value)
+}
+
+func TestConcurrentWritersWithGroupCommit(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 8, 128, WithDurability(GROUP), WithGroupCommitDelay(time.Millisecond))
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	numThreads := 8
+	wg := sync.WaitGroup{}
+
+	// Act
+	for threadId := 0; threadId < numThreads; threadId++ {
+		wg.Add(1)
+		go func(threadId int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				key := fmt.Sprintf("%02d%d", i, threadId)
+				assert.NoError(t, bpt.Set(key, key))
+			}
+		}(threadId)
+	}
+	wg.Wait()
+
+	// Assert
+	crash(&bpt)
+	// creating a new btree simulates recovering from a crash
+	bpt = NewBPlusTree(TestFile, 8, 128, WithDurability(GROUP), WithGroupCommitDelay(time.Millisecond))
+	bpt.ValidateTreeStructure()
+	for threadId := 0; threadId < numThreads; threadId++ {
+		for i := 0; i < 50; i++ {
+			key := fmt.Sprintf("%02d%d", i, threadId)
+			value, present, err := bpt.Get(key)
+			assert.NoError(t, err)
+			assert.True(t, present)
+			assert.Equal(t, key, value)
+		}
+	}
+}
+
+// BenchmarkConcurrentWrites compares the throughput of writers syncing every commit on their own against writers
+// sharing syncs through group commit, for an increasing number of writers
+func BenchmarkConcurrentWrites(b *testing.B) {
+	for _, groupCommit := range []bool{false, true} {
+		for _, numWriters := range []int{1, 4, 16, 64} {
+			name := fmt.Sprintf("sync/writers=%d", numWriters)
+			if groupCommit {
+				name = fmt.Sprintf("group/writers=%d", numWriters)
+			}
+			b.Run(name, func(b *testing.B) {
+				benchmarkConcurrentWrites(b, numWriters, groupCommit)
+			})
+		}
+	}
+}
+
+func benchmarkConcurrentWrites(b *testing.B, numWriters int, groupCommit bool) {
+	_ = os.Mkdir(TestDir, 0755)
+	opts := []Option{WithCheckpointSize(-1)}
+	if groupCommit {
+		opts = append(opts, WithDurability(GROUP))
+	}
+	bpt := NewBPlusTree(TestFile, 512, -1, opts...)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	numKeys := 10000
+	txn := bpt.Begin()
+	for i := 0; i < numKeys; i++ {
+		_ = txn.Put(fmt.Sprintf("key%05d", i), fmt.Sprintf("value%05d", i))
+	}
+	_ = txn.Commit()
+	wg := sync.WaitGroup{}
+
+	b.ResetTimer()
+	// writers overwrite random keys, which are spread over enough leaves for most writes not to wait on each other
+	for writer := 0; writer < numWriters; writer++ {
+		wg.Add(1)
+		go func(writer int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(writer)))
+			for i := writer; i < b.N; i += numWriters {
+				key := fmt.Sprintf("key%05d", r.Intn(numKeys))
+				_ = bpt.Set(key, fmt.Sprintf("value%05d", i%numKeys))
+			}
+		}(writer)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,329 @@
+package bplustree
+
+import (
+	"errors"
+	"fios-db/src/serialization"
+	"fmt"
+)
+
+// An Inspector decodes the pages and the WAL of a db which is not open, see offline.go, for debugging the storage layer
+// without adding PrintTree calls. Unlike Check it reports what is stored rather than what is wrong, so pages which fail
+// their checksum are decoded regardless, along with the reason they are corrupt. The db may be inspected while it is
+// open, in which case pages written concurrently may be read half written.
+
+// ErrNoSuchPage is returned when inspecting a page past the end of the db
+var ErrNoSuchPage = errors.New("page does not exist")
+
+// An Inspector reads the db stored in a file without modifying it
+type Inspector struct {
+	db *offlineDb
+}
+
+// MetadataInfo describes the metadata page and the size of the db
+type MetadataInfo struct {
+	Root          int64  `json:"root"`
+	FreePageStart int64  `json:"freePageStart"`
+	VersionClock  int64  `json:"versionClock"`    // highest version of a deleted key, see RetireVersion
+	Pages         int64  `json:"pages"`           // number of pages once the WAL is recovered
+	FileSize      int64  `json:"fileSize"`        // size of the db file in bytes
+	WALFrames     int    `json:"walFrames"`       // number of frames in the WAL
+	WALPages      int    `json:"walPages"`        // number of pages whose last committed version is in the WAL
+	Error         string `json:"error,omitempty"` // why the metadata page is corrupt
+}
+
+// PageInfo describes a page decoded according to its type. Only the fields of its type are set
+type PageInfo struct {
+	PageNum       int64       `json:"pageNum"`
+	Type          string      `json:"type"`
+	InWAL         bool        `json:"inWAL"`           // whether the page is read from the WAL rather than the db file
+	Error         string      `json:"error,omitempty"` // why the page is corrupt
+	Root          int64       `json:"root,omitempty"`
+	FreePageStart int64       `json:"freePageStart,omitempty"`
+	VersionClock  int64       `json:"versionClock,omitempty"`
+	Used          int         `json:"used,omitempty"`    // bytes used by a node
+	Entries       []EntryInfo `json:"entries,omitempty"` // entries of a leaf
+	Keys          []string    `json:"keys,omitempty"`    // keys of an internal node
+	Children      []int64     `json:"children,omitempty"`
+	Prev          int64       `json:"prev,omitempty"`    // previous leaf
+	Next          int64       `json:"next,omitempty"`    // next leaf, free page or overflow page
+	DataLen       int         `json:"dataLen,omitempty"` // bytes of the value stored in an overflow page
+}
+
+// EntryInfo describes an entry of a leaf
+type EntryInfo struct {
+	Key      string `json:"key"`
+	Value    string `json:"value,omitempty"` // empty when the value is stored in overflow pages
+	Version  int64  `json:"version"`
+	Overflow int64  `json:"overflow,omitempty"` // first overflow page holding the value
+}
+
+// WALInfo describes every frame of the WAL
+type WALInfo struct {
+	Frames  []FrameInfo `json:"frames"`
+	Commits int         `json:"commits"`
+	Error   string      `json:"error,omitempty"` // corruption the WAL was read up to
+}
+
+// FrameInfo describes a frame of the WAL. PageNum is only meaningful for PUT frames
+type FrameInfo struct {
+	Offset  int64  `json:"offset"`
+	Type    string `json:"type"`
+	TxnId   int64  `json:"txnId"`
+	PageNum int64  `json:"pageNum"`
+	// Commit numbers the commit the frame belongs to among the commits of the WAL from 1, 0 if the transaction of the
+	// frame did not commit
+	Commit int `json:"commit,omitempty"`
+	// Checkpointed is set for the frames of commits before the last CHECKPOINT frame, which recovery skips
+	Checkpointed bool `json:"checkpointed,omitempty"`
+}
+
+// FreeListInfo describes the chain of free pages
+type FreeListInfo struct {
+	Pages []int64 `json:"pages"`
+	Error string  `json:"error,omitempty"` // why the chain could not be followed to its end
+}
+
+// TreeStats describes the shape of the tree and the pages of the db
+type TreeStats struct {
+	Height     int            `json:"height"`
+	Keys       int            `json:"keys"`
+	Levels     []LevelStats   `json:"levels"`     // levels of the tree from the root down
+	PageTypes  map[string]int `json:"pageTypes"`  // number of pages of the db by type, see PageInfo.Type
+	Unreadable int            `json:"unreadable"` // nodes of the tree which could not be read
+}
+
+// LevelStats describes a level of the tree
+type LevelStats struct {
+	Nodes int     `json:"nodes"`
+	Keys  int     `json:"keys"`
+	Fill  float64 `json:"fill"` // fraction of the usable bytes of the nodes of the level in use
+}
+
+// NewInspector opens the db stored in fileName read only
+func NewInspector(fileName string) (*Inspector, error) {
+	db, err := openOfflineDb(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return &Inspector{db: db}, nil
+}
+
+// Close closes the files of the db
+func (i *Inspector) Close() error {
+	return i.db.Close()
+}
+
+// Metadata returns the metadata page along with the size of the db
+func (i *Inspector) Metadata() (MetadataInfo, error) {
+	info := MetadataInfo{
+		Pages:     i.db.numPages(),
+		FileSize:  i.db.fileSize,
+		WALFrames: len(i.db.frames),
+		WALPages:  len(i.db.pages),
+	}
+	page, err := i.Page(0)
+	info.Root = page.Root
+	info.FreePageStart = page.FreePageStart
+	info.VersionClock = page.VersionClock
+	info.Error = page.Error
+	return info, err
+}
+
+// Page decodes the page according to its type, see PageInfo. Page 0 is decoded as the metadata page, and pages which
+// were never written have type UNWRITTEN
+func (i *Inspector) Page(pageNum int64) (PageInfo, error) {
+	info := PageInfo{PageNum: pageNum}
+	if pageNum < 0 || pageNum >= i.db.numPages() {
+		return info, fmt.Errorf("%w: page %d is not within [0, %d)", ErrNoSuchPage, pageNum, i.db.numPages())
+	}
+	_, info.InWAL = i.db.pages[pageNum]
+	data, err := i.db.page(pageNum)
+	if errors.Is(err, ErrCorruption) && data != nil {
+		if unwritten(data) {
+			info.Type = "UNWRITTEN"
+			return info, nil
+		}
+		info.Error = err.Error()
+	} else if err != nil {
+		return info, err
+	}
+
+	if pageNum == 0 {
+		info.Type = "METADATA"
+		info.Root = serialization.BytesToInt64(data[:PageRefSize])
+		info.FreePageStart = serialization.BytesToInt64(data[PageRefSize : 2*PageRefSize])
+		info.VersionClock = serialization.BytesToInt64(data[2*PageRefSize : 3*PageRefSize])
+		return info, nil
+	}
+	pageType := PageType(serialization.BytesToInt16(data[:PageTypeSize]))
+	info.Type = pageType.String()
+	switch pageType {
+	case LEAF, INTERNAL:
+		decodeNodeInfo(&info, data)
+	case FREE:
+		info.Next = serialization.BytesToInt64(data[PageTypeSize : PageTypeSize+PageRefSize])
+	case OVERFLOW:
+		info.Next = serialization.BytesToInt64(data[PageTypeSize : PageTypeSize+PageRefSize])
+		info.DataLen = int(serialization.BytesToInt16(data[PageTypeSize+PageRefSize : PageTypeSize+PageRefSize+2]))
+	}
+	return info, nil
+}
+
+// decodeNodeInfo decodes the node stored in the page into info, or records why it cannot be decoded
+func decodeNodeInfo(info *PageInfo, data []byte) {
+	err := validateNodeLayout(info.PageNum, data)
+	var node *Node
+	if err == nil {
+		node, err = decodeNode(info.PageNum, data)
+	}
+	if err != nil {
+		info.Error = err.Error()
+		return
+	}
+	info.Used = node.Size()
+	if !node.IsLeaf {
+		info.Keys = node.Keys
+		info.Children = node.Children
+		return
+	}
+	info.Prev = node.Prev
+	info.Next = node.Next
+	info.Entries = make([]EntryInfo, len(node.Keys))
+	for idx, key := range node.Keys {
+		info.Entries[idx] = EntryInfo{
+			Key:      key,
+			Value:    node.Values[idx],
+			Version:  node.Versions[idx],
+			Overflow: node.Overflow[idx],
+		}
+	}
+}
+
+// WAL returns every frame of the WAL, numbering the commits they belong to
+func (i *Inspector) WAL() WALInfo {
+	info := WALInfo{Frames: make([]FrameInfo, len(i.db.frames))}
+	if i.db.walErr != nil {
+		info.Error = i.db.walErr.Error()
+	}
+	lastCheckpoint := -1
+	commitFrames := []int{-1} // index of the COMMIT frame of every commit
+	uncommittedFrames := map[int64][]int{}
+	for idx, f := range i.db.frames {
+		info.Frames[idx] = FrameInfo{
+			Offset:  f.offset,
+			Type:    f.frame.FrameType.String(),
+			TxnId:   f.frame.TxnId,
+			PageNum: f.frame.PageNum,
+		}
+		switch f.frame.FrameType {
+		case PUT:
+			uncommittedFrames[f.frame.TxnId] = append(uncommittedFrames[f.frame.TxnId], idx)
+		case COMMIT:
+			info.Commits++
+			commitFrames = append(commitFrames, idx)
+			for _, committed := range append(uncommittedFrames[f.frame.TxnId], idx) {
+				info.Frames[committed].Commit = info.Commits
+			}
+			delete(uncommittedFrames, f.frame.TxnId)
+		case ABORT:
+			delete(uncommittedFrames, f.frame.TxnId)
+		case CHECKPOINT:
+			lastCheckpoint = idx
+		}
+	}
+	for idx := range info.Frames {
+		commit := info.Frames[idx].Commit
+		info.Frames[idx].Checkpointed = commit > 0 && commitFrames[commit] < lastCheckpoint
+	}
+	return info
+}
+
+// FreeList follows the chain of free pages from the metadata page
+func (i *Inspector) FreeList() (FreeListInfo, error) {
+	info := FreeListInfo{Pages: make([]int64, 0)}
+	_, pageNum, err := i.db.metadata()
+	if err != nil {
+		return info, err
+	}
+	onList := map[int64]bool{}
+	for pageNum > 0 {
+		if onList[pageNum] {
+			info.Error = fmt.Sprintf("the free list loops back to page %d", pageNum)
+			return info, nil
+		}
+		page, err := i.Page(pageNum)
+		if errors.Is(err, ErrNoSuchPage) {
+			info.Error = fmt.Sprintf("the free list points at page %d past the end of the db", pageNum)
+			return info, nil
+		} else if err != nil {
+			return info, err
+		}
+		if page.Type != FREE.String() || page.Error != "" {
+			info.Error = fmt.Sprintf("the free list points at page %d of type %s %s", pageNum, page.Type, page.Error)
+			return info, nil
+		}
+		onList[pageNum] = true
+		info.Pages = append(info.Pages, pageNum)
+		pageNum = page.Next
+	}
+	return info, nil
+}
+
+// Stats walks the tree level by level from the root and counts the pages of the db by type
+func (i *Inspector) Stats() (TreeStats, error) {
+	stats := TreeStats{
+		Levels:    make([]LevelStats, 0),
+		PageTypes: map[string]int{},
+	}
+	root, _, err := i.db.metadata()
+	if err != nil {
+		return stats, err
+	}
+
+	seen := map[int64]bool{}
+	level := []int64{root}
+	for len(level) > 0 {
+		levelStats := LevelStats{}
+		used := 0
+		children := make([]int64, 0)
+		for _, pageNum := range level {
+			if seen[pageNum] {
+				continue
+			}
+			seen[pageNum] = true
+			node, err := i.db.node(pageNum)
+			if errors.Is(err, ErrCorruption) {
+				stats.Unreadable++
+				continue
+			} else if err != nil {
+				return stats, err
+			}
+			levelStats.Nodes++
+			levelStats.Keys += len(node.Keys)
+			used += node.Size()
+			if node.IsLeaf {
+				stats.Keys += len(node.Keys)
+			}
+			children = append(children, node.Children...)
+		}
+		if levelStats.Nodes > 0 {
+			levelStats.Fill = float64(used) / float64(levelStats.Nodes*UsablePageSize)
+		}
+		stats.Levels = append(stats.Levels, levelStats)
+		level = children
+	}
+	stats.Height = len(stats.Levels)
+
+	for pageNum := int64(0); pageNum < i.db.numPages(); pageNum++ {
+		page, err := i.Page(pageNum)
+		if err != nil {
+			return stats, err
+		}
+		if page.Error != "" {
+			stats.PageTypes["CORRUPT"]++
+		} else {
+			stats.PageTypes[page.Type]++
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,196 @@
+package bplustree
+
+import (
+	"errors"
+	"fios-db/src/serialization"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// inspect opens an Inspector on the test db
+func inspect(t *testing.T) *Inspector {
+	inspector, err := NewInspector(TestFile)
+	assert.NoError(t, err)
+	return inspector
+}
+
+func TestInspectMetadata(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 16, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	seedTree(&bpt, 300)
+	root := bpt.bpm.RootPageNum()
+	assert.NoError(t, bpt.Close())
+	inspector := inspect(t)
+	defer func() {_ = inspector.Close()}()
+
+	// Act
+	info, err := inspector.Metadata()
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, root, info.Root)
+	assert.Equal(t, int64(-1), info.FreePageStart)
+	assert.Equal(t, dbSize(t), info.FileSize)
+	assert.Equal(t, dbSize(t)/PageSize, info.Pages)
+	assert.Empty(t, info.Error)
+}
+
+func TestInspectPage(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 16, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	seedTree(&bpt, 300)
+	leaf := leafOf(&bpt, "k150")
+	root := bpt.bpm.RootPageNum()
+	assert.NoError(t, bpt.Close())
+	inspector := inspect(t)
+	defer func() {_ = inspector.Close()}()
+
+	// Act
+	leafInfo, leafErr := inspector.Page(leaf.PageNum)
+	rootInfo, rootErr := inspector.Page(root)
+	_, missingErr := inspector.Page(dbSize(t) / PageSize)
+
+	// Assert
+	assert.NoError(t, leafErr)
+	assert.Equal(t, "LEAF", leafInfo.Type)
+	assert.Equal(t, leaf.Prev, leafInfo.Prev)
+	assert.Equal(t, leaf.Next, leafInfo.Next)
+	assert.Equal(t, leaf.Size(), leafInfo.Used)
+	assert.Len(t, leafInfo.Entries, len(leaf.Keys))
+	for idx, entry := range leafInfo.Entries {
+		assert.Equal(t, leaf.Keys[idx], entry.Key)
+		assert.Equal(t, "v"+leaf.Keys[idx], entry.Value)
+	}
+	assert.NoError(t, rootErr)
+	assert.Equal(t, "INTERNAL", rootInfo.Type)
+	assert.Len(t, rootInfo.Children, len(rootInfo.Keys)+1)
+	assert.True(t, errors.Is(missingErr, ErrNoSuchPage))
+}
+
+func TestInspectCorruptPage(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 16, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	seedTree(&bpt, 300)
+	leaf := leafOf(&bpt, "k150")
+	assert.NoError(t, bpt.Close())
+	corruptFile(t, TestFile+".db", leaf.PageNum*PageSize+PageSize-1)
+	inspector := inspect(t)
+	defer func() {_ = inspector.Close()}()
+
+	// Act
+	info, err := inspector.Page(leaf.PageNum)
+
+	// Assert
+	// the page is decoded regardless of its checksum
+	assert.NoError(t, err)
+	assert.Equal(t, "LEAF", info.Type)
+	assert.NotEmpty(t, info.Error)
+	assert.Len(t, info.Entries, len(leaf.Keys))
+}
+
+func TestInspectWAL(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 16, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	seedTree(&bpt, 10)
+	txn := bpt.Begin()
+	assert.NoError(t, txn.Put("rolled", "back"))
+	assert.NoError(t, txn.Rollback())
+	assert.NoError(t, bpt.Set("last", "commit"))
+	crash(&bpt)
+	inspector := inspect(t)
+	defer func() {_ = inspector.Close()}()
+
+	// Act
+	info := inspector.WAL()
+
+	// Assert
+	assert.Empty(t, info.Error)
+	assert.True(t, info.Commits >= 11)
+	commit := 0
+	for _, frame := range info.Frames {
+		switch frame.Type {
+		case "COMMIT":
+			commit++
+			assert.Equal(t, commit, frame.Commit)
+		case "PUT":
+			// the PUT frames of a commit precede its COMMIT frame
+			assert.True(t, frame.Commit == 0 || frame.Commit > commit)
+		case "ABORT":
+			assert.Equal(t, 0, frame.Commit)
+		}
+		assert.False(t, frame.Checkpointed)
+	}
+	assert.Equal(t, info.Commits, commit)
+}
+
+func TestInspectFreeList(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 16, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	deleteMost(t, &bpt, seedTree(&bpt, 300))
+	assert.NoError(t, bpt.Close())
+	inspector := inspect(t)
+
+	// Act
+	freeList, err := inspector.FreeList()
+	stats, statsErr := inspector.Stats()
+	assert.NoError(t, inspector.Close())
+	data := readRawPage(t, freeList.Pages[0])
+	copy(data[PageTypeSize:], serialization.Int64ToBytes(freeList.Pages[0]))
+	writeRawPage(t, freeList.Pages[0], data)
+	inspector = inspect(t)
+	defer func() {_ = inspector.Close()}()
+	loop, loopErr := inspector.FreeList()
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NoError(t, statsErr)
+	assert.Empty(t, freeList.Error)
+	assert.Equal(t, stats.PageTypes["FREE"], len(freeList.Pages))
+	assert.NoError(t, loopErr)
+	assert.Equal(t, freeList.Pages[:1], loop.Pages)
+	assert.NotEmpty(t, loop.Error)
+}
+
+func TestInspectStats(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 16, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	keys := seedTree(&bpt, 500)
+	assert.NoError(t, bpt.Close())
+	inspector := inspect(t)
+	defer func() {_ = inspector.Close()}()
+
+	// Act
+	stats, err := inspector.Stats()
+
+	// Assert
+	assert.NoError(t, err)
+	assert.True(t, stats.Height >= 3)
+	assert.Len(t, stats.Levels, stats.Height)
+	assert.Equal(t, 1, stats.Levels[0].Nodes)
+	assert.Equal(t, len(keys), stats.Keys)
+	assert.Equal(t, len(keys), stats.Levels[stats.Height-1].Keys)
+	pages := 0
+	for level, levelStats := range stats.Levels {
+		assert.True(t, levelStats.Fill > 0 && levelStats.Fill <= 1)
+		if level > 0 {
+			assert.Equal(t, stats.Levels[level-1].Keys+stats.Levels[level-1].Nodes, levelStats.Nodes)
+		}
+		pages += levelStats.Nodes
+	}
+	assert.Equal(t, 1, stats.PageTypes["METADATA"])
+	assert.Equal(t, pages, stats.PageTypes["LEAF"]+stats.PageTypes["INTERNAL"])
+	assert.Equal(t, 0, stats.Unreadable)
+}
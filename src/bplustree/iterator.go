@@ -0,0 +1,254 @@
+package bplustree
+
+// KeyValue is a single entry returned by a range scan
+type KeyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Iterator walks the entries of a BPlusTree in key order. The iterator reads a snapshot of the tree pinned when it is
+// created, see Snapshot, so it neither takes latches nor blocks writers, and a transaction committed while iterating is
+// either seen in full or not at all. It reads one leaf at a time, descending from the root of the snapshot to position
+// itself and following the sibling pointers of the leaves of the snapshot from there on. An iterator which fails to read
+// a leaf becomes invalid and reports the failure through Err
+type Iterator struct {
+	reader   leafReader
+	reverse  bool
+	keysOnly bool  // skips reading values stored in overflow pages
+	leaf     *Node // current leaf, nil once the iterator is exhausted
+	idx      int   // index of the current entry within leaf
+	err      error // error which ended the iteration, if any
+	closed   bool
+	snapshot *Snapshot // snapshot pinned for the iterator and released by Close, if any
+}
+
+// A leafReader reads the leaves of a tree for iterators
+type leafReader interface {
+	// readLeaf returns the leaf reached by following next from the root. Values stored in overflow pages are read into
+	// a copy of the leaf unless keysOnly is set. The leaf must not be modified
+	readLeaf(next func(node *Node) int, keysOnly bool) (*Node, error)
+	// readSibling returns the leaf stored in the page, which a leaf returned earlier points at as its neighbour, like
+	// readLeaf
+	readSibling(pageNum int64, keysOnly bool) (*Node, error)
+}
+
+// Iterator returns an iterator positioned at the smallest key, or at the largest key if reverse is set. The iterator
+// must be closed once done with, which releases its snapshot
+func (t *BPlusTree) Iterator(reverse bool) *Iterator {
+	snapshot := t.Snapshot()
+	it := newIterator(snapshot, reverse)
+	it.snapshot = snapshot
+	return it
+}
+
+func newIterator(reader leafReader, reverse bool) *Iterator {
+	it := &Iterator{
+		reader:  reader,
+		reverse: reverse,
+	}
+
+	if reverse {
+		if it.load(last) {
+			it.idx = len(it.leaf.Keys) - 1
+		}
+	} else {
+		it.load(first)
+	}
+	it.settle()
+	return it
+}
+
+// Seek positions the iterator at the first key greater than or equal to key, or for a reverse iterator, at the last key
+// less than or equal to key
+func (it *Iterator) Seek(key string) {
+	if !it.load(atOrAfter(key)) {
+		return
+	}
+	i, found := findKeyIndexInLeaf(key, it.leaf.Keys)
+	if it.reverse && !found {
+		i--
+	}
+	it.idx = i
+	it.settle()
+}
+
+// Valid returns whether the iterator is positioned at an entry
+func (it *Iterator) Valid() bool {
+	return !it.closed && it.err == nil && it.leaf != nil
+}
+
+// Err returns the error which ended the iteration, or nil if the iterator is valid or reached the end of the tree
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Next moves the iterator to the next entry in iteration order
+func (it *Iterator) Next() {
+	if it.reverse {
+		it.idx--
+	} else {
+		it.idx++
+	}
+	it.settle()
+}
+
+// Key returns the key of the current entry
+func (it *Iterator) Key() string {
+	return it.leaf.Keys[it.idx]
+}
+
+// Value returns the value of the current entry
+func (it *Iterator) Value() string {
+	return it.leaf.Values[it.idx]
+}
+
+// Close marks the iterator as exhausted and releases the snapshot pinned for it, if any
+func (it *Iterator) Close() {
+	it.closed = true
+	if it.snapshot != nil {
+		it.snapshot.Release()
+	}
+}
+
+// load reads the leaf reached by following next from the root. Returns false and ends the iteration if the leaf could
+// not be read
+func (it *Iterator) load(next func(node *Node) int) bool {
+	leaf, err := it.reader.readLeaf(next, it.keysOnly)
+	return it.setLeaf(leaf, err)
+}
+
+// loadSibling reads the neighbouring leaf stored in the page. Returns false and ends the iteration if there is no such
+// leaf, pageNum being 0, or if the leaf could not be read
+func (it *Iterator) loadSibling(pageNum int64) bool {
+	if pageNum == 0 {
+		it.leaf = nil
+		return false
+	}
+	leaf, err := it.reader.readSibling(pageNum, it.keysOnly)
+	return it.setLeaf(leaf, err)
+}
+
+// setLeaf makes leaf the current leaf, or ends the iteration with err
+func (it *Iterator) setLeaf(leaf *Node, err error) bool {
+	if err != nil {
+		it.leaf = nil
+		it.err = err
+		return false
+	}
+	it.leaf = leaf
+	return true
+}
+
+// settle moves on to the neighbouring leaf until the iterator points at an entry, or marks the iterator as exhausted.
+// Leaves of the snapshot never change, so the neighbouring leaf is the one the current leaf points at
+func (it *Iterator) settle() {
+	for it.leaf != nil {
+		if !it.reverse && it.idx >= len(it.leaf.Keys) {
+			if !it.loadSibling(it.leaf.Next) {
+				return
+			}
+			it.idx = 0
+		} else if it.reverse && it.idx < 0 {
+			if !it.loadSibling(it.leaf.Prev) {
+				return
+			}
+			it.idx = len(it.leaf.Keys) - 1
+		} else {
+			return
+		}
+	}
+}
+
+// Scan returns up to limit entries with keys in [start, end) in ascending order, read from a snapshot of the tree. An
+// empty end scans to the last key and a limit <= 0 returns every entry in the range
+func (t *BPlusTree) Scan(start, end string, limit int) ([]KeyValue, error) {
+	snapshot := t.Snapshot()
+	defer snapshot.Release()
+	return scan(snapshot, start, end, limit)
+}
+
+func scan(reader leafReader, start, end string, limit int) ([]KeyValue, error) {
+	it := newIterator(reader, false)
+	defer it.Close()
+
+	pairs := make([]KeyValue, 0)
+	for it.Seek(start); it.Valid(); it.Next() {
+		if (end != "" && it.Key() >= end) || (limit > 0 && len(pairs) >= limit) {
+			break
+		}
+		pairs = append(pairs, KeyValue{
+			Key:   it.Key(),
+			Value: it.Value(),
+		})
+	}
+	if it.Err() != nil {
+		return nil, it.Err()
+	}
+	return pairs, nil
+}
+
+// ScanPrefix returns up to limit entries whose keys start with prefix in ascending order, read from a snapshot of the
+// tree. start resumes a previous scan by skipping keys smaller than it; an empty start begins at the first key with the
+// prefix. A limit <= 0 returns every matching entry
+func (t *BPlusTree) ScanPrefix(prefix, start string, limit int) ([]KeyValue, error) {
+	snapshot := t.Snapshot()
+	defer snapshot.Release()
+	return scanPrefix(snapshot, prefix, start, limit)
+}
+
+func scanPrefix(reader leafReader, prefix, start string, limit int) ([]KeyValue, error) {
+	if start < prefix {
+		start = prefix
+	}
+	return scan(reader, start, prefixEnd(prefix), limit)
+}
+
+// Count returns the number of keys in [start, end) as of a snapshot of the tree. An empty end counts up to the last key.
+// Leaves which fall entirely within the range are counted without visiting their entries one by one
+func (t *BPlusTree) Count(start, end string) (int, error) {
+	snapshot := t.Snapshot()
+	defer snapshot.Release()
+	return count(snapshot, start, end)
+}
+
+func count(reader leafReader, start, end string) (int, error) {
+	it := &Iterator{
+		reader:   reader,
+		keysOnly: true,
+	}
+	defer it.Close()
+
+	count := 0
+	it.Seek(start)
+	for it.Valid() {
+		lastKey := it.leaf.Keys[len(it.leaf.Keys)-1]
+		if end == "" || lastKey < end {
+			count += len(it.leaf.Keys) - it.idx
+			it.idx = len(it.leaf.Keys)
+			it.settle()
+			continue
+		}
+		if it.Key() >= end {
+			break
+		}
+		count++
+		it.Next()
+	}
+	if it.Err() != nil {
+		return 0, it.Err()
+	}
+	return count, nil
+}
+
+// prefixEnd returns the smallest key which is greater than every key starting with prefix, or an empty string if there
+// is no such key
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,288 @@
+package bplustree
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+)
+
+func seedTree(bpt *BPlusTree, n int) []string {
+	keys := make([]string, 0)
+	for i := 0; i < n; i++ {
+		keys = append(keys, fmt.Sprintf("k%03d", i))
+	}
+	// insert in an order which is not sorted so leaves are split in the middle of the key space
+	for i := 0; i < n; i++ {
+		key := keys[(i*7)%n]
+		_ = bpt.Set(key, "v"+key)
+	}
+	return keys
+}
+
+func collect(it *Iterator) []string {
+	keys := make([]string, 0)
+	for ; it.Valid(); it.Next() {
+		keys = append(keys, it.Key())
+	}
+	return keys
+}
+
+// countKeys counts the keys of a tree or a snapshot in [start, end), failing the test if they cannot be read
+func countKeys(t *testing.T, counter interface{ Count(start, end string) (int, error) }, start, end string) int {
+	n, err := counter.Count(start, end)
+	assert.NoError(t, err)
+	return n
+}
+
+func TestIteratorForward(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	keys := seedTree(&bpt, 100)
+
+	// Act
+	it := bpt.Iterator(false)
+	defer it.Close()
+
+	// Assert
+	assert.Equal(t, keys, collect(it))
+}
+
+func TestIteratorReverse(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	keys := seedTree(&bpt, 100)
+	reversed := make([]string, 0)
+	for i := len(keys) - 1; i >= 0; i-- {
+		reversed = append(reversed, keys[i])
+	}
+
+	// Act
+	it := bpt.Iterator(true)
+	defer it.Close()
+
+	// Assert
+	assert.Equal(t, reversed, collect(it))
+}
+
+func TestIteratorReadsEachLeafOnce(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 64, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	keys := seedTree(&bpt, 200)
+	numLeaves, height := 1, 1
+	for leaf := leafOf(&bpt, keys[0]); leaf.Next != 0; numLeaves++ {
+		leaf, _ = bpt.bpm.Get(leaf.Next)
+	}
+	for node, _ := bpt.bpm.GetRoot(); !node.IsLeaf; height++ {
+		node, _ = bpt.bpm.Get(node.Children[0])
+	}
+	before := bpt.CacheStats()
+
+	// Act
+	it := bpt.Iterator(false)
+	defer it.Close()
+	collected := collect(it)
+	after := bpt.CacheStats()
+
+	// Assert
+	// the iterator descends to the first leaf and follows the sibling pointers from there on
+	assert.Equal(t, keys, collected)
+	assert.Greater(t, height, 2)
+	assert.Equal(t, int64(height-1+numLeaves), after.Hits+after.Misses-before.Hits-before.Misses)
+}
+
+func TestIteratorSeek(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	_ = seedTree(&bpt, 100)
+	it := bpt.Iterator(false)
+	defer it.Close()
+	reverseIt := bpt.Iterator(true)
+	defer reverseIt.Close()
+
+	// Act/Assert
+	it.Seek("k050")
+	assert.True(t, it.Valid())
+	assert.Equal(t, "k050", it.Key())
+	assert.Equal(t, "vk050", it.Value())
+
+	it.Seek("k050a")
+	assert.True(t, it.Valid())
+	assert.Equal(t, "k051", it.Key())
+
+	it.Seek("a")
+	assert.True(t, it.Valid())
+	assert.Equal(t, "k000", it.Key())
+
+	it.Seek("z")
+	assert.False(t, it.Valid())
+
+	reverseIt.Seek("k050a")
+	assert.True(t, reverseIt.Valid())
+	assert.Equal(t, "k050", reverseIt.Key())
+	reverseIt.Next()
+	assert.Equal(t, "k049", reverseIt.Key())
+
+	reverseIt.Seek("a")
+	assert.False(t, reverseIt.Valid())
+}
+
+func TestIteratorAfterDeletes(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	keys := seedTree(&bpt, 100)
+	remaining := make([]string, 0)
+	for idx, key := range keys {
+		if idx%3 != 0 {
+			bpt.Delete(key)
+		} else {
+			remaining = append(remaining, key)
+		}
+	}
+	bpt.ValidateTreeStructure()
+
+	// Act
+	it := bpt.Iterator(false)
+	defer it.Close()
+
+	// Assert
+	assert.Equal(t, remaining, collect(it))
+}
+
+func TestIteratorEmptyTree(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+
+	// Act
+	it := bpt.Iterator(false)
+	defer it.Close()
+
+	// Assert
+	assert.False(t, it.Valid())
+}
+
+func TestIteratorSeesTxnCommittedMidScanAllOrNothing(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	keys := seedTree(&bpt, 100)
+	it := bpt.Iterator(false)
+	defer it.Close()
+	it.Seek("k050")
+
+	// Act
+	// the transaction writes keys on both sides of the iterator and splits the leaves ahead of it
+	txn := bpt.Begin()
+	assert.NoError(t, txn.Put("k010", "txn"))
+	assert.NoError(t, txn.Put("k090", "txn"))
+	assert.NoError(t, txn.Delete("k060"))
+	for i := 0; i < 50; i++ {
+		assert.NoError(t, txn.Put(fmt.Sprintf("k070-%02d", i), "txn"))
+	}
+	assert.NoError(t, txn.Commit())
+	values := map[string]string{}
+	for ; it.Valid(); it.Next() {
+		values[it.Key()] = it.Value()
+	}
+	after, err := bpt.Scan("", "", 0)
+
+	// Assert
+	assert.NoError(t, it.Err())
+	assert.Len(t, values, 50)
+	for _, key := range keys[50:] {
+		assert.Equal(t, "v"+key, values[key])
+	}
+	assert.NoError(t, err)
+	assert.Len(t, after, 100-1+50)
+	for _, pair := range after {
+		if pair.Key == "k010" || pair.Key == "k090" || strings.HasPrefix(pair.Key, "k070-") {
+			assert.Equal(t, "txn", pair.Value)
+		}
+		assert.NotEqual(t, "k060", pair.Key)
+	}
+}
+
+func TestScan(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	_ = seedTree(&bpt, 100)
+	large := strings.Repeat("l", 2*PageSize)
+	_ = bpt.Set("k010", large)
+
+	// Act
+	pairs, _ := bpt.Scan("k010", "k015", -1)
+	limited, _ := bpt.Scan("k095", "", 3)
+	all, _ := bpt.Scan("", "", 0)
+
+	// Assert
+	assert.Equal(t, []KeyValue{
+		{"k010", large}, {"k011", "vk011"}, {"k012", "vk012"}, {"k013", "vk013"}, {"k014", "vk014"},
+	}, pairs)
+	assert.Equal(t, []KeyValue{{"k095", "vk095"}, {"k096", "vk096"}, {"k097", "vk097"}}, limited)
+	assert.Equal(t, 100, len(all))
+}
+
+func TestScanPrefix(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	for _, key := range []string{"user:1:a", "user:1:b", "user:12:a", "user:2:a", "user:1", "user:1:c", "users"} {
+		_ = bpt.Set(key, key)
+	}
+
+	// Act
+	all, _ := bpt.ScanPrefix("user:1:", "", 0)
+	firstPage, _ := bpt.ScanPrefix("user:1:", "", 2)
+	secondPage, _ := bpt.ScanPrefix("user:1:", "user:1:c", 2)
+	none, err := bpt.ScanPrefix("admin:", "", 0)
+
+	// Assert
+	assert.Equal(t, []KeyValue{{"user:1:a", "user:1:a"}, {"user:1:b", "user:1:b"}, {"user:1:c", "user:1:c"}}, all)
+	assert.Equal(t, all[:2], firstPage)
+	assert.Equal(t, all[2:], secondPage)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(none))
+}
+
+func TestCount(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	_ = seedTree(&bpt, 100)
+
+	// Act
+	total, err := bpt.Count("", "")
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, 100, total)
+	assert.Equal(t, 10, countKeys(t, &bpt, "k010", "k020"))
+	assert.Equal(t, 5, countKeys(t, &bpt, "k095", ""))
+	assert.Equal(t, 0, countKeys(t, &bpt, "k050", "k050"))
+	assert.Equal(t, 0, countKeys(t, &bpt, "z", ""))
+}
+
+func TestPrefixEnd(t *testing.T) {
+	assert.Equal(t, "user;", prefixEnd("user:"))
+	assert.Equal(t, "b", prefixEnd("a\xff"))
+	assert.Equal(t, "", prefixEnd("\xff\xff"))
+	assert.Equal(t, "", prefixEnd(""))
+}
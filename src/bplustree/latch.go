@@ -0,0 +1,291 @@
+package bplustree
+
+import "errors"
+
+// Every page of the tree is guarded by a latch handed out by the BufferPoolManager, and reads and writes of single keys
+// use latch crabbing to run concurrently:
+//
+// Readers descend from the root latching each node shared and releasing its parent as soon as the node is latched, so
+// a reader holds at most two latches at any time.
+//
+// Writers first descend like readers but latch the leaf exclusively. If the write can neither split nor underflow the
+// leaf it is applied to the leaf alone. Otherwise the writer starts over and latches every node on its path exclusively,
+// releasing all latches above a node once the node is safe, meaning no write below it can split it, underflow it or
+// collapse it into its only child. Such pessimistic writes are serialized by smoLock, which together with every writer
+// latching top down and siblings only being latched under their exclusively latched parent keeps writers from
+// deadlocking. The leaf whose back link changes when a leaf is split or merged is latched last for the same reason.
+//
+// Exclusive latches are held until the write commits, so no other writer can build on a page which would be lost should
+// the write never commit. A write which fails part way, for instance on a corrupt page, is aborted before its latches
+// are released.
+
+// A writeOp is a write to a single key along with the latches it holds
+type writeOp struct {
+	key     string
+	value   string
+	delete  bool
+	cond    Condition // must hold for the current state of the key for the write to be applied, nil if unconditional
+	applied bool      // whether the write was applied
+
+	txn         *WriteTxn
+	latched     []int64         // exclusively latched pages in the order they were latched
+	rootLatched bool            // whether the root latch is held exclusively
+	smo         bool            // whether smoLock is held
+	prevLinks   map[int64]int64 // leaf to the new leaf preceding it, applied once the rest of the tree is restructured
+}
+
+// setPrev records that the leaf at pageNum must point back at prev
+func (op *writeOp) setPrev(pageNum int64, prev int64) {
+	if op.prevLinks == nil {
+		op.prevLinks = map[int64]int64{}
+	}
+	op.prevLinks[pageNum] = prev
+}
+
+// apply runs op as a transaction of its own. Returns whether the write was applied
+func (t *BPlusTree) apply(op *writeOp) (bool, error) {
+	t.txnLock.RLock()
+	defer t.txnLock.RUnlock()
+
+	op.txn = t.bpm.BeginWrite()
+	op.txn.durability = t.durability
+	err := t.write(op)
+	if err == nil && op.applied {
+		err = t.bpm.Commit(op.txn)
+	}
+	if err != nil && !errors.Is(err, ErrNotDurable) {
+		// the writes of op are discarded even if the ABORT frame fails to be written, so the error of the write is the
+		// one worth reporting
+		_ = t.bpm.Abort(op.txn)
+	}
+	t.release(op)
+	return op.applied && (err == nil || errors.Is(err, ErrNotDurable)), err
+}
+
+// write applies op without committing it. The caller is responsible for releasing the latches of op
+func (t *BPlusTree) write(op *writeOp) error {
+	written, err := t.writeOptimistic(op)
+	if err != nil || written {
+		return err
+	}
+	return t.writePessimistic(op)
+}
+
+// writeOptimistic applies op if it can neither split nor underflow the leaf holding the key. Returns false without
+// writing anything and releases every latch otherwise
+func (t *BPlusTree) writeOptimistic(op *writeOp) (bool, error) {
+	leaf, isRoot, err := t.findLeafForWrite(op.key)
+	if err != nil {
+		return false, err
+	}
+	op.latched = append(op.latched, leaf.PageNum)
+
+	size := leaf.Size()
+	i, found := findKeyIndexInLeaf(op.key, leaf.Keys)
+	if found {
+		size -= leaf.entrySize(i)
+	}
+	if !op.delete {
+		size += t.storedEntrySize(op.key, op.value)
+	}
+	if size > t.capacity || (!isRoot && size < t.minFill()) {
+		t.release(op)
+		return false, nil
+	}
+
+	modified, err := t.modify(op, leaf)
+	if err != nil {
+		return false, err
+	}
+	if modified {
+		if err := t.bpm.Set(op.txn, leaf); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// writePessimistic applies op latching every node which may be split or merged as a result
+func (t *BPlusTree) writePessimistic(op *writeOp) error {
+	t.smoLock.Lock()
+	op.smo = true
+	t.rootLatch.Lock()
+	op.rootLatched = true
+
+	root, err := t.latch(op, t.bpm.RootPageNum())
+	if err != nil {
+		return err
+	}
+	if t.safe(root, true) {
+		t.releaseAncestors(op)
+	}
+	modified, err := t.modify(op, root)
+	if err != nil {
+		return err
+	}
+	if modified {
+		if err := t.fixRoot(op, root); err != nil {
+			return err
+		}
+	}
+	for pageNum, prev := range op.prevLinks {
+		leaf, err := t.latch(op, pageNum)
+		if err != nil {
+			return err
+		}
+		leaf.Prev = prev
+		if err := t.bpm.Set(op.txn, leaf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// modify sets or deletes the key of op in the subtree rooted at node. Returns whether node was modified
+func (t *BPlusTree) modify(op *writeOp, node *Node) (bool, error) {
+	if op.delete {
+		return t.delete(op, node)
+	}
+	return t.set(op, node)
+}
+
+// latch latches the page exclusively on behalf of op and returns a copy of its node which op may modify
+func (t *BPlusTree) latch(op *writeOp, pageNum int64) (*Node, error) {
+	for _, latched := range op.latched {
+		if latched == pageNum {
+			return t.bpm.GetForUpdate(pageNum)
+		}
+	}
+	t.bpm.Latch(pageNum).Lock()
+	op.latched = append(op.latched, pageNum)
+	return t.bpm.GetForUpdate(pageNum)
+}
+
+// latchChild latches the child on the path of op exclusively, releasing every latch above it if the child is safe
+func (t *BPlusTree) latchChild(op *writeOp, pageNum int64) (*Node, error) {
+	child, err := t.latch(op, pageNum)
+	if err != nil {
+		return nil, err
+	}
+	if t.safe(child, false) {
+		t.releaseAncestors(op)
+	}
+	return child, nil
+}
+
+// releaseAncestors releases every latch held by op except the one on the page latched last
+func (t *BPlusTree) releaseAncestors(op *writeOp) {
+	if op.rootLatched {
+		t.rootLatch.Unlock()
+		op.rootLatched = false
+	}
+	last := op.latched[len(op.latched)-1]
+	for _, pageNum := range op.latched[:len(op.latched)-1] {
+		t.bpm.Latch(pageNum).Unlock()
+	}
+	op.latched = []int64{last}
+}
+
+// release releases every latch held by op
+func (t *BPlusTree) release(op *writeOp) {
+	if op.rootLatched {
+		t.rootLatch.Unlock()
+		op.rootLatched = false
+	}
+	for _, pageNum := range op.latched {
+		t.bpm.Latch(pageNum).Unlock()
+	}
+	op.latched = nil
+	op.prevLinks = nil
+	if op.smo {
+		t.smoLock.Unlock()
+		op.smo = false
+	}
+}
+
+// safe returns whether a write below node can neither split it, underflow it nor, for the root, collapse it into its
+// only child. A write adds, removes or replaces at most one entry of each node above the leaf it writes to
+func (t *BPlusTree) safe(node *Node, isRoot bool) bool {
+	if node.Size()+t.maxEntrySize() > t.capacity {
+		return false
+	}
+	if isRoot {
+		return node.IsLeaf || len(node.Keys) > 1
+	}
+	return node.Size()-t.maxEntrySize() >= t.minFill()
+}
+
+// findLeaf descends from the root to a leaf, following the child chosen by next at every internal node. The leaf is
+// returned latched shared. No latch is held if an error is returned
+func (t *BPlusTree) findLeaf(next func(node *Node) int) (*Node, error) {
+	t.rootLatch.RLock()
+	pageNum := t.bpm.RootPageNum()
+	t.bpm.Latch(pageNum).RLock()
+	t.rootLatch.RUnlock()
+
+	for {
+		node, err := t.bpm.Get(pageNum)
+		if err != nil {
+			t.bpm.Latch(pageNum).RUnlock()
+			return nil, err
+		}
+		if node.IsLeaf {
+			return node, nil
+		}
+		pageNum = node.Children[next(node)]
+		t.bpm.Latch(pageNum).RLock()
+		t.bpm.Latch(node.PageNum).RUnlock()
+	}
+}
+
+// findLeafForWrite descends from the root to the leaf holding key and returns a copy of it latched exclusively, along
+// with whether the leaf is the root. The parent of the leaf stays latched until the leaf is latched exclusively, so the leaf cannot
+// be split or merged in between. No latch is held if an error is returned
+func (t *BPlusTree) findLeafForWrite(key string) (*Node, bool, error) {
+	t.rootLatch.RLock()
+	unlatchParent := t.rootLatch.RUnlock
+	pageNum := t.bpm.RootPageNum()
+	isRoot := true
+	for {
+		latch := t.bpm.Latch(pageNum)
+		latch.RLock()
+		node, err := t.bpm.Get(pageNum)
+		if err != nil {
+			latch.RUnlock()
+			unlatchParent()
+			return nil, false, err
+		}
+		if node.IsLeaf {
+			latch.RUnlock()
+			latch.Lock()
+			unlatchParent()
+			node, err = t.bpm.GetForUpdate(pageNum)
+			if err != nil {
+				latch.Unlock()
+				return nil, false, err
+			}
+			return node, isRoot, nil
+		}
+		unlatchParent()
+		unlatchParent = latch.RUnlock
+		pageNum = node.Children[findChildPointerIndex(key, node.Keys)]
+		isRoot = false
+	}
+}
+
+// atOrAfter chooses the child holding key
+func atOrAfter(key string) func(node *Node) int {
+	return func(node *Node) int {
+		return findChildPointerIndex(key, node.Keys)
+	}
+}
+
+// first chooses the child holding the smallest keys
+func first(_ *Node) int {
+	return 0
+}
+
+// last chooses the child holding the largest keys
+func last(node *Node) int {
+	return len(node.Children) - 1
+}
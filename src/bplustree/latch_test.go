@@ -0,0 +1,176 @@
+package bplustree
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentWritersSplitAndMergeNodes(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 8, 104)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	numThreads := 8
+	expected := make([]map[string]string, numThreads)
+	wg := sync.WaitGroup{}
+
+	// Act
+	// every thread writes its own keys, which share leaves with the keys of the other threads
+	for threadId := 0; threadId < numThreads; threadId++ {
+		expected[threadId] = map[string]string{}
+		wg.Add(1)
+		go func(threadId int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(threadId)))
+			for i := 0; i < 400; i++ {
+				key := fmt.Sprintf("%02d%d", r.Intn(60), threadId)
+				if r.Intn(3) == 0 {
+					bpt.Delete(key)
+					delete(expected[threadId], key)
+				} else {
+					value := fmt.Sprintf("%d", i)
+					_ = bpt.Set(key, value)
+					expected[threadId][key] = value
+				}
+				if value, present := expected[threadId][key]; present {
+					actual, _, _ := bpt.Get(key)
+					assert.Equal(t, value, actual)
+				}
+			}
+		}(threadId)
+	}
+	// iterate while the tree is being written to
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			keys := collect(bpt.Iterator(i%2 == 1))
+			for j := 1; j < len(keys); j++ {
+				if i%2 == 0 {
+					assert.Less(t, keys[j-1], keys[j])
+				} else {
+					assert.Greater(t, keys[j-1], keys[j])
+				}
+			}
+		}
+	}()
+	wg.Wait()
+
+	// Assert
+	bpt.ValidateTreeStructure()
+	count := 0
+	for _, pairs := range expected {
+		for key, value := range pairs {
+			actual, present, _ := bpt.Get(key)
+			assert.True(t, present)
+			assert.Equal(t, value, actual)
+		}
+		count += len(pairs)
+	}
+	assert.Equal(t, count, countKeys(t, &bpt, "", ""))
+
+	crash(&bpt)
+
+	// creating a new btree simulates recovering from a crash
+	bpt = NewBPlusTree(TestFile, 8, 104)
+	bpt.ValidateTreeStructure()
+	assert.Equal(t, count, countKeys(t, &bpt, "", ""))
+}
+
+func TestIteratorSeesLeavesSplitWhileIterating(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	keys := seedTree(&bpt, 100)
+	it := bpt.Iterator(false)
+	defer it.Close()
+	reverseIt := bpt.Iterator(true)
+	defer reverseIt.Close()
+
+	// Act
+	// interleave iteration with writes which split and merge the leaves the iterators are about to visit
+	forward := make([]string, 0)
+	reverse := make([]string, 0)
+	for i := 0; it.Valid() || reverseIt.Valid(); i++ {
+		if it.Valid() {
+			forward = append(forward, it.Key())
+			it.Next()
+		}
+		if reverseIt.Valid() {
+			reverse = append(reverse, reverseIt.Key())
+			reverseIt.Next()
+		}
+		if i%10 == 0 {
+			for j := 0; j < 10; j++ {
+				_ = bpt.Set(fmt.Sprintf("k%03dx%d", i, j), "")
+				bpt.Delete(fmt.Sprintf("k%03dx%d", i, j))
+			}
+		}
+	}
+
+	// Assert
+	assert.Equal(t, keys, forward)
+	assert.Equal(t, len(keys), len(reverse))
+	for idx, key := range reverse {
+		assert.Equal(t, keys[len(keys)-1-idx], key)
+	}
+}
+
+// BenchmarkMixedLoad compares the throughput of concurrent reads and writes against the tree with the same workload
+// serialized by a single tree wide lock, as the tree used to do
+func BenchmarkMixedLoad(b *testing.B) {
+	for _, writePercent := range []int{10, 50} {
+		for _, treeLock := range []bool{true, false} {
+			name := fmt.Sprintf("writes=%d%%/latchCrabbing", writePercent)
+			if treeLock {
+				name = fmt.Sprintf("writes=%d%%/treeLock", writePercent)
+			}
+			b.Run(name, func(b *testing.B) {
+				benchmarkMixedLoad(b, writePercent, treeLock)
+			})
+		}
+	}
+}
+
+func benchmarkMixedLoad(b *testing.B, writePercent int, treeLock bool) {
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 512, -1)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	numKeys := 10000
+	txn := bpt.Begin()
+	for i := 0; i < numKeys; i++ {
+		_ = txn.Put(fmt.Sprintf("key%05d", i), fmt.Sprintf("value%05d", i))
+	}
+	_ = txn.Commit()
+	lock := sync.RWMutex{}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			key := fmt.Sprintf("key%05d", r.Intn(numKeys))
+			if r.Intn(100) < writePercent {
+				if treeLock {
+					lock.Lock()
+				}
+				_ = bpt.Set(key, key)
+				if treeLock {
+					lock.Unlock()
+				}
+			} else {
+				if treeLock {
+					lock.RLock()
+				}
+				_, _, _ = bpt.Get(key)
+				if treeLock {
+					lock.RUnlock()
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package bplustree
+
+import "fios-db/src/serialization"
+
+// The buffer pool is sized either in pages, with the cacheSize passed to NewBPlusTree, or in bytes of memory with
+// WithCacheBytes. A pool sized in bytes accounts every frame for the memory it holds on to:
+//
+//  frameOverhead  the frame itself, its entry in the pool and the bookkeeping of the replacement policy
+//  data           the latest version of the page
+//  node           the node decoded from the latest version of the page, see nodecache.go
+//  committed      the last committed version of the page if it differs from the latest version, that is while a
+//                 transaction which has not committed yet has rewritten a dirty page
+//
+// Overflow pages are accounted like any other page, and their share of the memory is reported separately by
+// CacheStats along with that of decoded nodes. As with a pool sized in pages, frames which are in use are never evicted, so the pool may use more memory
+// than its budget until the background writer cleans dirty frames or pinned frames are released.
+
+// frameOverhead is an estimate of the bytes of memory a frame uses on top of the versions of its page
+const frameOverhead = 160
+
+// Estimates of the bytes of memory used by the parts of a decoded node
+const (
+	nodeOverhead   = 160 // the Node itself and the headers of its slices
+	stringOverhead = 16  // the header of a string on top of its bytes
+	int64Size      = 8
+)
+
+// account updates the memory usage of the pool after the frame was added or changed. The caller must hold mu
+func (p *bufferPool) account(f *frame) {
+	p.unaccount(f)
+	f.memory = frameOverhead + int64(cap(f.data))
+	if len(f.committed) > 0 && &f.committed[0] != &f.data[0] {
+		f.memory += int64(cap(f.committed))
+	}
+	if f.node != nil {
+		f.nodeMemory = nodeMemory(f.node)
+		f.memory += f.nodeMemory
+		p.nodeBytes += f.nodeMemory
+	}
+	f.overflow = PageType(serialization.BytesToInt16(f.data[:PageTypeSize])) == OVERFLOW
+	p.bytes += f.memory
+	if f.overflow {
+		p.overflowBytes += f.memory
+	}
+}
+
+// unaccount removes the frame from the memory usage of the pool. The caller must hold mu
+func (p *bufferPool) unaccount(f *frame) {
+	p.bytes -= f.memory
+	if f.overflow {
+		p.overflowBytes -= f.memory
+	}
+	p.nodeBytes -= f.nodeMemory
+	f.memory = 0
+	f.nodeMemory = 0
+	f.overflow = false
+}
+
+// nodeMemory returns an estimate of the bytes of memory used by the decoded node
+func nodeMemory(n *Node) int64 {
+	size := int64(nodeOverhead)
+	for _, key := range n.Keys {
+		size += stringOverhead + int64(len(key))
+	}
+	for _, value := range n.Values {
+		size += stringOverhead + int64(len(value))
+	}
+	size += int64Size * int64(len(n.Overflow)+len(n.Versions)+len(n.Children))
+	return size
+}
+
+// overCapacity returns whether the pool holds more pages than its capacity, or uses more memory than its budget if it
+// is sized in bytes. The caller must hold mu
+func (p *bufferPool) overCapacity() bool {
+	if p.maxBytes > 0 {
+		return p.bytes > p.maxBytes
+	}
+	return len(p.frames) > p.capacity
+}
+
+// EvictionRate returns the number of pages evicted per second between the earlier stats and s. The rate since the db
+// was opened is returned if earlier is the zero CacheStats
+func (s CacheStats) EvictionRate(earlier CacheStats) float64 {
+	since := earlier.Time
+	if since.IsZero() {
+		since = s.Opened
+	}
+	elapsed := s.Time.Sub(since)
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Evictions-earlier.Evictions) / elapsed.Seconds()
+}
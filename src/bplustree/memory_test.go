@@ -0,0 +1,98 @@
+package bplustree
+
+import (
+	"fios-db/src/serialization"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPoolSizedInBytesStaysWithinBudget(t *testing.T) {
+	// Arrange
+	maxBytes := int64(10 * (frameOverhead + PageSize))
+	pool := newBufferPool(1, maxBytes, NewLRUPolicy(), false)
+
+	// Act
+	readPages(pool, 1, 101, false)
+
+	// Assert
+	stats := pool.cacheStats()
+	assert.Equal(t, 10, stats.Pages)
+	assert.Equal(t, maxBytes, stats.Bytes)
+	assert.Equal(t, maxBytes, stats.MaxBytes)
+	assert.Equal(t, 0, stats.MaxPages)
+	assert.Equal(t, int64(90), stats.Evictions)
+}
+
+func TestMemoryAccountsForCommittedVersionsAndOverflowPages(t *testing.T) {
+	// Arrange
+	pool := newBufferPool(16, 0, NewLRUPolicy(), false)
+	overflowPage := make([]byte, PageSize)
+	copy(overflowPage, serialization.Int16ToBytes(int16(OVERFLOW)))
+	pool.put(1, leafPage())
+	pool.commit(1, 1)
+	afterCommit := pool.cacheStats()
+
+	// Act
+	pool.put(1, leafPage())
+	afterRewrite := pool.cacheStats()
+	pool.discard(1)
+	afterDiscard := pool.cacheStats()
+	pool.unpin(pool.load(2, overflowPage, false))
+
+	// Assert
+	// the committed version shares the memory of the latest version until the page is rewritten
+	assert.Equal(t, int64(frameOverhead+PageSize), afterCommit.Bytes)
+	assert.Equal(t, int64(frameOverhead+2*PageSize), afterRewrite.Bytes)
+	assert.Equal(t, int64(frameOverhead+PageSize), afterDiscard.Bytes)
+	stats := pool.cacheStats()
+	assert.Equal(t, int64(2*(frameOverhead+PageSize)), stats.Bytes)
+	assert.Equal(t, int64(frameOverhead+PageSize), stats.OverflowBytes)
+}
+
+func TestEvictionRate(t *testing.T) {
+	// Arrange
+	opened := time.Now()
+	earlier := CacheStats{
+		Evictions: 10,
+		Opened:    opened,
+		Time:      opened.Add(time.Second),
+	}
+	later := CacheStats{
+		Evictions: 50,
+		Opened:    opened,
+		Time:      opened.Add(3 * time.Second),
+	}
+
+	// Act
+	rate := later.EvictionRate(earlier)
+	rateSinceOpened := later.EvictionRate(CacheStats{})
+
+	// Assert
+	assert.InDelta(t, 20, rate, 0.001)
+	assert.InDelta(t, 50.0/3, rateSinceOpened, 0.001)
+}
+
+func TestTreeWithCacheSizedInBytes(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	maxBytes := int64(16 * (frameOverhead + PageSize))
+	bpt := NewBPlusTree(TestFile, 1, 128, WithCacheBytes(maxBytes))
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	keys := seedTree(&bpt, 300)
+
+	// Act
+	assert.NoError(t, bpt.Checkpoint())
+	for _, key := range keys {
+		_, _, _ = bpt.Get(key)
+	}
+
+	// Assert
+	stats := bpt.CacheStats()
+	assert.Equal(t, maxBytes, stats.MaxBytes)
+	assert.LessOrEqual(t, stats.Bytes, maxBytes)
+	assert.Greater(t, stats.Pages, 1)
+	assert.Greater(t, stats.Evictions, int64(0))
+	assert.Greater(t, stats.EvictionRate(CacheStats{}), 0.0)
+}
@@ -0,0 +1,242 @@
+package bplustree
+
+import (
+	"fmt"
+	"math"
+)
+
+// Snapshots read the tree as of a commit without taking any latches. Every commit is numbered by a commit sequence
+// number, and a snapshot pinned at a commit reads each page as it was once that commit was made:
+//
+// While any snapshot is pinned, the BufferPoolManager records in pageSeqs which commit last wrote each page. A page
+// which has not been written since a snapshot was pinned is read from its committed image, which is held by the buffer
+// pool unless the page was evicted or written back to the db file with uncommitted writes on top of it, in which case
+// the image is read from the WAL or the db file and added to the pool. The image is located while holding mu but read
+// without it, so that snapshot reads do not hold up writers. A commit overwriting the page in between keeps the image
+// as a version, which the snapshot reads instead.
+// Otherwise the snapshot reads the version of the page kept for it when the page was overwritten: a commit which
+// overwrites a page some pinned snapshot may still read first copies the committed image of the page into versions.
+//
+// Versions are garbage collected as soon as the last snapshot which may read them is released.
+
+// A pageVersion is the committed image of a page which was current from commit seq until it was overwritten by commit
+// supersededAt
+type pageVersion struct {
+	data         []byte
+	err          error // set instead of data if the committed image could not be read
+	node         *Node // decoded from data the first time the version is read as a node, shared by every snapshot
+	seq          int64
+	supersededAt int64
+}
+
+// PinSnapshot pins the last commit, keeping every page as of that commit readable until the snapshot is unpinned.
+// Returns the commit sequence number of the snapshot along with the root of the tree as of that commit
+func (bpm *BufferPoolManager) PinSnapshot() (int64, int64) {
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+	bpm.snapshots[bpm.commitSeq]++
+	return bpm.commitSeq, bpm.committedRootPageNum
+}
+
+// UnpinSnapshot releases a snapshot pinned by PinSnapshot and drops every version no pinned snapshot can read anymore
+func (bpm *BufferPoolManager) UnpinSnapshot(seq int64) {
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+	bpm.snapshots[seq]--
+	if bpm.snapshots[seq] > 0 {
+		return
+	}
+	delete(bpm.snapshots, seq)
+
+	minSeq := int64(math.MaxInt64)
+	for snapshotSeq := range bpm.snapshots {
+		if snapshotSeq < minSeq {
+			minSeq = snapshotSeq
+		}
+	}
+	for pageNum, seq := range bpm.pageSeqs {
+		// pages written before the oldest snapshot read the same as pages which were never written
+		if seq <= minSeq {
+			delete(bpm.pageSeqs, pageNum)
+		}
+	}
+	for pageNum, versions := range bpm.versions {
+		live := versions[:0]
+		for _, version := range versions {
+			if bpm.pinnedWithin(version.seq, version.supersededAt) {
+				live = append(live, version)
+			}
+		}
+		if len(live) == 0 {
+			delete(bpm.versions, pageNum)
+		} else {
+			bpm.versions[pageNum] = live
+		}
+	}
+}
+
+// NumPageVersions returns the number of page versions kept for pinned snapshots
+func (bpm *BufferPoolManager) NumPageVersions() int {
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+	count := 0
+	for _, versions := range bpm.versions {
+		count += len(versions)
+	}
+	return count
+}
+
+// GetAt reads the node stored in the page as of commit seq. scan is set if a range scan reads the page, see GetForScan.
+// The node is shared with other readers and must not be modified, see nodecache.go
+func (bpm *BufferPoolManager) GetAt(pageNum int64, seq int64, scan bool) (*Node, error) {
+	pageBytes, node, err := bpm.pageAt(pageNum, seq, scan)
+	if err != nil || node != nil {
+		return node, err
+	}
+	if node, err = decodeNode(pageNum, pageBytes); err != nil {
+		return nil, err
+	}
+	bpm.cacheNodeAt(pageNum, seq, pageBytes, node)
+	return node, nil
+}
+
+// GetOverflowAt reads the value stored in the chain of overflow pages starting at pageNum as of commit seq. scan is set if
+// a range scan reads the value
+func (bpm *BufferPoolManager) GetOverflowAt(pageNum int64, seq int64, scan bool) (string, error) {
+	return readOverflow(pageNum, func(pageNum int64) ([]byte, error) {
+		return bpm.getPageAt(pageNum, seq, scan)
+	})
+}
+
+// getPageAt reads the page as of commit seq, which must be pinned. scan is set if a range scan reads the page. Returns a
+// CorruptPageError should the version of the page the snapshot reads be missing
+func (bpm *BufferPoolManager) getPageAt(pageNum int64, seq int64, scan bool) ([]byte, error) {
+	data, _, err := bpm.pageAt(pageNum, seq, scan)
+	return data, err
+}
+
+// pageAt reads the page as of commit seq like getPageAt, along with the node decoded from it if one is cached
+func (bpm *BufferPoolManager) pageAt(pageNum int64, seq int64, scan bool) ([]byte, *Node, error) {
+	for {
+		bpm.mu.Lock()
+		if bpm.pageSeqs[pageNum] > seq {
+			version, err := bpm.versionAt(pageNum, seq)
+			bpm.mu.Unlock()
+			if err != nil {
+				return nil, nil, err
+			}
+			return version.data, version.node, version.err
+		}
+		if data, node := bpm.pool.committedVersion(pageNum, scan); data != nil {
+			bpm.mu.Unlock()
+			return data, node, nil
+		}
+		offset, inWAL := bpm.wal.CommittedOffset(pageNum)
+		bpm.mu.Unlock()
+
+		data, err := bpm.readCommitted(pageNum, offset, inWAL)
+
+		bpm.mu.Lock()
+		if bpm.pageSeqs[pageNum] > seq {
+			// the page was overwritten while it was read, and what was read may be the new image. The image the
+			// snapshot reads was kept as a version
+			bpm.mu.Unlock()
+			continue
+		}
+		if err != nil {
+			newOffset, nowInWAL := bpm.wal.CommittedOffset(pageNum)
+			bpm.mu.Unlock()
+			if newOffset != offset || nowInWAL != inWAL {
+				// the WAL was checkpointed while the page was read, the image is now read from the db file
+				continue
+			}
+			return data, nil, err
+		}
+		bpm.pool.unpin(bpm.pool.load(pageNum, data, scan))
+		bpm.mu.Unlock()
+		return data, nil, nil
+	}
+}
+
+// versionAt returns the version of the page kept for the snapshot at commit seq. Returns a CorruptPageError should the
+// version be missing. The caller must hold mu
+func (bpm *BufferPoolManager) versionAt(pageNum int64, seq int64) (pageVersion, error) {
+	versions := bpm.versions[pageNum]
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].seq <= seq && seq < versions[i].supersededAt {
+			return versions[i], nil
+		}
+	}
+	// versions are only dropped once no pinned snapshot can read them, so this is a bug rather than a corrupt page, but
+	// it is reported to the reader all the same rather than taking the db down
+	return pageVersion{}, &CorruptPageError{
+		PageNum: pageNum,
+		Reason:  fmt.Sprintf("no version of the page is kept for the snapshot at commit %d", seq),
+	}
+}
+
+// cacheNodeAt keeps the node decoded from data, the page as of commit seq, for other reads of the same image: in the
+// version of the page kept for the snapshot, or in the buffer pool if the page was not written since
+func (bpm *BufferPoolManager) cacheNodeAt(pageNum int64, seq int64, data []byte, node *Node) {
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+	if bpm.pageSeqs[pageNum] <= seq {
+		bpm.pool.cacheCommittedNode(pageNum, data, node)
+		return
+	}
+	for i, version := range bpm.versions[pageNum] {
+		if version.node == nil && len(version.data) > 0 && &version.data[0] == &data[0] {
+			bpm.versions[pageNum][i].node = node
+			return
+		}
+	}
+}
+
+// retainVersion keeps the committed image of the page before it is overwritten by commit seq if a pinned snapshot may
+// still read it. The caller must hold mu
+func (bpm *BufferPoolManager) retainVersion(pageNum int64, seq int64) {
+	if !bpm.pinnedWithin(bpm.pageSeqs[pageNum], seq) {
+		return
+	}
+	data, err := bpm.committedPage(pageNum)
+	bpm.versions[pageNum] = append(bpm.versions[pageNum], pageVersion{
+		data:         data,
+		err:          err,
+		seq:          bpm.pageSeqs[pageNum],
+		supersededAt: seq,
+	})
+}
+
+// pinnedWithin returns whether a snapshot is pinned at a commit in [from, to). The caller must hold mu
+func (bpm *BufferPoolManager) pinnedWithin(from, to int64) bool {
+	for snapshotSeq := range bpm.snapshots {
+		if from <= snapshotSeq && snapshotSeq < to {
+			return true
+		}
+	}
+	return false
+}
+
+// committedPage reads the last committed image of the page, skipping writes of transactions which have not committed
+// yet. A page which fails its checksum is returned along with the CorruptPageError. The caller must hold mu
+func (bpm *BufferPoolManager) committedPage(pageNum int64) ([]byte, error) {
+	offset, inWAL := bpm.wal.CommittedOffset(pageNum)
+	return bpm.readCommitted(pageNum, offset, inWAL)
+}
+
+// readCommitted reads the committed image of the page from the frame at offset in the WAL if inWAL is set, and from the
+// db file otherwise. A page which fails its checksum is returned along with the CorruptPageError. mu need not be held,
+// in which case the caller must make sure the image was not overwritten or truncated while it was read
+func (bpm *BufferPoolManager) readCommitted(pageNum int64, offset int64, inWAL bool) ([]byte, error) {
+	var buffer []byte
+	var err error
+	if inWAL {
+		buffer, err = bpm.wal.ReadAt(offset)
+	} else {
+		buffer, err = bpm.readPage(pageNum)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buffer, verifyPage(pageNum, buffer)
+}
@@ -3,8 +3,12 @@ package bplustree
 // Node is a node in a b tree
 type Node struct {
 	Keys     []string // Keys of nodes
-	Values   []string // values
+	Values   []string // values, empty when the value is stored in overflow pages
+	Overflow []int64  // first overflow page holding the value, 0 when the value is stored inline
+	Versions []int64  // version of each value, incremented every time the key is written, see RetireVersion
 	Children []int64  // Children
+	Prev     int64    // leaf holding the next smaller keys, 0 if there is none
+	Next     int64    // leaf holding the next larger keys, 0 if there is none
 	PageNum  int64
 	IsLeaf   bool
 }
@@ -20,10 +24,12 @@ func NewLeafNode(pageNum int64, keys []string, values []string) *Node {
 	}
 
 	return &Node{
-		Keys:    keysCopied,
-		Values:  valuesCopied,
-		PageNum: pageNum,
-		IsLeaf:  true,
+		Keys:     keysCopied,
+		Values:   valuesCopied,
+		Overflow: make([]int64, len(values)),
+		Versions: make([]int64, len(values)),
+		PageNum:  pageNum,
+		IsLeaf:   true,
 	}
 }
 
@@ -45,6 +51,35 @@ func NewInnerNode(pageNum int64, keys []string, children []int64) *Node {
 	}
 }
 
+// clone returns a copy of the node which shares no slices with it, which may be modified without affecting the node
+func (n *Node) clone() *Node {
+	c := *n
+	c.Keys = copyStrings(n.Keys)
+	c.Values = copyStrings(n.Values)
+	c.Overflow = copyInt64s(n.Overflow)
+	c.Versions = copyInt64s(n.Versions)
+	c.Children = copyInt64s(n.Children)
+	return &c
+}
+
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	c := make([]string, len(s))
+	copy(c, s)
+	return c
+}
+
+func copyInt64s(s []int64) []int64 {
+	if s == nil {
+		return nil
+	}
+	c := make([]int64, len(s))
+	copy(c, s)
+	return c
+}
+
 func (n *Node) InsertKey(key string, idx int) {
 	if idx == len(n.Keys) {
 		n.Keys = append(n.Keys, key)
@@ -54,12 +89,19 @@ func (n *Node) InsertKey(key string, idx int) {
 	}
 }
 
+// InsertValue inserts an inline value with version 0 at idx
 func (n *Node) InsertValue(value string, idx int) {
 	if idx == len(n.Values) {
 		n.Values = append(n.Values, value)
+		n.Overflow = append(n.Overflow, 0)
+		n.Versions = append(n.Versions, 0)
 	} else {
 		n.Values = append(n.Values[:idx+1], n.Values[idx:]...)
 		n.Values[idx] = value
+		n.Overflow = append(n.Overflow[:idx+1], n.Overflow[idx:]...)
+		n.Overflow[idx] = 0
+		n.Versions = append(n.Versions[:idx+1], n.Versions[idx:]...)
+		n.Versions[idx] = 0
 	}
 }
 
@@ -78,57 +120,148 @@ func (n *Node) DeleteKey(idx int) {
 
 func (n *Node) DeleteValue(idx int) {
 	n.Values = append(n.Values[0:idx], n.Values[idx+1:]...)
+	n.Overflow = append(n.Overflow[0:idx], n.Overflow[idx+1:]...)
+	n.Versions = append(n.Versions[0:idx], n.Versions[idx+1:]...)
 }
 
 func (n *Node) DeleteChild(idx int) {
 	n.Children = append(n.Children[0:idx], n.Children[idx+1:]...)
 }
 
-func (n *Node) CanLend(capacity int) bool {
-	return len(n.Keys) > capacity/2
+// CanLend returns whether the entry at idx can be handed to a sibling without the node dropping below minFill bytes
+func (n *Node) CanLend(idx int, minFill int) bool {
+	return len(n.Keys) > 1 && n.Size()-n.entrySize(idx) >= minFill
+}
+
+// Size returns the number of bytes the node occupies once serialized into a slotted page
+func (n *Node) Size() int {
+	size := n.headerSize()
+	for idx := range n.Keys {
+		size += n.entrySize(idx)
+	}
+	return size
+}
+
+// SplitIndex returns the index at which the node should be split so that both halves hold roughly the same number of
+// bytes. For a leaf node the entry at the index is the first entry of the right half. For an internal node the key at
+// the index is pushed up into the parent
+func (n *Node) SplitIndex() int {
+	half := (n.Size() - n.headerSize()) / 2
+	acc := 0
+	idx := 0
+	for ; idx < len(n.Keys); idx++ {
+		if acc+n.entrySize(idx) > half {
+			break
+		}
+		acc += n.entrySize(idx)
+	}
+
+	if n.IsLeaf {
+		return clamp(idx, 1, len(n.Keys)-1)
+	}
+	return clamp(idx, 1, len(n.Keys)-2)
+}
+
+func (n *Node) headerSize() int {
+	if n.IsLeaf {
+		return LeafHeaderSize
+	}
+	return InternalHeaderSize
+}
+
+// entrySize returns the number of bytes used by the slot and cell of the entry at idx
+func (n *Node) entrySize(idx int) int {
+	if n.IsLeaf && n.Overflow[idx] != 0 {
+		return overflowEntrySize(n.Keys[idx])
+	}
+	if n.IsLeaf {
+		return leafEntrySize(n.Keys[idx], n.Values[idx])
+	}
+	return internalEntrySize(n.Keys[idx])
+}
+
+func leafEntrySize(key, value string) int {
+	return SlotSize + KeyLenSize + ValueLenSize + VersionSize + len(key) + len(value)
+}
+
+// overflowEntrySize is the size of a leaf entry whose value is stored in overflow pages
+func overflowEntrySize(key string) int {
+	return SlotSize + KeyLenSize + ValueLenSize + VersionSize + len(key) + PageRefSize
+}
+
+func internalEntrySize(key string) int {
+	return SlotSize + KeyLenSize + PageRefSize + len(key)
+}
+
+func clamp(i, min, max int) int {
+	if i < min {
+		return min
+	}
+	if i > max {
+		return max
+	}
+	return i
 }
 
-func (n *Node) RemoveMax() (string, string, int64) {
+// RemoveMax removes the largest entry and returns its key, value, page reference and version. The page reference is
+// the child for internal nodes and the first overflow page of the value for leaf nodes. Internal entries have no version
+func (n *Node) RemoveMax() (string, string, int64, int64) {
 	maxKey := n.Keys[len(n.Keys)-1]
 	n.Keys = n.Keys[:len(n.Keys)-1]
 	if n.IsLeaf {
 		value := n.Values[len(n.Values)-1]
+		overflow := n.Overflow[len(n.Overflow)-1]
+		version := n.Versions[len(n.Versions)-1]
 		n.Values = n.Values[:len(n.Values)-1]
-		return maxKey, value, -1
+		n.Overflow = n.Overflow[:len(n.Overflow)-1]
+		n.Versions = n.Versions[:len(n.Versions)-1]
+		return maxKey, value, overflow, version
 	} else {
 		child := n.Children[len(n.Children)-1]
 		n.Children = n.Children[:len(n.Children)-1]
-		return maxKey, "", child
+		return maxKey, "", child, 0
 	}
 }
 
-func (n *Node) RemoveMin() (string, string, int64) {
+// RemoveMin removes the smallest entry and returns its key, value, page reference and version. The page reference is
+// the child for internal nodes and the first overflow page of the value for leaf nodes. Internal entries have no version
+func (n *Node) RemoveMin() (string, string, int64, int64) {
 	minKeys := n.Keys[0]
 	n.Keys = n.Keys[1:]
 	if n.IsLeaf {
 		value := n.Values[0]
+		overflow := n.Overflow[0]
+		version := n.Versions[0]
 		n.Values = n.Values[1:]
-		return minKeys, value, -1
+		n.Overflow = n.Overflow[1:]
+		n.Versions = n.Versions[1:]
+		return minKeys, value, overflow, version
 	} else {
 		child := n.Children[0]
 		n.Children = n.Children[1:]
-		return minKeys, "", child
+		return minKeys, "", child, 0
 	}
 }
 
-func (n *Node) AcceptMaxFromLeftChild(key string, value string, child int64) {
+// AcceptMaxFromLeftChild inserts an entry removed with RemoveMax from the left sibling
+func (n *Node) AcceptMaxFromLeftChild(key string, value string, child int64, version int64) {
 	n.InsertKey(key, 0)
 	if n.IsLeaf {
 		n.InsertValue(value, 0)
+		n.Overflow[0] = child
+		n.Versions[0] = version
 	} else {
 		n.InsertChild(child, 0)
 	}
 }
 
-func (n *Node) AcceptMinFromRightChild(key string, value string, child int64) {
+// AcceptMinFromRightChild inserts an entry removed with RemoveMin from the right sibling
+func (n *Node) AcceptMinFromRightChild(key string, value string, child int64, version int64) {
 	n.InsertKey(key, len(n.Keys))
 	if n.IsLeaf {
 		n.InsertValue(value, len(n.Values))
+		n.Overflow[len(n.Overflow)-1] = child
+		n.Versions[len(n.Versions)-1] = version
 	} else {
 		n.InsertChild(child, len(n.Children))
 	}
@@ -2,6 +2,7 @@ package bplustree
 
 import (
 	"github.com/stretchr/testify/assert"
+	"strings"
 	"testing"
 )
 
@@ -48,10 +49,12 @@ func TestNewInnerNode(t *testing.T) {
 func TestInsertKey(t *testing.T) {
 	// Arrange
 	node := &Node{
-		Keys:    []string{"a", "b"},
-		Values:  []string{"a", "b"},
-		PageNum: 1,
-		IsLeaf:  true,
+		Keys:     []string{"a", "b"},
+		Values:   []string{"a", "b"},
+		Overflow: []int64{0, 0},
+		Versions: []int64{1, 1},
+		PageNum:  1,
+		IsLeaf:   true,
 	}
 
 	// Act
@@ -64,10 +67,12 @@ func TestInsertKey(t *testing.T) {
 func TestInsertValue(t *testing.T) {
 	// Arrange
 	node := &Node{
-		Keys:    []string{"a", "b"},
-		Values:  []string{"a", "b"},
-		PageNum: 1,
-		IsLeaf:  true,
+		Keys:     []string{"a", "b"},
+		Values:   []string{"a", "b"},
+		Overflow: []int64{0, 0},
+		Versions: []int64{1, 1},
+		PageNum:  1,
+		IsLeaf:   true,
 	}
 
 	// Act
@@ -76,15 +81,16 @@ func TestInsertValue(t *testing.T) {
 	// Assert
 	assert.Equal(t, []string{"a", "b"}, node.Keys)
 	assert.Equal(t, []string{"a", "b", "c"}, node.Values)
+	assert.Equal(t, []int64{1, 1, 0}, node.Versions)
 }
 
 func TestInsertChild(t *testing.T) {
 	// Arrange
 	node := &Node{
-		Keys:    []string{"a", "b"},
-		Children:  []int64{1, 2, 3},
-		PageNum: 1,
-		IsLeaf:  false,
+		Keys:     []string{"a", "b"},
+		Children: []int64{1, 2, 3},
+		PageNum:  1,
+		IsLeaf:   false,
 	}
 
 	// Act
@@ -98,10 +104,12 @@ func TestInsertChild(t *testing.T) {
 func TestDeleteKey(t *testing.T) {
 	// Arrange
 	node := &Node{
-		Keys:    []string{"a", "b"},
-		Values:  []string{"a", "b"},
-		PageNum: 1,
-		IsLeaf:  true,
+		Keys:     []string{"a", "b"},
+		Values:   []string{"a", "b"},
+		Overflow: []int64{0, 0},
+		Versions: []int64{1, 1},
+		PageNum:  1,
+		IsLeaf:   true,
 	}
 
 	// Act
@@ -115,10 +123,12 @@ func TestDeleteKey(t *testing.T) {
 func TestDeleteValue(t *testing.T) {
 	// Arrange
 	node := &Node{
-		Keys:    []string{"a", "b"},
-		Values:  []string{"a", "b"},
-		PageNum: 1,
-		IsLeaf:  true,
+		Keys:     []string{"a", "b"},
+		Values:   []string{"a", "b"},
+		Overflow: []int64{0, 0},
+		Versions: []int64{1, 1},
+		PageNum:  1,
+		IsLeaf:   true,
 	}
 
 	// Act
@@ -127,15 +137,16 @@ func TestDeleteValue(t *testing.T) {
 	// Assert
 	assert.Equal(t, []string{"a", "b"}, node.Keys)
 	assert.Equal(t, []string{"b"}, node.Values)
+	assert.Equal(t, []int64{1}, node.Versions)
 }
 
 func TestDeleteChild(t *testing.T) {
 	// Arrange
 	node := &Node{
-		Keys:    []string{"a", "b"},
-		Children:  []int64{1, 2, 3},
-		PageNum: 1,
-		IsLeaf:  false,
+		Keys:     []string{"a", "b"},
+		Children: []int64{1, 2, 3},
+		PageNum:  1,
+		IsLeaf:   false,
 	}
 
 	// Act
@@ -149,28 +160,160 @@ func TestDeleteChild(t *testing.T) {
 func TestCanLend(t *testing.T) {
 	// Arrange
 	node := &Node{
-		Keys:    []string{"a", "b"},
-		Children:  []int64{1, 2, 3},
-		PageNum: 1,
-		IsLeaf:  false,
+		Keys:     []string{"a", "b"},
+		Children: []int64{1, 2, 3},
+		PageNum:  1,
+		IsLeaf:   false,
 	}
 
 	// Act/Assert
-	assert.True(t, node.CanLend(3))
-	assert.False(t, node.CanLend(4))
+	// node occupies 12 + 2*13 bytes and each entry occupies 13 bytes
+	assert.True(t, node.CanLend(0, 25))
+	assert.True(t, node.CanLend(1, 25))
+	assert.False(t, node.CanLend(1, 26))
+}
+
+func TestCanLendLastEntry(t *testing.T) {
+	// Arrange
+	node := &Node{
+		Keys:     []string{"a"},
+		Values:   []string{"a"},
+		Overflow: []int64{0},
+		Versions: []int64{1},
+		PageNum:  1,
+		IsLeaf:   true,
+	}
+
+	// Act/Assert
+	assert.False(t, node.CanLend(0, 0))
+}
+
+func TestSizeLeafNode(t *testing.T) {
+	// Arrange
+	node := &Node{
+		Keys:     []string{"a", "bb"},
+		Values:   []string{"value", ""},
+		Overflow: []int64{0, 0},
+		Versions: []int64{1, 1},
+		PageNum:  1,
+		IsLeaf:   true,
+	}
+
+	// Act/Assert
+	assert.Equal(t, LeafHeaderSize+(14+1+5)+(14+2+0), node.Size())
+}
+
+func TestSizeLeafNodeWithOverflowValue(t *testing.T) {
+	// Arrange
+	node := &Node{
+		Keys:     []string{"a"},
+		Values:   []string{""},
+		Overflow: []int64{7},
+		Versions: []int64{1},
+		PageNum:  1,
+		IsLeaf:   true,
+	}
+
+	// Act/Assert
+	assert.Equal(t, LeafHeaderSize+(14+1+PageRefSize), node.Size())
+}
+
+func TestMoveOverflowValueBetweenLeafNodes(t *testing.T) {
+	// Arrange
+	left := NewLeafNode(1, []string{"a", "b"}, []string{"a", ""})
+	left.Overflow[1] = 7
+	left.Versions[1] = 3
+	right := NewLeafNode(2, []string{"c"}, []string{"c"})
+
+	// Act
+	key, value, overflow, version := left.RemoveMax()
+	right.AcceptMaxFromLeftChild(key, value, overflow, version)
+
+	// Assert
+	assert.Equal(t, []int64{0}, left.Overflow)
+	assert.Equal(t, []string{"b", "c"}, right.Keys)
+	assert.Equal(t, []int64{7, 0}, right.Overflow)
+	assert.Equal(t, []int64{3, 0}, right.Versions)
+}
+
+func TestSizeInnerNode(t *testing.T) {
+	// Arrange
+	node := &Node{
+		Keys:     []string{"a", "bb"},
+		Children: []int64{1, 2, 3},
+		PageNum:  1,
+		IsLeaf:   false,
+	}
+
+	// Act/Assert
+	assert.Equal(t, InternalHeaderSize+(12+1)+(12+2), node.Size())
+}
+
+func TestSplitIndexLeafNodeBalancesBytes(t *testing.T) {
+	// Arrange
+	node := &Node{
+		Keys:     []string{"a", "b", "c", "d"},
+		Values:   []string{strings.Repeat("a", 100), "b", "c", "d"},
+		Overflow: []int64{0, 0, 0, 0},
+		Versions: []int64{1, 1, 1, 1},
+		PageNum:  1,
+		IsLeaf:   true,
+	}
+
+	// Act
+	idx := node.SplitIndex()
+
+	// Assert
+	assert.Equal(t, 1, idx)
+}
+
+func TestSplitIndexLeafNodeKeepsBothHalvesNonEmpty(t *testing.T) {
+	// Arrange
+	node := &Node{
+		Keys:     []string{"a", "b"},
+		Values:   []string{"a", strings.Repeat("b", 100)},
+		Overflow: []int64{0, 0},
+		Versions: []int64{1, 1},
+		PageNum:  1,
+		IsLeaf:   true,
+	}
+
+	// Act
+	idx := node.SplitIndex()
+
+	// Assert
+	assert.Equal(t, 1, idx)
+}
+
+func TestSplitIndexInnerNode(t *testing.T) {
+	// Arrange
+	node := &Node{
+		Keys:     []string{"a", "b", "c", "d", "e"},
+		Children: []int64{1, 2, 3, 4, 5, 6},
+		PageNum:  1,
+		IsLeaf:   false,
+	}
+
+	// Act
+	idx := node.SplitIndex()
+
+	// Assert
+	assert.Equal(t, 2, idx)
 }
 
 func TestRemoveMaxLeafNode(t *testing.T) {
 	// Arrange
 	node := &Node{
-		Keys:    []string{"a", "b"},
-		Values:  []string{"a", "b"},
-		PageNum: 1,
-		IsLeaf:  true,
+		Keys:     []string{"a", "b"},
+		Values:   []string{"a", "b"},
+		Overflow: []int64{0, 0},
+		Versions: []int64{1, 1},
+		PageNum:  1,
+		IsLeaf:   true,
 	}
 
 	// Act
-	key, value, _ := node.RemoveMax()
+	key, value, _, _ := node.RemoveMax()
 
 	// Assert
 	assert.Equal(t, "b", key)
@@ -182,14 +325,14 @@ func TestRemoveMaxLeafNode(t *testing.T) {
 func TestRemoveMaxInnerNode(t *testing.T) {
 	// Arrange
 	node := &Node{
-		Keys:    []string{"a", "b"},
-		Children:  []int64{1, 2, 3},
-		PageNum: 1,
-		IsLeaf:  false,
+		Keys:     []string{"a", "b"},
+		Children: []int64{1, 2, 3},
+		PageNum:  1,
+		IsLeaf:   false,
 	}
 
 	// Act
-	key, _, child := node.RemoveMax()
+	key, _, child, _ := node.RemoveMax()
 
 	// Assert
 	assert.Equal(t, "b", key)
@@ -201,14 +344,16 @@ func TestRemoveMaxInnerNode(t *testing.T) {
 func TestRemoveMinLeafNode(t *testing.T) {
 	// Arrange
 	node := &Node{
-		Keys:    []string{"a", "b"},
-		Values:  []string{"a", "b"},
-		PageNum: 1,
-		IsLeaf:  true,
+		Keys:     []string{"a", "b"},
+		Values:   []string{"a", "b"},
+		Overflow: []int64{0, 0},
+		Versions: []int64{1, 1},
+		PageNum:  1,
+		IsLeaf:   true,
 	}
 
 	// Act
-	key, value, _ := node.RemoveMin()
+	key, value, _, _ := node.RemoveMin()
 
 	// Assert
 	assert.Equal(t, "a", key)
@@ -220,14 +365,14 @@ func TestRemoveMinLeafNode(t *testing.T) {
 func TestRemoveMinInnerNode(t *testing.T) {
 	// Arrange
 	node := &Node{
-		Keys:    []string{"a", "b"},
-		Children:  []int64{1, 2, 3},
-		PageNum: 1,
-		IsLeaf:  false,
+		Keys:     []string{"a", "b"},
+		Children: []int64{1, 2, 3},
+		PageNum:  1,
+		IsLeaf:   false,
 	}
 
 	// Act
-	key, _, child := node.RemoveMin()
+	key, _, child, _ := node.RemoveMin()
 
 	// Assert
 	assert.Equal(t, "a", key)
@@ -239,14 +384,16 @@ func TestRemoveMinInnerNode(t *testing.T) {
 func TestAcceptMaxFromLeftChildLeafNode(t *testing.T) {
 	// Arrange
 	node := &Node{
-		Keys:    []string{"b", "c"},
-		Values:  []string{"b", "c"},
-		PageNum: 1,
-		IsLeaf:  true,
+		Keys:     []string{"b", "c"},
+		Values:   []string{"b", "c"},
+		Overflow: []int64{0, 0},
+		Versions: []int64{1, 1},
+		PageNum:  1,
+		IsLeaf:   true,
 	}
 
 	// Act
-	node.AcceptMaxFromLeftChild("a", "a", -1)
+	node.AcceptMaxFromLeftChild("a", "a", 0, 1)
 
 	// Assert
 	assert.Equal(t, []string{"a", "b", "c"}, node.Keys)
@@ -256,14 +403,14 @@ func TestAcceptMaxFromLeftChildLeafNode(t *testing.T) {
 func TestAcceptMaxFromLeftChildInnerNode(t *testing.T) {
 	// Arrange
 	node := &Node{
-		Keys:    []string{"b", "c"},
-		Children:  []int64{2, 3, 4},
-		PageNum: 1,
-		IsLeaf:  false,
+		Keys:     []string{"b", "c"},
+		Children: []int64{2, 3, 4},
+		PageNum:  1,
+		IsLeaf:   false,
 	}
 
 	// Act
-	node.AcceptMaxFromLeftChild("a", "", 1)
+	node.AcceptMaxFromLeftChild("a", "", 1, 0)
 
 	// Assert
 	assert.Equal(t, []string{"a", "b", "c"}, node.Keys)
@@ -273,14 +420,16 @@ func TestAcceptMaxFromLeftChildInnerNode(t *testing.T) {
 func TestAcceptMinFromRightChildLeafNode(t *testing.T) {
 	// Arrange
 	node := &Node{
-		Keys:    []string{"a", "b"},
-		Values:  []string{"a", "b"},
-		PageNum: 1,
-		IsLeaf:  true,
+		Keys:     []string{"a", "b"},
+		Values:   []string{"a", "b"},
+		Overflow: []int64{0, 0},
+		Versions: []int64{1, 1},
+		PageNum:  1,
+		IsLeaf:   true,
 	}
 
 	// Act
-	node.AcceptMinFromRightChild("c", "c", -1)
+	node.AcceptMinFromRightChild("c", "c", 0, 1)
 
 	// Assert
 	assert.Equal(t, []string{"a", "b", "c"}, node.Keys)
@@ -290,14 +439,14 @@ func TestAcceptMinFromRightChildLeafNode(t *testing.T) {
 func TestAcceptMinFromRightChildInnerNode(t *testing.T) {
 	// Arrange
 	node := &Node{
-		Keys:    []string{"a", "b"},
-		Children:  []int64{1, 2, 3},
-		PageNum: 1,
-		IsLeaf:  false,
+		Keys:     []string{"a", "b"},
+		Children: []int64{1, 2, 3},
+		PageNum:  1,
+		IsLeaf:   false,
 	}
 
 	// Act
-	node.AcceptMinFromRightChild("c", "", 4)
+	node.AcceptMinFromRightChild("c", "", 4, 0)
 
 	// Assert
 	assert.Equal(t, []string{"a", "b", "c"}, node.Keys)
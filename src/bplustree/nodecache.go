@@ -0,0 +1,73 @@
+package bplustree
+
+// Decoding a page allocates a Node along with slices for its keys and values, which tree traversals would otherwise pay
+// for on every page they read, the root included. The frame holding a page in the buffer pool therefore also holds the
+// node decoded from the latest version of the page, which is decoded the first time the page is read as a node and
+// dropped whenever the page is written. Snapshots reading a page which was not written since they were pinned share that
+// node too, while a version of the page kept for snapshots holds the node decoded from it, see mvcc.go.
+//
+// Decoded nodes are shared by every reader and follow copy-on-write semantics: nodes returned by Get and GetForScan must
+// not be modified, and writers modify the private copy returned by GetForUpdate before writing it back with Set, which
+// replaces the page along with its decoded node. Readers holding on to a node after the page is written keep reading the
+// version they were handed.
+
+// GetForUpdate reads the node stored in the page as a copy which the caller may modify and write back with Set
+func (bpm *BufferPoolManager) GetForUpdate(pageNum int64) (*Node, error) {
+	node, err := bpm.get(pageNum, false)
+	if err != nil {
+		return nil, err
+	}
+	return node.clone(), nil
+}
+
+// get returns the node decoded from the page, decoding the page unless its frame already holds the node
+func (bpm *BufferPoolManager) get(pageNum int64, scan bool) (*Node, error) {
+	f, err := bpm.fetchPage(pageNum, scan)
+	if err != nil {
+		return nil, err
+	}
+	defer bpm.pool.unpin(f)
+	node, data := bpm.pool.decodedNode(f)
+	if node != nil {
+		return node, nil
+	}
+	if node, err = decodeNode(pageNum, data); err != nil {
+		return nil, err
+	}
+	bpm.pool.cacheNode(f, data, node)
+	return node, nil
+}
+
+// decodedNode returns the node decoded from the latest version of the page held by the pinned frame, or nil along with
+// the latest version of the page if it has not been decoded yet
+func (p *bufferPool) decodedNode(f *frame) (*Node, []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return f.node, f.data
+}
+
+// cacheCommittedNode keeps the node decoded from data, a committed version of the page read by a snapshot, in the frame
+// of the page if the frame holds data as its latest version
+func (p *bufferPool) cacheCommittedNode(pageNum int64, data []byte, node *Node) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f, ok := p.frames[pageNum]
+	if !ok || f.uncommitted || f.node != nil || &f.data[0] != &data[0] {
+		return
+	}
+	f.node = node
+	p.account(f)
+	p.evict()
+}
+
+// cacheNode keeps the node decoded from data in the pinned frame, unless the page was written since data was read
+func (p *bufferPool) cacheNode(f *frame, data []byte, node *Node) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if f.node != nil || &f.data[0] != &data[0] {
+		return
+	}
+	f.node = node
+	p.account(f)
+	p.evict()
+}
@@ -0,0 +1,142 @@
+package bplustree
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestGetAllocatesNothingOnCacheHit(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 64, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	keys := seedTree(&bpt, 100)
+	_, _, _ = bpt.Get(keys[0])
+
+	// Act
+	rootAllocs := testing.AllocsPerRun(100, func() {
+		_, _ = bpt.bpm.Get(bpt.bpm.RootPageNum())
+	})
+	getAllocs := testing.AllocsPerRun(100, func() {
+		_, _, _ = bpt.Get(keys[0])
+	})
+
+	// Assert
+	assert.Equal(t, 0.0, rootAllocs)
+	assert.Equal(t, 0.0, getAllocs)
+}
+
+func TestGetForUpdateReturnsCopy(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 64, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	seedTree(&bpt, 100)
+	rootPageNum := bpt.bpm.RootPageNum()
+	shared, err := bpt.bpm.Get(rootPageNum)
+	assert.NoError(t, err)
+	keys := copyStrings(shared.Keys)
+
+	// Act
+	copied, err := bpt.bpm.GetForUpdate(rootPageNum)
+	assert.NoError(t, err)
+	copied.Keys[0] = "modified"
+	copied.DeleteKey(1)
+	sharedAgain, err := bpt.bpm.Get(rootPageNum)
+	assert.NoError(t, err)
+
+	// Assert
+	assert.Same(t, shared, sharedAgain)
+	assert.Equal(t, keys, sharedAgain.Keys)
+}
+
+func TestWritesReplaceDecodedNode(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 64, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	before, err := bpt.bpm.Get(bpt.bpm.RootPageNum())
+	assert.NoError(t, err)
+
+	// Act
+	assert.NoError(t, bpt.Set("key", "value"))
+	after, err := bpt.bpm.Get(bpt.bpm.RootPageNum())
+	assert.NoError(t, err)
+
+	// Assert
+	// readers holding on to a node keep the version they were handed
+	assert.Equal(t, 0, len(before.Keys))
+	assert.Equal(t, []string{"key"}, after.Keys)
+	assert.Greater(t, bpt.CacheStats().NodeBytes, int64(0))
+}
+
+func TestScanDoesNotModifySharedLeaves(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 64, -1)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	value := string(make([]byte, 2*PageSize))
+	assert.NoError(t, bpt.Set("key", value))
+
+	// Act
+	pairs, err := bpt.Scan("", "", 0)
+	assert.NoError(t, err)
+	leaf, err := bpt.bpm.Get(bpt.bpm.RootPageNum())
+	assert.NoError(t, err)
+
+	// Assert
+	assert.Equal(t, value, pairs[0].Value)
+	assert.Equal(t, "", leaf.Values[0])
+}
+
+// BenchmarkGet measures the latency and allocations of reading keys through the decoded nodes held by the buffer pool
+func BenchmarkGet(b *testing.B) {
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 4096, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	keys := seedTree(&bpt, 5000)
+	r := rand.New(rand.NewSource(0))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _, _ = bpt.Get(keys[r.Intn(len(keys))])
+	}
+}
+
+// BenchmarkGetRoot measures the latency and allocations of reading the root node
+func BenchmarkGetRoot(b *testing.B) {
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 64, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	seedTree(&bpt, 5000)
+	rootPageNum := bpt.bpm.RootPageNum()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = bpt.bpm.Get(rootPageNum)
+	}
+}
+
+// BenchmarkDecodeNode measures the latency and allocations of decoding a leaf, which every read paid for before nodes
+// were kept by the buffer pool
+func BenchmarkDecodeNode(b *testing.B) {
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 64, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	keys := seedTree(&bpt, 100)
+	leaf := leafOf(&bpt, keys[0])
+	data, err := bpt.bpm.getPage(leaf.PageNum)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = decodeNode(leaf.PageNum, data)
+	}
+}
@@ -0,0 +1,231 @@
+package bplustree
+
+import (
+	"errors"
+	aol "fios-db/src/log"
+	"fios-db/src/serialization"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Tools such as Check read the files of a db which is not open without modifying them, which rules out opening the db
+// since that recovers the WAL into the db file. An offlineDb instead opens the db file and the WAL read only and
+// replays the WAL in memory: every page is read as recovery would leave it, from the last version committed to the WAL
+// after its last CHECKPOINT frame if there is one, or else from the db file. Like recovery, the WAL is read up to its
+// first corrupt frame.
+
+// An offlineDb reads the pages of a db which is not open, see above
+type offlineDb struct {
+	dbFile   *os.File
+	wal      *WAL             // nil if the db has no WAL
+	fileSize int64            // size of the db file
+	pages    map[int64][]byte // page number to the last version committed to the WAL
+	frames   []walFrame       // every frame read from the WAL
+	walErr   error            // corruption the WAL was read up to, nil if every frame was read
+}
+
+// A walFrame is a frame read from the WAL along with its offset
+type walFrame struct {
+	offset int64
+	frame  *Frame
+}
+
+// openOfflineDb opens the db stored in fileName read only
+func openOfflineDb(fileName string) (*offlineDb, error) {
+	dbFile, err := os.Open(fileName + ".db")
+	if err != nil {
+		return nil, err
+	}
+	fi, err := dbFile.Stat()
+	if err != nil {
+		_ = dbFile.Close()
+		return nil, ioError("stat", dbFile, err)
+	}
+	db := &offlineDb{
+		dbFile:   dbFile,
+		fileSize: fi.Size(),
+		pages:    map[int64][]byte{},
+	}
+
+	segments, err := filepath.Glob(fileName + ".*.store")
+	if err != nil || len(segments) == 0 {
+		return db, err
+	}
+	db.wal = &WAL{
+		log: aol.NewLog(fileName, aol.WithReadOnly()),
+	}
+	if err := db.replayWAL(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// replayWAL reads every frame of the WAL and keeps the last version of every page committed after the last checkpoint
+func (db *offlineDb) replayWAL() error {
+	err := db.wal.scan(db.wal.log.FirstOffset(), db.wal.log.Size(), func(offset int64, frame *Frame, _ []byte) error {
+		db.frames = append(db.frames, walFrame{offset: offset, frame: frame})
+		return nil
+	})
+	if errors.Is(err, ErrCorruption) {
+		db.walErr = err
+	} else if err != nil {
+		return err
+	}
+
+	lastCheckpoint := -1
+	for i, f := range db.frames {
+		if f.frame.FrameType == CHECKPOINT {
+			lastCheckpoint = i
+		}
+	}
+	uncommittedFrames := map[int64][]*Frame{}
+	for i, f := range db.frames {
+		switch f.frame.FrameType {
+		case PUT:
+			uncommittedFrames[f.frame.TxnId] = append(uncommittedFrames[f.frame.TxnId], f.frame)
+		case COMMIT:
+			if i > lastCheckpoint {
+				for _, committedFrame := range uncommittedFrames[f.frame.TxnId] {
+					db.pages[committedFrame.PageNum] = committedFrame.Data
+				}
+			}
+			delete(uncommittedFrames, f.frame.TxnId)
+		case ABORT:
+			delete(uncommittedFrames, f.frame.TxnId)
+		}
+	}
+	return nil
+}
+
+// numPages returns the number of pages of the db once the WAL is recovered
+func (db *offlineDb) numPages() int64 {
+	numPages := db.fileSize / PageSize
+	for pageNum := range db.pages {
+		if pageNum >= numPages {
+			numPages = pageNum + 1
+		}
+	}
+	return numPages
+}
+
+// walPages returns the pages committed to the WAL in ascending order
+func (db *offlineDb) walPages() []int64 {
+	pageNums := make([]int64, 0, len(db.pages))
+	for pageNum := range db.pages {
+		pageNums = append(pageNums, pageNum)
+	}
+	sort.Slice(pageNums, func(i, j int) bool {
+		return pageNums[i] < pageNums[j]
+	})
+	return pageNums
+}
+
+// page reads the page as recovery would leave it. A page which fails its checksum is returned along with the
+// CorruptPageError
+func (db *offlineDb) page(pageNum int64) ([]byte, error) {
+	if data, ok := db.pages[pageNum]; ok {
+		return data, verifyPage(pageNum, data)
+	}
+	data := make([]byte, PageSize)
+	_, err := db.dbFile.ReadAt(data, pageNum*PageSize)
+	if err == io.EOF {
+		return nil, &CorruptPageError{PageNum: pageNum, Reason: "page is past the end of the db file"}
+	} else if err != nil {
+		return nil, ioError("read", db.dbFile, err)
+	}
+	return data, verifyPage(pageNum, data)
+}
+
+// node reads the node stored in the page, checking that its cells lie within the page before decoding it
+func (db *offlineDb) node(pageNum int64) (*Node, error) {
+	data, err := db.page(pageNum)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateNodeLayout(pageNum, data); err != nil {
+		return nil, err
+	}
+	return decodeNode(pageNum, data)
+}
+
+// overflow reads the value stored in the chain of overflow pages starting at pageNum, failing on a chain longer than
+// the db rather than following a cycle forever
+func (db *offlineDb) overflow(pageNum int64) (string, error) {
+	remaining := db.numPages()
+	return readOverflow(pageNum, func(pageNum int64) ([]byte, error) {
+		remaining--
+		if remaining < 0 {
+			return nil, &CorruptPageError{PageNum: pageNum, Reason: "overflow chain loops"}
+		}
+		return db.page(pageNum)
+	})
+}
+
+// metadata returns the root and the start of the free list stored in the metadata page
+func (db *offlineDb) metadata() (int64, int64, error) {
+	data, err := db.page(0)
+	if err != nil {
+		return 0, 0, err
+	}
+	root := serialization.BytesToInt64(data[:PageRefSize])
+	freePageStart := serialization.BytesToInt64(data[PageRefSize : 2*PageRefSize])
+	return root, freePageStart, nil
+}
+
+// Close closes the files of the db
+func (db *offlineDb) Close() error {
+	var err error
+	if db.wal != nil {
+		err = db.wal.log.Close()
+	}
+	if closeErr := db.dbFile.Close(); err == nil && closeErr != nil {
+		err = ioError("close", db.dbFile, closeErr)
+	}
+	return err
+}
+
+// validateNodeLayout returns a CorruptPageError if the slot array or a cell of the node stored in the page runs past the
+// usable bytes of the page, which decodeNode does not check
+func validateNodeLayout(pageNum int64, data []byte) error {
+	pageType := PageType(serialization.BytesToInt16(data[:PageTypeSize]))
+	headerSize := InternalHeaderSize
+	cellHeaderSize := KeyLenSize + PageRefSize
+	if pageType == LEAF {
+		headerSize = LeafHeaderSize
+		cellHeaderSize = KeyLenSize + ValueLenSize + VersionSize
+	} else if pageType != INTERNAL {
+		return &CorruptPageError{PageNum: pageNum, Reason: "page is not a leaf or internal node"}
+	}
+
+	numKeys := int(serialization.BytesToInt16(data[PageTypeSize : PageTypeSize+KeyCountSize]))
+	slotsEnd := headerSize + numKeys*SlotSize
+	if numKeys < 0 || slotsEnd > UsablePageSize {
+		return &CorruptPageError{PageNum: pageNum, Reason: "slot array does not fit within the page"}
+	}
+	for i := 0; i < numKeys; i++ {
+		cellOffset := readSlot(data, headerSize, i)
+		if cellOffset < slotsEnd || cellOffset+cellHeaderSize > UsablePageSize {
+			return &CorruptPageError{PageNum: pageNum, Reason: fmt.Sprintf("cell %d is outside the page", i)}
+		}
+		cell := data[cellOffset:]
+		cellSize := cellHeaderSize + int(serialization.BytesToInt16(cell[:KeyLenSize]))
+		if pageType == LEAF {
+			valueLen := int(serialization.BytesToInt16(cell[KeyLenSize : KeyLenSize+ValueLenSize]))
+			if valueLen == OverflowValueLen {
+				valueLen = PageRefSize
+			}
+			if valueLen < 0 {
+				return &CorruptPageError{PageNum: pageNum, Reason: fmt.Sprintf("cell %d has a negative length", i)}
+			}
+			cellSize += valueLen
+		}
+		if cellSize < cellHeaderSize || cellOffset+cellSize > UsablePageSize {
+			return &CorruptPageError{PageNum: pageNum, Reason: fmt.Sprintf("cell %d runs past the page", i)}
+		}
+	}
+	return nil
+}
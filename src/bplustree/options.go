@@ -0,0 +1,106 @@
+package bplustree
+
+import "time"
+
+// DefaultCheckpointSize is the size in bytes the WAL may grow to before it is checkpointed
+const DefaultCheckpointSize = 16 << 20
+
+// DefaultAsyncFlushInterval is how often the WAL is synced when commits are ASYNC
+const DefaultAsyncFlushInterval = 10 * time.Millisecond
+
+// An Option configures a BPlusTree when it is opened
+type Option func(o *options)
+
+type options struct {
+	checkpointSize     int64
+	checkpointInterval time.Duration
+	durability         Durability
+	groupCommitDelay   time.Duration
+	asyncFlushInterval time.Duration
+
+	cacheBytes               int64
+	backgroundWriterInterval time.Duration
+	newReplacementPolicy     func() ReplacementPolicy
+	scanResistant            bool
+}
+
+func defaultOptions() options {
+	return options{
+		checkpointSize:     DefaultCheckpointSize,
+		durability:         SYNC,
+		asyncFlushInterval: DefaultAsyncFlushInterval,
+
+		backgroundWriterInterval: DefaultBackgroundWriterInterval,
+		newReplacementPolicy:     NewLRUPolicy,
+	}
+}
+
+// WithCheckpointSize checkpoints the WAL once it grows past size bytes. A size <= 0 disables checkpoints triggered by
+// the size of the WAL
+func WithCheckpointSize(size int64) Option {
+	return func(o *options) {
+		o.checkpointSize = size
+	}
+}
+
+// WithCheckpointInterval checkpoints the WAL every interval, on top of checkpoints triggered by the size of the WAL. The
+// tree must be closed to stop checkpointing
+func WithCheckpointInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.checkpointInterval = interval
+	}
+}
+
+// WithDurability sets how durable commits are by the time they return, see durability.go. Commits are SYNC by default
+func WithDurability(d Durability) Option {
+	return func(o *options) {
+		o.durability = d
+	}
+}
+
+// WithGroupCommitDelay makes GROUP commits wait up to maxDelay for more writers to commit before syncing the WAL, see
+// groupcommit.go. GROUP commits sync the WAL straight away by default
+func WithGroupCommitDelay(maxDelay time.Duration) Option {
+	return func(o *options) {
+		o.groupCommitDelay = maxDelay
+	}
+}
+
+// WithAsyncFlushInterval sets how often the WAL is synced when commits are ASYNC. A shorter interval loses fewer commits
+// in a crash at the cost of more syncs
+func WithAsyncFlushInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.asyncFlushInterval = interval
+	}
+}
+
+// WithCacheBytes sizes the buffer pool in bytes of memory instead of the number of pages passed to NewBPlusTree, see
+// memory.go. A size <= 0 sizes the buffer pool in pages
+func WithCacheBytes(size int64) Option {
+	return func(o *options) {
+		o.cacheBytes = size
+	}
+}
+
+// WithBackgroundWriterInterval sets how often dirty pages in the buffer pool are written to the db file, see
+// bufferpool.go. Dirty pages are also written as soon as they keep the buffer pool over capacity
+func WithBackgroundWriterInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.backgroundWriterInterval = interval
+	}
+}
+
+// WithReplacementPolicy picks the pages evicted from the buffer pool with the policy returned by newPolicy, such as
+// NewClockPolicy or New2QPolicy, see replacement.go. The buffer pool evicts the least recently used page by default
+func WithReplacementPolicy(newPolicy func() ReplacementPolicy) Option {
+	return func(o *options) {
+		o.newReplacementPolicy = newPolicy
+	}
+}
+
+// WithScanResistance keeps the pages read by range scans from displacing the working set out of the buffer pool
+func WithScanResistance() Option {
+	return func(o *options) {
+		o.scanResistant = true
+	}
+}
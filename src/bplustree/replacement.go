@@ -0,0 +1,387 @@
+package bplustree
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"time"
+)
+
+// A ReplacementPolicy picks the page the buffer pool evicts once it is over capacity. The buffer pool tells the policy
+// about every page it adds, uses again and drops, and asks it for a victim among the pages which are not in use.
+//
+// Accesses made by range scans are flagged when the tree is opened with scan resistance, see WithScanResistance. Each
+// policy evicts the pages touched only by scans before the pages of the working set: LRU and 2Q queue them as the least
+// recently used pages, CLOCK evicts them before sweeping, and LRU-K does not count scans as references.
+//
+// A policy belongs to a single buffer pool, which calls it with the pool locked, so it need not be safe for concurrent
+// use.
+
+// DefaultLRUK is the number of references LRU-K tracks per page, as in LRU-2
+const DefaultLRUK = 2
+
+// A ReplacementPolicy picks the pages evicted from the buffer pool. Policies are selected with WithReplacementPolicy
+type ReplacementPolicy interface {
+	// Name identifies the policy in CacheStats
+	Name() string
+	// Admit starts tracking a page added to the pool. scan is set if a range scan added the page
+	Admit(pageNum int64, scan bool)
+	// Access records that a page in the pool was used again. scan is set if a range scan used the page
+	Access(pageNum int64, scan bool)
+	// Victim returns the page to evict among the tracked pages evictable returns true for, or false if there is none
+	Victim(evictable func(pageNum int64) bool) (int64, bool)
+	// Remove stops tracking a page dropped from the pool
+	Remove(pageNum int64)
+}
+
+// CacheStats counts how well the buffer pool serves reads with its replacement policy, and how much memory it uses, see
+// memory.go
+type CacheStats struct {
+	Policy    string
+	Hits      int64 // reads of pages which were in the pool
+	Misses    int64 // reads of pages which had to be read from the WAL or the db file
+	Evictions int64
+	WALReads  int64 // misses which were read from the WAL rather than the db file
+
+	Pages         int   // pages in the pool
+	MaxPages      int   // number of pages the pool holds, 0 if it is sized in bytes
+	Bytes         int64 // bytes of memory used by the pool
+	OverflowBytes int64 // bytes of memory used by the overflow pages in the pool
+	NodeBytes     int64 // bytes of memory used by the nodes decoded from the pages in the pool
+	MaxBytes      int64 // bytes of memory the pool may use, 0 if it is sized in pages
+
+	Opened time.Time // when the counters started, which is when the db was opened
+	Time   time.Time // when the stats were taken
+}
+
+// HitRatio returns the fraction of reads served from the pool
+func (s CacheStats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// lruPolicy evicts the least recently used page
+type lruPolicy struct {
+	pages map[int64]*list.Element
+	order *list.List // pages from the most to the least recently used
+}
+
+// NewLRUPolicy returns a policy evicting the least recently used page
+func NewLRUPolicy() ReplacementPolicy {
+	return &lruPolicy{
+		pages: map[int64]*list.Element{},
+		order: list.New(),
+	}
+}
+
+func (p *lruPolicy) Name() string {
+	return "lru"
+}
+
+func (p *lruPolicy) Admit(pageNum int64, scan bool) {
+	if scan {
+		// pages read by a scan are the first to go
+		p.pages[pageNum] = p.order.PushBack(pageNum)
+	} else {
+		p.pages[pageNum] = p.order.PushFront(pageNum)
+	}
+}
+
+func (p *lruPolicy) Access(pageNum int64, scan bool) {
+	if !scan {
+		p.order.MoveToFront(p.pages[pageNum])
+	}
+}
+
+func (p *lruPolicy) Victim(evictable func(pageNum int64) bool) (int64, bool) {
+	for elem := p.order.Back(); elem != nil; elem = elem.Prev() {
+		if pageNum := elem.Value.(int64); evictable(pageNum) {
+			return pageNum, true
+		}
+	}
+	return 0, false
+}
+
+func (p *lruPolicy) Remove(pageNum int64) {
+	p.order.Remove(p.pages[pageNum])
+	delete(p.pages, pageNum)
+}
+
+// clockPolicy approximates LRU by sweeping a hand over the pages, evicting the first page whose reference bit is clear
+// and clearing the bits of the pages it passes
+type clockPolicy struct {
+	pages     map[int64]int // page number to its slot
+	slots     []clockSlot
+	freeSlots []int
+	hand      int
+}
+
+type clockSlot struct {
+	pageNum    int64
+	used       bool
+	referenced bool
+	scanned    bool // whether the page was only read by scans
+}
+
+// NewClockPolicy returns a policy approximating LRU with a clock
+func NewClockPolicy() ReplacementPolicy {
+	return &clockPolicy{
+		pages: map[int64]int{},
+	}
+}
+
+func (p *clockPolicy) Name() string {
+	return "clock"
+}
+
+func (p *clockPolicy) Admit(pageNum int64, scan bool) {
+	slot := clockSlot{
+		pageNum:    pageNum,
+		used:       true,
+		referenced: !scan,
+		scanned:    scan,
+	}
+	if len(p.freeSlots) > 0 {
+		i := p.freeSlots[len(p.freeSlots)-1]
+		p.freeSlots = p.freeSlots[:len(p.freeSlots)-1]
+		p.slots[i] = slot
+		p.pages[pageNum] = i
+		return
+	}
+	p.slots = append(p.slots, slot)
+	p.pages[pageNum] = len(p.slots) - 1
+}
+
+func (p *clockPolicy) Access(pageNum int64, scan bool) {
+	if !scan {
+		p.slots[p.pages[pageNum]].referenced = true
+		p.slots[p.pages[pageNum]].scanned = false
+	}
+}
+
+func (p *clockPolicy) Victim(evictable func(pageNum int64) bool) (int64, bool) {
+	// pages only read by scans go first, without the hand aging the pages of the working set
+	for _, slot := range p.slots {
+		if slot.used && slot.scanned && evictable(slot.pageNum) {
+			return slot.pageNum, true
+		}
+	}
+	// two sweeps clear every reference bit, so a third finds a victim if there is any
+	for i := 0; i < 3*len(p.slots); i++ {
+		slot := &p.slots[p.hand]
+		p.hand = (p.hand + 1) % len(p.slots)
+		if !slot.used || !evictable(slot.pageNum) {
+			continue
+		}
+		if slot.referenced {
+			slot.referenced = false
+			continue
+		}
+		return slot.pageNum, true
+	}
+	return 0, false
+}
+
+func (p *clockPolicy) Remove(pageNum int64) {
+	i := p.pages[pageNum]
+	p.slots[i] = clockSlot{}
+	p.freeSlots = append(p.freeSlots, i)
+	delete(p.pages, pageNum)
+}
+
+// lruKPolicy evicts the page whose K-th most recent reference is the oldest. Pages with fewer than K references are
+// evicted first, least recently used first, so a page must be referenced K times before it competes with the working set.
+// The references of evicted pages are remembered for a while so that a page of the working set evicted once does not
+// start over when it is read again
+type lruKPolicy struct {
+	k             int
+	now           int64
+	history       map[int64][]int64       // page number to the times of its last K references, most recent first
+	retained      map[int64]*list.Element // page number to the references of the page if it was recently evicted
+	retainedOrder *list.List              // references of recently evicted pages, newest first
+}
+
+type retainedHistory struct {
+	pageNum int64
+	history []int64
+}
+
+// NewLRUKPolicy returns a policy evicting the page whose k-th most recent reference is the oldest
+func NewLRUKPolicy(k int) ReplacementPolicy {
+	if k < 1 {
+		k = DefaultLRUK
+	}
+	return &lruKPolicy{
+		k:             k,
+		history:       map[int64][]int64{},
+		retained:      map[int64]*list.Element{},
+		retainedOrder: list.New(),
+	}
+}
+
+func (p *lruKPolicy) Name() string {
+	return fmt.Sprintf("lru-%d", p.k)
+}
+
+func (p *lruKPolicy) Admit(pageNum int64, scan bool) {
+	history := make([]int64, 0, p.k)
+	if elem, ok := p.retained[pageNum]; ok {
+		history = elem.Value.(retainedHistory).history
+		p.retainedOrder.Remove(elem)
+		delete(p.retained, pageNum)
+	}
+	p.history[pageNum] = history
+	p.Access(pageNum, scan)
+}
+
+func (p *lruKPolicy) Access(pageNum int64, scan bool) {
+	p.now++
+	history := p.history[pageNum]
+	if scan && len(history) > 0 {
+		return
+	}
+	if scan {
+		// a page only read by scans looks like it was last referenced before any other page
+		p.history[pageNum] = append(history, 0)
+		return
+	}
+	if len(history) < p.k {
+		history = append(history, 0)
+	}
+	copy(history[1:], history)
+	history[0] = p.now
+	p.history[pageNum] = history
+}
+
+func (p *lruKPolicy) Victim(evictable func(pageNum int64) bool) (int64, bool) {
+	victim, found := int64(0), false
+	victimKth, victimLast := int64(math.MaxInt64), int64(math.MaxInt64)
+	for pageNum, history := range p.history {
+		if !evictable(pageNum) {
+			continue
+		}
+		// the K-th reference of a page with fewer than K references is infinitely far back
+		kth := int64(math.MinInt64)
+		if len(history) == p.k {
+			kth = history[p.k-1]
+		}
+		last := history[0]
+		if kth < victimKth || (kth == victimKth && last < victimLast) {
+			victim, found = pageNum, true
+			victimKth, victimLast = kth, last
+		}
+	}
+	return victim, found
+}
+
+func (p *lruKPolicy) Remove(pageNum int64) {
+	// pages only read by scans are forgotten
+	if history := p.history[pageNum]; history[0] > 0 {
+		p.retained[pageNum] = p.retainedOrder.PushFront(retainedHistory{
+			pageNum: pageNum,
+			history: history,
+		})
+	}
+	delete(p.history, pageNum)
+
+	// as many evicted pages are remembered as there are pages tracked
+	for p.retainedOrder.Len() > len(p.history) {
+		oldest := p.retainedOrder.Back()
+		p.retainedOrder.Remove(oldest)
+		delete(p.retained, oldest.Value.(retainedHistory).pageNum)
+	}
+}
+
+// twoQPolicy admits new pages into a FIFO probationary queue, and only promotes a page to the LRU main queue if it is
+// used again after being evicted from the probationary queue, which a ghost queue of recently evicted pages detects
+type twoQPolicy struct {
+	pages   map[int64]*list.Element
+	inMain  map[int64]bool
+	scanned map[int64]bool // pages admitted by a scan, which are not remembered once evicted
+	a1in    *list.List     // probationary pages, newest first
+	am      *list.List     // promoted pages, most recently used first
+	ghosts  map[int64]*list.Element
+	a1out   *list.List // page numbers recently evicted from a1in, newest first
+}
+
+// New2QPolicy returns a policy evicting pages which were only used once before the pages which were used again
+func New2QPolicy() ReplacementPolicy {
+	return &twoQPolicy{
+		pages:   map[int64]*list.Element{},
+		inMain:  map[int64]bool{},
+		scanned: map[int64]bool{},
+		a1in:    list.New(),
+		am:      list.New(),
+		ghosts:  map[int64]*list.Element{},
+		a1out:   list.New(),
+	}
+}
+
+func (p *twoQPolicy) Name() string {
+	return "2q"
+}
+
+func (p *twoQPolicy) Admit(pageNum int64, scan bool) {
+	if ghost, ok := p.ghosts[pageNum]; ok && !scan {
+		p.a1out.Remove(ghost)
+		delete(p.ghosts, pageNum)
+		p.pages[pageNum] = p.am.PushFront(pageNum)
+		p.inMain[pageNum] = true
+		return
+	}
+	if scan {
+		// pages read by a scan are the first to go
+		p.pages[pageNum] = p.a1in.PushBack(pageNum)
+		p.scanned[pageNum] = true
+	} else {
+		p.pages[pageNum] = p.a1in.PushFront(pageNum)
+	}
+}
+
+func (p *twoQPolicy) Access(pageNum int64, scan bool) {
+	if p.inMain[pageNum] && !scan {
+		p.am.MoveToFront(p.pages[pageNum])
+	}
+	if !scan {
+		delete(p.scanned, pageNum)
+	}
+}
+
+func (p *twoQPolicy) Victim(evictable func(pageNum int64) bool) (int64, bool) {
+	// the probationary queue holds up to a quarter of the pages
+	queues := []*list.List{p.am, p.a1in}
+	if 4*p.a1in.Len() > len(p.pages) {
+		queues = []*list.List{p.a1in, p.am}
+	}
+	for _, queue := range queues {
+		for elem := queue.Back(); elem != nil; elem = elem.Prev() {
+			if pageNum := elem.Value.(int64); evictable(pageNum) {
+				return pageNum, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func (p *twoQPolicy) Remove(pageNum int64) {
+	if p.inMain[pageNum] {
+		p.am.Remove(p.pages[pageNum])
+		delete(p.inMain, pageNum)
+	} else {
+		p.a1in.Remove(p.pages[pageNum])
+		if !p.scanned[pageNum] {
+			p.ghosts[pageNum] = p.a1out.PushFront(pageNum)
+		}
+	}
+	delete(p.pages, pageNum)
+	delete(p.scanned, pageNum)
+
+	// the ghost queue remembers up to half as many pages as are tracked
+	for p.a1out.Len() > 0 && 2*p.a1out.Len() > len(p.pages) {
+		oldest := p.a1out.Back()
+		p.a1out.Remove(oldest)
+		delete(p.ghosts, oldest.Value.(int64))
+	}
+}
@@ -0,0 +1,158 @@
+package bplustree
+
+import (
+	"fios-db/src/serialization"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+var policies = []func() ReplacementPolicy{
+	NewLRUPolicy,
+	NewClockPolicy,
+	func() ReplacementPolicy { return NewLRUKPolicy(DefaultLRUK) },
+	New2QPolicy,
+}
+
+// leafPage returns an empty leaf page
+func leafPage() []byte {
+	data := make([]byte, PageSize)
+	copy(data, serialization.Int16ToBytes(int16(LEAF)))
+	return data
+}
+
+// readPages loads the pages into the pool as if read from disk, or uses them again if they are in the pool
+func readPages(pool *bufferPool, from, to int64, scan bool) {
+	for pageNum := from; pageNum < to; pageNum++ {
+		f := pool.pin(pageNum, scan)
+		if f == nil {
+			f = pool.load(pageNum, leafPage(), scan)
+		}
+		pool.unpin(f)
+	}
+}
+
+func TestPoliciesEvictOnlyEvictablePages(t *testing.T) {
+	for _, newPolicy := range policies {
+		// Arrange
+		pool := newBufferPool(4, 0, newPolicy(), false)
+		pinned := make([]*frame, 0)
+		for pageNum := int64(1); pageNum <= 3; pageNum++ {
+			pinned = append(pinned, pool.load(pageNum, leafPage(), false))
+		}
+
+		// Act
+		readPages(pool, 10, 20, false)
+
+		// Assert
+		for pageNum := int64(1); pageNum <= 3; pageNum++ {
+			f := pool.pin(pageNum, false)
+			assert.NotNil(t, f, pool.policy.Name())
+			pool.unpin(f)
+		}
+		assert.Equal(t, 4, pool.size(), pool.policy.Name())
+		for _, f := range pinned {
+			pool.unpin(f)
+		}
+	}
+}
+
+func TestScanResistancePreservesWorkingSet(t *testing.T) {
+	for _, newPolicy := range policies {
+		// Arrange
+		pool := newBufferPool(16, 0, newPolicy(), true)
+		for i := 0; i < 4; i++ {
+			readPages(pool, 1, 9, false)
+		}
+
+		// Act
+		readPages(pool, 100, 200, true)
+		before := pool.cacheStats()
+		readPages(pool, 1, 9, false)
+		after := pool.cacheStats()
+
+		// Assert
+		assert.Equal(t, int64(8), after.Hits-before.Hits, pool.policy.Name())
+		assert.Equal(t, int64(0), after.Misses-before.Misses, pool.policy.Name())
+	}
+}
+
+func TestScanFlushesWorkingSetWithoutScanResistance(t *testing.T) {
+	// Arrange
+	pool := newBufferPool(16, 0, NewLRUPolicy(), false)
+	readPages(pool, 1, 9, false)
+
+	// Act
+	readPages(pool, 100, 200, true)
+	before := pool.cacheStats()
+	readPages(pool, 1, 9, false)
+	after := pool.cacheStats()
+
+	// Assert
+	assert.Equal(t, int64(8), after.Misses-before.Misses)
+}
+
+func TestCacheStats(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 64, 128, WithReplacementPolicy(NewClockPolicy))
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	keys := seedTree(&bpt, 100)
+	assert.NoError(t, bpt.Checkpoint())
+	before := bpt.CacheStats()
+
+	// Act
+	for _, key := range keys {
+		_, _, _ = bpt.Get(key)
+	}
+	after := bpt.CacheStats()
+
+	// Assert
+	assert.Equal(t, "clock", after.Policy)
+	assert.Greater(t, after.Hits, before.Hits)
+	assert.Greater(t, after.HitRatio(), 0.5)
+}
+
+// BenchmarkReplacementPolicies reports the hit ratio of every policy for point reads of a small working set interleaved
+// with range scans over the whole tree, with and without scan resistance
+func BenchmarkReplacementPolicies(b *testing.B) {
+	for _, scanResistant := range []bool{false, true} {
+		for _, newPolicy := range policies {
+			name := fmt.Sprintf("%s/scanResistant=%t", newPolicy().Name(), scanResistant)
+			b.Run(name, func(b *testing.B) {
+				benchmarkReplacementPolicy(b, newPolicy, scanResistant)
+			})
+		}
+	}
+}
+
+func benchmarkReplacementPolicy(b *testing.B, newPolicy func() ReplacementPolicy, scanResistant bool) {
+	_ = os.Mkdir(TestDir, 0755)
+	opts := []Option{WithReplacementPolicy(newPolicy), WithCheckpointSize(-1)}
+	if scanResistant {
+		opts = append(opts, WithScanResistance())
+	}
+	bpt := NewBPlusTree(TestFile, 64, 256, opts...)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	keys := seedTree(&bpt, 5000)
+	_ = bpt.Checkpoint()
+	r := rand.New(rand.NewSource(0))
+	hits, misses := int64(0), int64(0)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%100 == 0 {
+			_, _ = bpt.Scan("", "", 0)
+		}
+		// a tenth of the keys make up the working set, and only the reads of the working set are counted
+		before := bpt.CacheStats()
+		_, _, _ = bpt.Get(keys[r.Intn(len(keys)/10)])
+		after := bpt.CacheStats()
+		hits += after.Hits - before.Hits
+		misses += after.Misses - before.Misses
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(hits)/float64(hits+misses), "hit-ratio")
+}
@@ -0,0 +1,159 @@
+package bplustree
+
+import "errors"
+
+// ErrSnapshotReleased is returned when reading a snapshot which was released
+var ErrSnapshotReleased = errors.New("snapshot has already been released")
+
+// A Snapshot is a read only view of the tree as of the commit it was taken at. Reads on a snapshot never see writes
+// committed after the snapshot was taken, and they take no latches, so they neither block nor are blocked by writers or
+// transactions. A snapshot keeps the pages it may read alive and must be released once done with, see mvcc.go
+type Snapshot struct {
+	tree     *BPlusTree
+	seq      int64
+	root     int64
+	released bool
+}
+
+// Snapshot returns a snapshot of the tree as of the last commit
+func (t *BPlusTree) Snapshot() *Snapshot {
+	seq, root := t.bpm.PinSnapshot()
+	return &Snapshot{
+		tree: t,
+		seq:  seq,
+		root: root,
+	}
+}
+
+// Seq returns the commit sequence number the snapshot is pinned at
+func (s *Snapshot) Seq() int64 {
+	return s.seq
+}
+
+// Get returns the value of the key as of the snapshot
+func (s *Snapshot) Get(key string) (string, bool, error) {
+	value, _, ok, err := s.GetVersion(key)
+	return value, ok, err
+}
+
+// GetVersion returns the value of the key along with its version as of the snapshot
+func (s *Snapshot) GetVersion(key string) (string, int64, bool, error) {
+	leaf, err := s.findLeaf(atOrAfter(key), false)
+	if err != nil {
+		return "", 0, false, err
+	}
+	i, keyExists := findKeyIndexInLeaf(key, leaf.Keys)
+	if keyExists {
+		value, err := s.value(leaf, i, false)
+		if err != nil {
+			return "", 0, false, err
+		}
+		return value, leaf.Versions[i], true, nil
+	} else {
+		return "", 0, false, nil
+	}
+}
+
+// Iterator returns an iterator over the snapshot positioned at the smallest key, or at the largest key if reverse is set
+func (s *Snapshot) Iterator(reverse bool) *Iterator {
+	return newIterator(s, reverse)
+}
+
+// Scan returns up to limit entries of the snapshot with keys in [start, end) in ascending order, see BPlusTree.Scan
+func (s *Snapshot) Scan(start, end string, limit int) ([]KeyValue, error) {
+	return scan(s, start, end, limit)
+}
+
+// ScanPrefix returns up to limit entries of the snapshot whose keys start with prefix, see BPlusTree.ScanPrefix
+func (s *Snapshot) ScanPrefix(prefix, start string, limit int) ([]KeyValue, error) {
+	return scanPrefix(s, prefix, start, limit)
+}
+
+// Count returns the number of keys of the snapshot in [start, end), see BPlusTree.Count
+func (s *Snapshot) Count(start, end string) (int, error) {
+	return count(s, start, end)
+}
+
+// Release unpins the snapshot, allowing the page versions only it could read to be garbage collected. The snapshot must
+// not be read once released, and reads return ErrSnapshotReleased if it is
+func (s *Snapshot) Release() {
+	if s.released {
+		return
+	}
+	s.released = true
+	s.tree.bpm.UnpinSnapshot(s.seq)
+}
+
+// readLeaf returns the leaf reached by following next from the root as of the snapshot on behalf of a range scan. Unless
+// keysOnly is set, overflow values are read into a copy of the leaf, which is otherwise shared and must not be modified
+func (s *Snapshot) readLeaf(next func(node *Node) int, keysOnly bool) (*Node, error) {
+	leaf, err := s.findLeaf(next, true)
+	if err != nil {
+		return nil, err
+	}
+	return s.readValues(leaf, keysOnly)
+}
+
+// readSibling returns the leaf stored in the page as of the snapshot on behalf of a range scan, like readLeaf. The page
+// is one a leaf of the snapshot points at as its neighbour
+func (s *Snapshot) readSibling(pageNum int64, keysOnly bool) (*Node, error) {
+	if s.released {
+		return nil, ErrSnapshotReleased
+	}
+	leaf, err := s.tree.bpm.GetAt(pageNum, s.seq, true)
+	if err != nil {
+		return nil, err
+	}
+	if !leaf.IsLeaf {
+		return nil, &CorruptPageError{PageNum: pageNum, Reason: "page linked from a neighbouring leaf is not a leaf"}
+	}
+	return s.readValues(leaf, keysOnly)
+}
+
+// readValues returns the leaf with the values stored in overflow pages read into a copy of it, unless keysOnly is set or
+// the leaf has no such value
+func (s *Snapshot) readValues(leaf *Node, keysOnly bool) (*Node, error) {
+	if keysOnly {
+		return leaf, nil
+	}
+	copied := false
+	for i := range leaf.Keys {
+		if leaf.Overflow[i] == 0 {
+			continue
+		}
+		if !copied {
+			leaf, copied = leaf.clone(), true
+		}
+		value, err := s.value(leaf, i, true)
+		if err != nil {
+			return nil, err
+		}
+		leaf.Values[i] = value
+	}
+	return leaf, nil
+}
+
+// findLeaf returns the leaf reached by following next from the root as of the snapshot. Pages are read on behalf of a
+// range scan if scan is set
+func (s *Snapshot) findLeaf(next func(node *Node) int, scan bool) (*Node, error) {
+	if s.released {
+		return nil, ErrSnapshotReleased
+	}
+
+	node, err := s.tree.bpm.GetAt(s.root, s.seq, scan)
+	for err == nil && !node.IsLeaf {
+		node, err = s.tree.bpm.GetAt(node.Children[next(node)], s.seq, scan)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// value returns the value of the entry at index i of the leaf as of the snapshot. scan is set if a range scan reads it
+func (s *Snapshot) value(leaf *Node, i int, scan bool) (string, error) {
+	if leaf.Overflow[i] != 0 {
+		return s.tree.bpm.GetOverflowAt(leaf.Overflow[i], s.seq, scan)
+	}
+	return leaf.Values[i], nil
+}
@@ -0,0 +1,247 @@
+package bplustree
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSnapshotDoesNotSeeLaterWrites(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	keys := seedTree(&bpt, 100)
+	large := strings.Repeat("l", 2*PageSize)
+	_ = bpt.Set("large", large)
+	snapshot := bpt.Snapshot()
+	defer snapshot.Release()
+
+	// Act
+	// the writes split and merge leaves, move the root and release the overflow pages of the large value
+	for i := 0; i < 100; i += 2 {
+		bpt.Delete(keys[i])
+	}
+	for i := 0; i < 200; i++ {
+		_ = bpt.Set(fmt.Sprintf("n%03d", i), "new")
+	}
+	_ = bpt.Set(keys[1], "overwritten")
+	bpt.Delete("large")
+	assert.NoError(t, bpt.Set("o", strings.Repeat("o", 2*PageSize)))
+
+	// Assert
+	bpt.ValidateTreeStructure()
+	for _, key := range keys {
+		value, version, present, _ := snapshot.GetVersion(key)
+		assert.True(t, present)
+		assert.Equal(t, "v"+key, value)
+		assert.Equal(t, int64(1), version)
+	}
+	value, _, _ := snapshot.Get("large")
+	assert.Equal(t, large, value)
+	_, present, _ := snapshot.Get("n000")
+	assert.False(t, present)
+	assert.Equal(t, append(keys, "large"), collect(snapshot.Iterator(false)))
+	assert.Equal(t, 101, countKeys(t, snapshot, "", ""))
+	pairs, err := snapshot.ScanPrefix("k01", "", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []KeyValue{{"k010", "vk010"}, {"k011", "vk011"}}, pairs)
+
+	value, _, _ = bpt.Get(keys[1])
+	assert.Equal(t, "overwritten", value)
+	assert.Equal(t, 251, countKeys(t, &bpt, "", ""))
+}
+
+func TestSnapshotsArePinnedAtTheirCommit(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	snapshots := make([]*Snapshot, 0)
+
+	// Act
+	for i := 0; i < 50; i++ {
+		snapshots = append(snapshots, bpt.Snapshot())
+		_ = bpt.Set(fmt.Sprintf("k%02d", i), fmt.Sprint(i))
+	}
+
+	// Assert
+	for i, snapshot := range snapshots {
+		assert.Equal(t, i, countKeys(t, snapshot, "", ""))
+		_, present, _ := snapshot.Get(fmt.Sprintf("k%02d", i))
+		assert.False(t, present)
+		if i > 0 {
+			assert.Less(t, snapshots[i-1].Seq(), snapshot.Seq())
+			value, _, _ := snapshot.Get(fmt.Sprintf("k%02d", i-1))
+			assert.Equal(t, fmt.Sprint(i-1), value)
+		}
+	}
+}
+
+func TestSnapshotSeesCommittedTxnsOnly(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	_ = bpt.Set("a", "committed")
+	txn := bpt.Begin()
+	_ = txn.Put("a", "uncommitted")
+	for i := 0; i < 50; i++ {
+		_ = txn.Put(fmt.Sprintf("k%02d", i), "uncommitted")
+	}
+
+	// Act
+	// the transaction holds the tree write locked, which must not block reads on a snapshot
+	snapshot := bpt.Snapshot()
+	defer snapshot.Release()
+	value, _, _ := snapshot.Get("a")
+	count := countKeys(t, snapshot, "", "")
+	_ = txn.Commit()
+
+	// Assert
+	assert.Equal(t, "committed", value)
+	assert.Equal(t, 1, count)
+	value, _, _ = snapshot.Get("a")
+	assert.Equal(t, "committed", value)
+	value, _, _ = bpt.Get("a")
+	assert.Equal(t, "uncommitted", value)
+}
+
+func TestReleasedSnapshotVersionsAreGarbageCollected(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	seedTree(&bpt, 50)
+	older := bpt.Snapshot()
+	_ = bpt.Set("k000", "second")
+	newer := bpt.Snapshot()
+	_ = bpt.Set("k000", "third")
+
+	// Act
+	versionsBefore := bpt.bpm.NumPageVersions()
+	older.Release()
+	versionsAfterOlder := bpt.bpm.NumPageVersions()
+	newer.Release()
+	versionsAfterNewer := bpt.bpm.NumPageVersions()
+	_ = bpt.Set("k000", "fourth")
+
+	// Assert
+	assert.Greater(t, versionsBefore, versionsAfterOlder)
+	assert.Greater(t, versionsAfterOlder, 0)
+	assert.Equal(t, 0, versionsAfterNewer)
+	assert.Equal(t, 0, bpt.bpm.NumPageVersions())
+	assert.Equal(t, 0, len(bpt.bpm.pageSeqs))
+}
+
+func TestReadingReleasedSnapshotFails(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	seedTree(&bpt, 50)
+	snapshot := bpt.Snapshot()
+	snapshot.Release()
+
+	// Act
+	_, _, getErr := snapshot.Get("k000")
+	_, scanErr := snapshot.Scan("", "", 0)
+	_, countErr := snapshot.Count("", "")
+	it := snapshot.Iterator(false)
+	defer it.Close()
+
+	// Assert
+	for _, err := range []error{getErr, scanErr, countErr, it.Err()} {
+		assert.True(t, errors.Is(err, ErrSnapshotReleased))
+	}
+	assert.False(t, it.Valid())
+}
+
+func TestMissingPageVersionIsReportedToReader(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	seedTree(&bpt, 50)
+	snapshot := bpt.Snapshot()
+	defer snapshot.Release()
+	_ = bpt.Set("k000", "second")
+	bpt.bpm.versions = map[int64][]pageVersion{}
+
+	// Act
+	_, _, err := snapshot.Get("k000")
+
+	// Assert
+	var corruptPageErr *CorruptPageError
+	assert.True(t, errors.As(err, &corruptPageErr))
+}
+
+func TestSnapshotReadsShareDecodedNodes(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 64, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	seedTree(&bpt, 50)
+	snapshot := bpt.Snapshot()
+	defer snapshot.Release()
+	leaf := leafOf(&bpt, "k000")
+
+	// Act
+	unchanged, err := bpt.bpm.GetAt(leaf.PageNum, snapshot.Seq(), false)
+	assert.NoError(t, err)
+	unchangedAgain, _ := bpt.bpm.GetAt(leaf.PageNum, snapshot.Seq(), false)
+	_ = bpt.Set("k000", "second")
+	retained, err := bpt.bpm.GetAt(leaf.PageNum, snapshot.Seq(), false)
+	assert.NoError(t, err)
+	retainedAgain, _ := bpt.bpm.GetAt(leaf.PageNum, snapshot.Seq(), false)
+
+	// Assert
+	// the node decoded from a page which was not written since is shared with the buffer pool, and the node decoded
+	// from a version kept for the snapshot with later reads of the version
+	assert.Same(t, unchanged, unchangedAgain)
+	assert.Same(t, retained, retainedAgain)
+	assert.Equal(t, unchanged.Values, retained.Values)
+	value, _, err := snapshot.Get("k000")
+	assert.NoError(t, err)
+	assert.Equal(t, "vk000", value)
+}
+
+func TestConcurrentWritersDoNotChangeSnapshot(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 8, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	keys := seedTree(&bpt, 100)
+	snapshot := bpt.Snapshot()
+	defer snapshot.Release()
+	wg := sync.WaitGroup{}
+
+	// Act
+	for threadId := 0; threadId < 4; threadId++ {
+		wg.Add(1)
+		go func(threadId int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				key := fmt.Sprintf("k%03d", (i*4+threadId)%100)
+				if i%2 == 0 {
+					bpt.Delete(key)
+				} else {
+					_ = bpt.Set(key+"x", "new")
+				}
+			}
+		}(threadId)
+	}
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, keys, collect(snapshot.Iterator(false)))
+	}
+	wg.Wait()
+
+	// Assert
+	bpt.ValidateTreeStructure()
+	assert.Equal(t, keys, collect(snapshot.Iterator(false)))
+	assert.Equal(t, 100, countKeys(t, &bpt, "", ""))
+}
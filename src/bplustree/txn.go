@@ -0,0 +1,111 @@
+package bplustree
+
+import "errors"
+
+// ErrTxnClosed is returned when a transaction is used after it has been committed or rolled back
+var ErrTxnClosed = errors.New("transaction has already been committed or rolled back")
+
+// A Txn applies several mutations to the tree atomically. Mutations are applied to the tree as they are made, writing
+// their pages to the WAL, but only become durable once Commit appends a single COMMIT frame covering all of them. The
+// tree is write locked from Begin until the transaction is committed or rolled back, so a transaction must always be
+// finished and should be kept short. A transaction whose write fails part way can only be rolled back: every later
+// operation returns the error of the failed write, and Commit rolls the transaction back instead
+type Txn struct {
+	tree     *BPlusTree
+	writeTxn *WriteTxn
+	closed   bool
+	err      error // error of the write which failed part way, if any
+}
+
+// Begin starts a new transaction
+func (t *BPlusTree) Begin() *Txn {
+	t.txnLock.Lock()
+	writeTxn := t.bpm.BeginWrite()
+	writeTxn.durability = t.durability
+	return &Txn{
+		tree:     t,
+		writeTxn: writeTxn,
+	}
+}
+
+// Get returns the value of the key, including writes made earlier in this transaction
+func (txn *Txn) Get(key string) (string, bool, error) {
+	if txn.closed {
+		return "", false, ErrTxnClosed
+	}
+	if txn.err != nil {
+		return "", false, txn.err
+	}
+	value, _, ok, err := txn.tree.get(key)
+	return value, ok, err
+}
+
+// Put sets the value of the key as part of this transaction
+func (txn *Txn) Put(key, value string) error {
+	if txn.closed {
+		return ErrTxnClosed
+	}
+	if err := txn.tree.checkKey(key); err != nil {
+		return err
+	}
+
+	return txn.write(&writeOp{key: key, value: value})
+}
+
+// Delete removes the key as part of this transaction
+func (txn *Txn) Delete(key string) error {
+	if txn.closed {
+		return ErrTxnClosed
+	}
+
+	return txn.write(&writeOp{key: key, delete: true})
+}
+
+// write applies op as part of this transaction. Since the tree is write locked, latches do not need to be held until
+// the transaction commits
+func (txn *Txn) write(op *writeOp) error {
+	if txn.err != nil {
+		return txn.err
+	}
+	op.txn = txn.writeTxn
+	txn.err = txn.tree.write(op)
+	txn.tree.release(op)
+	return txn.err
+}
+
+// Commit makes every mutation of this transaction durable and releases the tree. If a write of the transaction failed,
+// or the commit itself fails, the transaction is rolled back and the error is returned. An error matching ErrNotDurable
+// is returned instead if the transaction was committed but could not be synced, in which case its mutations are applied
+// and the transaction must not be retried
+func (txn *Txn) Commit() error {
+	if txn.closed {
+		return ErrTxnClosed
+	}
+	if txn.err != nil {
+		_ = txn.Rollback()
+		return txn.err
+	}
+	err := txn.tree.bpm.Commit(txn.writeTxn)
+	if err != nil && !errors.Is(err, ErrNotDurable) {
+		_ = txn.Rollback()
+		return err
+	}
+	txn.closed = true
+
+	txn.tree.txnLock.Unlock()
+	return err
+}
+
+// Rollback discards every mutation of this transaction and releases the tree. Rolling back a transaction which has
+// already been finished does nothing. The mutations are discarded even if an error is returned, which only reports that
+// the rollback could not be recorded on disk
+func (txn *Txn) Rollback() error {
+	if txn.closed {
+		return nil
+	}
+	txn.closed = true
+
+	err := txn.tree.bpm.Rollback(txn.writeTxn)
+	txn.tree.txnLock.Unlock()
+	return err
+}
@@ -0,0 +1,129 @@
+package bplustree
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTxnCommit(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	_ = bpt.Set("deleted", "deleted")
+
+	// Act
+	txn := bpt.Begin()
+	for i := 0; i < 50; i++ {
+		assert.NoError(t, txn.Put(fmt.Sprintf("k%02d", i), fmt.Sprintf("v%02d", i)))
+	}
+	assert.NoError(t, txn.Delete("deleted"))
+	value, present, err := txn.Get("k10")
+	assert.NoError(t, err)
+	assert.True(t, present)
+	assert.Equal(t, "v10", value)
+	assert.NoError(t, txn.Commit())
+
+	// Assert
+	crash(&bpt)
+	// creating a new btree simulates recovering from a crash
+	bpt = NewBPlusTree(TestFile, 1, 128)
+	bpt.ValidateTreeStructure()
+	for i := 0; i < 50; i++ {
+		value, present, _ := bpt.Get(fmt.Sprintf("k%02d", i))
+		assert.True(t, present)
+		assert.Equal(t, fmt.Sprintf("v%02d", i), value)
+	}
+	_, present, _ = bpt.Get("deleted")
+	assert.False(t, present)
+}
+
+func TestTxnRollback(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	for i := 0; i < 20; i++ {
+		_ = bpt.Set(fmt.Sprintf("k%02d", i), "committed")
+	}
+	fi, _ := os.Stat(TestFile + ".db")
+	sizeBefore := fi.Size()
+
+	// Act
+	txn := bpt.Begin()
+	for i := 0; i < 50; i++ {
+		assert.NoError(t, txn.Put(fmt.Sprintf("k%02d", i), "rolled back"))
+	}
+	assert.NoError(t, txn.Put("large", strings.Repeat("l", 4*PageSize)))
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, txn.Delete(fmt.Sprintf("k%02d", i)))
+	}
+	txn.Rollback()
+
+	// Assert
+	fi, _ = os.Stat(TestFile + ".db")
+	assert.Equal(t, sizeBefore, fi.Size())
+	assertCommittedState := func(bpt BPlusTree) {
+		bpt.ValidateTreeStructure()
+		for i := 0; i < 50; i++ {
+			value, present, _ := bpt.Get(fmt.Sprintf("k%02d", i))
+			assert.Equal(t, i < 20, present)
+			if present {
+				assert.Equal(t, "committed", value)
+			}
+		}
+		_, present, _ := bpt.Get("large")
+		assert.False(t, present)
+	}
+	assertCommittedState(bpt)
+
+	// frames of the rolled back transaction must not be recovered even once a later transaction commits
+	assert.NoError(t, bpt.Set("after", "after"))
+	crash(&bpt)
+	bpt = NewBPlusTree(TestFile, 1, 128)
+	assertCommittedState(bpt)
+	value, present, _ := bpt.Get("after")
+	assert.True(t, present)
+	assert.Equal(t, "after", value)
+}
+
+func TestTxnClosed(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	txn := bpt.Begin()
+	assert.NoError(t, txn.Commit())
+
+	// Act/Assert
+	assert.Equal(t, ErrTxnClosed, txn.Put("a", "a"))
+	assert.Equal(t, ErrTxnClosed, txn.Delete("a"))
+	assert.Equal(t, ErrTxnClosed, txn.Commit())
+	_, _, err := txn.Get("a")
+	assert.Equal(t, ErrTxnClosed, err)
+	txn.Rollback()
+
+	// the tree is usable once the transaction is finished
+	assert.NoError(t, bpt.Set("a", "a"))
+}
+
+func TestTxnPutKeyTooLarge(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 1, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	txn := bpt.Begin()
+	assert.NoError(t, txn.Put("a", "a"))
+
+	// Act
+	err := txn.Put(strings.Repeat("k", 96), "b")
+	txn.Rollback()
+
+	// Assert
+	assert.Equal(t, ErrKeyTooLarge, err)
+	_, present, _ := bpt.Get("a")
+	assert.False(t, present)
+}
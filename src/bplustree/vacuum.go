@@ -0,0 +1,402 @@
+package bplustree
+
+import (
+	"errors"
+	"fios-db/src/serialization"
+	"sort"
+)
+
+// Deleting keys returns pages to the free list, but the db file never shrinks on its own. Vacuum compacts the db file
+// while the tree stays online:
+//
+// The pages of the tree and of the overflow chains of its values are live, every other page is free, including pages
+// leaked by aborted transactions which never made it to the free list. Once compacted, the db file holds the metadata
+// page followed by the live pages, so every live page past that point is relocated to a free page before it. Pages are
+// relocated in batches, each of which is a transaction of its own holding the tree exclusively, so reads and writes run
+// in between batches. A batch copies every relocated page to its new page, rewrites the pages referring to it, be it the
+// parent of a node, the leaves next to a leaf, the leaf holding a value or the overflow page preceding another in its
+// chain, updates the root in the metadata page if the root moved, and rewrites the free list in ascending order so that
+// pages are reused from the front of the db file.
+//
+// Once no live page is left past the live pages, the free pages at the end of the db file are removed from the free
+// list, the WAL is checkpointed so that recovering it never writes to those pages again, and the db file is truncated.
+// Snapshots stay readable throughout, since relocating a page overwrites its old page like any other write, see mvcc.go.
+
+// vacuumBatchSize is the number of pages relocated by each transaction of a vacuum
+const vacuumBatchSize = 64
+
+// VacuumProgress reports how far a vacuum has come
+type VacuumProgress struct {
+	PagesBefore int64 // pages in the db file when the vacuum started
+	Moved       int   // pages relocated so far
+	Remaining   int   // pages left to relocate as of the last batch
+	Reclaimed   int   // leaked pages returned to the free list so far
+	PagesAfter  int64 // pages in the db file once truncated, 0 until the vacuum is done
+	Done        bool
+}
+
+// pageInfo locates a live page within the tree
+type pageInfo struct {
+	pageType PageType
+	referrer int64 // page referring to the page, 0 for the root
+	prev     int64 // leaf preceding a leaf, 0 if there is none
+	next     int64 // leaf following a leaf, 0 if there is none
+}
+
+// Vacuum relocates live pages toward the front of the db file and truncates the free pages at its end. progress is
+// called after every batch of relocated pages and once the db file is truncated, unless it is nil. A vacuum which fails
+// part way leaves a consistent tree behind, with the pages relocated so far, and may simply be run again
+func (t *BPlusTree) Vacuum(progress func(VacuumProgress)) (VacuumProgress, error) {
+	p := VacuumProgress{
+		PagesBefore: t.bpm.numPages(),
+	}
+	report := func() {
+		if progress != nil {
+			progress(p)
+		}
+	}
+	for {
+		moved, remaining, reclaimed, err := t.vacuumBatch()
+		p.Moved += moved
+		p.Reclaimed += reclaimed
+		if err != nil {
+			return p, err
+		}
+		p.Remaining = remaining
+		if moved == 0 {
+			break
+		}
+		report()
+	}
+	pages, err := t.truncateFreePages()
+	if err != nil {
+		return p, err
+	}
+	p.PagesAfter = pages
+	p.Done = true
+	report()
+	return p, nil
+}
+
+// vacuumBatch relocates up to vacuumBatchSize live pages past the live pages of the db file to free pages before them.
+// Returns the number of pages relocated, the number of pages left to relocate and the number of leaked pages returned
+// to the free list
+func (t *BPlusTree) vacuumBatch() (int, int, int, error) {
+	t.txnLock.Lock()
+	defer t.txnLock.Unlock()
+
+	live, err := t.livePages()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	freeList, err := t.bpm.freeList()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	numPages := t.bpm.numPages()
+	end := int64(len(live)) + 1
+
+	// the last live pages are moved to the first free pages
+	sources := make([]int64, 0)
+	for pageNum := numPages - 1; pageNum >= end; pageNum-- {
+		if _, ok := live[pageNum]; ok {
+			sources = append(sources, pageNum)
+		}
+	}
+	remaining := len(sources)
+	if len(sources) > vacuumBatchSize {
+		sources = sources[:vacuumBatchSize]
+	}
+	moves := map[int64]int64{}
+	targets := map[int64]bool{}
+	for pageNum := int64(1); pageNum < end && len(moves) < len(sources); pageNum++ {
+		if _, ok := live[pageNum]; !ok {
+			moves[sources[len(moves)]] = pageNum
+			targets[pageNum] = true
+		}
+	}
+
+	txn := t.bpm.BeginWrite()
+	if err := t.relocate(txn, live, moves); err != nil {
+		_ = t.bpm.Rollback(txn)
+		return 0, 0, 0, err
+	}
+	free := make([]int64, 0)
+	for pageNum := int64(1); pageNum < numPages; pageNum++ {
+		_, isLive := live[pageNum]
+		_, isSource := moves[pageNum]
+		if (!isLive || isSource) && !targets[pageNum] {
+			free = append(free, pageNum)
+		}
+	}
+	if err := t.bpm.setFreeList(txn, free, freeList); err != nil {
+		_ = t.bpm.Rollback(txn)
+		return 0, 0, 0, err
+	}
+	err = t.bpm.Commit(txn)
+	if err != nil && !errors.Is(err, ErrNotDurable) {
+		_ = t.bpm.Rollback(txn)
+		return 0, 0, 0, err
+	}
+	// a batch which was committed but not synced is counted before the vacuum stops
+	reclaimed := int(numPages) - 1 - len(live) - len(freeList)
+	return len(moves), remaining - len(moves), reclaimed, err
+}
+
+// relocate copies every page in moves to its new page as part of txn, and rewrites every page referring to a relocated
+// page
+func (t *BPlusTree) relocate(txn *WriteTxn, live map[int64]pageInfo, moves map[int64]int64) error {
+	remap := func(pageNum int64) int64 {
+		if to, ok := moves[pageNum]; ok {
+			return to
+		}
+		return pageNum
+	}
+
+	rewrite := map[int64]bool{}
+	for pageNum := range moves {
+		info := live[pageNum]
+		rewrite[pageNum] = true
+		if info.referrer != 0 {
+			rewrite[info.referrer] = true
+		} else {
+			t.bpm.SetRoot(txn, remap(pageNum))
+		}
+		if info.prev != 0 {
+			rewrite[info.prev] = true
+		}
+		if info.next != 0 {
+			rewrite[info.next] = true
+		}
+	}
+
+	pageNums := make([]int64, 0, len(rewrite))
+	for pageNum := range rewrite {
+		pageNums = append(pageNums, pageNum)
+	}
+	sort.Slice(pageNums, func(i, j int) bool { return pageNums[i] < pageNums[j] })
+	for _, pageNum := range pageNums {
+		if live[pageNum].pageType == OVERFLOW {
+			if err := t.bpm.moveOverflowPage(txn, pageNum, remap); err != nil {
+				return err
+			}
+			continue
+		}
+		node, err := t.bpm.GetForUpdate(pageNum)
+		if err != nil {
+			return err
+		}
+		node.PageNum = remap(node.PageNum)
+		node.Prev = remap(node.Prev)
+		node.Next = remap(node.Next)
+		for i := range node.Children {
+			node.Children[i] = remap(node.Children[i])
+		}
+		for i := range node.Overflow {
+			node.Overflow[i] = remap(node.Overflow[i])
+		}
+		if err := t.bpm.Set(txn, node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// truncateFreePages removes the free pages at the end of the db file from the free list and truncates the db file.
+// Returns the number of pages left in the db file
+func (t *BPlusTree) truncateFreePages() (int64, error) {
+	t.backupLock.Lock()
+	defer t.backupLock.Unlock()
+	t.txnLock.Lock()
+	defer t.txnLock.Unlock()
+
+	live, err := t.livePages()
+	if err != nil {
+		return 0, err
+	}
+	freeList, err := t.bpm.freeList()
+	if err != nil {
+		return 0, err
+	}
+	end := int64(1)
+	for pageNum := range live {
+		if pageNum >= end {
+			end = pageNum + 1
+		}
+	}
+	free := make([]int64, 0)
+	for pageNum := int64(1); pageNum < end; pageNum++ {
+		if _, ok := live[pageNum]; !ok {
+			free = append(free, pageNum)
+		}
+	}
+
+	txn := t.bpm.BeginWrite()
+	if err := t.bpm.setFreeList(txn, free, freeList); err != nil {
+		_ = t.bpm.Rollback(txn)
+		return 0, err
+	}
+	if err := t.bpm.Commit(txn); err != nil {
+		// a free list which was committed but not synced is kept, but the db file is not truncated
+		if !errors.Is(err, ErrNotDurable) {
+			_ = t.bpm.Rollback(txn)
+		}
+		return 0, err
+	}
+	if err := t.bpm.shrink(end); err != nil {
+		return 0, err
+	}
+	return t.bpm.numPages(), nil
+}
+
+// livePages walks the tree and returns every page of the tree or of the overflow chain of one of its values. The tree
+// must be held exclusively
+func (t *BPlusTree) livePages() (map[int64]pageInfo, error) {
+	live := map[int64]pageInfo{}
+	pending := []int64{t.bpm.RootPageNum()}
+	live[pending[0]] = pageInfo{}
+	for len(pending) > 0 {
+		pageNum := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		node, err := t.bpm.GetForScan(pageNum)
+		if err != nil {
+			return nil, err
+		}
+		info := live[pageNum]
+		if !node.IsLeaf {
+			info.pageType = INTERNAL
+			live[pageNum] = info
+			for _, child := range node.Children {
+				live[child] = pageInfo{referrer: pageNum}
+				pending = append(pending, child)
+			}
+			continue
+		}
+		info.pageType = LEAF
+		info.prev, info.next = node.Prev, node.Next
+		live[pageNum] = info
+		for _, overflowPageNum := range node.Overflow {
+			referrer := pageNum
+			for overflowPageNum > 0 {
+				live[overflowPageNum] = pageInfo{
+					pageType: OVERFLOW,
+					referrer: referrer,
+				}
+				pageBytes, err := getOverflowPage(overflowPageNum, func(pageNum int64) ([]byte, error) {
+					return t.bpm.getPageForScan(pageNum, true)
+				})
+				if err != nil {
+					return nil, err
+				}
+				referrer = overflowPageNum
+				overflowPageNum = serialization.BytesToInt64(pageBytes[PageTypeSize : PageTypeSize+PageRefSize])
+			}
+		}
+	}
+	return live, nil
+}
+
+// numPages returns the number of pages in the db file, counting a page the db file was partially extended by
+func (bpm *BufferPoolManager) numPages() int64 {
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+	return (bpm.size + PageSize - 1) / PageSize
+}
+
+// freeList returns the pages on the free list in the order they are reused
+func (bpm *BufferPoolManager) freeList() ([]int64, error) {
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+	pages := make([]int64, 0)
+	numPages := (bpm.size + PageSize - 1) / PageSize
+	for pageNum := bpm.freePageStart; pageNum > 0; {
+		if int64(len(pages)) >= numPages {
+			return nil, &CorruptPageError{PageNum: pageNum, Reason: "free list has a cycle"}
+		}
+		pageBytes, err := bpm.latestPage(pageNum)
+		if err != nil {
+			return nil, err
+		}
+		if PageType(serialization.BytesToInt16(pageBytes[:PageTypeSize])) != FREE {
+			return nil, &CorruptPageError{PageNum: pageNum, Reason: "page on the free list is not free"}
+		}
+		pages = append(pages, pageNum)
+		pageNum = serialization.BytesToInt64(pageBytes[PageTypeSize : PageTypeSize+PageRefSize])
+	}
+	return pages, nil
+}
+
+// setFreeList makes pages the free list as part of txn, in the order they are reused. Only the pages which are not
+// followed by the same page on the current free list are written
+func (bpm *BufferPoolManager) setFreeList(txn *WriteTxn, pages []int64, current []int64) error {
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+	currentNext := map[int64]int64{}
+	for i, pageNum := range current {
+		currentNext[pageNum] = -1
+		if i < len(current)-1 {
+			currentNext[pageNum] = current[i+1]
+		}
+	}
+	for i, pageNum := range pages {
+		next := int64(-1)
+		if i < len(pages)-1 {
+			next = pages[i+1]
+		}
+		if prevNext, ok := currentNext[pageNum]; ok && prevNext == next {
+			continue
+		}
+		pageBytes := make([]byte, PageSize)
+		copy(pageBytes, serialization.Int16ToBytes(int16(FREE)))
+		copy(pageBytes[PageTypeSize:], serialization.Int64ToBytes(next))
+		if err := bpm.setPage(txn, pageNum, pageBytes); err != nil {
+			return err
+		}
+	}
+	bpm.freePageStart = -1
+	if len(pages) > 0 {
+		bpm.freePageStart = pages[0]
+	}
+	txn.dirty = true
+	return nil
+}
+
+// moveOverflowPage copies the overflow page to the page remap returns for it as part of txn, pointing it at the page
+// remap returns for the next page of its chain
+func (bpm *BufferPoolManager) moveOverflowPage(txn *WriteTxn, pageNum int64, remap func(pageNum int64) int64) error {
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+	pageBytes, err := getOverflowPage(pageNum, bpm.latestPage)
+	if err != nil {
+		return err
+	}
+	data := make([]byte, PageSize)
+	copy(data, pageBytes)
+	next := serialization.BytesToInt64(pageBytes[PageTypeSize : PageTypeSize+PageRefSize])
+	copy(data[PageTypeSize:], serialization.Int64ToBytes(remap(next)))
+	return bpm.setPage(txn, remap(pageNum), data)
+}
+
+// shrink truncates the db file to numPages pages, none of which may be live or on the free list. The WAL is
+// checkpointed first so that recovering it never writes past the end of the db file
+func (bpm *BufferPoolManager) shrink(numPages int64) error {
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+	size := numPages * PageSize
+	if size >= bpm.size {
+		return nil
+	}
+	if err := bpm.checkpoint(); err != nil {
+		return err
+	}
+	if err := bpm.dbFile.Truncate(size); err != nil {
+		return ioError("truncate", bpm.dbFile, err)
+	}
+	if err := bpm.syncDbFile(); err != nil {
+		return err
+	}
+	bpm.size = size
+	bpm.committedSize = size
+	bpm.pool.drop(numPages)
+	return nil
+}
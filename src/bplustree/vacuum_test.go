@@ -0,0 +1,174 @@
+package bplustree
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// dbSize returns the size of the db file
+func dbSize(t *testing.T) int64 {
+	fi, err := os.Stat(TestFile + ".db")
+	assert.NoError(t, err)
+	return fi.Size()
+}
+
+// deleteMost deletes every key but one in ten, returning the keys which are kept
+func deleteMost(t *testing.T, bpt *BPlusTree, keys []string) []string {
+	kept := make([]string, 0)
+	for i, key := range keys {
+		if i%10 == 0 {
+			kept = append(kept, key)
+			continue
+		}
+		assert.NoError(t, bpt.Delete(key))
+	}
+	return kept
+}
+
+func TestVacuumShrinksDbFile(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 16, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	keys := seedTree(&bpt, 500)
+	kept := deleteMost(t, &bpt, keys)
+	assert.NoError(t, bpt.Checkpoint())
+	sizeBefore := dbSize(t)
+
+	// Act
+	progress, err := bpt.Vacuum(nil)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.True(t, progress.Done)
+	assert.Greater(t, progress.Moved, 0)
+	assert.Equal(t, 0, progress.Remaining)
+	assert.Equal(t, progress.PagesAfter*PageSize, dbSize(t))
+	assert.Less(t, dbSize(t), sizeBefore)
+	live, err := bpt.livePages()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(live))+1, progress.PagesAfter)
+	bpt.ValidateTreeStructure()
+	for _, key := range kept {
+		value, present, err := bpt.Get(key)
+		assert.NoError(t, err)
+		assert.True(t, present)
+		assert.Equal(t, "v"+key, value)
+	}
+	assert.Equal(t, len(kept), countKeys(t, &bpt, "", ""))
+
+	// the relocated pages are recovered once the db is reopened
+	crash(&bpt)
+	bpt = NewBPlusTree(TestFile, 16, 128)
+	bpt.ValidateTreeStructure()
+	assert.Equal(t, len(kept), countKeys(t, &bpt, "", ""))
+	assert.Equal(t, progress.PagesAfter*PageSize, dbSize(t))
+}
+
+func TestVacuumRelocatesOverflowPages(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 16, -1)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	keys := make([]string, 0)
+	for i := 0; i < 40; i++ {
+		key := fmt.Sprintf("k%02d", i)
+		keys = append(keys, key)
+		assert.NoError(t, bpt.Set(key, strings.Repeat(key, PageSize)))
+	}
+	kept := deleteMost(t, &bpt, keys)
+
+	// Act
+	progress, err := bpt.Vacuum(nil)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Greater(t, progress.Moved, 0)
+	for _, key := range kept {
+		value, present, err := bpt.Get(key)
+		assert.NoError(t, err)
+		assert.True(t, present)
+		assert.Equal(t, strings.Repeat(key, PageSize), value)
+	}
+	pairs, err := bpt.Scan("", "", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, len(kept), len(pairs))
+}
+
+func TestVacuumReportsProgress(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 16, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	keys := seedTree(&bpt, 1000)
+	deleteMost(t, &bpt, keys)
+	reports := make([]VacuumProgress, 0)
+
+	// Act
+	progress, err := bpt.Vacuum(func(p VacuumProgress) {
+		reports = append(reports, p)
+	})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Greater(t, len(reports), 2)
+	for i := 1; i < len(reports); i++ {
+		assert.GreaterOrEqual(t, reports[i].Moved, reports[i-1].Moved)
+	}
+	assert.False(t, reports[0].Done)
+	assert.Equal(t, progress, reports[len(reports)-1])
+}
+
+func TestVacuumKeepsSnapshotsReadable(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 16, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	keys := seedTree(&bpt, 300)
+	kept := deleteMost(t, &bpt, keys)
+	snapshot := bpt.Snapshot()
+	defer snapshot.Release()
+
+	// Act
+	_, err := bpt.Vacuum(nil)
+
+	// Assert
+	assert.NoError(t, err)
+	pairs, err := snapshot.Scan("", "", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, len(kept), len(pairs))
+}
+
+func TestVacuumWithConcurrentWriters(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	bpt := NewBPlusTree(TestFile, 16, 128)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	keys := seedTree(&bpt, 500)
+	deleteMost(t, &bpt, keys)
+	wg := sync.WaitGroup{}
+
+	// Act
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				assert.NoError(t, bpt.Set(fmt.Sprintf("w%d-%03d", w, i), "value"))
+			}
+		}(w)
+	}
+	_, err := bpt.Vacuum(nil)
+	wg.Wait()
+
+	// Assert
+	assert.NoError(t, err)
+	bpt.ValidateTreeStructure()
+	for w := 0; w < 4; w++ {
+		assert.Equal(t, 100, countKeys(t, &bpt, fmt.Sprintf("w%d-", w), fmt.Sprintf("w%d.", w)))
+	}
+}
@@ -1,141 +1,386 @@
 package bplustree
 
 import (
+	"errors"
+	"fmt"
 	aol "fios-db/src/log"
 	"fios-db/src/serialization"
 	"log"
+	"sync/atomic"
+	"time"
 )
 
-// Commit frame structure
+// Frames are tagged with the id of the transaction which wrote them so that writers running concurrently can commit
+// or abort independently of each other
+
+// Commit, abort and checkpoint frame structure
 // +--------------------------------+
 // + frameType (2 bytes)            +
-// +                                +
+// + txnId (8 bytes)                +
+// + checksum (4 bytes)             +
 // +--------------------------------+
 
 // Put frame structure
 // +--------------------------------+
 // + frameType (2 bytes)            +
+// + txnId (8 bytes)                +
+// + pageNum (8 bytes)              +
 // + pageData (4096 bytes)          +
-// +                                +
+// + checksum (4 bytes)             +
 // +--------------------------------+
 
 type FrameType int16
 
+// COMMIT makes every frame of its transaction durable
 const COMMIT FrameType = 1
 const PUT FrameType = 2
 
+// ABORT discards every frame of its transaction
+const ABORT FrameType = 3
+
+// CHECKPOINT marks that every transaction committed before it has been written to the db file
+const CHECKPOINT FrameType = 4
+
+func (frameType FrameType) String() string {
+	switch frameType {
+	case COMMIT:
+		return "COMMIT"
+	case PUT:
+		return "PUT"
+	case ABORT:
+		return "ABORT"
+	case CHECKPOINT:
+		return "CHECKPOINT"
+	}
+	return fmt.Sprintf("FrameType(%d)", int16(frameType))
+}
+
 type Frame struct {
 	FrameType FrameType
+	TxnId     int64
 	PageNum   int64
 	Data      []byte
 }
 
 // A WAL implements a wrapper around a basic log. Rather than appending raw bytes, a client
 // appends Frame objects. Rather than reading at an index in the log, a client retrieves
-// page data given a page number.
+// page data given a page number. A WAL is not safe for concurrent use
 type WAL struct {
 	log             *aol.Log
-	committedTxns   map[int64]int64 // PageNum to Data offset in WAL
-	uncommittedTxns map[int64]int64 // PageNum to Data offset in WAL
+	latestFrames    map[int64]int64           // PageNum to offset of the latest frame of the page, committed or not
+	committedTxns   map[int64]int64           // PageNum to Data offset in WAL
+	uncommittedTxns map[int64]map[int64]int64 // TxnId to PageNum to Data offset in WAL
+	lastTxnId       int64                     // largest transaction id found in the WAL
+	frameReads      int64                     // number of frames read back from the WAL since it was opened, accessed atomically
+	groupCommit     *groupCommitter           // syncs the COMMIT frames of GROUP and ASYNC commits
 }
 
-func NewWAL(fileName string) *WAL {
+// NewWAL opens the WAL stored in fileName. GROUP commits wait up to groupCommitDelay for more commits before syncing
+// the WAL, while the frames of ASYNC commits are synced every asyncFlushInterval
+func NewWAL(fileName string, groupCommitDelay time.Duration, asyncFlushInterval time.Duration) *WAL {
 	l := aol.NewLog(fileName)
 
 	return &WAL{
 		log:             l,
+		latestFrames:    map[int64]int64{},
 		committedTxns:   map[int64]int64{},
-		uncommittedTxns: map[int64]int64{},
+		uncommittedTxns: map[int64]map[int64]int64{},
+		groupCommit:     newGroupCommitter(groupCommitDelay, asyncFlushInterval, l.Size, l.Flush),
+	}
+}
+
+// NextTxnId returns an id which has not been used by any transaction in the WAL
+func (wal *WAL) NextTxnId() int64 {
+	wal.lastTxnId++
+	return wal.lastTxnId
+}
+
+// Append adds the given frame to the end of the WAL. Returns an IOError if the frame could not be written, in which
+// case a COMMIT frame leaves its transaction uncommitted. COMMIT frames are appended through Commit. The frames of a
+// transaction are discarded by an ABORT frame even if the ABORT frame itself could not be written, since recovery
+// discards frames which were never committed
+func (wal *WAL) Append(frame Frame) error {
+	return wal.append(frame)
+}
+
+// Commit appends the COMMIT frame of the transaction, which commits the transaction, and returns the offset following
+// it. The frame is left to be synced through Flush, Sync or SyncLater. Since recovery replays every transaction whose
+// COMMIT frame it finds, the transaction must be treated as committed once Commit succeeds, even if the frame then fails
+// to be synced
+func (wal *WAL) Commit(txnId int64) (int64, error) {
+	err := wal.append(Frame{
+		FrameType: COMMIT,
+		TxnId:     txnId,
+	})
+	if err != nil {
+		return 0, err
 	}
+	return wal.log.Size(), nil
 }
 
-// Append adds the given frame to the end of the WAL
-func (wal *WAL) Append(frame Frame) {
-	offset := wal.log.Append(wal.serializeFrame(&frame))
+// Flush syncs every frame appended so far right away, without waiting for concurrent commits to share the sync
+func (wal *WAL) Flush() error {
+	return wal.groupCommit.syncAll()
+}
 
-	if frame.FrameType == COMMIT {
-		// add all uncommitted transactions to the committed transactions
-		for pageNum, off := range wal.uncommittedTxns {
+// append adds the given frame to the end of the WAL
+func (wal *WAL) append(frame Frame) error {
+	if frame.FrameType == ABORT {
+		// pages written by the transaction revert to their last committed version
+		for pageNum, off := range wal.uncommittedTxns[frame.TxnId] {
+			if wal.latestFrames[pageNum] != off {
+				continue
+			}
+			if committedOffset, ok := wal.committedTxns[pageNum]; ok {
+				wal.latestFrames[pageNum] = committedOffset
+			} else {
+				delete(wal.latestFrames, pageNum)
+			}
+		}
+		delete(wal.uncommittedTxns, frame.TxnId)
+	}
+
+	offset, err := wal.log.Append(wal.serializeFrame(&frame))
+	if err != nil {
+		return err
+	}
+
+	if frame.FrameType == CHECKPOINT {
+		return wal.log.Flush()
+	} else if frame.FrameType == COMMIT {
+		// add the pages of the transaction to the committed pages
+		for pageNum, off := range wal.uncommittedTxns[frame.TxnId] {
 			wal.committedTxns[pageNum] = off
 		}
-		wal.uncommittedTxns = map[int64]int64{}
-		// flush contents to stable storage
-		wal.log.Flush()
-	} else {
-		// add this to the uncommitted transactions
-		wal.uncommittedTxns[frame.PageNum] = offset
+		delete(wal.uncommittedTxns, frame.TxnId)
+	} else if frame.FrameType == PUT {
+		// add this to the uncommitted pages of the transaction
+		if _, ok := wal.uncommittedTxns[frame.TxnId]; !ok {
+			wal.uncommittedTxns[frame.TxnId] = map[int64]int64{}
+		}
+		wal.uncommittedTxns[frame.TxnId][frame.PageNum] = offset
+		wal.latestFrames[frame.PageNum] = offset
 	}
+	return nil
+}
+
+// Sync returns once every frame before end is durable. Frames are synced in batches shared by concurrent callers, see
+// groupcommit.go. Unlike the rest of the WAL, Sync is safe for concurrent use
+func (wal *WAL) Sync(end int64) error {
+	return wal.groupCommit.wait(end)
 }
 
-// Read reads the page with the given pageNum out of the WAL
-func (wal *WAL) Read(pageNum int64) ([]byte, bool) {
-	if offset, ok := wal.uncommittedTxns[pageNum]; ok {
-		frameBytes, _:= wal.log.Read(offset)
-		return wal.deserializeFrame(frameBytes).Data, true
+// SyncLater makes sure every frame before end is synced within the async flush interval, without waiting for it
+func (wal *WAL) SyncLater(end int64) {
+	wal.groupCommit.syncLater(end)
+}
+
+// UncommittedPages returns the pages written by the transaction which have not been committed yet
+func (wal *WAL) UncommittedPages(txnId int64) []int64 {
+	pageNums := make([]int64, 0, len(wal.uncommittedTxns[txnId]))
+	for pageNum := range wal.uncommittedTxns[txnId] {
+		pageNums = append(pageNums, pageNum)
 	}
+	return pageNums
+}
 
-	if offset, ok := wal.committedTxns[pageNum]; ok {
-		frameBytes, _ := wal.log.Read(offset)
-		return wal.deserializeFrame(frameBytes).Data, true
+// Read reads the latest version of the page with the given pageNum out of the WAL, including versions written by
+// transactions which have not committed yet
+func (wal *WAL) Read(pageNum int64) ([]byte, bool, error) {
+	if offset, ok := wal.latestFrames[pageNum]; ok {
+		frame, err := wal.readFrame(offset)
+		if err != nil {
+			return nil, false, err
+		}
+		return frame.Data, true, nil
 	}
 
-	return nil, false
+	return nil, false, nil
+}
+
+// CommittedOffset returns the offset of the frame holding the last committed version of the page with the given
+// pageNum, if the WAL holds it
+func (wal *WAL) CommittedOffset(pageNum int64) (int64, bool) {
+	offset, ok := wal.committedTxns[pageNum]
+	return offset, ok
+}
+
+// ReadAt reads the page held by the frame at offset. Unlike the other methods of the WAL, ReadAt may run concurrently
+// with any of them, and returns an error wrapping ErrNotFound if the frame was truncated in between
+func (wal *WAL) ReadAt(offset int64) ([]byte, error) {
+	frame, err := wal.readFrame(offset)
+	if err != nil {
+		return nil, err
+	}
+	return frame.Data, nil
+}
+
+func (wal *WAL) readFrame(offset int64) (*Frame, error) {
+	atomic.AddInt64(&wal.frameReads, 1)
+	frameBytes, err := wal.log.Read(offset)
+	if err != nil {
+		return nil, err
+	}
+	return wal.deserializeFrame(offset, frameBytes)
+}
+
+// Forget drops the last committed version of the page once it was written to the db file, which the page is read from
+// from then on. A version of the page written by a transaction which has not committed yet is still read from the WAL
+func (wal *WAL) Forget(pageNum int64) {
+	offset, ok := wal.committedTxns[pageNum]
+	if !ok {
+		return
+	}
+	delete(wal.committedTxns, pageNum)
+	if wal.latestFrames[pageNum] == offset {
+		delete(wal.latestFrames, pageNum)
+	}
+}
+
+// FrameReads returns the number of frames read back from the WAL since it was opened
+func (wal *WAL) FrameReads() int64 {
+	return atomic.LoadInt64(&wal.frameReads)
+}
+
+// SizeInBytes returns the number of bytes taken up by the frames of the WAL
+func (wal *WAL) SizeInBytes() int64 {
+	return wal.log.SizeInBytes()
+}
+
+// Truncate discards the frames of committed and aborted transactions from the WAL. Every committed page must have been
+// written to the db file beforehand, and a CHECKPOINT frame appended so that recovery skips the commits of frames which
+// outlive the truncation. The WAL is truncated a segment at a time, up to the oldest frame of a transaction which has
+// not committed yet, so that such transactions can still commit or abort
+func (wal *WAL) Truncate() error {
+	offset := wal.log.Size()
+	for _, pages := range wal.uncommittedTxns {
+		for _, off := range pages {
+			if off < offset {
+				offset = off
+			}
+		}
+	}
+	if err := wal.log.TruncateBefore(offset); err != nil {
+		return err
+	}
+
+	// committed pages are read from the db file from now on
+	for pageNum, off := range wal.committedTxns {
+		if wal.latestFrames[pageNum] == off {
+			delete(wal.latestFrames, pageNum)
+		}
+	}
+	wal.committedTxns = map[int64]int64{}
+	return nil
+}
+
+// Close stops syncing the frames of async commits in the background and closes the files backing the WAL, which syncs
+// every frame
+func (wal *WAL) Close() error {
+	wal.groupCommit.close()
+	return wal.log.Close()
 }
 
 // ReadAllCommittedFrames recovers the state of the WAL before crash. It reads all the committed frames in the WAL and
-// writes them to the channel. Frames which were not committed are discarded
-// Sets the IO offset to the location after the last committed txn
-func (wal *WAL) ReadAllCommittedFrames() <-chan *Frame {
+// writes them to the channel in commit order. Frames of transactions which did not commit, or were aborted, are
+// discarded, as are frames of transactions committed before the last checkpoint since those are already in the db file.
+// Frames are read up to the first corrupt frame, which is where the WAL was torn should the db have crashed while
+// appending to it. Any other failure to read the WAL is sent on the error channel, in which case no frame is written
+// since committed transactions would be lost. The error channel receives nil otherwise, and is meant to be read once
+// the frame channel is closed
+func (wal *WAL) ReadAllCommittedFrames() (<-chan *Frame, <-chan error) {
 	framesChan := make(chan *Frame)
+	errChan := make(chan error, 1)
 
 	go func() {
 		defer close(framesChan)
-		var uncommittedFrames []*Frame
+		uncommittedFrames := map[int64][]*Frame{}
+		frames := make([]*Frame, 0)
+		lastCheckpoint := -1
+		it := wal.log.Iterator(wal.log.FirstOffset())
+		defer it.Close()
+		for ; it.Valid(); it.Next() {
+			frame, err := wal.deserializeFrame(it.Offset(), it.Record())
+			if err != nil {
+				log.Printf("Stopping WAL recovery: %v", err)
+				break
+			}
+			if frame.FrameType == CHECKPOINT {
+				lastCheckpoint = len(frames)
+			}
+			frames = append(frames, frame)
+		}
+		if errors.Is(it.Err(), ErrCorruption) {
+			log.Printf("Stopping WAL recovery: %v", it.Err())
+		} else if it.Err() != nil {
+			errChan <- it.Err()
+			return
+		}
+		errChan <- nil
 
-		for i := int64(0); i < wal.log.Size(); i++ {
-			frameBytes, _ := wal.log.Read(i)
-			frame := wal.deserializeFrame(frameBytes)
-			if frame.FrameType == COMMIT {
-				for _, uncommittedFrame := range uncommittedFrames {
+		for i, frame := range frames {
+			if frame.TxnId > wal.lastTxnId {
+				wal.lastTxnId = frame.TxnId
+			}
+			if frame.FrameType == CHECKPOINT {
+				continue
+			} else if frame.FrameType == COMMIT && i < lastCheckpoint {
+				delete(uncommittedFrames, frame.TxnId)
+			} else if frame.FrameType == COMMIT {
+				for _, uncommittedFrame := range uncommittedFrames[frame.TxnId] {
 					framesChan <- uncommittedFrame
 				}
-				uncommittedFrames = make([]*Frame, 0)
+				delete(uncommittedFrames, frame.TxnId)
+			} else if frame.FrameType == ABORT {
+				delete(uncommittedFrames, frame.TxnId)
 			} else {
-				uncommittedFrames = append(uncommittedFrames, frame)
+				uncommittedFrames[frame.TxnId] = append(uncommittedFrames[frame.TxnId], frame)
 			}
 		}
 	}()
 
-	return framesChan
+	return framesChan, errChan
 }
 
 func (wal *WAL) serializeFrame(f *Frame) []byte {
 	buf := make([]byte, 0)
 	buf = append(buf, serialization.Int16ToBytes(int16(f.FrameType))...)
+	buf = append(buf, serialization.Int64ToBytes(f.TxnId)...)
 
-	if f.FrameType != 1 {
+	if f.FrameType == PUT {
 		buf = append(buf, serialization.Int64ToBytes(f.PageNum)...)
 		buf = append(buf, f.Data...)
 	}
-	return buf
+	return append(buf, checksum(buf)...)
 }
 
-func (wal *WAL) deserializeFrame(frameBytes []byte) *Frame {
-	frameType := FrameType(serialization.BytesToInt16(frameBytes[0:FrameTypeSize]))
-	if frameType == COMMIT {
+func (wal *WAL) deserializeFrame(offset int64, frameBytes []byte) (*Frame, error) {
+	if len(frameBytes) < FrameTypeSize+TxnIdSize+ChecksumSize {
+		return nil, &CorruptFrameError{Offset: offset, Reason: "frame is truncated"}
+	}
+	body := frameBytes[:len(frameBytes)-ChecksumSize]
+	if string(checksum(body)) != string(frameBytes[len(body):]) {
+		return nil, &CorruptFrameError{Offset: offset, Reason: "checksum mismatch"}
+	}
+
+	frameType := FrameType(serialization.BytesToInt16(body[0:FrameTypeSize]))
+	txnId := serialization.BytesToInt64(body[FrameTypeSize : FrameTypeSize+TxnIdSize])
+	body = body[FrameTypeSize+TxnIdSize:]
+	if frameType == COMMIT || frameType == ABORT || frameType == CHECKPOINT {
 		return &Frame{
 			FrameType: frameType,
-		}
-	} else if frameType == PUT {
-		pageNumber := serialization.BytesToInt64(frameBytes[FrameTypeSize:FrameTypeSize+PageRefSize])
-		pageData := frameBytes[FrameTypeSize+PageRefSize:FrameTypeSize+PageRefSize+PageSize]
+			TxnId:     txnId,
+		}, nil
+	} else if frameType == PUT && len(body) == PageRefSize+PageSize {
+		pageNumber := serialization.BytesToInt64(body[:PageRefSize])
+		pageData := body[PageRefSize : PageRefSize+PageSize]
 		return &Frame{
 			FrameType: frameType,
+			TxnId:     txnId,
 			PageNum:   pageNumber,
 			Data:      pageData,
-		}
+		}, nil
 	}
 
-	log.Fatalf("Unrecognized frame type: %d", frameType)
-	return nil
+	return nil, &CorruptFrameError{Offset: offset, Reason: fmt.Sprintf("unrecognized frame type %d", frameType)}
 }
@@ -0,0 +1,54 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotFound is matched by errors.Is when reading a record which is not in the log
+var ErrNotFound = errors.New("record not found")
+
+// ErrClosed is returned when waiting for records on a log which has been closed
+var ErrClosed = errors.New("log is closed")
+
+// ErrReadOnly is returned when writing to a log opened with WithReadOnly
+var ErrReadOnly = errors.New("log is read only")
+
+// ErrCorruption is matched by errors.Is for every error caused by data which cannot be read back as it was written
+var ErrCorruption = errors.New("data is corrupt")
+
+// ErrIO is matched by errors.Is for every error caused by a failed read or write of a file
+var ErrIO = errors.New("i/o failure")
+
+// An IOError reports a failed operation on a file. Err is the error returned by the operation
+type IOError struct {
+	Op   string
+	File string
+	Err  error
+}
+
+func (e *IOError) Error() string {
+	return fmt.Sprintf("failure to %s %s: %v", e.Op, e.File, e.Err)
+}
+
+func (e *IOError) Unwrap() error {
+	return e.Err
+}
+
+func (e *IOError) Is(target error) bool {
+	return target == ErrIO
+}
+
+// A CorruptRecordError reports a record of the log which cannot be read back
+type CorruptRecordError struct {
+	Offset int64
+	Reason string
+}
+
+func (e *CorruptRecordError) Error() string {
+	return fmt.Sprintf("record %d is corrupt: %s", e.Offset, e.Reason)
+}
+
+func (e *CorruptRecordError) Is(target error) bool {
+	return target == ErrCorruption
+}
@@ -3,7 +3,6 @@ package log
 import (
 	"encoding/binary"
 	"fios-db/src/serialization"
-	"log"
 	"os"
 )
 
@@ -15,56 +14,89 @@ type index struct {
 	size int64 // number of records in this log
 }
 
-func newIndex(file *os.File) *index {
+func newIndex(file *os.File) (*index, error) {
 	fi, err := os.Stat(file.Name())
 	if err != nil {
-		log.Fatalf("Failure constructing index: %v", err)
+		return nil, &IOError{Op: "stat", File: file.Name(), Err: err}
 	}
 	size := fi.Size() / storeOffsetFieldWidthInBytes
 	return &index{
 		file: file,
 		size: size,
-	}
+	}, nil
 }
 
 // Append Appends the store offset to the index file and returns the record index
-func (i *index) Append(storeOffset int64) int64 {
-	_, _ = i.file.Seek(0, 2)
-
-	_, err := i.file.Write(serialization.Int64ToBytes(storeOffset))
+func (i *index) Append(storeOffset int64) (int64, error) {
+	_, err := i.file.WriteAt(serialization.Int64ToBytes(storeOffset), i.size * storeOffsetFieldWidthInBytes)
 	if err != nil {
-		log.Fatalf("Failure writing length of record")
+		return 0, &IOError{Op: "append to", File: i.file.Name(), Err: err}
 	}
 
 	recordIndex := i.size
 	i.size = i.size + 1
-	return recordIndex
+	return recordIndex, nil
 }
 
 
 // Takes a record offset and returns the offset of that record in the store
-func (i *index) Read(offset int64) int64 {
-	recordLenBytes := make([]byte, recordLenFieldWidthInBytes)
-	_, err := i.file.ReadAt(recordLenBytes, offset* storeOffsetFieldWidthInBytes)
+func (i *index) Read(offset int64) (int64, error) {
+	storeOffsetBytes := make([]byte, storeOffsetFieldWidthInBytes)
+	_, err := i.file.ReadAt(storeOffsetBytes, offset* storeOffsetFieldWidthInBytes)
 	if err != nil {
-		log.Fatalf("Failure reading length of record")
+		return 0, &IOError{Op: "read", File: i.file.Name(), Err: err}
 	}
 
-	return int64(binary.LittleEndian.Uint64(recordLenBytes))
+	return int64(binary.LittleEndian.Uint64(storeOffsetBytes)), nil
+}
+
+// ReadAll returns the store offset of every record of the index in record order
+func (i *index) ReadAll() ([]int64, error) {
+	return i.ReadRange(0, i.size)
 }
 
-func (i *index) Write(offset int64, storeOffset int64) {
+// ReadRange returns the store offsets of up to n records starting at the given record index in record order
+func (i *index) ReadRange(offset int64, n int64) ([]int64, error) {
+	if offset + n > i.size {
+		n = i.size - offset
+	}
+	if n <= 0 {
+		return []int64{}, nil
+	}
+	buf := make([]byte, n * storeOffsetFieldWidthInBytes)
+	_, err := i.file.ReadAt(buf, offset * storeOffsetFieldWidthInBytes)
+	if err != nil {
+		return nil, &IOError{Op: "read", File: i.file.Name(), Err: err}
+	}
+	storeOffsets := make([]int64, n)
+	for j := range storeOffsets {
+		storeOffsets[j] = serialization.BytesToInt64(buf[j * storeOffsetFieldWidthInBytes:])
+	}
+	return storeOffsets, nil
+}
+
+func (i *index) Write(offset int64, storeOffset int64) error {
 	_, err := i.file.WriteAt(serialization.Int64ToBytes(storeOffset), offset * storeOffsetFieldWidthInBytes)
 	if err != nil {
-		log.Fatalf("Failure overwriting length of record")
+		return &IOError{Op: "write to", File: i.file.Name(), Err: err}
 	}
+	return nil
 }
 
-func (i *index) Flush() {
+func (i *index) Flush() error {
 	err := i.file.Sync()
 	if err != nil {
-		log.Fatalf("Failure syncing index file to disk")
+		return &IOError{Op: "sync", File: i.file.Name(), Err: err}
 	}
+	return nil
 }
 
-
+// TruncateTo removes every record index from the given record index onwards
+func (i *index) TruncateTo(offset int64) error {
+	err := i.file.Truncate(offset * storeOffsetFieldWidthInBytes)
+	if err != nil {
+		return &IOError{Op: "truncate", File: i.file.Name(), Err: err}
+	}
+	i.size = offset
+	return i.Flush()
+}
@@ -0,0 +1,151 @@
+package log
+
+import (
+	"context"
+	"fmt"
+)
+
+// iteratorBatchRecords is the most records an iterator reads from the log at a time
+const iteratorBatchRecords = 1024
+
+// iteratorBatchBytes is roughly the most bytes of records an iterator reads from the log at a time
+const iteratorBatchBytes = 256 << 10
+
+// Iterator reads the records of a Log in offset order. Records are read in batches, with a single read of the index and
+// of the store of a segment per batch, rather than with the two reads Read takes per record. The iterator does not hold
+// the lock of the log between batches, so records appended, replaced or truncated while iterating may or may not be
+// seen. An iterator which fails to read a record becomes invalid and reports the failure through Err
+type Iterator struct {
+	log     *Log
+	offset  int64    // offset of the current record
+	records [][]byte // batch of consecutive records holding the current record
+	idx     int      // index of the current record within records
+	err     error    // error which ended the iteration, if any
+	closed  bool
+}
+
+// Iterator returns an iterator positioned at the record at fromOffset. The iterator is exhausted straight away if
+// fromOffset is Size, and reports an error matching ErrNotFound if there is no record at fromOffset
+func (l *Log) Iterator(fromOffset int64) *Iterator {
+	it := &Iterator{
+		log:    l,
+		offset: fromOffset,
+	}
+	it.load()
+	return it
+}
+
+// Valid returns whether the iterator is positioned at a record
+func (it *Iterator) Valid() bool {
+	return !it.closed && it.err == nil && it.idx < len(it.records)
+}
+
+// Err returns the error which ended the iteration, or nil if the iterator is valid or reached the end of the log
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Next moves the iterator to the next record
+func (it *Iterator) Next() {
+	it.offset++
+	it.idx++
+	if it.idx >= len(it.records) {
+		it.load()
+	}
+}
+
+// Offset returns the offset of the current record
+func (it *Iterator) Offset() int64 {
+	return it.offset
+}
+
+// Record returns the current record
+func (it *Iterator) Record() []byte {
+	return it.records[it.idx]
+}
+
+// Close marks the iterator as exhausted
+func (it *Iterator) Close() {
+	it.closed = true
+}
+
+// load reads the batch of records starting at the current offset. The iterator is exhausted if the current offset is
+// Size
+func (it *Iterator) load() {
+	it.records, it.err = it.log.readBatch(it.offset)
+	it.idx = 0
+}
+
+// readBatch returns consecutive records starting at offset, or no records if offset is Size
+func (l *Log) readBatch(offset int64) ([][]byte, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if offset == l.active().nextOffset() {
+		return nil, nil
+	}
+	segment := l.segment(offset)
+	if segment == nil {
+		return nil, fmt.Errorf("offset %d is not in the log: %w", offset, ErrNotFound)
+	}
+	return segment.ReadBatch(offset, iteratorBatchRecords, iteratorBatchBytes)
+}
+
+// A TailReader reads the records of a Log in offset order and waits for records to be appended once it reaches the end
+// of the log. Records are seen as soon as they are appended, before the log is flushed
+type TailReader struct {
+	log *Log
+	it  *Iterator
+}
+
+// Tail returns a tail reader whose first record is the record at fromOffset
+func (l *Log) Tail(fromOffset int64) *TailReader {
+	return &TailReader{
+		log: l,
+		it:  l.Iterator(fromOffset),
+	}
+}
+
+// Next returns the next record along with its offset, waiting for it to be appended if needed. Returns the error of ctx
+// if it is done before the record is appended, ErrClosed if the log is closed, or an error matching ErrNotFound if the
+// record was discarded from the log
+func (r *TailReader) Next(ctx context.Context) (int64, []byte, error) {
+	for !r.it.Valid() {
+		if r.it.Err() != nil {
+			return 0, nil, r.it.Err()
+		}
+		if err := r.log.waitForAppend(ctx, r.it.Offset()); err != nil {
+			return 0, nil, err
+		}
+		r.it.load()
+	}
+	offset, record := r.it.Offset(), r.it.Record()
+	r.it.Next()
+	return offset, record, nil
+}
+
+// waitForAppend waits until the log holds a record at offset
+func (l *Log) waitForAppend(ctx context.Context, offset int64) error {
+	for {
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			return ErrClosed
+		}
+		if offset < l.active().nextOffset() {
+			l.mu.Unlock()
+			return nil
+		}
+		if l.appended == nil {
+			l.appended = make(chan struct{})
+		}
+		appended := l.appended
+		l.mu.Unlock()
+
+		select {
+		case <-appended:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
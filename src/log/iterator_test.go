@@ -0,0 +1,165 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+// iterate returns the records from the iterator until it is exhausted
+func iterate(it *Iterator) [][]byte {
+	records := make([][]byte, 0)
+	for ; it.Valid(); it.Next() {
+		records = append(records, it.Record())
+	}
+	return records
+}
+
+func TestIterator(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	l := NewLog(TestFile, WithSegmentSize(64))
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	records := appendRecords(l, 20)
+
+	// Act
+	it := l.Iterator(5)
+	defer it.Close()
+	actual := iterate(it)
+
+	// Assert
+	assert.NoError(t, it.Err())
+	assert.Equal(t, records[5:], actual)
+	assert.Equal(t, int64(20), it.Offset())
+}
+
+func TestIteratorAcrossBatches(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	l := NewLog(TestFile)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	records := appendRecords(l, 2*iteratorBatchRecords+10)
+	// a record larger than a batch is read on its own
+	large := bytes.Repeat([]byte("x"), 2*iteratorBatchBytes)
+	_, _ = l.Append(large)
+	records = append(records, large)
+	records = append(records, appendRecords(l, 10)...)
+
+	// Act
+	it := l.Iterator(0)
+	defer it.Close()
+	actual := iterate(it)
+
+	// Assert
+	assert.NoError(t, it.Err())
+	assert.Equal(t, records, actual)
+}
+
+func TestIteratorReadsReplacedRecords(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	l := NewLog(TestFile)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	records := appendRecords(l, 4)
+	_ = l.Write([]byte("Goodbye world"), 1)
+	records[1] = []byte("Goodbye world")
+
+	// Act
+	it := l.Iterator(0)
+	defer it.Close()
+	actual := iterate(it)
+
+	// Assert
+	assert.NoError(t, it.Err())
+	assert.Equal(t, records, actual)
+}
+
+func TestIteratorFromDiscardedOffsetIsNotFound(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	l := NewLog(TestFile, WithSegmentSize(64))
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	appendRecords(l, 20)
+	_ = l.TruncateBefore(10)
+
+	// Act
+	it := l.Iterator(0)
+	defer it.Close()
+
+	// Assert
+	assert.False(t, it.Valid())
+	assert.True(t, errors.Is(it.Err(), ErrNotFound))
+}
+
+func TestIteratorStopsAtCorruptRecord(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	l := NewLog(TestFile)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	records := appendRecords(l, 3)
+	_ = l.Flush()
+	storeFile, _ := os.OpenFile(segmentFileName(TestFile, 0)+".store", os.O_RDWR, 0666)
+	_, _ = storeFile.WriteAt([]byte("!"), int64(2*recordHeaderWidthInBytes+len(records[0])))
+	_ = storeFile.Close()
+
+	// Act
+	it := l.Iterator(0)
+	defer it.Close()
+	actual := iterate(it)
+
+	// Assert
+	assert.Equal(t, records[:1], actual)
+	assert.True(t, errors.Is(it.Err(), ErrCorruption))
+	assert.Equal(t, int64(1), it.Offset())
+}
+
+func TestTailWaitsForAppends(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	l := NewLog(TestFile)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	records := appendRecords(l, 2)
+	tail := l.Tail(1)
+
+	// Act
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_, _ = l.Append([]byte("Hello world"))
+	}()
+	offset1, record1, err1 := tail.Next(context.Background())
+	offset2, record2, err2 := tail.Next(context.Background())
+
+	// Assert
+	assert.NoError(t, err1)
+	assert.Equal(t, int64(1), offset1)
+	assert.Equal(t, records[1], record1)
+	assert.NoError(t, err2)
+	assert.Equal(t, int64(2), offset2)
+	assert.Equal(t, []byte("Hello world"), record2)
+}
+
+func TestTailStopsWaiting(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	l := NewLog(TestFile)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	tail := l.Tail(0)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// Act
+	_, _, timeoutErr := tail.Next(ctx)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = l.Close()
+	}()
+	_, _, closeErr := tail.Next(context.Background())
+
+	// Assert
+	assert.True(t, errors.Is(timeoutErr, context.DeadlineExceeded))
+	assert.True(t, errors.Is(closeErr, ErrClosed))
+}
@@ -1,57 +1,358 @@
 package log
 
 import (
-	"errors"
+	"fios-db/src/serialization"
+	"fmt"
 	"log"
 	"os"
+	"sort"
+	"sync"
 )
 
-// A Log provides the basic abstraction of a log.
+// A Log provides the basic abstraction of a log. Records are addressed by offset, starting at 0 for the first record
+// ever appended, and are stored in a sequence of segments, see segment.go. The log rolls over to a new segment once the
+// last segment grows past the segment size, so that old records can be discarded a segment at a time, by
+// TruncateBefore or by retention, without rewriting the rest of the log. A Log is safe for concurrent use
 type Log struct {
-	index *index
-	store *store
+	mu       sync.RWMutex
+	fileName string
+	options  options
+	segments []*segment     // ordered by base offset, records are appended to the last segment
+	appended chan struct{} // closed on the next append to wake tail readers, nil if none are waiting
+	closed   bool
+	flushMu  sync.Mutex // held while syncing segments without holding mu, and while deleting segments
 }
 
+// NewLog opens the log stored in fileName, creating it if it does not exist. A log written before logs were split into
+// segments is opened as the first segment of the log. Records which were not completely written before a crash are
+// dropped, see recovery.go
+func NewLog(fileName string, opts ...Option) *Log {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
 
-func NewLog(fileName string) *Log {
-	indexFile, err := os.OpenFile(fileName + ".index", os.O_RDWR|os.O_CREATE, 0666)
+	// a read only log is never migrated, so it only reads logs which were split into segments
+	if !o.readOnly {
+		if err := migrateUnsegmentedLog(fileName); err != nil {
+			log.Fatalf("Failure migrating log: %v", err)
+		}
+	}
+	baseOffsets, err := segmentBaseOffsets(fileName)
 	if err != nil {
-		log.Fatalf("Failure opening index file")
+		log.Fatalf("Failure listing log segments: %v", err)
+	}
+	if len(baseOffsets) == 0 {
+		baseOffsets = []int64{0}
+	}
+
+	l := &Log{
+		fileName: fileName,
+		options:  o,
+	}
+	for _, baseOffset := range baseOffsets {
+		segment, err := openSegment(fileName, baseOffset, o.readOnly)
+		if err != nil {
+			log.Fatalf("Failure opening log segment: %v", err)
+		}
+		l.segments = append(l.segments, segment)
+	}
+	return l
+}
+
+// migrateUnsegmentedLog copies the records of a log written before logs were split into segments, whose records had no
+// checksums, to the first segment of the log and then deletes the old files. The old store file is deleted first, so a
+// migration interrupted by a crash is started over the next time the log is opened
+func migrateUnsegmentedLog(fileName string) error {
+	storeBytes, err := os.ReadFile(fileName + ".store")
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
 	}
-	storeFile, err := os.OpenFile(fileName + ".store", os.O_RDWR|os.O_CREATE, 0666)
+	indexBytes, err := os.ReadFile(fileName + ".index")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	name := segmentFileName(fileName, 0)
+	for _, ext := range []string{".store", ".index"} {
+		if err := os.Remove(name + ext); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	segment, err := openSegment(fileName, 0, false)
 	if err != nil {
-		log.Fatalf("Failure opening store file")
+		return err
+	}
+	for i := 0; i + storeOffsetFieldWidthInBytes <= len(indexBytes); i += storeOffsetFieldWidthInBytes {
+		storeOffset := serialization.BytesToInt64(indexBytes[i:])
+		if storeOffset < 0 || storeOffset + recordLenFieldWidthInBytes > int64(len(storeBytes)) {
+			break
+		}
+		recordLen := serialization.BytesToInt64(storeBytes[storeOffset:])
+		dataOffset := storeOffset + recordLenFieldWidthInBytes
+		if recordLen < 0 || recordLen > int64(len(storeBytes)) - dataOffset {
+			break
+		}
+		if _, err := segment.Append(storeBytes[dataOffset : dataOffset + recordLen]); err != nil {
+			_ = segment.Close()
+			return err
+		}
+	}
+	if err := segment.Close(); err != nil {
+		return err
 	}
 
-	return &Log{
-		index: newIndex(indexFile),
-		store: newStore(storeFile),
+	if err := os.Remove(fileName + ".store"); err != nil {
+		return err
+	}
+	if err := os.Remove(fileName + ".index"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Append appends the record to the log and returns its offset. Returns an IOError if the record could not be written
+func (l *Log) Append(data []byte) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.append(data)
+}
+
+// append appends the record to the last segment, rolling over to a new segment first if the last one is full. The
+// caller must hold mu
+func (l *Log) append(data []byte) (int64, error) {
+	if l.options.readOnly {
+		return 0, ErrReadOnly
+	}
+	if active := l.active(); active.store.size >= l.options.segmentSize && active.index.size > 0 {
+		if err := l.roll(); err != nil {
+			return 0, err
+		}
+	}
+	offset, err := l.active().Append(data)
+	if err != nil {
+		return 0, err
 	}
+	if l.appended != nil {
+		close(l.appended)
+		l.appended = nil
+	}
+	return offset, nil
 }
 
-func (l *Log) Append(data []byte) int64 {
-	storeOffset := l.store.Append(data)
-	return l.index.Append(storeOffset)
+// roll starts a new segment after the last one, then discards the oldest segments for as long as the log is larger than
+// the retention size. The caller must hold mu
+func (l *Log) roll() error {
+	if err := l.active().Flush(); err != nil {
+		return err
+	}
+	segment, err := openSegment(l.fileName, l.active().nextOffset(), false)
+	if err != nil {
+		return err
+	}
+	l.segments = append(l.segments, segment)
+
+	for l.options.retentionSize > 0 && len(l.segments) > 1 && l.sizeInBytes() > l.options.retentionSize {
+		if err := l.removeFirst(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
+// Read returns the record at the given offset. Returns an error matching ErrNotFound if there is no record at the
+// offset, a CorruptRecordError if the record cannot be read back or an IOError if reading it failed
 func (l *Log) Read(offset int64) ([]byte, error) {
-	if offset >= l.Size() {
-		return []byte{}, errors.New("read is beyond the end of the log")
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	segment := l.segment(offset)
+	if segment == nil {
+		return nil, fmt.Errorf("offset %d is not in the log: %w", offset, ErrNotFound)
 	}
-	storeOffset := l.index.Read(offset)
-	return l.store.Read(storeOffset), nil
+	return segment.Read(offset)
 }
 
-func (l *Log) Write(data []byte, offset int64) {
-	storeOffset := l.store.Append(data)
-	l.index.Write(offset, storeOffset)
+// Write replaces the record at the given offset. Writing at Size appends the record
+func (l *Log) Write(data []byte, offset int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.options.readOnly {
+		return ErrReadOnly
+	}
+	if offset == l.active().nextOffset() {
+		_, err := l.append(data)
+		return err
+	}
+	segment := l.segment(offset)
+	if segment == nil {
+		return fmt.Errorf("offset %d is not in the log: %w", offset, ErrNotFound)
+	}
+	return segment.Write(data, offset)
 }
 
+// Size returns the offset the next record is appended at
 func (l *Log) Size() int64 {
-	return l.index.size
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.active().nextOffset()
+}
+
+// FirstOffset returns the offset of the oldest record which has not been discarded. Records are held from FirstOffset up
+// to Size
+func (l *Log) FirstOffset() int64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.segments[0].baseOffset
+}
+
+// Flush syncs every record appended before Flush is called to stable storage. Records are appended while the log is
+// synced, so that concurrent writers can append records to be synced by the next Flush
+func (l *Log) Flush() error {
+	l.mu.RLock()
+	segments := append([]*segment{}, l.segments...)
+	l.mu.RUnlock()
+
+	l.flushMu.Lock()
+	defer l.flushMu.Unlock()
+	for _, segment := range segments {
+		if segment.removed {
+			continue
+		}
+		if err := segment.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SizeInBytes returns the number of bytes taken up by the records of the log
+func (l *Log) SizeInBytes() int64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.sizeInBytes()
+}
+
+// sizeInBytes returns the number of bytes taken up by the records of the log. The caller must hold mu
+func (l *Log) sizeInBytes() int64 {
+	size := int64(0)
+	for _, segment := range l.segments {
+		size += segment.store.size
+	}
+	return size
+}
+
+// TruncateBefore discards the records before offset a segment at a time. Every segment holding only records before
+// offset is deleted, while the segment holding offset is kept whole, so records before offset may remain readable.
+// Truncating before Size discards every record, after which records are still appended at Size
+func (l *Log) TruncateBefore(offset int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.options.readOnly {
+		return ErrReadOnly
+	}
+	if active := l.active(); offset >= active.nextOffset() && active.index.size > 0 {
+		// start an empty segment so that the last segment can be deleted as well
+		segment, err := openSegment(l.fileName, active.nextOffset(), false)
+		if err != nil {
+			return err
+		}
+		l.segments = append(l.segments, segment)
+	}
+	for len(l.segments) > 1 && l.segments[1].baseOffset <= offset {
+		if err := l.removeFirst(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TruncateAfter removes every record after offset, so that the next record is appended at offset + 1. Truncating after
+// an offset before FirstOffset removes every record
+func (l *Log) TruncateAfter(offset int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.options.readOnly {
+		return ErrReadOnly
+	}
+	if offset < -1 {
+		offset = -1
+	}
+	// segments are removed from the last one so that a failure never leaves a gap between segments
+	for len(l.segments) > 1 && l.active().baseOffset > offset {
+		segment := l.active()
+		l.segments = l.segments[:len(l.segments)-1]
+		if err := l.remove(segment); err != nil {
+			return err
+		}
+	}
+	if first := l.segments[0]; first.baseOffset > offset+1 {
+		empty, err := openSegment(l.fileName, offset+1, false)
+		if err != nil {
+			return err
+		}
+		l.segments = []*segment{empty}
+		return l.remove(first)
+	}
+	return l.active().TruncateAfter(offset)
+}
+
+// Truncate removes every record from the log. Records appended afterwards start again at offset 0
+func (l *Log) Truncate() error {
+	return l.TruncateAfter(-1)
+}
+
+// Close closes the files backing the log and wakes tail readers waiting for records. The log must not be used once
+// closed
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	if l.appended != nil {
+		close(l.appended)
+		l.appended = nil
+	}
+	var err error
+	for _, segment := range l.segments {
+		if closeErr := segment.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// active returns the segment records are appended to. The caller must hold mu
+func (l *Log) active() *segment {
+	return l.segments[len(l.segments)-1]
+}
+
+// segment returns the segment holding the record at offset, nil if the record is not in the log. The caller must hold
+// mu
+func (l *Log) segment(offset int64) *segment {
+	if offset < l.segments[0].baseOffset || offset >= l.active().nextOffset() {
+		return nil
+	}
+	i := sort.Search(len(l.segments), func(i int) bool {
+		return l.segments[i].baseOffset > offset
+	})
+	return l.segments[i-1]
+}
+
+// removeFirst deletes the oldest segment, which must not be the last one. The caller must hold mu
+func (l *Log) removeFirst() error {
+	segment := l.segments[0]
+	l.segments = l.segments[1:]
+	return l.remove(segment)
 }
 
-func (l *Log) Flush() {
-	l.store.Flush()
-	l.index.Flush()
+// remove deletes the segment once no Flush is syncing it. The caller must hold mu
+func (l *Log) remove(segment *segment) error {
+	l.flushMu.Lock()
+	defer l.flushMu.Unlock()
+	return segment.Remove()
 }
@@ -1,6 +1,9 @@
 package log
 
 import (
+	"errors"
+	"fios-db/src/serialization"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"testing"
@@ -27,7 +30,8 @@ func TestAppend(t *testing.T) {
 	data := []byte("Hello world")
 
 	// Act/Assert
-	offset := l.Append(data)
+	offset, err := l.Append(data)
+	assert.NoError(t, err)
 	assert.Equal(t, int64(0), offset)
 	assert.Equal(t, int64(1), l.Size())
 }
@@ -41,8 +45,8 @@ func TestRead(t *testing.T) {
 	data2 := []byte("Goodbye world")
 
 	// Act/Assert
-	offset1 := l.Append(data1)
-	offset2 := l.Append(data2)
+	offset1, _ := l.Append(data1)
+	offset2, _ := l.Append(data2)
 	assert.Equal(t, int64(0), offset1)
 	assert.Equal(t, int64(1), offset2)
 	assert.Equal(t, int64(2), l.Size())
@@ -59,10 +63,10 @@ func TestWrite(t *testing.T) {
 	defer func() {_ = os.RemoveAll(TestDir)}()
 	data1 := []byte("Hello world")
 	data2 := []byte("Goodbye world")
-	_ = l.Append(data1)
+	_, _ = l.Append(data1)
 
 	// Act/Assert
-	l.Write(data2, 0)
+	assert.NoError(t, l.Write(data2, 0))
 	assert.Equal(t, int64(1), l.Size())
 	data1Actual, _ := l.Read(0)
 	assert.Equal(t, data2, data1Actual)
@@ -75,8 +79,8 @@ func TestCreateLogAfterCrash(t *testing.T) {
 	defer func() {_ = os.RemoveAll(TestDir)}()
 	data1 := []byte("Hello world")
 	data2 := []byte("Goodbye world")
-	_ = l1.Append(data1)
-	_ = l1.Append(data2)
+	_, _ = l1.Append(data1)
+	_, _ = l1.Append(data2)
 	l1.Flush()
 
 	// Act/Assert
@@ -91,12 +95,12 @@ func TestAppendAfterCrash(t *testing.T) {
 	defer func() {_ = os.RemoveAll(TestDir)}()
 	data1 := []byte("Hello world")
 	data2 := []byte("Goodbye world")
-	_ = l1.Append(data1)
+	_, _ = l1.Append(data1)
 	l1.Flush()
 
 	// Act/Assert
 	l2 := NewLog(TestFile)
-	offset := l2.Append(data2)
+	offset, _ := l2.Append(data2)
 	assert.Equal(t, int64(1), offset)
 	assert.Equal(t, int64(2), l2.Size())
 }
@@ -108,8 +112,8 @@ func TestReadAfterCrash(t *testing.T) {
 	defer func() {_ = os.RemoveAll(TestDir)}()
 	data1 := []byte("Hello world")
 	data2 := []byte("Goodbye world")
-	offset1 := l1.Append(data1)
-	offset2 := l1.Append(data2)
+	offset1, _ := l1.Append(data1)
+	offset2, _ := l1.Append(data2)
 	l1.Flush()
 
 	// Act/Assert
@@ -130,12 +134,246 @@ func TestWriteAfterCrash(t *testing.T) {
 	defer func() {_ = os.RemoveAll(TestDir)}()
 	data1 := []byte("Hello world")
 	data2 := []byte("Goodbye world")
-	_ = l1.Append(data1)
+	_, _ = l1.Append(data1)
 
 	// Act/Assert
 	l2 := NewLog(TestFile)
-	l2.Write(data2, 0)
+	assert.NoError(t, l2.Write(data2, 0))
 	assert.Equal(t, int64(1), l2.Size())
 	data1Actual, _ := l2.Read(0)
 	assert.Equal(t, data2, data1Actual)
 }
+
+func TestTruncate(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	l1 := NewLog(TestFile)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	data1 := []byte("Hello world")
+	data2 := []byte("Goodbye world")
+	_, _ = l1.Append(data1)
+	_, _ = l1.Append(data1)
+
+	// Act
+	assert.NoError(t, l1.Truncate())
+	offset, _ := l1.Append(data2)
+
+	// Assert
+	assert.Equal(t, int64(0), offset)
+	assert.Equal(t, int64(1), l1.Size())
+	assert.Equal(t, int64(recordHeaderWidthInBytes+len(data2)), l1.SizeInBytes())
+	l2 := NewLog(TestFile)
+	assert.Equal(t, int64(1), l2.Size())
+	data2Actual, _ := l2.Read(0)
+	assert.Equal(t, data2, data2Actual)
+}
+
+func TestReadBeyondEndIsNotFound(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	l := NewLog(TestFile)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	_, _ = l.Append([]byte("Hello world"))
+
+	// Act
+	_, err1 := l.Read(1)
+	_, err2 := l.Read(-1)
+
+	// Assert
+	assert.True(t, errors.Is(err1, ErrNotFound))
+	assert.True(t, errors.Is(err2, ErrNotFound))
+}
+
+func TestReadCorruptRecordIsCorrupt(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	l := NewLog(TestFile)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	_, _ = l.Append([]byte("Hello world"))
+	_, _ = l.Append([]byte("Goodbye world"))
+	_ = l.Flush()
+	storeFile, _ := os.OpenFile(segmentFileName(TestFile, 0)+".store", os.O_RDWR, 0666)
+	_, _ = storeFile.WriteAt([]byte("G"), l.SizeInBytes()-1)
+	_ = storeFile.Close()
+
+	// Act
+	data1, err1 := l.Read(0)
+	_, err2 := l.Read(1)
+
+	// Assert
+	assert.NoError(t, err1)
+	assert.Equal(t, []byte("Hello world"), data1)
+	var corruptRecordErr *CorruptRecordError
+	assert.True(t, errors.As(err2, &corruptRecordErr))
+	assert.Equal(t, int64(1), corruptRecordErr.Offset)
+	assert.True(t, errors.Is(err2, ErrCorruption))
+}
+
+func TestFailedWritesReturnIOError(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	l := NewLog(TestFile)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	_, _ = l.Append([]byte("Hello world"))
+	_ = l.Close()
+
+	// Act
+	_, appendErr := l.Append([]byte("Goodbye world"))
+	writeErr := l.Write([]byte("Goodbye world"), 0)
+	_, readErr := l.Read(0)
+	flushErr := l.Flush()
+
+	// Assert
+	for _, err := range []error{appendErr, writeErr, readErr, flushErr} {
+		assert.True(t, errors.Is(err, ErrIO))
+		assert.True(t, errors.Is(err, os.ErrClosed))
+	}
+	assert.Equal(t, int64(1), l.Size())
+}
+
+// appendRecords appends n records to the log and returns them
+func appendRecords(l *Log, n int) [][]byte {
+	records := make([][]byte, 0)
+	for i := 0; i < n; i++ {
+		record := []byte(fmt.Sprintf("record %02d", i))
+		_, _ = l.Append(record)
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestRollOverToNewSegments(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	l1 := NewLog(TestFile, WithSegmentSize(64))
+	defer func() {_ = os.RemoveAll(TestDir)}()
+
+	// Act
+	records := appendRecords(l1, 20)
+	_ = l1.Close()
+	l2 := NewLog(TestFile, WithSegmentSize(64))
+
+	// Assert
+	baseOffsets, _ := segmentBaseOffsets(TestFile)
+	assert.Equal(t, []int64{0, 4, 8, 12, 16}, baseOffsets)
+	assert.Equal(t, int64(20), l2.Size())
+	for i, record := range records {
+		actual, err := l2.Read(int64(i))
+		assert.NoError(t, err)
+		assert.Equal(t, record, actual)
+	}
+}
+
+func TestTruncateBefore(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	l1 := NewLog(TestFile, WithSegmentSize(64))
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	records := appendRecords(l1, 20)
+
+	// Act
+	err := l1.TruncateBefore(10)
+	offset, _ := l1.Append([]byte("Hello world"))
+
+	// Assert
+	assert.NoError(t, err)
+	// the segment holding offset 10 starts at offset 8
+	assert.Equal(t, int64(8), l1.FirstOffset())
+	assert.Equal(t, int64(20), offset)
+	_, err = l1.Read(7)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	l2 := NewLog(TestFile, WithSegmentSize(64))
+	assert.Equal(t, int64(8), l2.FirstOffset())
+	assert.Equal(t, int64(21), l2.Size())
+	for i := 8; i < 20; i++ {
+		actual, _ := l2.Read(int64(i))
+		assert.Equal(t, records[i], actual)
+	}
+}
+
+func TestTruncateBeforeEnd(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	l1 := NewLog(TestFile, WithSegmentSize(64))
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	appendRecords(l1, 20)
+
+	// Act
+	err := l1.TruncateBefore(l1.Size())
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, int64(20), l1.FirstOffset())
+	assert.Equal(t, int64(0), l1.SizeInBytes())
+	baseOffsets, _ := segmentBaseOffsets(TestFile)
+	assert.Equal(t, []int64{20}, baseOffsets)
+	offset, _ := l1.Append([]byte("Hello world"))
+	assert.Equal(t, int64(20), offset)
+}
+
+func TestTruncateAfter(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	l1 := NewLog(TestFile, WithSegmentSize(64))
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	records := appendRecords(l1, 20)
+
+	// Act
+	err := l1.TruncateAfter(9)
+	offset, _ := l1.Append([]byte("Hello world"))
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), offset)
+	l2 := NewLog(TestFile, WithSegmentSize(64))
+	assert.Equal(t, int64(11), l2.Size())
+	for i := 0; i < 10; i++ {
+		actual, _ := l2.Read(int64(i))
+		assert.Equal(t, records[i], actual)
+	}
+	actual, _ := l2.Read(10)
+	assert.Equal(t, []byte("Hello world"), actual)
+	baseOffsets, _ := segmentBaseOffsets(TestFile)
+	assert.Equal(t, []int64{0, 4, 8}, baseOffsets)
+}
+
+func TestRetention(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	retentionSize := int64(128)
+	l := NewLog(TestFile, WithSegmentSize(64), WithRetentionSize(retentionSize))
+	defer func() {_ = os.RemoveAll(TestDir)}()
+
+	// Act
+	records := appendRecords(l, 50)
+
+	// Assert
+	assert.Greater(t, l.FirstOffset(), int64(0))
+	assert.LessOrEqual(t, l.SizeInBytes(), retentionSize+64)
+	for i := l.FirstOffset(); i < l.Size(); i++ {
+		actual, _ := l.Read(i)
+		assert.Equal(t, records[i], actual)
+	}
+	_, err := l.Read(l.FirstOffset() - 1)
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestOpenUnsegmentedLog(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	data := []byte("Hello world")
+	_ = os.WriteFile(TestFile+".store", append(serialization.Int64ToBytes(int64(len(data))), data...), 0666)
+	_ = os.WriteFile(TestFile+".index", serialization.Int64ToBytes(0), 0666)
+
+	// Act
+	l := NewLog(TestFile)
+
+	// Assert
+	assert.Equal(t, int64(1), l.Size())
+	actual, err := l.Read(0)
+	assert.NoError(t, err)
+	assert.Equal(t, data, actual)
+	_, err = os.Stat(TestFile + ".store")
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,44 @@
+package log
+
+// DefaultSegmentSize is the size in bytes a segment may grow to before the log rolls over to a new segment
+const DefaultSegmentSize = 8 << 20
+
+// An Option configures a Log when it is opened
+type Option func(o *options)
+
+type options struct {
+	segmentSize   int64
+	retentionSize int64
+	readOnly      bool
+}
+
+func defaultOptions() options {
+	return options{
+		segmentSize: DefaultSegmentSize,
+	}
+}
+
+// WithSegmentSize rolls the log over to a new segment once the records of the last segment take up size bytes
+func WithSegmentSize(size int64) Option {
+	return func(o *options) {
+		o.segmentSize = size
+	}
+}
+
+// WithReadOnly opens the files of the log read only, so that inspecting a log never modifies it. Records which were not
+// completely written before a crash are skipped rather than dropped from the files, and every write to the log returns
+// ErrReadOnly
+func WithReadOnly() Option {
+	return func(o *options) {
+		o.readOnly = true
+	}
+}
+
+// WithRetentionSize discards the oldest segments whenever the log rolls over to a new segment, for as long as the
+// records of the log take up more than size bytes. The last segment is never discarded. A size <= 0 retains every
+// segment, which is the default
+func WithRetentionSize(size int64) Option {
+	return func(o *options) {
+		o.retentionSize = size
+	}
+}
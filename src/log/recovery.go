@@ -0,0 +1,134 @@
+package log
+
+import (
+	"bufio"
+	"fios-db/src/serialization"
+	"io"
+	"log"
+)
+
+// A record is appended by writing it to the store and then writing its store offset to the index, and neither file is
+// synced until the log is flushed. A crash can therefore leave a record which was only partially written to the store,
+// a partially written index entry, a store record which was never indexed, or, since the OS may write the files back in
+// any order, an index entry pointing at a store record which never reached the disk. Recovery repairs the segment when
+// it is opened:
+//
+//  1. the store is scanned from the start and every record is checked against its length and checksum, up to the first
+//     record which runs past the end of the store or does not match its checksum
+//  2. every index entry, including a partially written one, which does not point at a valid record is rebuilt from the
+//     scan: records are appended to the store in the order of their index entries, so the entry points at the record
+//     following the record of the previous entry. The index is cut at the first entry which cannot be rebuilt because
+//     there is no valid record there
+//  3. the store is cut after the last record referenced by the index
+//
+// A bad index entry thus never causes the valid records after it to be dropped. Store records which no index entry
+// refers to are dropped rather than indexed, since they are either the records of appends which did not write any of
+// their index entry, records cut by TruncateAfter, or replacements written by Write whose index entry was not rewritten,
+// and only the first of those were ever part of the log.
+//
+// A record replaced by Write is synced to the store before the index points at it, so the index never refers to a
+// replacement which could be lost in a crash.
+
+// recover repairs the records of the segment which were not completely written before a crash. A read only segment is
+// not repaired, and only the records up to its first incomplete index entry are read
+func (s *segment) recover() error {
+	records, err := s.store.scan()
+	if err != nil {
+		return err
+	}
+	storeOffsets, err := s.index.ReadAll()
+	if err != nil {
+		return err
+	}
+	fi, err := s.index.file.Stat()
+	if err != nil {
+		return &IOError{Op: "stat", File: s.index.file.Name(), Err: err}
+	}
+
+	// a partially written entry is counted along with the complete ones
+	numEntries := (fi.Size() + storeOffsetFieldWidthInBytes - 1) / storeOffsetFieldWidthInBytes
+	rebuilt := map[int64]int64{} // index of every rebuilt entry to the store offset it points at
+	indexSize := int64(0)
+	storeSize := int64(0)
+	next := int64(0) // offset of the record following the record of the previous entry
+	for ; indexSize < numEntries; indexSize++ {
+		storeOffset := int64(-1)
+		if indexSize < int64(len(storeOffsets)) {
+			storeOffset = storeOffsets[indexSize]
+		}
+		end, ok := records[storeOffset]
+		if !ok {
+			if end, ok = records[next]; !ok || s.readOnly {
+				break
+			}
+			storeOffset = next
+			rebuilt[indexSize] = storeOffset
+		}
+		if end > storeSize {
+			storeSize = end
+		}
+		next = end
+	}
+
+	if s.readOnly {
+		// the incomplete records are skipped but left in the files
+		s.index.size = indexSize
+		s.store.size = storeSize
+		return nil
+	}
+	if len(rebuilt) > 0 {
+		log.Printf("Recovering log segment %s: rebuilding %d index entries", s.index.file.Name(), len(rebuilt))
+		for entry, storeOffset := range rebuilt {
+			if err := s.index.Write(entry, storeOffset); err != nil {
+				return err
+			}
+		}
+	}
+	if len(rebuilt) > 0 || fi.Size() != indexSize * storeOffsetFieldWidthInBytes {
+		if indexSize < numEntries {
+			log.Printf("Recovering log segment %s: dropping %d incomplete records", s.index.file.Name(), numEntries - indexSize)
+		}
+		if err := s.index.TruncateTo(indexSize); err != nil {
+			return err
+		}
+	}
+	if storeSize < s.store.size {
+		log.Printf("Recovering log segment %s: dropping %d bytes of unindexed records", s.store.file.Name(), s.store.size - storeSize)
+		if err := s.store.TruncateTo(storeSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scan reads the records of the store from the start and returns the end of every valid record keyed by its offset.
+// Scanning stops at the first record which runs past the end of the store or does not match its checksum
+func (s *store) scan() (map[int64]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := map[int64]int64{}
+	reader := bufio.NewReader(io.NewSectionReader(s.file, 0, s.size))
+	header := make([]byte, recordHeaderWidthInBytes)
+	offset := int64(0)
+	for offset + recordHeaderWidthInBytes <= s.size {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return nil, &IOError{Op: "read", File: s.file.Name(), Err: err}
+		}
+		recordLen := serialization.BytesToInt64(header[:recordLenFieldWidthInBytes])
+		if recordLen < 0 || recordLen > s.size - offset - recordHeaderWidthInBytes {
+			break
+		}
+		data := make([]byte, recordLen)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, &IOError{Op: "read", File: s.file.Name(), Err: err}
+		}
+		if string(recordChecksum(header[:recordLenFieldWidthInBytes], data)) != string(header[recordLenFieldWidthInBytes:]) {
+			break
+		}
+		end := offset + recordHeaderWidthInBytes + recordLen
+		records[offset] = end
+		offset = end
+	}
+	return records, nil
+}
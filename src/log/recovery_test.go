@@ -0,0 +1,225 @@
+package log
+
+import (
+	"fios-db/src/serialization"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// segmentFiles returns the contents of the store and index files of the first segment of the log
+func segmentFiles(t *testing.T) ([]byte, []byte) {
+	storeBytes, err := os.ReadFile(segmentFileName(TestFile, 0) + ".store")
+	assert.NoError(t, err)
+	indexBytes, err := os.ReadFile(segmentFileName(TestFile, 0) + ".index")
+	assert.NoError(t, err)
+	return storeBytes, indexBytes
+}
+
+// writeSegmentFiles replaces the store and index files of the first segment of the log, simulating the files left
+// behind by a crash
+func writeSegmentFiles(t *testing.T, storeBytes []byte, indexBytes []byte) {
+	assert.NoError(t, os.WriteFile(segmentFileName(TestFile, 0)+".store", storeBytes, 0666))
+	assert.NoError(t, os.WriteFile(segmentFileName(TestFile, 0)+".index", indexBytes, 0666))
+}
+
+// assertRecords asserts that the log holds exactly the given records and that records are appended after them
+func assertRecords(t *testing.T, l *Log, records [][]byte) {
+	assert.Equal(t, int64(len(records)), l.Size())
+	for i, record := range records {
+		actual, err := l.Read(int64(i))
+		assert.NoError(t, err)
+		assert.Equal(t, record, actual)
+	}
+	offset, err := l.Append([]byte("Hello world"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(records)), offset)
+	actual, err := l.Read(offset)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("Hello world"), actual)
+}
+
+func TestRecoverCrashDuringAppend(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	l1 := NewLog(TestFile)
+	records := appendRecords(l1, 2)
+	_ = l1.Flush()
+	storeBefore, indexBefore := segmentFiles(t)
+	_, _ = l1.Append([]byte("Goodbye world"))
+	_ = l1.Close()
+	storeAfter, indexAfter := segmentFiles(t)
+
+	// every prefix of the store record combined with every prefix of the index entry covers a crash at any point of the
+	// append, including the files being written back in either order. A record which was written to the store in full
+	// is kept as soon as any of its index entry was written
+	for storeLen := len(storeBefore); storeLen <= len(storeAfter); storeLen++ {
+		for indexLen := len(indexBefore); indexLen <= len(indexAfter); indexLen++ {
+			t.Run(fmt.Sprintf("store %d index %d", storeLen, indexLen), func(t *testing.T) {
+				// Act
+				writeSegmentFiles(t, storeAfter[:storeLen], indexAfter[:indexLen])
+				l2 := NewLog(TestFile)
+				defer func() {_ = l2.Close()}()
+
+				// Assert
+				if storeLen == len(storeAfter) && indexLen > len(indexBefore) {
+					assertRecords(t, l2, append(records, []byte("Goodbye world")))
+				} else {
+					assertRecords(t, l2, records)
+				}
+			})
+		}
+	}
+}
+
+func TestRecoverCrashDuringWrite(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	l1 := NewLog(TestFile)
+	records := appendRecords(l1, 2)
+	_ = l1.Flush()
+	storeBefore, indexBefore := segmentFiles(t)
+	_ = l1.Write([]byte("Goodbye world"), 0)
+	_ = l1.Close()
+	storeAfter, indexAfter := segmentFiles(t)
+
+	// the index entry is only rewritten once the new record is synced, so the index points at either record
+	for storeLen := len(storeBefore); storeLen <= len(storeAfter); storeLen++ {
+		for _, indexBytes := range [][]byte{indexBefore, indexAfter} {
+			if storeLen < len(storeAfter) && string(indexBytes) == string(indexAfter) {
+				continue
+			}
+			t.Run(fmt.Sprintf("store %d rewritten %t", storeLen, string(indexBytes) == string(indexAfter)), func(t *testing.T) {
+				// Act
+				writeSegmentFiles(t, storeAfter[:storeLen], indexBytes)
+				l2 := NewLog(TestFile)
+				defer func() {_ = l2.Close()}()
+
+				// Assert
+				if string(indexBytes) == string(indexAfter) {
+					assertRecords(t, l2, [][]byte{[]byte("Goodbye world"), records[1]})
+				} else {
+					assertRecords(t, l2, records)
+				}
+			})
+		}
+	}
+}
+
+func TestRecoverCrashDuringTruncateAfter(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	l1 := NewLog(TestFile)
+	records := appendRecords(l1, 4)
+	_ = l1.Write([]byte("Goodbye world"), 0)
+	records[0] = []byte("Goodbye world")
+	_ = l1.Close()
+	storeBefore, _ := segmentFiles(t)
+	l2 := NewLog(TestFile)
+	_ = l2.TruncateAfter(1)
+	_ = l2.Close()
+	_, indexAfter := segmentFiles(t)
+
+	// Act
+	// the index was truncated but the crash came before the store was
+	writeSegmentFiles(t, storeBefore, indexAfter)
+	l3 := NewLog(TestFile)
+	defer func() {_ = l3.Close()}()
+
+	// Assert
+	assertRecords(t, l3, records[:2])
+}
+
+func TestTruncateAfterKeepsReplacedRecords(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	l1 := NewLog(TestFile)
+	records := appendRecords(l1, 4)
+	_ = l1.Write([]byte("Goodbye world"), 0)
+	records[0] = []byte("Goodbye world")
+
+	// Act
+	err := l1.TruncateAfter(1)
+	_ = l1.Close()
+	l2 := NewLog(TestFile)
+	defer func() {_ = l2.Close()}()
+
+	// Assert
+	assert.NoError(t, err)
+	assertRecords(t, l2, records[:2])
+}
+
+func TestRecoverDanglingIndexEntries(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	l1 := NewLog(TestFile)
+	records := appendRecords(l1, 4)
+	_ = l1.Close()
+	storeBytes, indexBytes := segmentFiles(t)
+
+	// Act
+	// the store was written back up to the middle of the third record while the whole index was
+	writeSegmentFiles(t, storeBytes[:2*(recordHeaderWidthInBytes+len(records[0]))+5], indexBytes)
+	l2 := NewLog(TestFile)
+	defer func() {_ = l2.Close()}()
+
+	// Assert
+	assertRecords(t, l2, records[:2])
+}
+
+func TestRecoverRebuildsCorruptIndexEntry(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	l1 := NewLog(TestFile)
+	records := appendRecords(l1, 4)
+	_ = l1.Close()
+	storeBytes, indexBytes := segmentFiles(t)
+
+	// Act
+	// the entry of the second record points in the middle of the first one
+	copy(indexBytes[storeOffsetFieldWidthInBytes:], serialization.Int64ToBytes(3))
+	writeSegmentFiles(t, storeBytes, indexBytes)
+	l2 := NewLog(TestFile)
+	defer func() {_ = l2.Close()}()
+
+	// Assert
+	assertRecords(t, l2, records)
+	storeAfter, _ := segmentFiles(t)
+	assert.Equal(t, storeBytes, storeAfter[:len(storeBytes)])
+}
+
+func TestReadOnlyLogSkipsIncompleteRecords(t *testing.T) {
+	// Arrange
+	_ = os.Mkdir(TestDir, 0755)
+	defer func() {_ = os.RemoveAll(TestDir)}()
+	l1 := NewLog(TestFile)
+	records := appendRecords(l1, 2)
+	_, _ = l1.Append([]byte("Goodbye world"))
+	_ = l1.Close()
+	storeAfter, indexAfter := segmentFiles(t)
+	writeSegmentFiles(t, storeAfter[:len(storeAfter)-1], indexAfter)
+
+	// Act
+	l2 := NewLog(TestFile, WithReadOnly())
+	defer func() {_ = l2.Close()}()
+	_, appendErr := l2.Append([]byte("Hello world"))
+
+	// Assert
+	assert.Equal(t, int64(len(records)), l2.Size())
+	for i, record := range records {
+		actual, err := l2.Read(int64(i))
+		assert.NoError(t, err)
+		assert.Equal(t, record, actual)
+	}
+	assert.Equal(t, ErrReadOnly, appendErr)
+	storeBytes, indexBytes := segmentFiles(t)
+	assert.Equal(t, storeAfter[:len(storeAfter)-1], storeBytes)
+	assert.Equal(t, indexAfter, indexBytes)
+}
@@ -0,0 +1,234 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// A segment holds the records of the log from its base offset up to the base offset of the next segment in a
+// .store/.index file pair of its own. The files of a segment are named after the log and the base offset of the
+// segment, so the segments of a log are found by listing its directory
+type segment struct {
+	baseOffset int64
+	store      *store
+	index      *index
+	dirty      int32 // set to 1 when records are written since the segment was last flushed, accessed atomically
+	readOnly   bool  // whether the files of the segment were opened read only
+	removed    bool  // whether the files of the segment were deleted, guarded by the flushMu of the log
+}
+
+// segmentFileName returns the name shared by the files of the segment of the log stored in fileName starting at
+// baseOffset. Base offsets are zero padded so the files of a log sort in offset order
+func segmentFileName(fileName string, baseOffset int64) string {
+	return fmt.Sprintf("%s.%020d", fileName, baseOffset)
+}
+
+// segmentBaseOffsets returns the base offsets of the segments of the log stored in fileName in ascending order
+func segmentBaseOffsets(fileName string) ([]int64, error) {
+	storeFileNames, err := filepath.Glob(fileName + ".*.store")
+	if err != nil {
+		return nil, err
+	}
+	prefix := filepath.Base(fileName) + "."
+	baseOffsets := make([]int64, 0, len(storeFileNames))
+	for _, storeFileName := range storeFileNames {
+		baseOffset := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(storeFileName), prefix), ".store")
+		if offset, err := strconv.ParseInt(baseOffset, 10, 64); err == nil {
+			baseOffsets = append(baseOffsets, offset)
+		}
+	}
+	sort.Slice(baseOffsets, func(i, j int) bool {
+		return baseOffsets[i] < baseOffsets[j]
+	})
+	return baseOffsets, nil
+}
+
+// openSegment opens the segment of the log stored in fileName starting at baseOffset, creating its files if needed
+// unless readOnly is set
+func openSegment(fileName string, baseOffset int64, readOnly bool) (*segment, error) {
+	name := segmentFileName(fileName, baseOffset)
+	flag := os.O_RDWR | os.O_CREATE
+	if readOnly {
+		flag = os.O_RDONLY
+	}
+	indexFile, err := os.OpenFile(name + ".index", flag, 0666)
+	if err != nil {
+		return nil, &IOError{Op: "open", File: name + ".index", Err: err}
+	}
+	storeFile, err := os.OpenFile(name + ".store", flag, 0666)
+	if err != nil {
+		_ = indexFile.Close()
+		return nil, &IOError{Op: "open", File: name + ".store", Err: err}
+	}
+	s := &segment{baseOffset: baseOffset, readOnly: readOnly}
+	if s.index, err = newIndex(indexFile); err == nil {
+		s.store, err = newStore(storeFile)
+	}
+	if err == nil {
+		err = s.recover()
+	}
+	if err != nil {
+		_ = indexFile.Close()
+		_ = storeFile.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// nextOffset returns the offset the next record appended to the segment is stored at
+func (s *segment) nextOffset() int64 {
+	return s.baseOffset + s.index.size
+}
+
+func (s *segment) Append(data []byte) (int64, error) {
+	// a failed write may still have reached the files in part. The segment is marked dirty again once the record is
+	// written in case a flush running concurrently cleared the mark before the record was written
+	atomic.StoreInt32(&s.dirty, 1)
+	defer atomic.StoreInt32(&s.dirty, 1)
+	storeOffset, err := s.store.Append(data)
+	if err != nil {
+		return 0, err
+	}
+	relativeOffset, err := s.index.Append(storeOffset)
+	if err != nil {
+		return 0, err
+	}
+	return s.baseOffset + relativeOffset, nil
+}
+
+func (s *segment) Read(offset int64) ([]byte, error) {
+	storeOffset, err := s.index.Read(offset - s.baseOffset)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.store.Read(storeOffset)
+	if err == errRecordOutOfBounds || err == errChecksumMismatch {
+		return nil, &CorruptRecordError{Offset: offset, Reason: err.Error()}
+	}
+	return data, err
+}
+
+// ReadBatch returns consecutive records starting at offset, which must be within the segment. The records are read
+// with a single read of the index and of the store, so only the records found in the first maxBytes bytes of the store
+// following the first record are returned, along with the first record itself should it be larger
+func (s *segment) ReadBatch(offset int64, maxRecords int64, maxBytes int64) ([][]byte, error) {
+	storeOffsets, err := s.index.ReadRange(offset - s.baseOffset, maxRecords)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := s.store.ReadSpan(storeOffsets[0], maxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([][]byte, 0, len(storeOffsets))
+	for _, storeOffset := range storeOffsets {
+		start := storeOffset - storeOffsets[0]
+		if start < 0 || start >= int64(len(buf)) {
+			// the record was replaced by Write so is stored elsewhere
+			break
+		}
+		data, err := decodeRecord(buf[start:])
+		if err != nil {
+			break
+		}
+		records = append(records, data)
+	}
+	if len(records) == 0 {
+		// the first record is larger than maxBytes or cannot be read back
+		data, err := s.Read(offset)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, data)
+	}
+	return records, nil
+}
+
+// Write replaces the record at offset, which must be within the segment. The new record is synced to the store before
+// the index points at it, so that a crash cannot leave the index pointing at a record which was lost
+func (s *segment) Write(data []byte, offset int64) error {
+	atomic.StoreInt32(&s.dirty, 1)
+	defer atomic.StoreInt32(&s.dirty, 1)
+	storeOffset, err := s.store.Append(data)
+	if err != nil {
+		return err
+	}
+	if err := s.store.Flush(); err != nil {
+		return err
+	}
+	return s.index.Write(offset - s.baseOffset, storeOffset)
+}
+
+// TruncateAfter removes every record of the segment after offset, which must be within the segment. The store is cut
+// after the last record still referenced by the index, which is not the record following offset if a record before it
+// was replaced by Write
+func (s *segment) TruncateAfter(offset int64) error {
+	if offset + 1 >= s.nextOffset() {
+		return nil
+	}
+	storeOffsets, err := s.index.ReadAll()
+	if err != nil {
+		return err
+	}
+	storeOffsets = storeOffsets[:offset + 1 - s.baseOffset]
+	lastStoreOffset := int64(-1)
+	for _, storeOffset := range storeOffsets {
+		if storeOffset > lastStoreOffset {
+			lastStoreOffset = storeOffset
+		}
+	}
+	storeSize := int64(0)
+	if lastStoreOffset >= 0 {
+		if storeSize, err = s.store.RecordEnd(lastStoreOffset); err != nil {
+			return err
+		}
+	}
+
+	if err := s.index.TruncateTo(int64(len(storeOffsets))); err != nil {
+		return err
+	}
+	return s.store.TruncateTo(storeSize)
+}
+
+// Flush syncs the files of the segment if records were written since it was last flushed. Flush may run concurrently
+// with writes to the segment, in which case the records written concurrently may or may not be synced
+func (s *segment) Flush() error {
+	if !atomic.CompareAndSwapInt32(&s.dirty, 1, 0) {
+		return nil
+	}
+	err := s.store.Flush()
+	if err == nil {
+		err = s.index.Flush()
+	}
+	if err != nil {
+		atomic.StoreInt32(&s.dirty, 1)
+	}
+	return err
+}
+
+func (s *segment) Close() error {
+	err := s.Flush()
+	_ = s.store.file.Close()
+	_ = s.index.file.Close()
+	return err
+}
+
+// Remove closes the segment and deletes its files
+func (s *segment) Remove() error {
+	s.removed = true
+	_ = s.store.file.Close()
+	_ = s.index.file.Close()
+	if err := os.Remove(s.store.file.Name()); err != nil {
+		return &IOError{Op: "remove", File: s.store.file.Name(), Err: err}
+	}
+	if err := os.Remove(s.index.file.Name()); err != nil {
+		return &IOError{Op: "remove", File: s.index.file.Name(), Err: err}
+	}
+	return nil
+}
@@ -1,13 +1,27 @@
 package log
 
 import (
+	"errors"
 	"fios-db/src/serialization"
-	"log"
+	"hash/crc32"
 	"os"
 	"sync"
 )
 
+// Record structure
+// +--------------------------------+
+// + recordLen (8 bytes)            +
+// + checksum (4 bytes)             +
+// + data (recordLen bytes)         +
+// +--------------------------------+
+// The checksum is a CRC32C of the record length and the data, so that a record which was only partially written before
+// a crash is told apart from a whole one
+
 const recordLenFieldWidthInBytes = 8
+const recordChecksumFieldWidthInBytes = 4
+const recordHeaderWidthInBytes = recordLenFieldWidthInBytes + recordChecksumFieldWidthInBytes
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
 
 type store struct {
 	mu sync.Mutex
@@ -15,59 +29,145 @@ type store struct {
 	size int64
 }
 
-func newStore(file *os.File) *store {
+func newStore(file *os.File) (*store, error) {
 	fi, err := os.Stat(file.Name())
 	if err != nil {
-		log.Fatalf("Failure constructing store: %v", err)
+		return nil, &IOError{Op: "stat", File: file.Name(), Err: err}
 	}
 	size := fi.Size()
 	return &store{
 		mu:   sync.Mutex{},
 		file: file,
 		size: size,
+	}, nil
+}
+
+// errRecordOutOfBounds is returned by Read when the record runs past the end of the store
+var errRecordOutOfBounds = errors.New("record runs past the end of the store")
+
+// errChecksumMismatch is returned by Read when the record does not match its checksum
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+func recordChecksum(recordLenBytes []byte, data []byte) []byte {
+	crc := crc32.Update(crc32.Checksum(recordLenBytes, castagnoli), castagnoli, data)
+	return serialization.Int32ToBytes(int32(crc))
+}
+
+// Append writes the record after the last record of the store and returns its offset. A record which fails to be
+// written is overwritten by the next record appended
+func (s *store) Append(data []byte) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	recordLenBytes := serialization.Int64ToBytes(int64(len(data)))
+	record := make([]byte, 0, recordHeaderWidthInBytes + len(data))
+	record = append(record, recordLenBytes...)
+	record = append(record, recordChecksum(recordLenBytes, data)...)
+	record = append(record, data...)
+	_, err := s.file.WriteAt(record, s.size)
+	if err != nil {
+		return 0, &IOError{Op: "append to", File: s.file.Name(), Err: err}
 	}
+
+	recordOffset := s.size
+	s.size = s.size + int64(len(record))
+	return recordOffset, nil
 }
 
-func (s *store) Append(data []byte) int64 {
+func (s *store) Read(offset int64) ([]byte, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	_, _ = s.file.Seek(0, 2)
+	if offset < 0 || offset + recordHeaderWidthInBytes > s.size {
+		return nil, errRecordOutOfBounds
+	}
+	header := make([]byte, recordHeaderWidthInBytes)
+	_, err := s.file.ReadAt(header, offset)
+	if err != nil {
+		return nil, &IOError{Op: "read", File: s.file.Name(), Err: err}
+	}
 
-	_, err := s.file.Write(serialization.Int64ToBytes(int64(len(data))))
+	recordLen := serialization.BytesToInt64(header[:recordLenFieldWidthInBytes])
+	if recordLen < 0 || offset + recordHeaderWidthInBytes + recordLen > s.size {
+		return nil, errRecordOutOfBounds
+	}
+	data := make([]byte, recordLen)
+	_, err = s.file.ReadAt(data, offset + recordHeaderWidthInBytes)
 	if err != nil {
-		log.Fatalf("Failure writing length of record")
+		return nil, &IOError{Op: "read", File: s.file.Name(), Err: err}
 	}
+	if string(recordChecksum(header[:recordLenFieldWidthInBytes], data)) != string(header[recordLenFieldWidthInBytes:]) {
+		return nil, errChecksumMismatch
+	}
+	return data, nil
+}
 
-	_, err = s.file.Write(data)
+// ReadSpan returns up to n bytes of the store starting at offset
+func (s *store) ReadSpan(offset int64, n int64) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if offset + n > s.size {
+		n = s.size - offset
+	}
+	if n <= 0 {
+		return []byte{}, nil
+	}
+	buf := make([]byte, n)
+	_, err := s.file.ReadAt(buf, offset)
 	if err != nil {
-		log.Fatalf("Failure writing data")
+		return nil, &IOError{Op: "read", File: s.file.Name(), Err: err}
 	}
+	return buf, nil
+}
 
-	recordOffset := s.size
-	s.size = s.size + recordLenFieldWidthInBytes + int64(len(data))
-	return recordOffset
+// decodeRecord returns the data of the record at the start of buf. Returns errRecordOutOfBounds if the record runs past
+// the end of buf or errChecksumMismatch if it does not match its checksum
+func decodeRecord(buf []byte) ([]byte, error) {
+	if len(buf) < recordHeaderWidthInBytes {
+		return nil, errRecordOutOfBounds
+	}
+	recordLen := serialization.BytesToInt64(buf[:recordLenFieldWidthInBytes])
+	if recordLen < 0 || recordLen > int64(len(buf) - recordHeaderWidthInBytes) {
+		return nil, errRecordOutOfBounds
+	}
+	data := buf[recordHeaderWidthInBytes : recordHeaderWidthInBytes + recordLen]
+	if string(recordChecksum(buf[:recordLenFieldWidthInBytes], data)) != string(buf[recordLenFieldWidthInBytes:recordHeaderWidthInBytes]) {
+		return nil, errChecksumMismatch
+	}
+	return data, nil
 }
 
-func (s *store) Read(offset int64) []byte {
+// RecordEnd returns the offset following the record stored at offset
+func (s *store) RecordEnd(offset int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	recordLenBytes := make([]byte, recordLenFieldWidthInBytes)
 	_, err := s.file.ReadAt(recordLenBytes, offset)
 	if err != nil {
-		log.Fatalf("Failure reading length of record")
+		return 0, &IOError{Op: "read", File: s.file.Name(), Err: err}
 	}
+	return offset + recordHeaderWidthInBytes + serialization.BytesToInt64(recordLenBytes), nil
+}
 
-	recordLen := serialization.BytesToInt64(recordLenBytes)
-	data := make([]byte, recordLen)
-	_, err = s.file.ReadAt(data, offset + recordLenFieldWidthInBytes)
+func (s *store) Flush() error {
+	err := s.file.Sync()
 	if err != nil {
-		log.Fatalf("Failure reading data")
+		return &IOError{Op: "sync", File: s.file.Name(), Err: err}
 	}
-	return data
+	return nil
 }
 
-func (s *store) Flush() {
-	err := s.file.Sync()
+// TruncateTo removes every record stored at or after the given offset
+func (s *store) TruncateTo(offset int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.file.Truncate(offset)
 	if err != nil {
-		log.Fatalf("Failure syncing store file to disk")
+		return &IOError{Op: "truncate", File: s.file.Name(), Err: err}
 	}
+	s.size = offset
+	return s.Flush()
 }
@@ -2,28 +2,158 @@ package main
 
 import (
 	"./bplustree"
+	"encoding/base64"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"github.com/gorilla/mux"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
-const cacheSize = 64
+// defaultCacheBytes is the memory the buffer pool may use unless the CACHE_BYTES environment variable sets it, which
+// is how the cache is sized relative to the memory limit of the container
+const defaultCacheBytes = 64 * bplustree.PageSize
 
-var bPlusTree = bplustree.NewBPlusTree("./data/db", cacheSize, -1)
+// defaultScanLimit is the number of entries in a page of a scan when no limit is given
+const defaultScanLimit = 100
+
+// maxScanLimit is the largest limit a scan accepts, so that no request reads the whole tree. Larger scans are paged
+const maxScanLimit = 1000
+
+// checkpointInterval is how often the WAL is checkpointed, on top of checkpoints triggered by the size of the WAL
+const checkpointInterval = time.Minute
+
+// durability is how durable writes are once acknowledged, unless a request picks its own through the X-Durability header
+const durability = bplustree.SYNC
+
+// dbPath is the path of the db files, without their extensions
+const dbPath = "./data/db"
+
+// backupRoot is the directory backups taken through the admin endpoint are written to, each in a directory of its own
+const backupRoot = "./data/backups"
+
+var bPlusTree bplustree.BPlusTree
+
+// commands maps the name of every subcommand to the function running it with the remaining arguments. The server is
+// started when no subcommand is given
+var commands = map[string]func(args []string){
+	"serve":   serve,
+	"load":    load,
+	"backup":  backup,
+	"restore": restore,
+	"check":   check,
+	"inspect": inspect,
+}
 
 type SetRequest struct {
 	Key   string `json:"key"`
 	Value string `json:"value"`
 }
 
+type TxnOp struct {
+	Op    string `json:"op"` // either "put" or "delete"
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type TxnRequest struct {
+	Ops []TxnOp `json:"ops"`
+}
+
+// ScanResponse is a page of the entries of a range or prefix scan
+type ScanResponse struct {
+	Entries []bplustree.KeyValue `json:"entries"`
+	// NextToken is passed as the token of the next request to fetch the following page. Empty on the last page
+	NextToken string `json:"nextToken,omitempty"`
+}
+
+type CountResponse struct {
+	Count int `json:"count"`
+}
+
+type VacuumResponse struct {
+	Running  bool                     `json:"running"`
+	Progress bplustree.VacuumProgress `json:"progress"`
+	Error    string                   `json:"error,omitempty"`
+}
+
+// vacuum tracks the last vacuum started through the admin endpoint
+var vacuum = struct {
+	sync.Mutex
+	VacuumResponse
+}{}
+
+type BackupRequest struct {
+	// Name is the directory within backupRoot the backup is written to. It must be a relative path without ".."
+	Name string `json:"name"`
+}
+
+type StatsResponse struct {
+	Cache        bplustree.CacheStats `json:"cache"`
+	HitRatio     float64              `json:"hitRatio"`
+	EvictionRate float64              `json:"evictionRate"` // pages evicted per second since the db was opened
+}
+
 func main() {
+	if len(os.Args) < 2 {
+		serve(nil)
+		return
+	}
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		log.Fatalf("Unknown command: %s", os.Args[1])
+	}
+	command(os.Args[2:])
+}
+
+// openTree opens the tree stored at dbPath
+func openTree() bplustree.BPlusTree {
+	return bplustree.NewBPlusTree(dbPath, 0, -1, bplustree.WithCacheBytes(cacheBytes()),
+		bplustree.WithCheckpointInterval(checkpointInterval), bplustree.WithDurability(durability))
+}
+
+// serve opens the tree and serves requests on port 8080
+func serve(_ []string) {
+	bPlusTree = openTree()
 	r := mux.NewRouter()
+	r.HandleFunc("/admin/stats", Stats).Methods(http.MethodGet)
+	r.HandleFunc("/admin/vacuum", VacuumStatus).Methods(http.MethodGet)
+	r.HandleFunc("/admin/vacuum", Vacuum).Methods(http.MethodPost)
+	r.HandleFunc("/admin/backup", Backup).Methods(http.MethodPost)
+	// scans and counts are told apart by their query on / rather than by a path, which could be taken for a key
+	r.HandleFunc("/", Count).Methods(http.MethodGet).Queries("count", "")
+	r.HandleFunc("/", ScanPrefix).Methods(http.MethodGet).Queries("prefix", "{prefix}")
+	r.HandleFunc("/", Scan).Methods(http.MethodGet)
 	r.HandleFunc("/{key}", Get).Methods(http.MethodGet)
 	r.HandleFunc("/", Set).Methods(http.MethodPost)
+	r.HandleFunc("/txn", Txn).Methods(http.MethodPost)
 	r.HandleFunc("/{key}", Delete).Methods(http.MethodDelete)
 
+	// checkpoint the WAL on shutdown so the next start does not need to recover it
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		if err := bPlusTree.Close(); err != nil {
+			log.Fatalf("Closing the tree failed: %v", err)
+		}
+		os.Exit(0)
+	}()
+
 	log.Fatal(http.ListenAndServe(":8080", r))
 }
 
@@ -35,13 +165,596 @@ func Get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	log.Printf("Handling get request for key: %s\n", key)
-	value, ok := bPlusTree.Get(key)
+	value, version, ok, err := bPlusTree.GetVersion(key)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
 	if !ok {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	_, err := w.Write([]byte(value))
+	w.Header().Set("ETag", etag(version))
+	_, err = w.Write([]byte(value))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func Scan(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit, ok := parseLimit(query.Get("limit"))
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	start := query.Get("start")
+	// the token is the encoded key at which the next page starts, and takes the place of start
+	if token := query.Get("token"); token != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		start = string(decoded)
+	}
+	log.Printf("Handling scan request for range: [%s, %s), limit: %d\n", start, query.Get("end"), limit)
+
+	// fetch one extra entry to learn where the next page starts
+	pairs, err := bPlusTree.Scan(start, query.Get("end"), limit+1)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJson(w, scanPage(pairs, limit))
+}
+
+func ScanPrefix(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	prefix, ok := vars["prefix"]
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	limit, ok := parseLimit(query.Get("limit"))
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// the token is the encoded key at which the next page starts
+	start, err := base64.RawURLEncoding.DecodeString(query.Get("token"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	log.Printf("Handling prefix request for prefix: %s, start: %s, limit: %d\n", prefix, start, limit)
+
+	// fetch one extra entry to learn where the next page starts
+	pairs, err := bPlusTree.ScanPrefix(prefix, string(start), limit+1)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJson(w, scanPage(pairs, limit))
+}
+
+// scanPage returns the first limit entries of pairs as a page, along with the token of the next page if pairs holds
+// more entries
+func scanPage(pairs []bplustree.KeyValue, limit int) ScanResponse {
+	response := ScanResponse{
+		Entries: pairs,
+	}
+	if len(pairs) > limit {
+		response.Entries = pairs[:limit]
+		response.NextToken = base64.RawURLEncoding.EncodeToString([]byte(pairs[limit].Key))
+	}
+	return response
+}
+
+func Count(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	log.Printf("Handling count request for range: [%s, %s)\n", query.Get("start"), query.Get("end"))
+	count, err := bPlusTree.Count(query.Get("start"), query.Get("end"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJson(w, CountResponse{
+		Count: count,
+	})
+}
+
+// Stats reports the memory usage, hit ratio and eviction rate of the buffer pool
+func Stats(w http.ResponseWriter, _ *http.Request) {
+	stats := bPlusTree.CacheStats()
+	writeJson(w, StatsResponse{
+		Cache:        stats,
+		HitRatio:     stats.HitRatio(),
+		EvictionRate: stats.EvictionRate(bplustree.CacheStats{}),
+	})
+}
+
+// Vacuum starts compacting the db file in the background, unless a vacuum is already running. Its progress is reported
+// by VacuumStatus
+func Vacuum(w http.ResponseWriter, _ *http.Request) {
+	vacuum.Lock()
+	defer vacuum.Unlock()
+	if vacuum.Running {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	log.Printf("Handling vacuum request\n")
+	vacuum.VacuumResponse = VacuumResponse{
+		Running: true,
+	}
+	go func() {
+		progress, err := bPlusTree.Vacuum(func(p bplustree.VacuumProgress) {
+			vacuum.Lock()
+			defer vacuum.Unlock()
+			vacuum.Progress = p
+		})
+		vacuum.Lock()
+		defer vacuum.Unlock()
+		vacuum.Running = false
+		vacuum.Progress = progress
+		if err != nil {
+			log.Printf("Vacuum failed: %v\n", err)
+			vacuum.Error = err.Error()
+		}
+	}()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// VacuumStatus reports the progress of the running vacuum, or the outcome of the last one
+func VacuumStatus(w http.ResponseWriter, _ *http.Request) {
+	vacuum.Lock()
+	response := vacuum.VacuumResponse
+	vacuum.Unlock()
+	writeJson(w, response)
+}
+
+// load bulk loads the entries of a CSV or JSONL file, sorted by key, into the empty tree:
+//
+//	load [-format csv|jsonl] [-fill factor] file
+//
+// Every line of a CSV file holds a key and a value, while every line of a JSONL file holds an object with a key and a
+// value. The format is picked from the extension of the file unless it is given
+func load(args []string) {
+	flags := flag.NewFlagSet("load", flag.ExitOnError)
+	format := flags.String("format", "", "format of the file, either csv or jsonl")
+	fill := flags.Float64("fill", bplustree.DefaultFillFactor, "fraction of every page to fill")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		log.Fatalf("Usage: load [-format csv|jsonl] [-fill factor] file")
+	}
+	path := flags.Arg(0)
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("Opening %s failed: %v", path, err)
+	}
+	defer func() { _ = file.Close() }()
+	var source bplustree.BulkLoadSource
+	switch *format {
+	case "csv":
+		reader := csv.NewReader(file)
+		reader.FieldsPerRecord = 2
+		source = csvSource{reader: reader}
+	case "jsonl":
+		source = jsonlSource{decoder: json.NewDecoder(file)}
+	default:
+		log.Fatalf("Unknown format: %s", *format)
+	}
+
+	tree := openTree()
+	start := time.Now()
+	n, err := tree.BulkLoad(source, *fill)
+	if err != nil {
+		_ = tree.Close()
+		log.Fatalf("Loading %s failed: %v", path, err)
+	}
+	if err := tree.Close(); err != nil {
+		log.Fatalf("Closing the tree failed: %v", err)
+	}
+	log.Printf("Loaded %d entries from %s in %v\n", n, path, time.Since(start))
+}
+
+// csvSource yields the key and value of every record of a CSV file
+type csvSource struct {
+	reader *csv.Reader
+}
+
+func (s csvSource) Next() (bplustree.KeyValue, bool, error) {
+	record, err := s.reader.Read()
+	if err == io.EOF {
+		return bplustree.KeyValue{}, false, nil
+	}
+	if err != nil {
+		return bplustree.KeyValue{}, false, err
+	}
+	return bplustree.KeyValue{Key: record[0], Value: record[1]}, true, nil
+}
+
+// jsonlSource yields the entry held by every line of a JSONL file
+type jsonlSource struct {
+	decoder *json.Decoder
+}
+
+func (s jsonlSource) Next() (bplustree.KeyValue, bool, error) {
+	var kv bplustree.KeyValue
+	if err := s.decoder.Decode(&kv); err == io.EOF {
+		return kv, false, nil
+	} else if err != nil {
+		return kv, false, fmt.Errorf("decoding entry: %w", err)
+	}
+	return kv, true, nil
+}
+
+// Backup writes a backup of the tree to the directory named by the request within backupRoot, which is created on the
+// server. Writes continue while the backup is taken, and the response describes the commits the backup may be restored
+// to
+func Backup(w http.ResponseWriter, r *http.Request) {
+	bodyBytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var request BackupRequest
+	err = json.Unmarshal(bodyBytes, &request)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	dir, ok := backupDir(request.Name)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Handling backup request to: %s\n", dir)
+	info, err := bPlusTree.Backup(dir)
+	if errors.Is(err, bplustree.ErrBackupDirNotEmpty) {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJson(w, info)
+}
+
+// backupDir returns the directory within backupRoot the backup named name is written to. Returns false if name is
+// empty, absolute or contains "..", so that requests cannot write anywhere else on the server
+func backupDir(name string) (string, bool) {
+	if name == "" || filepath.IsAbs(name) {
+		return "", false
+	}
+	for _, element := range strings.Split(filepath.ToSlash(name), "/") {
+		if element == ".." {
+			return "", false
+		}
+	}
+	dir := filepath.Join(backupRoot, name)
+	if dir == filepath.Clean(backupRoot) {
+		return "", false
+	}
+	return dir, true
+}
+
+// backup asks the running server to write a backup of the tree to the directory name within backupRoot on the server,
+// since the tree cannot be opened by two processes:
+//
+//	backup [-server url] name
+func backup(args []string) {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	server := flags.String("server", "http://localhost:8080", "url of the running server")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		log.Fatalf("Usage: backup [-server url] name")
+	}
+	name := flags.Arg(0)
+	dir, ok := backupDir(name)
+	if !ok {
+		log.Fatalf("Backup names must be relative paths without \"..\": %s", name)
+	}
+
+	body, err := json.Marshal(BackupRequest{
+		Name: name,
+	})
+	if err != nil {
+		log.Fatalf("Encoding the request failed: %v", err)
+	}
+	resp, err := http.Post(*server+"/admin/backup", "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		log.Fatalf("Backup failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("Backup failed: %s", resp.Status)
+	}
+	var info bplustree.BackupInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		log.Fatalf("Decoding the response failed: %v", err)
+	}
+	log.Printf("Backed up %d pages to %s on the server, restorable to commits %d through %d\n", info.Pages, dir, info.Seq,
+		info.LastSeq)
+}
+
+// restore restores a backup to the db, which must not exist yet, replaying its WAL up to the given commit or up to its
+// last commit if none is given:
+//
+//	restore [-seq commit] dir
+func restore(args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	seq := flags.Int64("seq", -1, "commit to restore the backup to, its last commit if negative")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		log.Fatalf("Usage: restore [-seq commit] dir")
+	}
+	dir := flags.Arg(0)
+
+	info, err := bplustree.ReadBackupInfo(dir)
+	if err != nil {
+		log.Fatalf("Reading the backup in %s failed: %v", dir, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+		log.Fatalf("Creating the db directory failed: %v", err)
+	}
+	if err := bplustree.Restore(dir, dbPath, *seq); err != nil {
+		log.Fatalf("Restoring %s failed: %v", dir, err)
+	}
+	if *seq < 0 {
+		*seq = info.LastSeq
+	}
+	log.Printf("Restored %s to commit %d\n", dir, *seq)
+}
+
+// check reports every problem found in the db, and rebuilds it from the leaves which can still be read if -repair is
+// given. The server must not be running:
+//
+//	check [-repair]
+//
+// Exits with status 1 if a problem was found and the db was not repaired
+func check(args []string) {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	repair := flags.Bool("repair", false, "rebuild the db from its readable leaves if a problem is found")
+	_ = flags.Parse(args)
+
+	report, err := bplustree.Check(dbPath)
+	if err != nil {
+		log.Fatalf("Checking the db failed: %v", err)
+	}
+	for _, problem := range report.Problems {
+		fmt.Println(problem)
+	}
+	fmt.Printf("%d pages, %d live, %d free, %d keys, %d problems\n", report.Pages, report.Live, report.Free,
+		report.Keys, len(report.Problems))
+	if report.OK() {
+		return
+	}
+	if !*repair {
+		os.Exit(1)
+	}
+
+	repaired, err := bplustree.Repair(dbPath)
+	if err != nil {
+		log.Fatalf("Repairing the db failed: %v", err)
+	}
+	log.Printf("Rebuilt the db from %d leaves with %d keys, %d values were lost, the corrupt db was moved to %s\n",
+		repaired.Leaves, repaired.Keys, repaired.Lost, dbPath+".corrupt")
+}
+
+// inspect dumps the storage of the db for debugging, as text or as JSON for scripting:
+//
+//	inspect [-json] meta|page num|wal|freelist|stats
+//
+// The db is read without being modified, so it may be inspected while the server is running, although pages written
+// concurrently may then be read half written
+func inspect(args []string) {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print JSON rather than text")
+	_ = flags.Parse(args)
+	usage := "Usage: inspect [-json] meta|page num|wal|freelist|stats"
+	if flags.NArg() < 1 {
+		log.Fatal(usage)
+	}
+
+	inspector, err := bplustree.NewInspector(dbPath)
+	if err != nil {
+		log.Fatalf("Opening the db failed: %v", err)
+	}
+	defer func() { _ = inspector.Close() }()
+	var result interface{}
+	switch flags.Arg(0) {
+	case "meta":
+		result, err = inspector.Metadata()
+	case "page":
+		if flags.NArg() != 2 {
+			log.Fatal(usage)
+		}
+		pageNum, parseErr := strconv.ParseInt(flags.Arg(1), 10, 64)
+		if parseErr != nil {
+			log.Fatalf("Invalid page number: %s", flags.Arg(1))
+		}
+		result, err = inspector.Page(pageNum)
+	case "wal":
+		result = inspector.WAL()
+	case "freelist":
+		result, err = inspector.FreeList()
+	case "stats":
+		result, err = inspector.Stats()
+	default:
+		log.Fatal(usage)
+	}
+	if err != nil {
+		log.Fatalf("Inspecting the db failed: %v", err)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(result); err != nil {
+			log.Fatalf("Encoding the result failed: %v", err)
+		}
+		return
+	}
+	switch result := result.(type) {
+	case bplustree.MetadataInfo:
+		printMetadata(result)
+	case bplustree.PageInfo:
+		printPage(result)
+	case bplustree.WALInfo:
+		printWAL(result)
+	case bplustree.FreeListInfo:
+		printFreeList(result)
+	case bplustree.TreeStats:
+		printStats(result)
+	}
+}
+
+func printMetadata(info bplustree.MetadataInfo) {
+	fmt.Printf("root:           %d\n", info.Root)
+	fmt.Printf("free list:      %d\n", info.FreePageStart)
+	fmt.Printf("version clock:  %d\n", info.VersionClock)
+	fmt.Printf("pages:          %d\n", info.Pages)
+	fmt.Printf("db file:        %d bytes\n", info.FileSize)
+	fmt.Printf("WAL:            %d frames, %d pages\n", info.WALFrames, info.WALPages)
+	if info.Error != "" {
+		fmt.Printf("error:          %s\n", info.Error)
+	}
+}
+
+func printPage(info bplustree.PageInfo) {
+	source := "db file"
+	if info.InWAL {
+		source = "WAL"
+	}
+	fmt.Printf("page %d: %s, read from the %s\n", info.PageNum, info.Type, source)
+	if info.Error != "" {
+		fmt.Printf("error: %s\n", info.Error)
+	}
+	switch info.Type {
+	case "METADATA":
+		fmt.Printf("root: %d, free list: %d, version clock: %d\n", info.Root, info.FreePageStart, info.VersionClock)
+	case "LEAF":
+		fmt.Printf("%d entries, %d bytes used, prev: %d, next: %d\n", len(info.Entries), info.Used, info.Prev,
+			info.Next)
+		for _, entry := range info.Entries {
+			if entry.Overflow != 0 {
+				fmt.Printf("  %q v%d -> overflow page %d\n", entry.Key, entry.Version, entry.Overflow)
+			} else {
+				fmt.Printf("  %q v%d = %q\n", entry.Key, entry.Version, entry.Value)
+			}
+		}
+	case "INTERNAL":
+		fmt.Printf("%d keys, %d bytes used\n", len(info.Keys), info.Used)
+		for idx, child := range info.Children {
+			fmt.Printf("  -> %d\n", child)
+			if idx < len(info.Keys) {
+				fmt.Printf("  %q\n", info.Keys[idx])
+			}
+		}
+	case "FREE":
+		fmt.Printf("next: %d\n", info.Next)
+	case "OVERFLOW":
+		fmt.Printf("%d bytes, next: %d\n", info.DataLen, info.Next)
+	}
+}
+
+// printWAL prints every frame of the WAL, separating the commits
+func printWAL(info bplustree.WALInfo) {
+	for _, frame := range info.Frames {
+		switch frame.Type {
+		case "PUT":
+			fmt.Printf("%12d  PUT         txn %d  page %d\n", frame.Offset, frame.TxnId, frame.PageNum)
+		case "COMMIT":
+			checkpointed := ""
+			if frame.Checkpointed {
+				checkpointed = ", checkpointed"
+			}
+			fmt.Printf("%12d  COMMIT      txn %d  commit %d%s\n", frame.Offset, frame.TxnId, frame.Commit, checkpointed)
+			fmt.Println(strings.Repeat("-", 60))
+		default:
+			fmt.Printf("%12d  %-10s  txn %d\n", frame.Offset, frame.Type, frame.TxnId)
+		}
+	}
+	fmt.Printf("%d frames, %d commits\n", len(info.Frames), info.Commits)
+	if info.Error != "" {
+		fmt.Printf("error: %s\n", info.Error)
+	}
+}
+
+func printFreeList(info bplustree.FreeListInfo) {
+	for _, pageNum := range info.Pages {
+		fmt.Printf("%d\n", pageNum)
+	}
+	fmt.Printf("%d free pages\n", len(info.Pages))
+	if info.Error != "" {
+		fmt.Printf("error: %s\n", info.Error)
+	}
+}
+
+func printStats(stats bplustree.TreeStats) {
+	fmt.Printf("height: %d, keys: %d\n", stats.Height, stats.Keys)
+	for level, levelStats := range stats.Levels {
+		fmt.Printf("  level %d: %d nodes, %d keys, %.1f%% full\n", level, levelStats.Nodes, levelStats.Keys,
+			100*levelStats.Fill)
+	}
+	if stats.Unreadable > 0 {
+		fmt.Printf("unreadable nodes: %d\n", stats.Unreadable)
+	}
+	pageTypes := make([]string, 0, len(stats.PageTypes))
+	for pageType := range stats.PageTypes {
+		pageTypes = append(pageTypes, pageType)
+	}
+	sort.Strings(pageTypes)
+	fmt.Println("pages:")
+	for _, pageType := range pageTypes {
+		fmt.Printf("  %-10s %d\n", pageType, stats.PageTypes[pageType])
+	}
+}
+
+// cacheBytes returns the memory the buffer pool may use, read from the CACHE_BYTES environment variable if it is set
+func cacheBytes() int64 {
+	env := os.Getenv("CACHE_BYTES")
+	if env == "" {
+		return defaultCacheBytes
+	}
+	size, err := strconv.ParseInt(env, 10, 64)
+	if err != nil || size <= 0 {
+		log.Fatalf("CACHE_BYTES must be a positive number of bytes: %s", env)
+	}
+	return size
+}
+
+// parseLimit parses the limit query parameter of a scan, falling back to defaultScanLimit when it is absent. The limit
+// must be positive and at most maxScanLimit
+func parseLimit(limitParam string) (int, bool) {
+	if limitParam == "" {
+		return defaultScanLimit, true
+	}
+	limit, err := strconv.Atoi(limitParam)
+	if err != nil || limit <= 0 || limit > maxScanLimit {
+		return 0, false
+	}
+	return limit, true
+}
+
+func writeJson(w http.ResponseWriter, response interface{}) {
+	responseJson, err := json.Marshal(response)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(responseJson)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 	}
@@ -62,12 +775,72 @@ func Set(w http.ResponseWriter, r *http.Request) {
 	}
 
 	log.Printf("Handling set request for key: %s, value: %s\n", request.Key, request.Value)
-	if request.Key == "" {
+	tree, ok := writeTree(r)
+	if request.Key == "" || !ok {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	bPlusTree.Set(request.Key, request.Value)
+	set := true
+	if condition := writeCondition(r); condition != nil {
+		set, err = tree.SetIf(request.Key, request.Value, condition)
+	} else {
+		err = tree.Set(request.Key, request.Value)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !set {
+		w.WriteHeader(http.StatusPreconditionFailed)
+	}
+}
+
+// Txn applies a batch of puts and deletes atomically
+func Txn(w http.ResponseWriter, r *http.Request) {
+	bodyBytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var request TxnRequest
+	err = json.Unmarshal(bodyBytes, &request)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	for _, op := range request.Ops {
+		if op.Key == "" || (op.Op != "put" && op.Op != "delete") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	tree, ok := writeTree(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Handling txn request with %d ops\n", len(request.Ops))
+	txn := tree.Begin()
+	for _, op := range request.Ops {
+		if op.Op == "put" {
+			err = txn.Put(op.Key, op.Value)
+		} else {
+			err = txn.Delete(op.Key)
+		}
+		if err != nil {
+			_ = txn.Rollback()
+			writeError(w, err)
+			return
+		}
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		writeError(w, err)
+	}
 }
 
 func Delete(w http.ResponseWriter, r *http.Request) {
@@ -77,6 +850,88 @@ func Delete(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	tree, ok := writeTree(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
 	log.Printf("Handling delete request for key: %s\n", key)
-	bPlusTree.Delete(key)
+	if condition := writeCondition(r); condition != nil {
+		deleted, err := tree.DeleteIf(key, condition)
+		if err != nil {
+			writeError(w, err)
+		} else if !deleted {
+			w.WriteHeader(http.StatusPreconditionFailed)
+		}
+		return
+	}
+	if err := tree.Delete(key); err != nil {
+		writeError(w, err)
+	}
+}
+
+// writeError responds with the status matching an error returned by the tree. Corrupt data is a server error, while a
+// failed read or write of the storage is reported as unavailable since it may succeed when retried. A write which was
+// applied but could not be made durable is a server error as well, since retrying it would apply it twice
+func writeError(w http.ResponseWriter, err error) {
+	log.Printf("Request failed: %v\n", err)
+	switch {
+	case errors.Is(err, bplustree.ErrKeyTooLarge):
+		w.WriteHeader(http.StatusBadRequest)
+	case errors.Is(err, bplustree.ErrNotDurable):
+		w.WriteHeader(http.StatusInternalServerError)
+	case errors.Is(err, bplustree.ErrIO):
+		w.WriteHeader(http.StatusServiceUnavailable)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// writeTree returns the tree a write is made through, which commits with the durability named by the X-Durability
+// header when it is present. Returns false if the header does not name a durability
+func writeTree(r *http.Request) (bplustree.BPlusTree, bool) {
+	header := r.Header.Get("X-Durability")
+	if header == "" {
+		return bPlusTree, true
+	}
+	d, err := bplustree.ParseDurability(header)
+	if err != nil {
+		return bplustree.BPlusTree{}, false
+	}
+	return bPlusTree.WithDurability(d), true
+}
+
+// writeCondition builds the condition of a conditional write from the If-Match and If-None-Match headers, which hold
+// ETags returned by Get or "*" to match any existing key. Returns nil when neither header is present
+func writeCondition(r *http.Request) bplustree.Condition {
+	ifMatch := r.Header.Get("If-Match")
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if ifMatch == "" && ifNoneMatch == "" {
+		return nil
+	}
+	return func(_ string, version int64, present bool) bool {
+		if ifMatch != "" && !(present && etagMatches(ifMatch, version)) {
+			return false
+		}
+		if ifNoneMatch != "" && present && etagMatches(ifNoneMatch, version) {
+			return false
+		}
+		return true
+	}
+}
+
+// etag returns the entity tag of the given version of a key
+func etag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// etagMatches returns whether the comma separated list of entity tags in header matches the given version
+func etagMatches(header string, version int64) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag(version) {
+			return true
+		}
+	}
+	return false
 }
@@ -10,33 +10,53 @@ type Log struct {
 	log *aol.Log
 }
 
-func NewLog(fileName string) *Log {
+func NewLog(fileName string, opts ...aol.Option) *Log {
 	return &Log{
-		log: aol.NewLog(fileName),
+		log: aol.NewLog(fileName, opts...),
 	}
 }
 
-func (l *Log) Append(term int, command []byte) int64 {
+func (l *Log) Append(term int, command []byte) (int64, error) {
 	entry := Entry{
 		Command: command,
 		Term:    term,
 	}
 	entryBytes := l.serializeEntry(entry)
-	idx := l.log.Append(entryBytes)
-	l.log.Flush()
-	return idx
+	idx, err := l.log.Append(entryBytes)
+	if err != nil {
+		return 0, err
+	}
+	return idx, l.log.Flush()
 }
 
-func (l *Log) Put(entry Entry) {
+func (l *Log) Put(entry Entry) error {
 	entrySerialized := l.serializeEntry(entry)
-	l.log.Write(entrySerialized, entry.Index)
-	l.log.Flush()
+	if err := l.log.Write(entrySerialized, entry.Index); err != nil {
+		return err
+	}
+	return l.log.Flush()
 }
 
-func (l *Log) BatchPut(entries []Entry) {
+// BatchPut stores the entries sent by the leader. An existing entry which conflicts with a new entry, having the same
+// index but a different term, is removed along with every entry following it
+func (l *Log) BatchPut(entries []Entry) error {
 	for _, entry := range entries {
-		l.Put(entry)
+		if existing, err := l.Get(entry.Index); err == nil && existing.Term != entry.Term {
+			if err := l.log.TruncateAfter(entry.Index - 1); err != nil {
+				return err
+			}
+		}
+		if err := l.Put(entry); err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+// DiscardBefore discards entries before idx, which must no longer be needed to be applied or sent to a peer. Entries are
+// discarded a segment at a time so some entries before idx may remain
+func (l *Log) DiscardBefore(idx int64) error {
+	return l.log.TruncateBefore(idx)
 }
 
 func (l *Log) Get(idx int64) (Entry, error) {
@@ -49,10 +69,14 @@ func (l *Log) Get(idx int64) (Entry, error) {
 	return entry, nil
 }
 
+// BatchGet returns the entries from startIdx up to endIdx, or up to the latest entry if endIdx < 0
 func (l *Log) BatchGet(startIdx, endIdx int64) []Entry {
 	entries := make([]Entry, 0)
-	for i := startIdx; i < endIdx; i++ {
-		entry, _ := l.Get(i)
+	it := l.log.Iterator(startIdx)
+	defer it.Close()
+	for ; it.Valid() && (endIdx < 0 || it.Offset() < endIdx); it.Next() {
+		entry := l.deserializeEntry(it.Record())
+		entry.Index = it.Offset()
 		entries = append(entries, entry)
 	}
 	return entries
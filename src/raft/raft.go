@@ -1,6 +1,8 @@
 package raft
 
 import (
+	aol "fios-db/src/log"
+	"log"
 	"math/rand"
 	"net/http"
 	"sort"
@@ -121,7 +123,10 @@ type Raft struct {
 	committedCommands chan []byte
 }
 
-func NewRaft(id int, peerIds []int, idToPeerMap map[int]Peer, committedCommands chan []byte, logFileName string) *Raft {
+// NewRaft starts a raft instance whose log is stored in logFileName. logOpts configure the segments of the log. Entries
+// are discarded from the log once they are applied and every peer has them, so retention options which would discard
+// entries regardless should not be passed
+func NewRaft(id int, peerIds []int, idToPeerMap map[int]Peer, committedCommands chan []byte, logFileName string, logOpts ...aol.Option) *Raft {
 	raft := &Raft{
 		mu:                  sync.Mutex{},
 		currentTerm:         0,
@@ -133,7 +138,7 @@ func NewRaft(id int, peerIds []int, idToPeerMap map[int]Peer, committedCommands
 		lastAppliedIdx:      -1,
 		matchIdx:            make(map[int]int64),
 		nextIdx:             make(map[int]int64),
-		log:                 NewLog(logFileName),
+		log:                 NewLog(logFileName, logOpts...),
 		rpc:                 NewRPC(id, idToPeerMap),
 		submitChan:          make(chan interface{}),
 		termChangeChan:      make(chan interface{}),
@@ -176,7 +181,10 @@ func (r *Raft) Submit(command []byte) bool {
 	r.mu.Unlock()
 
 	// Append this entry to our log
-	idx := r.log.Append(savedCurrentTerm, command)
+	idx, err := r.log.Append(savedCurrentTerm, command)
+	if err != nil {
+		return false
+	}
 
 	// Notify other threads that a new client command has been appended to the log and we should begin replicating
 	r.submitChan <- struct{}{}
@@ -206,14 +214,13 @@ func (r *Raft) onAppendEntries(aeWrapper AppendEntriesRequestWrapper) {
 	if request.Term == r.currentTerm {
 		prevCommand, err := r.log.Get(request.PrevLogIdx)
 		if err == nil && prevCommand.Term == request.Term {
-			// Our logs do indeed match up to where the leader thinks they match
-			r.log.BatchPut(request.Entries)
-			if request.CommitIdx > r.commitIdx {
+			// Our logs do indeed match up to where the leader thinks they match. Entries which fail to be stored are not
+			// acknowledged so that the leader sends them again
+			success = r.log.BatchPut(request.Entries) == nil
+			if success && request.CommitIdx > r.commitIdx {
 				r.commitIdx = request.CommitIdx
 				r.newCommitReadyChan <- struct{}{}
 			}
-
-			success = true
 		}
 
 		// this needs to go outside the above if command. When a node becomes a leader it begins probing the peers to
@@ -265,7 +272,29 @@ func (r *Raft) commitChanSender() {
 		for _, entry := range entries {
 			r.commitChan <- entry
 		}
+
+		r.mu.Lock()
+		discardIdx := r.discardIdx()
+		r.mu.Unlock()
+		if err := r.log.DiscardBefore(discardIdx); err != nil {
+			log.Printf("Failure discarding raft log entries: %v", err)
+		}
+	}
+}
+
+// discardIdx returns the index before which entries are no longer needed: they were applied and, when leading, every
+// peer has them. A follower only discards entries which were applied, which a majority of the peers has. The entry at
+// the index is kept since its term is checked against the entries following it. The caller must hold mu
+func (r *Raft) discardIdx() int64 {
+	idx := r.lastAppliedIdx
+	if r.state == Leader {
+		for _, peerId := range r.peerIds {
+			if r.matchIdx[peerId] < idx {
+				idx = r.matchIdx[peerId]
+			}
+		}
 	}
+	return idx
 }
 
 func (r *Raft) startLeader() {
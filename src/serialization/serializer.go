@@ -15,6 +15,16 @@ func BytesToInt64(buf []byte) int64 {
 	return int64(binary.LittleEndian.Uint64(buf))
 }
 
+func Int32ToBytes(i int32) []byte {
+	var buf = make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, uint32(i))
+	return buf
+}
+
+func BytesToInt32(buf []byte) int32 {
+	return int32(binary.LittleEndian.Uint32(buf))
+}
+
 func Int16ToBytes(i int16) []byte {
 	var buf = make([]byte, 2)
 	binary.LittleEndian.PutUint16(buf, uint16(i))
@@ -1,7 +1,6 @@
 package serialization
 
 import (
-	"fios-db/src/bplustree"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
@@ -20,6 +19,20 @@ func TestInt64SerializationAndDeserialization(t *testing.T) {
 	}
 }
 
+func TestInt32SerializationAndDeserialization(t *testing.T) {
+	// Arrange
+	var int32s = []int32{-2147483648, 0, 2147483647}
+
+	// Act/Assert
+	for _, int32ToSerialize := range int32s {
+		int32Serialized := Int32ToBytes(int32ToSerialize)
+		assert.Equal(t, len(int32Serialized), 4)
+
+		int32Deserialized := BytesToInt32(int32Serialized)
+		assert.Equal(t, int32Deserialized, int32ToSerialize)
+	}
+}
+
 func TestInt16SerializationAndDeserialization(t *testing.T) {
 	// Arrange
 	var int16s = []int16{-32768, 0, 32767}
@@ -41,7 +54,7 @@ func TestStringSerializationAndDeserialization(t *testing.T) {
 	// Act/Assert
 	for _, key := range keys {
 		keySerialized := StringToBytes(key, 8)
-		assert.Equal(t, len(keySerialized), bplustree.KeySize)
+		assert.Equal(t, len(keySerialized), 8)
 
 		keyDeserialized := FixedLengthBytesToString(keySerialized)
 		assert.Equal(t, key, keyDeserialized)